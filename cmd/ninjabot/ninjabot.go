@@ -1,14 +1,25 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/rodrigo-brito/ninjabot/download"
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/validation"
 
+	"github.com/glebarez/sqlite"
+	"github.com/samber/lo"
 	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func main() {
@@ -22,10 +33,10 @@ func main() {
 				HelpName: "download",
 				Usage:    "Download historical data",
 				Flags: []cli.Flag{
-					&cli.StringFlag{
+					&cli.StringSliceFlag{
 						Name:     "pair",
 						Aliases:  []string{"p"},
-						Usage:    "eg. BTCUSDT",
+						Usage:    "eg. BTCUSDT or BTCUSDT,ETHUSDT",
 						Required: true,
 					},
 					&cli.IntFlag{
@@ -48,18 +59,43 @@ func main() {
 						Layout:   "2006-01-02",
 						Required: false,
 					},
-					&cli.StringFlag{
+					&cli.StringSliceFlag{
 						Name:     "timeframe",
 						Aliases:  []string{"t"},
-						Usage:    "eg. 1h",
+						Usage:    "eg. 1h or 1h,4h",
 						Required: true,
 					},
 					&cli.StringFlag{
 						Name:     "output",
 						Aliases:  []string{"o"},
-						Usage:    "eg. ./btc.csv",
+						Usage:    "eg. ./btc.csv, or ./{pair}-{timeframe}.csv for many pairs and timeframes",
 						Required: true,
 					},
+					&cli.BoolFlag{
+						Name:     "resume",
+						Aliases:  []string{"r"},
+						Usage:    "append the candles after the last one of existing output files",
+						Value:    false,
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "concurrency",
+						Usage:    "number of concurrent downloads",
+						Value:    4,
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "retries",
+						Usage:    "retries of a failed request",
+						Value:    3,
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "rate",
+						Usage:    "maximum requests per second of all downloads",
+						Value:    10,
+						Required: false,
+					},
 					&cli.BoolFlag{
 						Name:     "futures",
 						Aliases:  []string{"f"},
@@ -67,6 +103,32 @@ func main() {
 						Value:    false,
 						Required: false,
 					},
+					&cli.BoolFlag{
+						Name:     "binary",
+						Aliases:  []string{"b"},
+						Usage:    "write the binary columnar format instead of CSV",
+						Value:    false,
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "gzip",
+						Aliases:  []string{"z"},
+						Usage:    "compress the binary format with gzip",
+						Value:    false,
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "cache",
+						Aliases:  []string{"c"},
+						Usage:    "SQLite file of the local candle cache, only missing candles are downloaded",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name: "futures-data",
+						Usage: "futures market data written as extra columns: funding_rate, open_interest, " +
+							"mark_price, index_price, long_short_ratio or all",
+						Required: false,
+					},
 				},
 				Action: func(c *cli.Context) error {
 					var (
@@ -101,11 +163,211 @@ func main() {
 						log.Fatal("START and END must be informed together")
 					}
 
-					return download.NewDownloader(exc).Download(c.Context, c.String("pair"),
-						c.String("timeframe"), c.String("output"), options...)
+					if c.Bool("binary") {
+						options = append(options, download.WithBinaryFormat(compression(c.Bool("gzip"))))
+					}
+
+					if path := c.String("cache"); path != "" {
+						name := "binance"
+						if c.Bool("futures") {
+							name = "binance-futures"
+						}
+
+						cache, err := download.NewCache(sqlite.Open(path), name, exc, &gorm.Config{
+							Logger: logger.Default.LogMode(logger.Silent),
+						})
+						if err != nil {
+							return err
+						}
+						options = append(options, download.WithCache(cache))
+					}
+
+					if c.Bool("resume") {
+						options = append(options, download.WithResume())
+					}
+
+					if metrics := c.StringSlice("futures-data"); len(metrics) > 0 {
+						var dataOptions []exchange.FuturesDataOption
+						if !lo.Contains(metrics, "all") {
+							dataOptions = append(dataOptions, exchange.WithFuturesMetrics(metrics...))
+						}
+
+						data, err := exchange.NewFuturesData(dataOptions...)
+						if err != nil {
+							return err
+						}
+						options = append(options, download.WithMetadata(data))
+					}
+
+					options = append(options,
+						download.WithConcurrency(c.Int("concurrency")),
+						download.WithRetries(c.Int("retries"), time.Second),
+						download.WithRateLimit(c.Int("rate"), time.Second),
+					)
+
+					jobs, err := download.Jobs(c.StringSlice("pair"), c.StringSlice("timeframe"), c.String("output"))
+					if err != nil {
+						return err
+					}
+
+					return download.NewDownloader(exc).DownloadAll(c.Context, jobs, options...)
 
 				},
 			},
+			{
+				Name:     "convert",
+				HelpName: "convert",
+				Usage:    "Convert a CSV file to the binary columnar format",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "eg. ./btc.csv",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "eg. ./btc.bin",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "pair",
+						Aliases:  []string{"p"},
+						Usage:    "eg. BTCUSDT",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "timeframe",
+						Aliases:  []string{"t"},
+						Usage:    "eg. 1h",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:     "gzip",
+						Aliases:  []string{"z"},
+						Usage:    "compress the output with gzip",
+						Value:    false,
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					feed := exchange.PairFeed{
+						Pair:      c.String("pair"),
+						File:      c.String("input"),
+						Timeframe: c.String("timeframe"),
+					}
+					return download.ConvertCSV(feed, c.String("output"), compression(c.Bool("gzip")))
+				},
+			},
+			{
+				Name:     "validate",
+				HelpName: "validate",
+				Usage:    "Check the quality of a candle file (CSV or binary) and repair it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "eg. ./btc.csv",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "timeframe",
+						Aliases:  []string{"t"},
+						Usage:    "eg. 1h",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "pair",
+						Aliases:  []string{"p"},
+						Usage:    "eg. BTCUSDT",
+						Required: false,
+					},
+					&cli.Float64Flag{
+						Name:     "spike",
+						Usage:    "close price change rate considered a spike",
+						Value:    0.5,
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "report",
+						Aliases:  []string{"r"},
+						Usage:    "JSON report file, default is stdout",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name:     "repair",
+						Usage:    "repair modes: sort, dedupe, outliers, fill",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "CSV file of repaired candles, required with --repair",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					feed := exchange.PairFeed{
+						Pair:      c.String("pair"),
+						File:      c.String("input"),
+						Timeframe: c.String("timeframe"),
+					}
+
+					candles, err := readCandles(feed)
+					if err != nil {
+						return err
+					}
+
+					options := []validation.Option{validation.WithSpikeThreshold(c.Float64("spike"))}
+					if modes := c.StringSlice("repair"); len(modes) > 0 {
+						output := c.String("output")
+						if output == "" {
+							return errors.New("OUTPUT must be informed with REPAIR")
+						}
+
+						repairModes := make([]validation.RepairMode, 0, len(modes))
+						for _, mode := range modes {
+							repairModes = append(repairModes, validation.RepairMode(mode))
+						}
+
+						candles, err = validation.Repair(candles, feed.Timeframe, repairModes, options...)
+						if err != nil {
+							return err
+						}
+
+						if err := writeCandles(output, candles); err != nil {
+							return err
+						}
+					}
+
+					report, err := validation.Validate(candles, feed.Timeframe, options...)
+					if err != nil {
+						return err
+					}
+
+					var writer io.Writer = os.Stdout
+					if path := c.String("report"); path != "" {
+						file, err := os.Create(path)
+						if err != nil {
+							return err
+						}
+						defer file.Close()
+						writer = file
+					}
+
+					encoder := json.NewEncoder(writer)
+					encoder.SetIndent("", "  ")
+					if err := encoder.Encode(report); err != nil {
+						return err
+					}
+
+					if !report.Valid() {
+						return cli.Exit(fmt.Sprintf("%d issues found", len(report.Issues)), 1)
+					}
+					return nil
+				},
+			},
 		},
 	}
 
@@ -114,3 +376,43 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func compression(gzip bool) exchange.Compression {
+	if gzip {
+		return exchange.CompressionGzip
+	}
+	return exchange.CompressionNone
+}
+
+// readCandles reads a binary candle file or a CSV file
+func readCandles(feed exchange.PairFeed) ([]model.Candle, error) {
+	if _, err := exchange.ReadBinaryHeader(feed.File); errors.Is(err, exchange.ErrInvalidBinaryFile) {
+		return exchange.ReadCSVCandles(feed)
+	} else if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(feed.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, candles, err := exchange.ReadBinaryCandles(file)
+	return candles, err
+}
+
+func writeCandles(output string, candles []model.Candle) error {
+	writer, err := download.NewCSVWriter(output, exchange.DefaultAssetsInfo("").QuotePrecision)
+	if err != nil {
+		return err
+	}
+
+	for _, candle := range candles {
+		if err := writer.Write(candle); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,554 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xhit/go-str2duration/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+const cachePageSize = 1000
+
+var ErrCacheOffline = errors.New("cache without source exchange")
+
+// Range is an interval of candles, Start and End are the open times of the first and the last candle
+type Range struct {
+	Start time.Time
+	End   time.Time
+}
+
+type cachedCandle struct {
+	Exchange  string `gorm:"primaryKey"`
+	Pair      string `gorm:"primaryKey"`
+	Timeframe string `gorm:"primaryKey"`
+	Time      int64  `gorm:"primaryKey;autoIncrement:false"`
+	Open      float64
+	Close     float64
+	Low       float64
+	High      float64
+	Volume    float64
+	Metadata  string
+}
+
+func (cachedCandle) TableName() string {
+	return "candle_cache"
+}
+
+// cachedRange is an interval already downloaded from the exchange, including missing candles
+type cachedRange struct {
+	ID        uint   `gorm:"primaryKey"`
+	Exchange  string `gorm:"index:idx_candle_cache_range"`
+	Pair      string `gorm:"index:idx_candle_cache_range"`
+	Timeframe string `gorm:"index:idx_candle_cache_range"`
+	StartTime int64
+	EndTime   int64
+}
+
+func (cachedRange) TableName() string {
+	return "candle_cache_ranges"
+}
+
+// Cache is a local candle repository keyed by exchange, pair and timeframe. It records the downloaded
+// ranges, so only missing ranges are requested to the source exchange.
+type Cache struct {
+	mtx      sync.Mutex
+	db       *gorm.DB
+	exchange string
+	source   service.Feeder
+}
+
+// NewCache creates a candle cache in a SQL database. The source is used to download missing candles,
+// it can be nil to use only the local data. Example of usage:
+//
+//	import "github.com/glebarez/sqlite"
+//	cache, err := download.NewCache(sqlite.Open("candles.db"), "binance", binance)
+func NewCache(dialect gorm.Dialector, exchange string, source service.Feeder, opts ...gorm.Option) (*Cache, error) {
+	db, err := gorm.Open(dialect, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AutoMigrate(&cachedCandle{}, &cachedRange{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		db:       db,
+		exchange: exchange,
+		source:   source,
+	}, nil
+}
+
+// alignTime returns the open time of the candle that contains t. Go zero time is a Monday
+// at midnight UTC, so weekly and daily candles are aligned as in Binance.
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	return t.Truncate(interval).UTC()
+}
+
+// bounds returns the open times of the first and the last complete candles of an interval
+func bounds(timeframe string, start, end time.Time) (first, last time.Time, interval time.Duration, err error) {
+	interval, err = str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return first, last, interval, err
+	}
+
+	first = alignTime(start, interval)
+	if first.Before(start) {
+		first = first.Add(interval)
+	}
+
+	last = alignTime(end, interval)
+	if lastComplete := alignTime(time.Now(), interval).Add(-interval); last.After(lastComplete) {
+		last = lastComplete
+	}
+
+	return first, last, interval, nil
+}
+
+// Ranges returns the downloaded ranges of a pair in a timeframe
+func (c *Cache) Ranges(pair, timeframe string) ([]Range, error) {
+	return c.ranges(c.db, pair, timeframe)
+}
+
+func (c *Cache) ranges(db *gorm.DB, pair, timeframe string) ([]Range, error) {
+	var rows []cachedRange
+	result := db.Where("exchange = ? AND pair = ? AND timeframe = ?", c.exchange, pair, timeframe).
+		Order("start_time").Find(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	ranges := make([]Range, 0, len(rows))
+	for _, row := range rows {
+		ranges = append(ranges, Range{
+			Start: time.Unix(row.StartTime, 0).UTC(),
+			End:   time.Unix(row.EndTime, 0).UTC(),
+		})
+	}
+	return ranges, nil
+}
+
+// Missing returns the ranges of the interval that were not downloaded yet
+func (c *Cache) Missing(pair, timeframe string, start, end time.Time) ([]Range, error) {
+	first, last, interval, err := bounds(timeframe, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := c.Ranges(pair, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	return subtractRanges(Range{Start: first, End: last}, ranges, interval), nil
+}
+
+// subtractRanges returns the parts of target not covered by the sorted ranges
+func subtractRanges(target Range, ranges []Range, interval time.Duration) []Range {
+	missing := make([]Range, 0)
+	next := target.Start
+	for _, r := range ranges {
+		if next.After(target.End) {
+			break
+		}
+		if r.End.Before(next) {
+			continue
+		}
+		if r.Start.After(next) {
+			end := r.Start.Add(-interval)
+			if end.After(target.End) {
+				end = target.End
+			}
+			missing = append(missing, Range{Start: next, End: end})
+		}
+		next = r.End.Add(interval)
+	}
+
+	if !next.After(target.End) {
+		missing = append(missing, Range{Start: next, End: target.End})
+	}
+	return missing
+}
+
+// mergeRanges joins overlapping and adjacent ranges
+func mergeRanges(ranges []Range, interval time.Duration) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start.Before(ranges[j].Start)
+	})
+
+	merged := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if !r.Start.After(last.End.Add(interval)) {
+				if r.End.After(last.End) {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// CandlesFetcher downloads the candles of a period, eg. the CandlesByPeriod of an exchange
+type CandlesFetcher func(ctx context.Context, pair, timeframe string, start, end time.Time) ([]model.Candle, error)
+
+// Sync downloads the complete candles of the interval that are not in the cache yet. Each batch is
+// saved when downloaded, so an interrupted sync is resumed in the next call.
+func (c *Cache) Sync(ctx context.Context, pair, timeframe string, start, end time.Time) error {
+	if c.source == nil {
+		return ErrCacheOffline
+	}
+	return c.SyncWith(ctx, c.source.CandlesByPeriod, pair, timeframe, start, end)
+}
+
+// SyncWith is like Sync, but the missing candles are downloaded with fetch, eg. to share the rate limit
+// and the retries of a download. Concurrent syncs lock the cache only to read and save the candles.
+func (c *Cache) SyncWith(ctx context.Context, fetch CandlesFetcher, pair, timeframe string,
+	start, end time.Time) error {
+
+	c.mtx.Lock()
+	missing, err := c.Missing(pair, timeframe, start, end)
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range missing {
+		log.Infof("[CACHE] Downloading %s %s from %s to %s", pair, timeframe,
+			r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+
+		for begin := r.Start; !begin.After(r.End); begin = begin.Add(interval * batchSize) {
+			end := begin.Add(interval * (batchSize - 1))
+			if end.After(r.End) {
+				end = r.End
+			}
+
+			candles, err := fetch(ctx, pair, timeframe, begin, end)
+			if err != nil {
+				return err
+			}
+
+			c.mtx.Lock()
+			err = c.store(pair, timeframe, candles, Range{Start: begin, End: end}, interval)
+			c.mtx.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// store saves the candles of a downloaded range and records the range
+func (c *Cache) store(pair, timeframe string, candles []model.Candle, downloaded Range,
+	interval time.Duration) error {
+
+	rows := make([]cachedCandle, 0, len(candles))
+	for _, candle := range candles {
+		if candle.Time.Before(downloaded.Start) || candle.Time.After(downloaded.End) {
+			continue
+		}
+
+		row := cachedCandle{
+			Exchange:  c.exchange,
+			Pair:      pair,
+			Timeframe: timeframe,
+			Time:      candle.Time.Unix(),
+			Open:      candle.Open,
+			Close:     candle.Close,
+			Low:       candle.Low,
+			High:      candle.High,
+			Volume:    candle.Volume,
+		}
+
+		if len(candle.Metadata) > 0 {
+			metadata, err := json.Marshal(candle.Metadata)
+			if err != nil {
+				return err
+			}
+			row.Metadata = string(metadata)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			result := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(rows, batchSize)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		ranges, err := c.ranges(tx, pair, timeframe)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("exchange = ? AND pair = ? AND timeframe = ?", c.exchange, pair, timeframe).
+			Delete(&cachedRange{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, r := range mergeRanges(append(ranges, downloaded), interval) {
+			result = tx.Create(&cachedRange{
+				Exchange:  c.exchange,
+				Pair:      pair,
+				Timeframe: timeframe,
+				StartTime: r.Start.Unix(),
+				EndTime:   r.End.Unix(),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+}
+
+// Gaps returns the ranges of missing candles in the interval, both not downloaded and
+// not provided by the exchange
+func (c *Cache) Gaps(pair, timeframe string, start, end time.Time) ([]Range, error) {
+	first, last, interval, err := bounds(timeframe, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var times []int64
+	result := c.db.Model(&cachedCandle{}).
+		Where("exchange = ? AND pair = ? AND timeframe = ? AND time >= ? AND time <= ?",
+			c.exchange, pair, timeframe, first.Unix(), last.Unix()).
+		Order("time").Pluck("time", &times)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	gaps := make([]Range, 0)
+	next := first
+	for _, timestamp := range times {
+		t := time.Unix(timestamp, 0).UTC()
+		if t.After(next) {
+			gaps = append(gaps, Range{Start: next, End: t.Add(-interval)})
+		}
+		next = t.Add(interval)
+	}
+
+	if !next.After(last) {
+		gaps = append(gaps, Range{Start: next, End: last})
+	}
+	return gaps, nil
+}
+
+// Candles returns the cached candles of the interval, sorted by time. The result is limited
+// to the given number of candles if limit is greater than zero.
+func (c *Cache) Candles(pair, timeframe string, start, end time.Time, limit int) ([]model.Candle, error) {
+	query := c.db.Where("exchange = ? AND pair = ? AND timeframe = ? AND time >= ? AND time <= ?",
+		c.exchange, pair, timeframe, start.Unix(), end.Unix()).Order("time")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var rows []cachedCandle
+	if result := query.Find(&rows); result.Error != nil {
+		return nil, result.Error
+	}
+
+	candles := make([]model.Candle, 0, len(rows))
+	for _, row := range rows {
+		t := time.Unix(row.Time, 0).UTC()
+		candle := model.Candle{
+			Pair:      pair,
+			Time:      t,
+			UpdatedAt: t,
+			Open:      row.Open,
+			Close:     row.Close,
+			Low:       row.Low,
+			High:      row.High,
+			Volume:    row.Volume,
+			Complete:  true,
+		}
+
+		if row.Metadata != "" {
+			if err := json.Unmarshal([]byte(row.Metadata), &candle.Metadata); err != nil {
+				return nil, err
+			}
+		}
+
+		candles = append(candles, candle)
+	}
+
+	return candles, nil
+}
+
+// CacheFeed is a data feed of the cached candles in an interval, for backtests. Missing candles
+// are downloaded from the source exchange of the cache before reading.
+type CacheFeed struct {
+	mtx    sync.Mutex
+	cache  *Cache
+	start  time.Time
+	end    time.Time
+	synced map[string]bool
+	offset map[string]time.Time
+}
+
+// Feed returns a data feed of the candles between start and end
+func (c *Cache) Feed(start, end time.Time) *CacheFeed {
+	return &CacheFeed{
+		cache:  c,
+		start:  start,
+		end:    end,
+		synced: make(map[string]bool),
+		offset: make(map[string]time.Time),
+	}
+}
+
+func (f *CacheFeed) key(pair, timeframe string) string {
+	return fmt.Sprintf("%s--%s", pair, timeframe)
+}
+
+// sync downloads the missing candles of the feed interval once per pair and timeframe
+func (f *CacheFeed) sync(ctx context.Context, pair, timeframe string) error {
+	if f.cache.source == nil {
+		return nil
+	}
+
+	key := f.key(pair, timeframe)
+	f.mtx.Lock()
+	synced := f.synced[key]
+	f.mtx.Unlock()
+	if synced {
+		return nil
+	}
+
+	if err := f.cache.Sync(ctx, pair, timeframe, f.start, f.end); err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	f.synced[key] = true
+	f.mtx.Unlock()
+	return nil
+}
+
+// next returns the next unread candles of the feed and marks them as read
+func (f *CacheFeed) next(pair, timeframe string, limit int) ([]model.Candle, error) {
+	key := f.key(pair, timeframe)
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	start, ok := f.offset[key]
+	if !ok {
+		start = f.start
+	}
+
+	candles, err := f.cache.Candles(pair, timeframe, start, f.end, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candles) > 0 {
+		f.offset[key] = candles[len(candles)-1].Time.Add(time.Second)
+	}
+	return candles, nil
+}
+
+func (f *CacheFeed) AssetsInfo(pair string) model.AssetInfo {
+	if f.cache.source != nil {
+		return f.cache.source.AssetsInfo(pair)
+	}
+	return exchange.DefaultAssetsInfo(pair)
+}
+
+func (f *CacheFeed) LastQuote(ctx context.Context, pair string) (float64, error) {
+	if f.cache.source != nil {
+		return f.cache.source.LastQuote(ctx, pair)
+	}
+	return 0, errors.New("invalid operation")
+}
+
+func (f *CacheFeed) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	if f.cache.source != nil {
+		if err := f.cache.Sync(ctx, pair, timeframe, start, end); err != nil {
+			return nil, err
+		}
+	}
+	return f.cache.Candles(pair, timeframe, start, end, 0)
+}
+
+// CandlesByLimit returns the first candles of the feed, they are not sent again by the subscription
+func (f *CacheFeed) CandlesByLimit(ctx context.Context, pair, timeframe string, limit int) ([]model.Candle, error) {
+	if err := f.sync(ctx, pair, timeframe); err != nil {
+		return nil, err
+	}
+
+	candles, err := f.next(pair, timeframe, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candles) < limit {
+		return nil, fmt.Errorf("%w: %s", exchange.ErrInsufficientData, pair)
+	}
+	return candles, nil
+}
+
+func (f *CacheFeed) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error) {
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error, 1)
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+
+		if err := f.sync(ctx, pair, timeframe); err != nil {
+			cerr <- err
+			return
+		}
+
+		for {
+			candles, err := f.next(pair, timeframe, cachePageSize)
+			if err != nil {
+				cerr <- err
+				return
+			}
+
+			for _, candle := range candles {
+				select {
+				case ccandle <- candle:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if len(candles) < cachePageSize {
+				return
+			}
+		}
+	}()
+	return ccandle, cerr
+}
@@ -0,0 +1,154 @@
+package download
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// fakeSource returns hourly candles, except the missing ones, and records the requested periods
+type fakeSource struct {
+	service.Feeder
+	missing  map[time.Time]bool
+	requests []Range
+}
+
+func (f *fakeSource) CandlesByPeriod(_ context.Context, pair, _ string, start, end time.Time) ([]model.Candle, error) {
+	f.requests = append(f.requests, Range{Start: start, End: end})
+	candles := make([]model.Candle, 0)
+	for t := start; !t.After(end); t = t.Add(time.Hour) {
+		if f.missing[t] {
+			continue
+		}
+		candles = append(candles, model.Candle{
+			Pair: pair, Time: t, UpdatedAt: t, Close: float64(t.Hour()), Volume: 1, Complete: true,
+			Metadata: map[string]float64{"trades": 10},
+		})
+	}
+	return candles, nil
+}
+
+func newTestCache(t *testing.T, source service.Feeder) *Cache {
+	cache, err := NewCache(sqlite.Open(filepath.Join(t.TempDir(), "cache.db")), "binance", source,
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	return cache
+}
+
+func TestCache_Sync(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{missing: map[time.Time]bool{day.Add(5 * time.Hour): true}}
+	cache := newTestCache(t, source)
+
+	err := cache.Sync(ctx, "BTCUSDT", "1h", day, day.Add(9*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []Range{{Start: day, End: day.Add(9 * time.Hour)}}, source.requests)
+
+	// only the missing range is requested
+	source.requests = nil
+	err = cache.Sync(ctx, "BTCUSDT", "1h", day.Add(5*time.Hour), day.Add(12*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []Range{{Start: day.Add(10 * time.Hour), End: day.Add(12 * time.Hour)}}, source.requests)
+
+	source.requests = nil
+	err = cache.Sync(ctx, "BTCUSDT", "1h", day.Add(20*time.Minute), day.Add(12*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, source.requests)
+
+	ranges, err := cache.Ranges("BTCUSDT", "1h")
+	require.NoError(t, err)
+	require.Equal(t, []Range{{Start: day, End: day.Add(12 * time.Hour)}}, ranges)
+
+	// missing candles of the exchange and not downloaded ranges are gaps
+	gaps, err := cache.Gaps("BTCUSDT", "1h", day, day.Add(15*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []Range{
+		{Start: day.Add(5 * time.Hour), End: day.Add(5 * time.Hour)},
+		{Start: day.Add(13 * time.Hour), End: day.Add(15 * time.Hour)},
+	}, gaps)
+
+	missing, err := cache.Missing("BTCUSDT", "1h", day, day.Add(15*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []Range{{Start: day.Add(13 * time.Hour), End: day.Add(15 * time.Hour)}}, missing)
+
+	candles, err := cache.Candles("BTCUSDT", "1h", day, day.Add(12*time.Hour), 0)
+	require.NoError(t, err)
+	require.Len(t, candles, 12)
+	require.Equal(t, day.Add(time.Hour), candles[1].Time)
+	require.Equal(t, 1.0, candles[1].Close)
+	require.Equal(t, 10.0, candles[1].Metadata["trades"])
+}
+
+func TestCache_Feed(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &fakeSource{}
+	cache := newTestCache(t, source)
+
+	err := cache.Sync(ctx, "BTCUSDT", "1h", day, day.Add(23*time.Hour))
+	require.NoError(t, err)
+
+	t.Run("offline", func(t *testing.T) {
+		offline := &Cache{db: cache.db, exchange: "binance"}
+		require.ErrorIs(t, offline.Sync(ctx, "BTCUSDT", "1h", day, day.Add(time.Hour)), ErrCacheOffline)
+
+		feed := offline.Feed(day, day.Add(23*time.Hour))
+		require.Equal(t, "USDT", feed.AssetsInfo("BTCUSDT").QuoteAsset)
+
+		warmup, err := feed.CandlesByLimit(ctx, "BTCUSDT", "1h", 4)
+		require.NoError(t, err)
+		require.Len(t, warmup, 4)
+
+		candles := make([]model.Candle, 0)
+		ccandle, cerr := feed.CandlesSubscription(ctx, "BTCUSDT", "1h")
+		for candle := range ccandle {
+			candles = append(candles, candle)
+		}
+		require.NoError(t, <-cerr)
+		require.Len(t, candles, 20)
+		require.Equal(t, day.Add(4*time.Hour), candles[0].Time)
+
+		_, err = feed.CandlesByLimit(ctx, "BTCUSDT", "1h", 1)
+		require.Error(t, err)
+	})
+
+	t.Run("download missing candles", func(t *testing.T) {
+		source.requests = nil
+		feed := cache.Feed(day.Add(20*time.Hour), day.Add(26*time.Hour))
+		candles, err := feed.CandlesByLimit(ctx, "BTCUSDT", "1h", 7)
+		require.NoError(t, err)
+		require.Len(t, candles, 7)
+		require.Equal(t, []Range{{Start: day.Add(24 * time.Hour), End: day.Add(26 * time.Hour)}}, source.requests)
+	})
+}
+
+func TestRanges(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time {
+		return day.Add(time.Duration(h) * time.Hour)
+	}
+
+	merged := mergeRanges([]Range{
+		{Start: hour(5), End: hour(8)},
+		{Start: hour(0), End: hour(2)},
+		{Start: hour(3), End: hour(4)},
+		{Start: hour(10), End: hour(12)},
+	}, time.Hour)
+	require.Equal(t, []Range{{Start: hour(0), End: hour(8)}, {Start: hour(10), End: hour(12)}}, merged)
+
+	missing := subtractRanges(Range{Start: hour(1), End: hour(14)}, merged, time.Hour)
+	require.Equal(t, []Range{{Start: hour(9), End: hour(9)}, {Start: hour(13), End: hour(14)}}, missing)
+
+	// weekly candles start on Monday
+	require.Equal(t, time.Monday, alignTime(hour(100), 7*24*time.Hour).Weekday())
+}
@@ -2,13 +2,20 @@ package download
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jpillora/backoff"
 	"github.com/schollz/progressbar/v3"
 	"github.com/xhit/go-str2duration/v2"
 
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/tools/log"
 )
@@ -26,8 +33,31 @@ func NewDownloader(exchange service.Feeder) Downloader {
 }
 
 type Parameters struct {
-	Start time.Time
-	End   time.Time
+	Start       time.Time
+	End         time.Time
+	Binary      bool
+	Compression exchange.Compression
+	Cache       *Cache
+	Concurrency int
+	Retries     int
+	RetryWait   time.Duration
+	RateLimit   int
+	RatePeriod  time.Duration
+	Resume      bool
+	Metadata    MetadataSource
+}
+
+// MetadataSource adds metadata to downloaded candles, written as additional CSV columns,
+// eg. exchange.FuturesData
+type MetadataSource interface {
+	AddMetadata(ctx context.Context, pair, timeframe string, candles []model.Candle) error
+}
+
+// WaitMetadataSource is a metadata source with several requests for a batch of candles, wait is called
+// before each one to share the rate limit of the download, eg. exchange.FuturesData
+type WaitMetadataSource interface {
+	AddMetadataWait(ctx context.Context, pair, timeframe string, candles []model.Candle,
+		wait func(context.Context) error) error
 }
 
 type Option func(*Parameters)
@@ -46,6 +76,84 @@ func WithDays(days int) Option {
 	}
 }
 
+// WithBinaryFormat writes the candles in the binary columnar format of exchange.NewBinaryFeed
+func WithBinaryFormat(compression exchange.Compression) Option {
+	return func(parameters *Parameters) {
+		parameters.Binary = true
+		parameters.Compression = compression
+	}
+}
+
+// WithCache downloads only the candles missing in the cache, the output is written from the cache
+func WithCache(cache *Cache) Option {
+	return func(parameters *Parameters) {
+		parameters.Cache = cache
+	}
+}
+
+// WithConcurrency sets the number of concurrent downloads of DownloadAll, default is 4
+func WithConcurrency(concurrency int) Option {
+	return func(parameters *Parameters) {
+		parameters.Concurrency = concurrency
+	}
+}
+
+// WithRetries retries a failed batch, waiting an exponential backoff starting at wait, default is 3 retries
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(parameters *Parameters) {
+		parameters.Retries = retries
+		parameters.RetryWait = wait
+	}
+}
+
+// WithRateLimit limits the requests of all concurrent downloads, default is 10 requests per second
+func WithRateLimit(requests int, period time.Duration) Option {
+	return func(parameters *Parameters) {
+		parameters.RateLimit = requests
+		parameters.RatePeriod = period
+	}
+}
+
+// WithResume appends the candles after the last one of an existing output file
+func WithResume() Option {
+	return func(parameters *Parameters) {
+		parameters.Resume = true
+	}
+}
+
+// WithMetadata adds the metadata of a source to the candles, eg. funding rate and open interest
+// of exchange.NewFuturesData
+func WithMetadata(source MetadataSource) Option {
+	return func(parameters *Parameters) {
+		parameters.Metadata = source
+	}
+}
+
+// Job is the download of a pair and timeframe to an output file
+type Job struct {
+	Pair      string
+	Timeframe string
+	Output    string
+}
+
+// Jobs returns a job for each pair and timeframe, the output is a path template with
+// the placeholders {pair} and {timeframe}, eg. ./data/{pair}-{timeframe}.csv
+func Jobs(pairs, timeframes []string, output string) ([]Job, error) {
+	jobs := make([]Job, 0, len(pairs)*len(timeframes))
+	outputs := make(map[string]bool)
+	for _, pair := range pairs {
+		for _, timeframe := range timeframes {
+			path := strings.NewReplacer("{pair}", pair, "{timeframe}", timeframe).Replace(output)
+			if outputs[path] {
+				return nil, fmt.Errorf("duplicated output %s, use the placeholders {pair} and {timeframe}", path)
+			}
+			outputs[path] = true
+			jobs = append(jobs, Job{Pair: pair, Timeframe: timeframe, Output: path})
+		}
+	}
+	return jobs, nil
+}
+
 func candlesCount(start, end time.Time, timeframe string) (int, time.Duration, error) {
 	totalDuration := end.Sub(start)
 	interval, err := str2duration.ParseDuration(timeframe)
@@ -56,16 +164,21 @@ func candlesCount(start, end time.Time, timeframe string) (int, time.Duration, e
 }
 
 func (d Downloader) Download(ctx context.Context, pair, timeframe string, output string, options ...Option) error {
-	recordFile, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer recordFile.Close()
+	return d.DownloadAll(ctx, []Job{{Pair: pair, Timeframe: timeframe, Output: output}}, options...)
+}
 
+// DownloadAll runs the download jobs concurrently, sharing the rate limit. It returns the errors of
+// all failed jobs.
+func (d Downloader) DownloadAll(ctx context.Context, jobs []Job, options ...Option) error {
 	now := time.Now()
 	parameters := &Parameters{
-		Start: now.AddDate(0, -1, 0),
-		End:   now,
+		Start:       now.AddDate(0, -1, 0),
+		End:         now,
+		Concurrency: 4,
+		Retries:     3,
+		RetryWait:   time.Second,
+		RateLimit:   10,
+		RatePeriod:  time.Second,
 	}
 
 	for _, option := range options {
@@ -82,27 +195,156 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 		parameters.End = now
 	}
 
-	candlesCount, interval, err := candlesCount(parameters.Start, parameters.End, timeframe)
+	total := 0
+	for _, job := range jobs {
+		count, _, err := candlesCount(parameters.Start, parameters.End, job.Timeframe)
+		if err != nil {
+			return err
+		}
+		total += count + 1
+	}
+
+	limiter := newRateLimiter(parameters.RateLimit, parameters.RatePeriod)
+	progressBar := progressbar.Default(int64(total))
+	defer func() {
+		if err := progressBar.Close(); err != nil {
+			log.Warnf("close progresbar fail: %s", err.Error())
+		}
+	}()
+
+	var (
+		wg     sync.WaitGroup
+		mtx    sync.Mutex
+		errs   []error
+		tokens = make(chan struct{}, max(parameters.Concurrency, 1))
+	)
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+
+			if err := d.download(ctx, job, *parameters, limiter, progressBar); err != nil {
+				mtx.Lock()
+				errs = append(errs, fmt.Errorf("%s %s: %w", job.Pair, job.Timeframe, err))
+				mtx.Unlock()
+			}
+		}(job)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	log.Info("Done!")
+	return nil
+}
+
+// lastCandleTime returns the time of the last complete candle of an output file
+func lastCandleTime(output string, binary bool) (time.Time, bool, error) {
+	if _, err := os.Stat(output); os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	}
+
+	if binary {
+		candles, err := readBinaryOutput(output)
+		if err != nil || len(candles) == 0 {
+			return time.Time{}, false, err
+		}
+		return candles[len(candles)-1].Time, true, nil
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer file.Close()
+
+	// the last line is in the end of the file, a partial row is removed by AppendCSVWriter
+	size, err := completeRowsSize(file)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	offset := max(size-4096, 0)
+	tail := make([]byte, size-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil {
+		return time.Time{}, false, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(tail)), "\n")
+	fields := strings.Split(lines[len(lines)-1], ",")
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		// only the header
+		return time.Time{}, false, nil
+	}
+	return time.Unix(timestamp, 0).UTC(), true, nil
+}
+
+func (d Downloader) download(ctx context.Context, job Job, parameters Parameters, limiter *rateLimiter,
+	progressBar *progressbar.ProgressBar) error {
+
+	candlesCount, interval, err := candlesCount(parameters.Start, parameters.End, job.Timeframe)
 	if err != nil {
 		return err
 	}
 	candlesCount++
 
-	log.Infof("Downloading %d candles of %s for %s", candlesCount, timeframe, pair)
-	info := d.exchange.AssetsInfo(pair)
-	writer := csv.NewWriter(recordFile)
+	info := d.exchange.AssetsInfo(job.Pair)
+	header := exchange.BinaryHeader{
+		Pair:        job.Pair,
+		Timeframe:   job.Timeframe,
+		Compression: parameters.Compression,
+	}
 
-	progressBar := progressbar.Default(int64(candlesCount))
-	lostData := 0
-	isLastLoop := false
+	var writer CandleWriter
+	if parameters.Resume {
+		last, ok, err := lastCandleTime(job.Output, parameters.Binary)
+		if err != nil {
+			return err
+		}
 
-	// write headers
-	err = writer.Write([]string{
-		"time", "open", "close", "low", "high", "volume",
-	})
+		if ok && !last.Before(parameters.Start) {
+			skipped := min(int(last.Sub(parameters.Start)/interval)+1, candlesCount)
+			candlesCount -= skipped
+			if err := progressBar.Add(skipped); err != nil {
+				log.Warnf("update progresbar fail: %s", err.Error())
+			}
+
+			parameters.Start = last.Add(interval)
+			if !parameters.Start.Before(parameters.End) {
+				log.Infof("%s already has the candles of %s for %s", job.Output, job.Timeframe, job.Pair)
+				return nil
+			}
+			log.Infof("Resuming %s from %s", job.Output, parameters.Start.Format(time.RFC3339))
+		}
+
+		if parameters.Binary {
+			writer, err = AppendBinaryWriter(job.Output, header)
+		} else {
+			writer, err = AppendCSVWriter(job.Output, info.QuotePrecision)
+		}
+	} else if parameters.Binary {
+		writer, err = NewBinaryWriter(job.Output, header)
+	} else {
+		writer, err = NewCSVWriter(job.Output, info.QuotePrecision)
+	}
 	if err != nil {
 		return err
 	}
+	defer writer.Close()
+
+	log.Infof("Downloading %d candles of %s for %s", candlesCount, job.Timeframe, job.Pair)
+
+	if parameters.Cache != nil {
+		return d.downloadCached(ctx, parameters.Cache, job.Pair, job.Timeframe, &parameters, limiter, writer)
+	}
+
+	lostData := 0
+	isLastLoop := false
 
 	for begin := parameters.Start; begin.Before(parameters.End); begin = begin.Add(interval * batchSize) {
 		end := begin.Add(interval * batchSize)
@@ -113,13 +355,17 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 			isLastLoop = true
 		}
 
-		candles, err := d.exchange.CandlesByPeriod(ctx, pair, timeframe, begin, end)
+		candles, err := d.candlesByPeriod(ctx, parameters, limiter, job.Pair, job.Timeframe, begin, end)
 		if err != nil {
 			return err
 		}
 
+		if err := addMetadata(ctx, parameters, limiter, job.Pair, job.Timeframe, candles); err != nil {
+			return err
+		}
+
 		for _, candle := range candles {
-			err := writer.Write(candle.ToSlice(info.QuotePrecision))
+			err := writer.Write(candle)
 			if err != nil {
 				return err
 			}
@@ -135,15 +381,95 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 		}
 	}
 
-	if err = progressBar.Close(); err != nil {
-		log.Warnf("close progresbar fail: %s", err.Error())
+	if lostData > 0 {
+		log.Warnf("%d missing candles of %s for %s", lostData, job.Timeframe, job.Pair)
 	}
 
-	if lostData > 0 {
-		log.Warnf("%d missing candles", lostData)
+	return writer.Close()
+}
+
+// candlesByPeriod fetches a batch of candles under the rate limit, retrying with backoff on errors
+func (d Downloader) candlesByPeriod(ctx context.Context, parameters Parameters, limiter *rateLimiter,
+	pair, timeframe string, start, end time.Time) ([]model.Candle, error) {
+
+	ba := &backoff.Backoff{
+		Min: parameters.RetryWait,
+		Max: 30 * time.Second,
 	}
 
-	writer.Flush()
-	log.Info("Done!")
-	return writer.Error()
+	for attempt := 0; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		candles, err := d.exchange.CandlesByPeriod(ctx, pair, timeframe, start, end)
+		if err == nil || attempt >= parameters.Retries {
+			return candles, err
+		}
+
+		wait := ba.Duration()
+		log.Warnf("download of %s %s failed, retrying in %s: %v", pair, timeframe, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// addMetadata adds the metadata of the source to a batch of candles, if any
+func addMetadata(ctx context.Context, parameters Parameters, limiter *rateLimiter, pair, timeframe string,
+	candles []model.Candle) error {
+
+	if parameters.Metadata == nil {
+		return nil
+	}
+
+	if source, ok := parameters.Metadata.(WaitMetadataSource); ok {
+		return source.AddMetadataWait(ctx, pair, timeframe, candles, limiter.Wait)
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return parameters.Metadata.AddMetadata(ctx, pair, timeframe, candles)
+}
+
+func (d Downloader) downloadCached(ctx context.Context, cache *Cache, pair, timeframe string,
+	parameters *Parameters, limiter *rateLimiter, writer CandleWriter) error {
+
+	fetch := func(ctx context.Context, pair, timeframe string, start, end time.Time) ([]model.Candle, error) {
+		return d.candlesByPeriod(ctx, *parameters, limiter, pair, timeframe, start, end)
+	}
+
+	err := cache.SyncWith(ctx, fetch, pair, timeframe, parameters.Start, parameters.End)
+	if err != nil {
+		return err
+	}
+
+	candles, err := cache.Candles(pair, timeframe, parameters.Start, parameters.End, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := addMetadata(ctx, *parameters, limiter, pair, timeframe, candles); err != nil {
+		return err
+	}
+
+	for _, candle := range candles {
+		if err := writer.Write(candle); err != nil {
+			return err
+		}
+	}
+
+	gaps, err := cache.Gaps(pair, timeframe, parameters.Start, parameters.End)
+	if err != nil {
+		return err
+	}
+
+	for _, gap := range gaps {
+		log.Warnf("missing candles from %s to %s", gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339))
+	}
+
+	return writer.Close()
 }
@@ -2,11 +2,15 @@ package download
 
 import (
 	"context"
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/service"
 
 	"github.com/stretchr/testify/assert"
@@ -97,4 +101,264 @@ func TestDownloader_download(t *testing.T) {
 		require.NoError(t, err)
 		require.Len(t, csvFeed.CandlePairTimeFrame["BTCUSDT--1d"], 14)
 	})
+	t.Run("binary", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "btc.bin")
+		err = downloader.Download(ctx, "BTCUSDT", "1d", output, WithInterval(param.Start, param.End),
+			WithBinaryFormat(exchange.CompressionGzip))
+		require.NoError(t, err)
+
+		header, err := exchange.ReadBinaryHeader(output)
+		require.NoError(t, err)
+		require.Equal(t, "BTCUSDT", header.Pair)
+		require.Equal(t, "1d", header.Timeframe)
+		require.Equal(t, 14, header.Rows)
+
+		feed, err := exchange.NewBinaryFeed("1d", exchange.PairFeed{File: output})
+		require.NoError(t, err)
+		require.Equal(t, csvFeed.CandlePairTimeFrame, feed.CandlePairTimeFrame)
+	})
+}
+
+func TestConvertCSV(t *testing.T) {
+	feed := exchange.PairFeed{
+		Pair:      "BTCUSDT",
+		File:      "../testdata/btc-1d-header.csv",
+		Timeframe: "1d",
+	}
+	output := filepath.Join(t.TempDir(), "btc.bin")
+	err := ConvertCSV(feed, output, exchange.CompressionNone)
+	require.NoError(t, err)
+
+	expected, err := exchange.NewCSVFeed("1d", feed)
+	require.NoError(t, err)
+
+	binaryFeed, err := exchange.NewBinaryFeed("1d", exchange.PairFeed{File: output})
+	require.NoError(t, err)
+	require.Equal(t, expected.CandlePairTimeFrame, binaryFeed.CandlePairTimeFrame)
+	require.Equal(t, 2174544.0, binaryFeed.CandlePairTimeFrame["BTCUSDT--1d"][0].Metadata["trades"])
+}
+
+func TestJobs(t *testing.T) {
+	jobs, err := Jobs([]string{"BTCUSDT", "ETHUSDT"}, []string{"1h"}, "./{pair}-{timeframe}.csv")
+	require.NoError(t, err)
+	require.Equal(t, []Job{
+		{Pair: "BTCUSDT", Timeframe: "1h", Output: "./BTCUSDT-1h.csv"},
+		{Pair: "ETHUSDT", Timeframe: "1h", Output: "./ETHUSDT-1h.csv"},
+	}, jobs)
+
+	_, err = Jobs([]string{"BTCUSDT"}, []string{"1h", "4h"}, "./{pair}.csv")
+	require.Error(t, err)
+}
+
+// flakyFeeder fails the first requests of each pair
+type flakyFeeder struct {
+	*fakeSource
+	mtx      sync.Mutex
+	failures map[string]int
+}
+
+func (f *flakyFeeder) AssetsInfo(pair string) model.AssetInfo {
+	return exchange.DefaultAssetsInfo(pair)
+}
+
+func (f *flakyFeeder) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.failures[pair] > 0 {
+		f.failures[pair]--
+		return nil, errors.New("connection reset")
+	}
+	return f.fakeSource.CandlesByPeriod(ctx, pair, timeframe, start, end)
+}
+
+// fundingSource sets a funding rate of the candle hour
+type fundingSource struct{}
+
+func (fundingSource) AddMetadata(_ context.Context, _, _ string, candles []model.Candle) error {
+	for i := range candles {
+		candles[i].Metadata["funding_rate"] = float64(candles[i].Time.Hour()) / 1000
+	}
+	return nil
+}
+
+// waitSource is a funding source with a request per candle
+type waitSource struct {
+	fundingSource
+	waits int
+}
+
+func (s *waitSource) AddMetadataWait(ctx context.Context, pair, timeframe string, candles []model.Candle,
+	wait func(context.Context) error) error {
+
+	for range candles {
+		if err := wait(ctx); err != nil {
+			return err
+		}
+		s.waits++
+	}
+	return s.AddMetadata(ctx, pair, timeframe, candles)
+}
+
+func TestDownloader_DownloadAll(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	jobs, err := Jobs([]string{"BTCUSDT", "ETHUSDT"}, []string{"1h"}, filepath.Join(dir, "{pair}.csv"))
+	require.NoError(t, err)
+
+	t.Run("retries", func(t *testing.T) {
+		feeder := &flakyFeeder{fakeSource: &fakeSource{}, failures: map[string]int{"BTCUSDT": 2, "ETHUSDT": 1}}
+		downloader := NewDownloader(feeder)
+
+		err := downloader.DownloadAll(ctx, jobs, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithRetries(2, time.Millisecond), WithRateLimit(0, 0))
+		require.NoError(t, err)
+
+		for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+			candles, err := exchange.ReadCSVCandles(exchange.PairFeed{Pair: pair, File: filepath.Join(dir, pair+".csv")})
+			require.NoError(t, err)
+			require.Len(t, candles, 25)
+		}
+
+		feeder.failures["BTCUSDT"] = 2
+		err = downloader.DownloadAll(ctx, jobs, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithRetries(1, time.Millisecond))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "BTCUSDT 1h")
+	})
+
+	t.Run("cache retries", func(t *testing.T) {
+		feeder := &flakyFeeder{fakeSource: &fakeSource{}, failures: map[string]int{"BTCUSDT": 2, "ETHUSDT": 1}}
+		downloader := NewDownloader(feeder)
+
+		// 5 requests, 50ms apart
+		begin := time.Now()
+		err := downloader.DownloadAll(ctx, jobs, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithCache(newTestCache(t, nil)), WithConcurrency(2), WithRetries(2, time.Millisecond),
+			WithRateLimit(20, time.Second))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(begin), 200*time.Millisecond)
+
+		for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+			candles, err := exchange.ReadCSVCandles(exchange.PairFeed{Pair: pair, File: filepath.Join(dir, pair+".csv")})
+			require.NoError(t, err)
+			require.Len(t, candles, 25)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		feeder := &flakyFeeder{fakeSource: &fakeSource{}}
+		downloader := NewDownloader(feeder)
+
+		err := downloader.DownloadAll(ctx, jobs, WithInterval(day, day.AddDate(0, 0, 1)))
+		require.NoError(t, err)
+
+		feeder.requests = nil
+		err = downloader.DownloadAll(ctx, jobs, WithInterval(day, day.AddDate(0, 0, 2)), WithResume())
+		require.NoError(t, err)
+
+		// only the candles after the last one of each file are requested
+		require.Equal(t, []Range{
+			{Start: day.Add(25 * time.Hour), End: day.AddDate(0, 0, 2)},
+			{Start: day.Add(25 * time.Hour), End: day.AddDate(0, 0, 2)},
+		}, feeder.requests)
+
+		candles, err := exchange.ReadCSVCandles(exchange.PairFeed{Pair: "BTCUSDT", File: jobs[0].Output})
+		require.NoError(t, err)
+		require.Len(t, candles, 49)
+		require.Equal(t, 10.0, candles[48].Metadata["trades"])
+		for i, candle := range candles {
+			require.Equal(t, day.Add(time.Duration(i)*time.Hour), candle.Time)
+		}
+	})
+
+	t.Run("resume binary", func(t *testing.T) {
+		output := filepath.Join(dir, "btc.bin")
+		downloader := NewDownloader(&flakyFeeder{fakeSource: &fakeSource{}})
+		for _, days := range []int{1, 2} {
+			err := downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, days)),
+				WithBinaryFormat(exchange.CompressionGzip), WithResume())
+			require.NoError(t, err)
+		}
+
+		header, err := exchange.ReadBinaryHeader(output)
+		require.NoError(t, err)
+		require.Equal(t, 49, header.Rows)
+		require.Equal(t, day.AddDate(0, 0, 2), header.End)
+	})
+
+	t.Run("resume partial row", func(t *testing.T) {
+		output := filepath.Join(dir, "partial.csv")
+		downloader := NewDownloader(&flakyFeeder{fakeSource: &fakeSource{}})
+		err := downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, 1)))
+		require.NoError(t, err)
+
+		// interrupted while writing the row of the next candle
+		file, err := os.OpenFile(output, os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = file.WriteString("16411")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		err = downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, 2)),
+			WithResume())
+		require.NoError(t, err)
+
+		candles, err := exchange.ReadCSVCandles(exchange.PairFeed{Pair: "BTCUSDT", File: output})
+		require.NoError(t, err)
+		require.Len(t, candles, 49)
+		for i, candle := range candles {
+			require.Equal(t, day.Add(time.Duration(i)*time.Hour), candle.Time)
+		}
+	})
+
+	t.Run("resume empty binary", func(t *testing.T) {
+		output := filepath.Join(dir, "empty.bin")
+		require.NoError(t, os.WriteFile(output, nil, 0644))
+
+		downloader := NewDownloader(&flakyFeeder{fakeSource: &fakeSource{}})
+		err := downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithBinaryFormat(exchange.CompressionNone), WithResume())
+		require.NoError(t, err)
+
+		header, err := exchange.ReadBinaryHeader(output)
+		require.NoError(t, err)
+		require.Equal(t, 25, header.Rows)
+
+		// the file is replaced only on close
+		entries, err := filepath.Glob(filepath.Join(dir, "empty.bin.*"))
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		output := filepath.Join(dir, "funding.csv")
+		downloader := NewDownloader(&flakyFeeder{fakeSource: &fakeSource{}})
+		err := downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithMetadata(fundingSource{}))
+		require.NoError(t, err)
+
+		candles, err := exchange.ReadCSVCandles(exchange.PairFeed{Pair: "BTCUSDT", File: output})
+		require.NoError(t, err)
+		require.Len(t, candles, 25)
+		require.Equal(t, 0.005, candles[5].Metadata["funding_rate"])
+		require.Equal(t, 10.0, candles[5].Metadata["trades"])
+	})
+
+	t.Run("metadata rate limit", func(t *testing.T) {
+		output := filepath.Join(dir, "limited.csv")
+		source := &waitSource{}
+		downloader := NewDownloader(&flakyFeeder{fakeSource: &fakeSource{}})
+		begin := time.Now()
+		err := downloader.Download(ctx, "BTCUSDT", "1h", output, WithInterval(day, day.AddDate(0, 0, 1)),
+			WithMetadata(source), WithRateLimit(1000, time.Second))
+		require.NoError(t, err)
+
+		// a candles request and a metadata request per candle, 1ms apart
+		require.Equal(t, 25, source.waits)
+		require.GreaterOrEqual(t, time.Since(begin), 25*time.Millisecond)
+	})
 }
@@ -0,0 +1,51 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces the requests of concurrent downloads evenly
+type rateLimiter struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter allows a number of requests per period, a nil limiter has no limit
+func newRateLimiter(requests int, period time.Duration) *rateLimiter {
+	if requests <= 0 || period <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: period / time.Duration(requests)}
+}
+
+// Wait blocks until the next request is allowed
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	if r == nil {
+		return ctx.Err()
+	}
+
+	r.mtx.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mtx.Unlock()
+
+	if wait == 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
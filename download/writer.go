@@ -0,0 +1,276 @@
+package download
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// CandleWriter saves downloaded candles to a file
+type CandleWriter interface {
+	Write(candle model.Candle) error
+	// Close flushes the pending data and closes the file, it can be called more than once
+	Close() error
+}
+
+// CSVWriter writes candles in the CSV layout of exchange.NewCSVFeed. The metadata of the
+// first candle is written as additional columns.
+type CSVWriter struct {
+	file      *os.File
+	writer    *csv.Writer
+	precision int
+	metadata  []string
+	started   bool
+	closed    bool
+}
+
+func NewCSVWriter(output string, precision int) (*CSVWriter, error) {
+	file, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSVWriter{
+		file:      file,
+		writer:    csv.NewWriter(file),
+		precision: precision,
+	}, nil
+}
+
+// completeRowsSize returns the size of a CSV file without the partial last row left by an
+// interrupted download, rows are shorter than the tail read from the end of the file
+func completeRowsSize(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := max(info.Size()-4096, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil {
+		return 0, err
+	}
+	return offset + int64(bytes.LastIndexByte(tail, '\n')) + 1, nil
+}
+
+// AppendCSVWriter opens a CSV file written by CSVWriter to append candles, the metadata columns are
+// read from its header. A partial last row is removed and a new file is created if it doesn't exist
+// or is empty.
+func AppendCSVWriter(output string, precision int) (*CSVWriter, error) {
+	file, err := os.OpenFile(output, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := completeRowsSize(file)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header, err := csv.NewReader(file).Read()
+	if err == io.EOF {
+		file.Close()
+		return NewCSVWriter(output, precision)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	writer := &CSVWriter{
+		file:      file,
+		writer:    csv.NewWriter(file),
+		precision: precision,
+		started:   true,
+	}
+	if len(header) > 6 {
+		writer.metadata = header[6:]
+	}
+	return writer, nil
+}
+
+func (w *CSVWriter) writeHeader(candle model.Candle) error {
+	w.started = true
+	for key := range candle.Metadata {
+		w.metadata = append(w.metadata, key)
+	}
+	sort.Strings(w.metadata)
+
+	return w.writer.Write(append([]string{
+		"time", "open", "close", "low", "high", "volume",
+	}, w.metadata...))
+}
+
+func (w *CSVWriter) Write(candle model.Candle) error {
+	if !w.started {
+		if err := w.writeHeader(candle); err != nil {
+			return err
+		}
+	}
+
+	line := candle.ToSlice(w.precision)
+	for _, key := range w.metadata {
+		line = append(line, strconv.FormatFloat(candle.Metadata[key], 'f', -1, 64))
+	}
+	return w.writer.Write(line)
+}
+
+func (w *CSVWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if !w.started {
+		if err := w.writeHeader(model.Candle{}); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// BinaryWriter writes candles in the binary columnar format of exchange.NewBinaryFeed.
+// Columns are kept in memory and written on Close to a temporary file that replaces the output,
+// so an interrupted download never leaves a partial file.
+type BinaryWriter struct {
+	output  string
+	file    *os.File
+	header  exchange.BinaryHeader
+	candles []model.Candle
+	closed  bool
+}
+
+// NewBinaryWriter creates a binary writer, the pair, timeframe and compression are taken from the header
+func NewBinaryWriter(output string, header exchange.BinaryHeader) (*BinaryWriter, error) {
+	// fail early on an invalid output
+	file, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &BinaryWriter{
+		output: output,
+		file:   file,
+		header: header,
+	}, nil
+}
+
+// readBinaryOutput returns the candles of a binary output file. A missing, empty or invalid file,
+// eg. left by an interrupted download, has no candles.
+func readBinaryOutput(output string) ([]model.Candle, error) {
+	file, err := os.Open(output)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, candles, err := exchange.ReadBinaryCandles(file)
+	if errors.Is(err, exchange.ErrInvalidBinaryFile) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		log.Warnf("%s: ignoring invalid binary file: %v", output, err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", output, err)
+	}
+	return candles, nil
+}
+
+// AppendBinaryWriter opens a binary file to append candles, the existing candles are loaded in memory.
+// The file is written from scratch if it doesn't exist, is empty or is invalid.
+func AppendBinaryWriter(output string, header exchange.BinaryHeader) (*BinaryWriter, error) {
+	candles, err := readBinaryOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewBinaryWriter(output, header)
+	if err != nil {
+		return nil, err
+	}
+	writer.candles = candles
+	return writer, nil
+}
+
+func (w *BinaryWriter) Write(candle model.Candle) error {
+	w.candles = append(w.candles, candle)
+	return nil
+}
+
+func (w *BinaryWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := exchange.WriteBinaryCandles(w.file, w.header, w.candles)
+	if err == nil {
+		err = w.file.Chmod(0644)
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.file.Name(), w.output)
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	return nil
+}
+
+// ConvertCSV converts a CSV file of exchange.NewCSVFeed to the binary format,
+// additional columns are kept as metadata
+func ConvertCSV(feed exchange.PairFeed, output string, compression exchange.Compression) error {
+	candles, err := exchange.ReadCSVCandles(feed)
+	if err != nil {
+		return err
+	}
+
+	writer, err := NewBinaryWriter(output, exchange.BinaryHeader{
+		Pair:        feed.Pair,
+		Timeframe:   feed.Timeframe,
+		Compression: compression,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, candle := range candles {
+		if err := writer.Write(candle); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,236 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// TradeAggregator builds candles of any timeframe from trades. Periods are aligned as in Resampler
+// and the first period is skipped, since its first trades may be missing.
+type TradeAggregator struct {
+	resampler *Resampler
+	current   *model.Candle
+	started   bool
+	closed    time.Time
+}
+
+// NewTradeAggregator creates an aggregator of trades to candles, partial candles are emitted
+// on each trade unless disabled with WithPartialCandles(false)
+func NewTradeAggregator(timeframe string, options ...ResamplerOption) (*TradeAggregator, error) {
+	r, err := NewResampler(timeframe, timeframe, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &TradeAggregator{resampler: r}, nil
+}
+
+// Add updates the candle of the trade period. It returns the previous candle as complete when
+// the trade opens a new period, followed by the partial candle of the trade period.
+func (a *TradeAggregator) Add(trade model.Trade) []model.Candle {
+	period := a.resampler.PeriodStart(trade.Time)
+	if !a.started {
+		a.started = true
+		a.closed = period
+		return nil
+	}
+
+	// trade of a closed period
+	if !period.After(a.closed) {
+		return nil
+	}
+
+	candles := make([]model.Candle, 0, 2)
+	if a.current != nil && period.After(a.current.Time) {
+		candles = append(candles, a.close())
+	}
+
+	if a.current == nil {
+		a.current = &model.Candle{
+			Pair:     trade.Pair,
+			Time:     period,
+			Open:     trade.Price,
+			High:     trade.Price,
+			Low:      trade.Price,
+			Metadata: make(map[string]float64),
+		}
+	}
+
+	a.current.UpdatedAt = trade.Time
+	a.current.Close = trade.Price
+	a.current.High = math.Max(a.current.High, trade.Price)
+	a.current.Low = math.Min(a.current.Low, trade.Price)
+	a.current.Volume += trade.Quantity
+
+	if a.resampler.partial {
+		candles = append(candles, a.candle())
+	}
+	return candles
+}
+
+// Flush closes the open candle if its period ended before the given time, it is used to close
+// candles of periods without new trades
+func (a *TradeAggregator) Flush(now time.Time) (model.Candle, bool) {
+	if a.current == nil || now.Before(a.resampler.target.add(a.current.Time, 1)) {
+		return model.Candle{}, false
+	}
+	return a.close(), true
+}
+
+// candle returns a copy of the open candle
+func (a *TradeAggregator) candle() model.Candle {
+	candle := *a.current
+	candle.Metadata = make(map[string]float64)
+	return candle
+}
+
+func (a *TradeAggregator) close() model.Candle {
+	candle := a.candle()
+	candle.Complete = true
+	a.closed = candle.Time
+	a.current = nil
+	return candle
+}
+
+// TradeCandleFeed is a data feed of candles built from trades, eg. to drive partial candles of
+// a strategy by the trade flow. Historical candles, used in warmup, are requested to an optional
+// candle feed.
+type TradeCandleFeed struct {
+	trades     service.TradeFeeder
+	history    service.Feeder
+	options    []ResamplerOption
+	closeDelay time.Duration
+}
+
+type TradeCandleFeedOption func(*TradeCandleFeed)
+
+// WithTradeHistory sets the feed of historical candles
+func WithTradeHistory(feeder service.Feeder) TradeCandleFeedOption {
+	return func(t *TradeCandleFeed) {
+		t.history = feeder
+	}
+}
+
+// WithTradeResampler sets the options of the candle periods, eg. WithPartialCandles(false)
+func WithTradeResampler(options ...ResamplerOption) TradeCandleFeedOption {
+	return func(t *TradeCandleFeed) {
+		t.options = options
+	}
+}
+
+// WithClockClose closes candles by the wall clock, after the period end plus a delay, when no trade
+// arrives. It must be used only with live trades.
+func WithClockClose(delay time.Duration) TradeCandleFeedOption {
+	return func(t *TradeCandleFeed) {
+		t.closeDelay = delay
+	}
+}
+
+// NewTradeCandleFeed creates a candle feed from a trade feed
+func NewTradeCandleFeed(trades service.TradeFeeder, options ...TradeCandleFeedOption) *TradeCandleFeed {
+	feed := &TradeCandleFeed{
+		trades:     trades,
+		closeDelay: -1,
+	}
+	for _, option := range options {
+		option(feed)
+	}
+	return feed
+}
+
+func (t *TradeCandleFeed) AssetsInfo(pair string) model.AssetInfo {
+	if t.history != nil {
+		return t.history.AssetsInfo(pair)
+	}
+	return DefaultAssetsInfo(pair)
+}
+
+func (t *TradeCandleFeed) LastQuote(ctx context.Context, pair string) (float64, error) {
+	if t.history != nil {
+		return t.history.LastQuote(ctx, pair)
+	}
+	return 0, errors.New("invalid operation")
+}
+
+func (t *TradeCandleFeed) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	if t.history != nil {
+		return t.history.CandlesByPeriod(ctx, pair, timeframe, start, end)
+	}
+	return make([]model.Candle, 0), nil
+}
+
+func (t *TradeCandleFeed) CandlesByLimit(ctx context.Context, pair, timeframe string,
+	limit int) ([]model.Candle, error) {
+
+	if t.history != nil {
+		return t.history.CandlesByLimit(ctx, pair, timeframe, limit)
+	}
+	return make([]model.Candle, 0), nil
+}
+
+// CandlesSubscription aggregates the trades of the pair into candles of the timeframe
+func (t *TradeCandleFeed) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle,
+	chan error) {
+
+	ccandle := make(chan model.Candle)
+	aggregator, err := NewTradeAggregator(timeframe, t.options...)
+	if err != nil {
+		cerr := make(chan error, 1)
+		cerr <- err
+		close(ccandle)
+		close(cerr)
+		return ccandle, cerr
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ctrade, cerr := t.trades.TradesSubscription(ctx, pair)
+
+	go func() {
+		defer cancel()
+		defer close(ccandle)
+
+		var clock <-chan time.Time
+		if t.closeDelay >= 0 {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			clock = ticker.C
+		}
+
+		send := func(candle model.Candle) bool {
+			select {
+			case ccandle <- candle:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case trade, ok := <-ctrade:
+				if !ok {
+					return
+				}
+				for _, candle := range aggregator.Add(trade) {
+					if !send(candle) {
+						return
+					}
+				}
+			case now := <-clock:
+				if candle, ok := aggregator.Flush(now.Add(-t.closeDelay)); ok && !send(candle) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
@@ -0,0 +1,108 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/testdata/mocks"
+)
+
+func tradeAt(start time.Time, seconds int, price float64) model.Trade {
+	return model.Trade{Pair: "BTCUSDT", Time: start.Add(time.Duration(seconds) * time.Second), Price: price,
+		Quantity: 1}
+}
+
+func TestTradeAggregator(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	aggregator, err := NewTradeAggregator("1m")
+	require.NoError(t, err)
+
+	// the first period is skipped
+	require.Empty(t, aggregator.Add(tradeAt(start, 30, 1)))
+	require.Empty(t, aggregator.Add(tradeAt(start, 50, 1)))
+
+	candles := aggregator.Add(tradeAt(start, 60, 10))
+	require.Len(t, candles, 1)
+	require.False(t, candles[0].Complete)
+	require.Equal(t, start.Add(time.Minute), candles[0].Time)
+	require.Equal(t, start.Add(time.Minute), candles[0].UpdatedAt)
+
+	aggregator.Add(tradeAt(start, 70, 12))
+	aggregator.Add(tradeAt(start, 80, 8))
+	candles = aggregator.Add(tradeAt(start, 130, 9))
+	require.Len(t, candles, 2)
+	require.Equal(t, model.Candle{
+		Pair: "BTCUSDT", Time: start.Add(time.Minute), UpdatedAt: start.Add(80 * time.Second),
+		Open: 10, Close: 8, High: 12, Low: 8, Volume: 3, Complete: true, Metadata: map[string]float64{},
+	}, candles[0])
+	require.False(t, candles[1].Complete)
+	require.Equal(t, 9.0, candles[1].Open)
+
+	_, ok := aggregator.Flush(start.Add(179 * time.Second))
+	require.False(t, ok)
+	candle, ok := aggregator.Flush(start.Add(3 * time.Minute))
+	require.True(t, ok)
+	require.True(t, candle.Complete)
+	require.Equal(t, start.Add(2*time.Minute), candle.Time)
+
+	// late trades of closed periods are ignored
+	require.Empty(t, aggregator.Add(tradeAt(start, 170, 1)))
+
+	t.Run("without partial candles", func(t *testing.T) {
+		aggregator, err := NewTradeAggregator("1h", WithPartialCandles(false))
+		require.NoError(t, err)
+
+		candles := make([]model.Candle, 0)
+		for i := 0; i < 4*60; i++ {
+			candles = append(candles, aggregator.Add(tradeAt(start, i*60, float64(i)))...)
+		}
+		require.Len(t, candles, 2)
+		require.Equal(t, 60.0, candles[0].Volume)
+		require.Equal(t, start.Add(2*time.Hour), candles[1].Time)
+	})
+
+	_, err = NewTradeAggregator("batata")
+	require.Error(t, err)
+}
+
+func TestTradeCandleFeed(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ctrade, cerr := make(chan model.Trade), make(chan error)
+	go func() {
+		defer close(cerr)
+		defer close(ctrade)
+		for i := 0; i < 10; i++ {
+			ctrade <- tradeAt(start, i*20, float64(i))
+		}
+	}()
+
+	trades := mocks.NewTradeFeeder(t)
+	trades.EXPECT().TradesSubscription(mock.Anything, "BTCUSDT").Return(ctrade, cerr)
+
+	feed := NewTradeCandleFeed(trades)
+	require.Equal(t, "USDT", feed.AssetsInfo("BTCUSDT").QuoteAsset)
+
+	warmup, err := feed.CandlesByLimit(ctx, "BTCUSDT", "1m", 10)
+	require.NoError(t, err)
+	require.Empty(t, warmup)
+
+	candles, err := collectCandles(feed.CandlesSubscription(ctx, "BTCUSDT", "1m"))
+	require.NoError(t, err)
+	require.Len(t, candles, 9)
+
+	complete := lo.Filter(candles, func(candle model.Candle, _ int) bool {
+		return candle.Complete
+	})
+	require.Len(t, complete, 2)
+	require.Equal(t, 3.0, complete[0].Open)
+	require.Equal(t, 5.0, complete[0].Close)
+}
@@ -0,0 +1,392 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+type BarType string
+
+const (
+	// BarVolume closes a bar when the traded volume reaches the bar size
+	BarVolume BarType = "volume"
+	// BarDollar closes a bar when the traded value in the quote asset reaches the bar size
+	BarDollar BarType = "dollar"
+	// BarTick closes a bar after a number of trades
+	BarTick BarType = "tick"
+	// BarRange starts a new bar when a trade would extend the high-low range beyond the bar size
+	BarRange BarType = "range"
+	// BarRenko emits a brick each time the price moves the bar size beyond the last brick
+	BarRenko BarType = "renko"
+)
+
+// BarBuilder builds information-driven bars from trades or from fine-grained candles. Bars are
+// emitted as candles, opened at the time of their first trade. A bar opened at the time of the
+// previous one is moved one millisecond ahead, so bar times are always increasing.
+type BarBuilder struct {
+	kind    BarType
+	size    float64
+	partial bool
+
+	current *model.Candle
+	value   float64
+	last    time.Time
+
+	// bounds of the last renko brick
+	started bool
+	top     float64
+	bottom  float64
+}
+
+type BarBuilderOption func(*BarBuilder)
+
+// WithPartialBars emits the open bar on each trade, with Complete = false. It is enabled by default.
+func WithPartialBars(enabled bool) BarBuilderOption {
+	return func(b *BarBuilder) {
+		b.partial = enabled
+	}
+}
+
+// NewBarBuilder creates a builder of bars of a type and size, eg. 100 BTC volume bars or 50 USDT
+// renko bricks
+func NewBarBuilder(kind BarType, size float64, options ...BarBuilderOption) (*BarBuilder, error) {
+	switch kind {
+	case BarVolume, BarDollar, BarTick, BarRange, BarRenko:
+	default:
+		return nil, fmt.Errorf("invalid bar type: %s", kind)
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid bar size: %f", size)
+	}
+
+	builder := &BarBuilder{kind: kind, size: size, partial: true}
+	for _, option := range options {
+		option(builder)
+	}
+	return builder, nil
+}
+
+// AddTrade updates the open bar with a trade and returns the closed bars, followed by the open bar
+// when partial bars are enabled
+func (b *BarBuilder) AddTrade(trade model.Trade) []model.Candle {
+	candles := make([]model.Candle, 0, 2)
+
+	if b.kind == BarRange && b.current != nil &&
+		math.Max(b.current.High, trade.Price)-math.Min(b.current.Low, trade.Price) > b.size {
+		candles = append(candles, b.close())
+	}
+
+	b.update(trade)
+
+	switch b.kind {
+	case BarVolume:
+		b.value += trade.Quantity
+	case BarDollar:
+		b.value += trade.Quantity * trade.Price
+	case BarTick:
+		b.value++
+	case BarRenko:
+		candles = append(candles, b.bricks(trade.Price)...)
+	}
+
+	if (b.kind == BarVolume || b.kind == BarDollar || b.kind == BarTick) && b.value >= b.size {
+		candles = append(candles, b.close())
+	}
+
+	if b.partial && b.current != nil {
+		candles = append(candles, b.candle())
+	}
+	return candles
+}
+
+// AddCandle updates the bars with a complete candle, as a sequence of four trades: open, low,
+// high and close in a bullish candle, or open, high, low and close in a bearish one. The candle
+// volume is split equally between them. Partial candles are ignored.
+func (b *BarBuilder) AddCandle(candle model.Candle) []model.Candle {
+	if !candle.Complete {
+		return nil
+	}
+
+	prices := []float64{candle.Open, candle.Low, candle.High, candle.Close}
+	if candle.Close < candle.Open {
+		prices[1], prices[2] = candle.High, candle.Low
+	}
+
+	candles := make([]model.Candle, 0)
+	for i, price := range prices {
+		bars := b.AddTrade(model.Trade{
+			Pair:     candle.Pair,
+			Time:     candle.Time,
+			Price:    price,
+			Quantity: candle.Volume / float64(len(prices)),
+		})
+
+		// only the last update of the open bar is relevant
+		if i < len(prices)-1 && len(bars) > 0 && !bars[len(bars)-1].Complete {
+			bars = bars[:len(bars)-1]
+		}
+		candles = append(candles, bars...)
+	}
+	return candles
+}
+
+func (b *BarBuilder) update(trade model.Trade) {
+	if b.current == nil {
+		start := trade.Time
+		if !start.After(b.last) {
+			start = b.last.Add(time.Millisecond)
+		}
+
+		b.current = &model.Candle{
+			Pair:     trade.Pair,
+			Time:     start,
+			Open:     trade.Price,
+			High:     trade.Price,
+			Low:      trade.Price,
+			Metadata: make(map[string]float64),
+		}
+	}
+
+	b.current.UpdatedAt = trade.Time
+	b.current.Close = trade.Price
+	b.current.High = math.Max(b.current.High, trade.Price)
+	b.current.Low = math.Min(b.current.Low, trade.Price)
+	b.current.Volume += trade.Quantity
+}
+
+// bricks closes the renko bricks reached by the price, the open bar volume is assigned to the first one
+func (b *BarBuilder) bricks(price float64) []model.Candle {
+	if !b.started {
+		b.started = true
+		b.top, b.bottom = price, price
+		return nil
+	}
+
+	candles := make([]model.Candle, 0)
+	for {
+		var open, close float64
+		switch {
+		case price >= b.top+b.size:
+			open, close = b.top, b.top+b.size
+			b.bottom, b.top = open, close
+		case price <= b.bottom-b.size:
+			open, close = b.bottom, b.bottom-b.size
+			b.top, b.bottom = open, close
+		default:
+			return candles
+		}
+
+		if b.current == nil {
+			b.update(model.Trade{Pair: candles[0].Pair, Time: candles[0].UpdatedAt, Price: close})
+		}
+
+		b.current.Open, b.current.Close = open, close
+		b.current.High, b.current.Low = math.Max(open, close), math.Min(open, close)
+		candles = append(candles, b.close())
+	}
+}
+
+// candle returns a copy of the open bar
+func (b *BarBuilder) candle() model.Candle {
+	candle := *b.current
+	candle.Metadata = make(map[string]float64)
+	return candle
+}
+
+func (b *BarBuilder) close() model.Candle {
+	candle := b.candle()
+	candle.Complete = true
+	b.last = candle.Time
+	b.current = nil
+	b.value = 0
+	return candle
+}
+
+// BarFeed is a data feed of information-driven bars, built from the trades of a TradeFeeder or from
+// the candles of a Feeder, eg. 1m candles of CSVFeed. The timeframe requested to the feed is only
+// a label of the bars, as in strategy.Timeframe().
+type BarFeed struct {
+	kind    BarType
+	size    float64
+	options []BarBuilderOption
+
+	trades    service.TradeFeeder
+	candles   service.Feeder
+	timeframe string
+	history   int
+}
+
+type BarFeedOption func(*BarFeed)
+
+// WithBarTrades builds the bars from trades
+func WithBarTrades(feeder service.TradeFeeder) BarFeedOption {
+	return func(b *BarFeed) {
+		b.trades = feeder
+	}
+}
+
+// WithBarCandles builds the bars from candles of a timeframe. When trades are also provided,
+// candles are used only for historical bars.
+func WithBarCandles(feeder service.Feeder, timeframe string) BarFeedOption {
+	return func(b *BarFeed) {
+		b.candles = feeder
+		b.timeframe = timeframe
+	}
+}
+
+// WithBarHistory sets the number of source candles used to build bars in CandlesByLimit, default is 1000
+func WithBarHistory(candles int) BarFeedOption {
+	return func(b *BarFeed) {
+		b.history = candles
+	}
+}
+
+// WithBarOptions sets the options of the bar builders
+func WithBarOptions(options ...BarBuilderOption) BarFeedOption {
+	return func(b *BarFeed) {
+		b.options = options
+	}
+}
+
+// NewBarFeed creates a data feed of bars of a type and size
+func NewBarFeed(kind BarType, size float64, options ...BarFeedOption) (*BarFeed, error) {
+	feed := &BarFeed{
+		kind:    kind,
+		size:    size,
+		history: 1000,
+	}
+	for _, option := range options {
+		option(feed)
+	}
+
+	if _, err := NewBarBuilder(kind, size, feed.options...); err != nil {
+		return nil, err
+	}
+
+	if feed.trades == nil && feed.candles == nil {
+		return nil, errors.New("bar feed without trades or candles")
+	}
+	return feed, nil
+}
+
+func (b *BarFeed) builder() *BarBuilder {
+	builder, _ := NewBarBuilder(b.kind, b.size, b.options...)
+	return builder
+}
+
+// bars returns the complete bars of source candles
+func (b *BarFeed) bars(candles []model.Candle) []model.Candle {
+	builder := b.builder()
+	bars := make([]model.Candle, 0)
+	for _, candle := range candles {
+		for _, bar := range builder.AddCandle(candle) {
+			if bar.Complete {
+				bars = append(bars, bar)
+			}
+		}
+	}
+	return bars
+}
+
+func (b *BarFeed) AssetsInfo(pair string) model.AssetInfo {
+	if b.candles != nil {
+		return b.candles.AssetsInfo(pair)
+	}
+	return DefaultAssetsInfo(pair)
+}
+
+func (b *BarFeed) LastQuote(ctx context.Context, pair string) (float64, error) {
+	if b.candles != nil {
+		return b.candles.LastQuote(ctx, pair)
+	}
+	return 0, errors.New("invalid operation")
+}
+
+func (b *BarFeed) CandlesByPeriod(ctx context.Context, pair, _ string, start, end time.Time) ([]model.Candle, error) {
+	if b.candles == nil {
+		return make([]model.Candle, 0), nil
+	}
+
+	candles, err := b.candles.CandlesByPeriod(ctx, pair, b.timeframe, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return b.bars(candles), nil
+}
+
+// CandlesByLimit returns the last bars built from the history candles
+func (b *BarFeed) CandlesByLimit(ctx context.Context, pair, _ string, limit int) ([]model.Candle, error) {
+	if b.candles == nil {
+		return make([]model.Candle, 0), nil
+	}
+
+	candles, err := b.candles.CandlesByLimit(ctx, pair, b.timeframe, b.history)
+	if err != nil {
+		return nil, err
+	}
+
+	bars := b.bars(candles)
+	if len(bars) < limit {
+		return nil, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+	return bars[len(bars)-limit:], nil
+}
+
+func (b *BarFeed) CandlesSubscription(ctx context.Context, pair, _ string) (chan model.Candle, chan error) {
+	ctx, cancel := context.WithCancel(ctx)
+	builder := b.builder()
+	ccandle := make(chan model.Candle)
+
+	var next func() ([]model.Candle, bool)
+	var cerr chan error
+	if b.trades != nil {
+		var ctrade chan model.Trade
+		ctrade, cerr = b.trades.TradesSubscription(ctx, pair)
+		next = func() ([]model.Candle, bool) {
+			trade, ok := <-ctrade
+			if !ok {
+				return nil, false
+			}
+			return builder.AddTrade(trade), true
+		}
+	} else {
+		var csource chan model.Candle
+		csource, cerr = b.candles.CandlesSubscription(ctx, pair, b.timeframe)
+		next = func() ([]model.Candle, bool) {
+			candle, ok := <-csource
+			if !ok {
+				return nil, false
+			}
+			return builder.AddCandle(candle), true
+		}
+	}
+
+	go func() {
+		defer cancel()
+		defer close(ccandle)
+
+		for {
+			bars, ok := next()
+			if !ok {
+				return
+			}
+
+			for _, bar := range bars {
+				select {
+				case ccandle <- bar:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
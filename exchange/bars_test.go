@@ -0,0 +1,136 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func completeBars(candles []model.Candle) []model.Candle {
+	return lo.Filter(candles, func(candle model.Candle, _ int) bool {
+		return candle.Complete
+	})
+}
+
+func TestBarBuilder(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	addTrades := func(builder *BarBuilder, prices ...float64) []model.Candle {
+		candles := make([]model.Candle, 0)
+		for i, price := range prices {
+			candles = append(candles, builder.AddTrade(tradeAt(start, i, price))...)
+		}
+		return candles
+	}
+
+	t.Run("volume", func(t *testing.T) {
+		builder, err := NewBarBuilder(BarVolume, 3)
+		require.NoError(t, err)
+
+		candles := addTrades(builder, 10, 11, 12, 13, 14, 15, 16)
+		require.Len(t, candles, 7)
+
+		bars := completeBars(candles)
+		require.Len(t, bars, 2)
+		require.Equal(t, model.Candle{
+			Pair: "BTCUSDT", Time: start, UpdatedAt: start.Add(2 * time.Second), Open: 10, Close: 12, Low: 10,
+			High: 12, Volume: 3, Complete: true, Metadata: map[string]float64{},
+		}, bars[0])
+		require.Equal(t, start.Add(3*time.Second), bars[1].Time)
+
+		// the open bar has the time of its first trade
+		require.False(t, candles[6].Complete)
+		require.Equal(t, start.Add(6*time.Second), candles[6].Time)
+	})
+
+	t.Run("dollar and tick", func(t *testing.T) {
+		builder, err := NewBarBuilder(BarDollar, 25, WithPartialBars(false))
+		require.NoError(t, err)
+		bars := addTrades(builder, 10, 10, 10, 10, 10, 10)
+		require.Len(t, bars, 2)
+		require.Equal(t, 3.0, bars[0].Volume)
+
+		builder, err = NewBarBuilder(BarTick, 2, WithPartialBars(false))
+		require.NoError(t, err)
+		require.Len(t, addTrades(builder, 1, 2, 3, 4, 5), 2)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		builder, err := NewBarBuilder(BarRange, 2, WithPartialBars(false))
+		require.NoError(t, err)
+
+		bars := addTrades(builder, 10, 11, 12, 13, 11, 14)
+		require.Len(t, bars, 2)
+		require.Equal(t, 10.0, bars[0].Low)
+		require.Equal(t, 12.0, bars[0].High)
+		require.Equal(t, 13.0, bars[1].Open)
+		require.Equal(t, 11.0, bars[1].Close)
+	})
+
+	t.Run("renko", func(t *testing.T) {
+		builder, err := NewBarBuilder(BarRenko, 1, WithPartialBars(false))
+		require.NoError(t, err)
+
+		bars := addTrades(builder, 10, 10.5, 12.2, 11.5, 9.9)
+		require.Len(t, bars, 3)
+		require.Equal(t, []float64{10, 11, 11}, lo.Map(bars, func(bar model.Candle, _ int) float64 { return bar.Open }))
+		require.Equal(t, []float64{11, 12, 10}, lo.Map(bars, func(bar model.Candle, _ int) float64 { return bar.Close }))
+
+		require.Equal(t, start, bars[0].Time)
+		require.Equal(t, start.Add(2*time.Second), bars[1].Time)
+		require.Equal(t, 3.0, bars[0].Volume)
+		require.Zero(t, bars[1].Volume)
+	})
+
+	t.Run("from candles", func(t *testing.T) {
+		builder, err := NewBarBuilder(BarVolume, 2)
+		require.NoError(t, err)
+
+		candles := builder.AddCandle(model.Candle{Pair: "BTCUSDT", Time: start, Open: 10, Low: 9, High: 12, Close: 11,
+			Volume: 4, Complete: true})
+		require.Len(t, candles, 2)
+		require.Equal(t, 9.0, candles[0].Close)
+		require.Equal(t, start.Add(time.Millisecond), candles[1].Time)
+		require.Equal(t, 12.0, candles[1].Open)
+
+		require.Empty(t, builder.AddCandle(model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Hour), Close: 1}))
+	})
+
+	_, err := NewBarBuilder("batata", 1)
+	require.Error(t, err)
+	_, err = NewBarBuilder(BarVolume, 0)
+	require.Error(t, err)
+}
+
+func TestBarFeed(t *testing.T) {
+	ctx := context.Background()
+	feed, err := NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"})
+	require.NoError(t, err)
+
+	bars, err := NewBarFeed(BarRenko, 500, WithBarCandles(feed, "1h"), WithBarHistory(100),
+		WithBarOptions(WithPartialBars(false)))
+	require.NoError(t, err)
+
+	warmup, err := bars.CandlesByLimit(ctx, "BTCUSDT", "renko", 2)
+	require.NoError(t, err)
+	require.Len(t, warmup, 2)
+
+	candles, err := collectCandles(bars.CandlesSubscription(ctx, "BTCUSDT", "renko"))
+	require.NoError(t, err)
+	require.NotEmpty(t, candles)
+
+	previous := time.Time{}
+	for _, candle := range candles {
+		require.True(t, candle.Complete)
+		require.True(t, candle.Time.After(previous))
+		require.Equal(t, 500.0, candle.High-candle.Low)
+		previous = candle.Time
+	}
+
+	_, err = NewBarFeed(BarRenko, 500)
+	require.Error(t, err)
+}
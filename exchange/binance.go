@@ -2,7 +2,13 @@ package exchange
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -11,6 +17,7 @@ import (
 	"github.com/jpillora/backoff"
 
 	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/tools/log"
 )
 
@@ -128,6 +135,10 @@ func NewBinance(ctx context.Context, options ...BinanceOption) (*Binance, error)
 					tradeLimits.MaxPrice, _ = strconv.ParseFloat(filter["maxPrice"].(string), 64)
 					tradeLimits.TickSize, _ = strconv.ParseFloat(filter["tickSize"].(string), 64)
 				}
+
+				if typ == string(binance.SymbolFilterTypeMinNotional) || typ == "NOTIONAL" {
+					tradeLimits.MinNotional, _ = strconv.ParseFloat(filter["minNotional"].(string), 64)
+				}
 			}
 		}
 		exchange.assetsInfo[info.Symbol] = tradeLimits
@@ -224,16 +235,14 @@ func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64)
 
 	order, err := b.client.NewCreateOrderService().Symbol(pair).
 		Type(binance.OrderTypeStopLoss).
-		TimeInForce(binance.TimeInForceTypeGTC).
 		Side(binance.SideTypeSell).
 		Quantity(b.formatQuantity(pair, quantity)).
-		Price(b.formatPrice(pair, limit)).
+		StopPrice(b.formatPrice(pair, limit)).
 		Do(b.ctx)
 	if err != nil {
 		return model.Order{}, err
 	}
 
-	price, _ := strconv.ParseFloat(order.Price, 64)
 	quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
 
 	return model.Order{
@@ -244,7 +253,8 @@ func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64)
 		Side:       model.SideType(order.Side),
 		Type:       model.OrderType(order.Type),
 		Status:     model.OrderStatusType(order.Status),
-		Price:      price,
+		Price:      limit,
+		Stop:       &limit,
 		Quantity:   quantity,
 	}, nil
 }
@@ -306,6 +316,92 @@ func (b *Binance) CreateOrderLimit(side model.SideType, pair string,
 	}, nil
 }
 
+// CreateOrder creates an order given a generic request. Post-only orders are sent as LIMIT_MAKER.
+// Reduce-only orders are not supported in spot market.
+func (b *Binance) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	err := request.Validate()
+	if err != nil {
+		return model.Order{}, &OrderError{Err: err, Pair: request.Pair, Quantity: request.Quantity}
+	}
+
+	if request.ReduceOnly {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: reduce-only in spot market", ErrNotSupported),
+			Pair:     request.Pair,
+			Quantity: request.Quantity,
+		}
+	}
+
+	err = b.validate(request.Pair, request.Quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	service := b.client.NewCreateOrderService().
+		Symbol(request.Pair).
+		Side(binance.SideType(request.Side)).
+		Quantity(b.formatQuantity(request.Pair, request.Quantity)).
+		NewOrderRespType(binance.NewOrderRespTypeFULL)
+
+	switch {
+	case request.Type == model.OrderTypeMarket:
+		service.Type(binance.OrderTypeMarket)
+	case request.IsPostOnly():
+		service.Type(binance.OrderTypeLimitMaker).
+			Price(b.formatPrice(request.Pair, request.Price))
+	default:
+		timeInForce := binance.TimeInForceTypeGTC
+		if request.TimeInForce != "" {
+			timeInForce = binance.TimeInForceType(request.TimeInForce)
+		}
+		service.Type(binance.OrderTypeLimit).
+			TimeInForce(timeInForce).
+			Price(b.formatPrice(request.Pair, request.Price))
+	}
+
+	if request.ClientID != "" {
+		service.NewClientOrderID(request.ClientID)
+	}
+
+	order, err := service.Do(b.ctx)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	var price, quantity float64
+	cost, _ := strconv.ParseFloat(order.CummulativeQuoteQuantity, 64)
+	executed, _ := strconv.ParseFloat(order.ExecutedQuantity, 64)
+	if request.Type == model.OrderTypeMarket && cost > 0 && executed > 0 {
+		price = cost / executed
+		quantity = executed
+	} else {
+		price, err = strconv.ParseFloat(order.Price, 64)
+		if err != nil {
+			return model.Order{}, err
+		}
+
+		quantity, err = strconv.ParseFloat(order.OrigQuantity, 64)
+		if err != nil {
+			return model.Order{}, err
+		}
+	}
+
+	return model.Order{
+		ExchangeID:  order.OrderID,
+		CreatedAt:   time.Unix(0, order.TransactTime*int64(time.Millisecond)),
+		UpdatedAt:   time.Unix(0, order.TransactTime*int64(time.Millisecond)),
+		Pair:        request.Pair,
+		Side:        model.SideType(order.Side),
+		Type:        model.OrderType(order.Type),
+		Status:      model.OrderStatusType(order.Status),
+		Price:       price,
+		Quantity:    quantity,
+		TimeInForce: model.TimeInForceType(order.TimeInForce),
+		ClientID:    order.ClientOrderID,
+		Tag:         request.Tag,
+	}, nil
+}
+
 func (b *Binance) CreateOrderMarket(side model.SideType, pair string, quantity float64) (model.Order, error) {
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -394,6 +490,122 @@ func (b *Binance) Cancel(order model.Order) error {
 	return err
 }
 
+// cancelReplaceResponse is the result of the cancel-replace endpoint, not covered by the binance client
+type cancelReplaceResponse struct {
+	CancelResult     string                       `json:"cancelResult"`
+	NewOrderResult   string                       `json:"newOrderResult"`
+	NewOrderResponse *binance.CreateOrderResponse `json:"newOrderResponse"`
+}
+
+// ReplaceOrder cancels an open limit order and creates a new one with the given price and quantity
+// in a single request. The new order is not created if the cancellation fails.
+func (b *Binance) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	if order.Type != model.OrderTypeLimit && order.Type != model.OrderTypeLimitMaker {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: replace %s order", ErrNotSupported, order.Type),
+			Pair:     order.Pair,
+			Quantity: quantity,
+		}
+	}
+
+	err := b.validate(order.Pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	params := url.Values{}
+	params.Set("symbol", order.Pair)
+	params.Set("side", string(order.Side))
+	params.Set("type", string(order.Type))
+	if order.Type == model.OrderTypeLimit {
+		timeInForce := model.TimeInForceTypeGTC
+		if order.TimeInForce != "" {
+			timeInForce = order.TimeInForce
+		}
+		params.Set("timeInForce", string(timeInForce))
+	}
+	params.Set("quantity", b.formatQuantity(order.Pair, quantity))
+	params.Set("price", b.formatPrice(order.Pair, price))
+	params.Set("cancelReplaceMode", "STOP_ON_FAILURE")
+	params.Set("cancelOrderId", strconv.FormatInt(order.ExchangeID, 10))
+
+	var result cancelReplaceResponse
+	err = b.signedRequest(http.MethodPost, "/api/v3/order/cancelReplace", params, &result)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	if result.NewOrderResponse == nil {
+		return model.Order{}, fmt.Errorf("binance cancel-replace: cancel %s, new order %s",
+			result.CancelResult, result.NewOrderResult)
+	}
+
+	newOrder := result.NewOrderResponse
+	price, err = strconv.ParseFloat(newOrder.Price, 64)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	quantity, err = strconv.ParseFloat(newOrder.OrigQuantity, 64)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	return model.Order{
+		ExchangeID:  newOrder.OrderID,
+		CreatedAt:   time.Unix(0, newOrder.TransactTime*int64(time.Millisecond)),
+		UpdatedAt:   time.Unix(0, newOrder.TransactTime*int64(time.Millisecond)),
+		Pair:        order.Pair,
+		Side:        model.SideType(newOrder.Side),
+		Type:        model.OrderType(newOrder.Type),
+		Status:      model.OrderStatusType(newOrder.Status),
+		Price:       price,
+		Quantity:    quantity,
+		TimeInForce: model.TimeInForceType(newOrder.TimeInForce),
+		ClientID:    newOrder.ClientOrderID,
+		Tag:         order.Tag,
+	}, nil
+}
+
+// signedRequest calls an endpoint of the main API that is not available in the binance client
+func (b *Binance) signedRequest(method, endpoint string, params url.Values, result interface{}) error {
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli()-b.client.TimeOffset, 10))
+	query := params.Encode()
+	mac := hmac.New(sha256.New, []byte(b.client.SecretKey))
+	_, err := mac.Write([]byte(query))
+	if err != nil {
+		return err
+	}
+
+	fullURL := fmt.Sprintf("%s%s?%s&signature=%x", b.client.BaseURL, endpoint, query, mac.Sum(nil))
+	request, err := http.NewRequestWithContext(b.ctx, method, fullURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-MBX-APIKEY", b.client.APIKey)
+
+	response, err := b.client.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		apiErr := new(common.APIError)
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Code == 0 {
+			return fmt.Errorf("binance: status %d: %s", response.StatusCode, data)
+		}
+		return apiErr
+	}
+
+	return json.Unmarshal(data, result)
+}
+
 func (b *Binance) Orders(pair string, limit int) ([]model.Order, error) {
 	result, err := b.client.NewListOrdersService().
 		Symbol(pair).
@@ -435,7 +647,7 @@ func newOrder(order *binance.Order) model.Order {
 		quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
 	}
 
-	return model.Order{
+	result := model.Order{
 		ExchangeID: order.OrderID,
 		Pair:       order.Symbol,
 		CreatedAt:  time.Unix(0, order.Time*int64(time.Millisecond)),
@@ -446,6 +658,22 @@ func newOrder(order *binance.Order) model.Order {
 		Price:      price,
 		Quantity:   quantity,
 	}
+
+	// stop market orders have no price until executed
+	if stop, _ := strconv.ParseFloat(order.StopPrice, 64); stop > 0 {
+		result.Stop = &stop
+		if result.Price == 0 {
+			result.Price = stop
+		}
+	}
+	return result
+}
+
+// WithContext returns a copy of the exchange that sends the account and order requests with ctx
+func (b *Binance) WithContext(ctx context.Context) service.Exchange {
+	exchange := *b
+	exchange.ctx = ctx
+	return &exchange
 }
 
 func (b *Binance) Account() (model.Account, error) {
@@ -488,7 +716,36 @@ func (b *Binance) Position(pair string) (asset, quote float64, err error) {
 	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
 }
 
+// PositionInfo returns the asset balance of a pair as a long position without leverage.
+// The entry price is not available in spot market.
+func (b *Binance) PositionInfo(pair string) (model.Position, error) {
+	asset, _, err := b.Position(pair)
+	if err != nil {
+		return model.Position{}, err
+	}
+
+	position := model.Position{Pair: pair, Leverage: 1}
+	if asset <= 0 {
+		return position, nil
+	}
+
+	price, err := b.LastQuote(b.ctx, pair)
+	if err != nil {
+		return model.Position{}, err
+	}
+
+	position.Side = model.PositionSideTypeLong
+	position.Quantity = asset
+	position.MarkPrice = price
+	position.Margin = asset * price
+	return position, nil
+}
+
 func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	if source, ok := binanceResampler.source(period); ok {
+		return binanceResampler.CandlesSubscription(ctx, b, pair, source, period)
+	}
+
 	ccandle := make(chan model.Candle)
 	cerr := make(chan error)
 	ha := model.NewHeikinAshi()
@@ -500,7 +757,7 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 		}
 
 		for {
-			done, _, err := binance.WsKlineServe(pair, period, func(event *binance.WsKlineEvent) {
+			done, stop, err := binance.WsKlineServe(pair, period, func(event *binance.WsKlineEvent) {
 				ba.Reset()
 				candle := CandleFromWsKline(pair, event.Kline)
 
@@ -516,13 +773,21 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 					}
 				}
 
-				ccandle <- candle
-
+				select {
+				case ccandle <- candle:
+				case <-ctx.Done():
+				}
 			}, func(err error) {
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 			})
 			if err != nil {
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 				close(cerr)
 				close(ccandle)
 				return
@@ -530,6 +795,8 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 
 			select {
 			case <-ctx.Done():
+				close(stop)
+				<-done
 				close(cerr)
 				close(ccandle)
 				return
@@ -542,7 +809,90 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 	return ccandle, cerr
 }
 
+// TradesSubscription streams the aggregated trades of a pair, it reconnects when the websocket is closed
+func (b *Binance) TradesSubscription(ctx context.Context, pair string) (chan model.Trade, chan error) {
+	ctrade := make(chan model.Trade)
+	cerr := make(chan error)
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		for {
+			done, stop, err := binance.WsAggTradeServe(pair, func(event *binance.WsAggTradeEvent) {
+				ba.Reset()
+				select {
+				case ctrade <- TradeFromWsAggTrade(pair, event):
+				case <-ctx.Done():
+				}
+			}, func(err error) {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+				close(cerr)
+				close(ctrade)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				close(stop)
+				<-done
+				close(cerr)
+				close(ctrade)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return ctrade, cerr
+}
+
+// OrderBookSubscription streams the order book of a pair, kept from the diff depth stream and synced
+// with snapshots of the REST API. It reconnects when the websocket is closed.
+func (b *Binance) OrderBookSubscription(ctx context.Context, pair string) (chan model.OrderBook, chan error) {
+	serve := func(pair string, handler func(model.DepthUpdate), errHandler func(error)) (
+		chan struct{}, chan struct{}, error) {
+
+		return binance.WsDepthServe100Ms(pair, func(event *binance.WsDepthEvent) {
+			handler(DepthUpdateFromWsDepth(pair, event))
+		}, errHandler)
+	}
+
+	return orderBookSubscription(ctx, pair, serve, b.orderBook)
+}
+
+func (b *Binance) orderBook(ctx context.Context, pair string) (model.OrderBook, error) {
+	depth, err := b.client.NewDepthService().Symbol(pair).Limit(1000).Do(ctx)
+	if err != nil {
+		return model.OrderBook{}, err
+	}
+
+	return model.OrderBook{
+		Pair:         pair,
+		Time:         time.Now(),
+		LastUpdateID: depth.LastUpdateID,
+		Bids:         PriceLevels(depth.Bids),
+		Asks:         PriceLevels(depth.Asks),
+	}, nil
+}
+
 func (b *Binance) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	if source, ok := binanceResampler.source(period); ok {
+		return binanceResampler.CandlesByLimit(ctx, b, pair, source, period, limit)
+	}
+
 	candles := make([]model.Candle, 0)
 	klineService := b.client.NewKlinesService()
 	ha := model.NewHeikinAshi()
@@ -573,6 +923,10 @@ func (b *Binance) CandlesByLimit(ctx context.Context, pair, period string, limit
 func (b *Binance) CandlesByPeriod(ctx context.Context, pair, period string,
 	start, end time.Time) ([]model.Candle, error) {
 
+	if source, ok := binanceResampler.source(period); ok {
+		return binanceResampler.CandlesByPeriod(ctx, b, pair, source, period, start, end)
+	}
+
 	candles := make([]model.Candle, 0)
 	klineService := b.client.NewKlinesService()
 	ha := model.NewHeikinAshi()
@@ -625,3 +979,40 @@ func CandleFromWsKline(pair string, k binance.WsKline) model.Candle {
 	candle.Metadata = make(map[string]float64)
 	return candle
 }
+
+func TradeFromWsAggTrade(pair string, event *binance.WsAggTradeEvent) model.Trade {
+	trade := model.Trade{
+		ID:           event.AggTradeID,
+		Pair:         pair,
+		Time:         time.Unix(0, event.TradeTime*int64(time.Millisecond)),
+		IsBuyerMaker: event.IsBuyerMaker,
+	}
+	trade.Price, _ = strconv.ParseFloat(event.Price, 64)
+	trade.Quantity, _ = strconv.ParseFloat(event.Quantity, 64)
+	return trade
+}
+
+// PriceLevels converts the bids or asks of Binance to order book levels
+func PriceLevels(levels []common.PriceLevel) []model.PriceLevel {
+	result := make([]model.PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		price, quantity, err := level.Parse()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		result = append(result, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+	return result
+}
+
+func DepthUpdateFromWsDepth(pair string, event *binance.WsDepthEvent) model.DepthUpdate {
+	return model.DepthUpdate{
+		Pair:          pair,
+		Time:          time.Unix(0, event.Time*int64(time.Millisecond)),
+		FirstUpdateID: event.FirstUpdateID,
+		FinalUpdateID: event.LastUpdateID,
+		Bids:          PriceLevels(event.Bids),
+		Asks:          PriceLevels(event.Asks),
+	}
+}
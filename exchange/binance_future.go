@@ -2,7 +2,9 @@ package exchange
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -10,9 +12,11 @@ import (
 	"github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/common"
 	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
 
 	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/tools/log"
 )
 
@@ -38,6 +42,8 @@ type BinanceFuture struct {
 	HeikinAshi bool
 	Testnet    bool
 
+	APIURL    string
+	WsURL     string
 	APIKey    string
 	APISecret string
 
@@ -62,6 +68,16 @@ func WithBinanceFutureCredentials(key, secret string) BinanceFutureOption {
 	}
 }
 
+// WithBinanceFutureAPIEndpoint will set custom endpoints for the REST API and the websocket streams of
+// Binance Futures, eg. WsURL + "/btcusdt@kline_1m". An empty wsURL keeps the default streams. The kline
+// and trade streams are redirected, the order book stream always uses the default endpoint.
+func WithBinanceFutureAPIEndpoint(apiURL, wsURL string) BinanceFutureOption {
+	return func(b *BinanceFuture) {
+		b.APIURL = apiURL
+		b.WsURL = wsURL
+	}
+}
+
 // WithBinanceFutureLeverage will set the leverage for a pair
 func WithBinanceFutureLeverage(pair string, leverage int, marginType MarginType) BinanceFutureOption {
 	return func(b *BinanceFuture) {
@@ -73,6 +89,14 @@ func WithBinanceFutureLeverage(pair string, leverage int, marginType MarginType)
 	}
 }
 
+// WithBinanceFutureMetadataFetcher will include aditional information about the market in complete candles,
+// eg. the fetchers of FuturesData
+func WithBinanceFutureMetadataFetcher(fetcher MetadataFetchers) BinanceFutureOption {
+	return func(b *BinanceFuture) {
+		b.MetadataFetchers = append(b.MetadataFetchers, fetcher)
+	}
+}
+
 // NewBinanceFuture will create a new BinanceFuture instance
 func NewBinanceFuture(ctx context.Context, options ...BinanceFutureOption) (*BinanceFuture, error) {
 	binance.WebsocketKeepalive = true
@@ -82,6 +106,9 @@ func NewBinanceFuture(ctx context.Context, options ...BinanceFutureOption) (*Bin
 	}
 
 	exchange.client = futures.NewClient(exchange.APIKey, exchange.APISecret)
+	if exchange.APIURL != "" {
+		exchange.client.SetApiEndpoint(exchange.APIURL)
+	}
 	err := exchange.client.NewPingService().Do(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("binance ping fail: %w", err)
@@ -129,6 +156,10 @@ func NewBinanceFuture(ctx context.Context, options ...BinanceFutureOption) (*Bin
 					tradeLimits.MaxPrice, _ = strconv.ParseFloat(filter["maxPrice"].(string), 64)
 					tradeLimits.TickSize, _ = strconv.ParseFloat(filter["tickSize"].(string), 64)
 				}
+
+				if typ == string(futures.SymbolFilterTypeMinNotional) {
+					tradeLimits.MinNotional, _ = strconv.ParseFloat(filter["notional"].(string), 64)
+				}
 			}
 		}
 		exchange.assetsInfo[info.Symbol] = tradeLimits
@@ -181,16 +212,14 @@ func (b *BinanceFuture) CreateOrderStop(pair string, quantity float64, limit flo
 
 	order, err := b.client.NewCreateOrderService().Symbol(pair).
 		Type(futures.OrderTypeStopMarket).
-		TimeInForce(futures.TimeInForceTypeGTC).
 		Side(futures.SideTypeSell).
 		Quantity(b.formatQuantity(pair, quantity)).
-		Price(b.formatPrice(pair, limit)).
+		StopPrice(b.formatPrice(pair, limit)).
 		Do(b.ctx)
 	if err != nil {
 		return model.Order{}, err
 	}
 
-	price, _ := strconv.ParseFloat(order.Price, 64)
 	quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
 
 	return model.Order{
@@ -201,7 +230,8 @@ func (b *BinanceFuture) CreateOrderStop(pair string, quantity float64, limit flo
 		Side:       model.SideType(order.Side),
 		Type:       model.OrderType(order.Type),
 		Status:     model.OrderStatusType(order.Status),
-		Price:      price,
+		Price:      limit,
+		Stop:       &limit,
 		Quantity:   quantity,
 	}, nil
 }
@@ -263,6 +293,94 @@ func (b *BinanceFuture) CreateOrderLimit(side model.SideType, pair string,
 	}, nil
 }
 
+// CreateOrder creates an order given a generic request. Post-only orders are sent as GTX limit orders.
+func (b *BinanceFuture) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	err := request.Validate()
+	if err != nil {
+		return model.Order{}, &OrderError{Err: err, Pair: request.Pair, Quantity: request.Quantity}
+	}
+
+	err = b.validate(request.Pair, request.Quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	service := b.client.NewCreateOrderService().
+		Symbol(request.Pair).
+		Side(futures.SideType(request.Side)).
+		Quantity(b.formatQuantity(request.Pair, request.Quantity)).
+		NewOrderResponseType(futures.NewOrderRespTypeRESULT)
+
+	switch {
+	case request.Type == model.OrderTypeMarket:
+		service.Type(futures.OrderTypeMarket)
+	case request.IsPostOnly():
+		service.Type(futures.OrderTypeLimit).
+			TimeInForce(futures.TimeInForceTypeGTX).
+			Price(b.formatPrice(request.Pair, request.Price))
+	default:
+		timeInForce := futures.TimeInForceTypeGTC
+		if request.TimeInForce != "" {
+			timeInForce = futures.TimeInForceType(request.TimeInForce)
+		}
+		service.Type(futures.OrderTypeLimit).
+			TimeInForce(timeInForce).
+			Price(b.formatPrice(request.Pair, request.Price))
+	}
+
+	if request.ReduceOnly {
+		service.ReduceOnly(true)
+	}
+
+	if request.ClientID != "" {
+		service.NewClientOrderID(request.ClientID)
+	}
+
+	order, err := service.Do(b.ctx)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	var price, quantity float64
+	cost, _ := strconv.ParseFloat(order.CumQuote, 64)
+	executed, _ := strconv.ParseFloat(order.ExecutedQuantity, 64)
+	if request.Type == model.OrderTypeMarket && cost > 0 && executed > 0 {
+		price = cost / executed
+		quantity = executed
+	} else {
+		price, err = strconv.ParseFloat(order.Price, 64)
+		if err != nil {
+			return model.Order{}, err
+		}
+
+		quantity, err = strconv.ParseFloat(order.OrigQuantity, 64)
+		if err != nil {
+			return model.Order{}, err
+		}
+	}
+
+	orderType := model.OrderType(order.Type)
+	if order.TimeInForce == futures.TimeInForceTypeGTX {
+		orderType = model.OrderTypeLimitMaker
+	}
+
+	return model.Order{
+		ExchangeID:  order.OrderID,
+		CreatedAt:   time.Unix(0, order.UpdateTime*int64(time.Millisecond)),
+		UpdatedAt:   time.Unix(0, order.UpdateTime*int64(time.Millisecond)),
+		Pair:        request.Pair,
+		Side:        model.SideType(order.Side),
+		Type:        orderType,
+		Status:      model.OrderStatusType(order.Status),
+		Price:       price,
+		Quantity:    quantity,
+		TimeInForce: model.TimeInForceType(order.TimeInForce),
+		ReduceOnly:  order.ReduceOnly,
+		ClientID:    order.ClientOrderID,
+		Tag:         request.Tag,
+	}, nil
+}
+
 func (b *BinanceFuture) CreateOrderMarket(side model.SideType, pair string, quantity float64) (model.Order, error) {
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -315,6 +433,41 @@ func (b *BinanceFuture) Cancel(order model.Order) error {
 	return err
 }
 
+// ReplaceOrder cancels an open limit order and creates a new one with the given price and quantity.
+// The operation is emulated with two requests, the new order is not created if the cancellation fails.
+func (b *BinanceFuture) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	if order.Type != model.OrderTypeLimit && order.Type != model.OrderTypeLimitMaker {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: replace %s order", ErrNotSupported, order.Type),
+			Pair:     order.Pair,
+			Quantity: quantity,
+		}
+	}
+
+	err := b.validate(order.Pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	err = b.Cancel(order)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	request := model.NewOrderRequest(order.Side, order.Pair, quantity,
+		model.WithLimitPrice(price),
+		model.WithTag(order.Tag),
+	)
+	if order.Type == model.OrderTypeLimitMaker {
+		request.PostOnly = true
+	} else if order.TimeInForce != "" {
+		request.TimeInForce = order.TimeInForce
+	}
+	request.ReduceOnly = order.ReduceOnly
+
+	return b.CreateOrder(request)
+}
+
 func (b *BinanceFuture) Orders(pair string, limit int) ([]model.Order, error) {
 	result, err := b.client.NewListOrdersService().
 		Symbol(pair).
@@ -361,7 +514,7 @@ func newFutureOrder(order *futures.Order) model.Order {
 		log.CheckErr(log.WarnLevel, err)
 	}
 
-	return model.Order{
+	result := model.Order{
 		ExchangeID: order.OrderID,
 		Pair:       order.Symbol,
 		CreatedAt:  time.Unix(0, order.Time*int64(time.Millisecond)),
@@ -372,6 +525,22 @@ func newFutureOrder(order *futures.Order) model.Order {
 		Price:      price,
 		Quantity:   quantity,
 	}
+
+	// stop market orders have no price until executed
+	if stop, _ := strconv.ParseFloat(order.StopPrice, 64); stop > 0 {
+		result.Stop = &stop
+		if result.Price == 0 {
+			result.Price = stop
+		}
+	}
+	return result
+}
+
+// WithContext returns a copy of the exchange that sends the account and order requests with ctx
+func (b *BinanceFuture) WithContext(ctx context.Context) service.Exchange {
+	exchange := *b
+	exchange.ctx = ctx
+	return &exchange
 }
 
 func (b *BinanceFuture) Account() (model.Account, error) {
@@ -442,7 +611,73 @@ func (b *BinanceFuture) Position(pair string) (asset, quote float64, err error)
 	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
 }
 
+// PositionInfo returns the open position of a pair from the position risk endpoint.
+// In hedge mode, the side with the largest quantity is returned.
+func (b *BinanceFuture) PositionInfo(pair string) (model.Position, error) {
+	risks, err := b.client.NewGetPositionRiskService().Symbol(pair).Do(b.ctx)
+	if err != nil {
+		return model.Position{}, err
+	}
+
+	position := model.Position{Pair: pair}
+	for _, risk := range risks {
+		amount, err := strconv.ParseFloat(risk.PositionAmt, 64)
+		if err != nil {
+			return model.Position{}, err
+		}
+
+		leverage, err := strconv.ParseFloat(risk.Leverage, 64)
+		if err != nil {
+			return model.Position{}, err
+		}
+
+		// keep leverage and margin type of pairs without position
+		if position.Leverage == 0 {
+			position.Leverage = leverage
+			position.MarginType = newMarginType(risk.MarginType)
+		}
+
+		if amount == 0 || math.Abs(amount) <= position.Quantity {
+			continue
+		}
+
+		position.Side = model.PositionSideTypeLong
+		if amount < 0 || risk.PositionSide == string(futures.PositionSideTypeShort) {
+			position.Side = model.PositionSideTypeShort
+		}
+
+		position.Quantity = math.Abs(amount)
+		position.Leverage = leverage
+		position.MarginType = newMarginType(risk.MarginType)
+		position.EntryPrice, _ = strconv.ParseFloat(risk.EntryPrice, 64)
+		position.MarkPrice, _ = strconv.ParseFloat(risk.MarkPrice, 64)
+		position.UnrealizedPnL, _ = strconv.ParseFloat(risk.UnRealizedProfit, 64)
+		position.LiquidationPrice, _ = strconv.ParseFloat(risk.LiquidationPrice, 64)
+
+		if position.MarginType == model.MarginTypeIsolated {
+			position.Margin, _ = strconv.ParseFloat(risk.IsolatedMargin, 64)
+		} else {
+			notional, _ := strconv.ParseFloat(risk.Notional, 64)
+			position.Margin = math.Abs(notional) / leverage
+		}
+	}
+
+	return position, nil
+}
+
+// newMarginType converts the margin type of position risk endpoint, eg. "isolated" or "cross"
+func newMarginType(marginType string) model.MarginType {
+	if strings.EqualFold(marginType, string(model.MarginTypeIsolated)) {
+		return model.MarginTypeIsolated
+	}
+	return model.MarginTypeCrossed
+}
+
 func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	if source, ok := binanceFutureResampler.source(period); ok {
+		return binanceFutureResampler.CandlesSubscription(ctx, b, pair, source, period)
+	}
+
 	ccandle := make(chan model.Candle)
 	cerr := make(chan error)
 	ha := model.NewHeikinAshi()
@@ -454,7 +689,7 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 		}
 
 		for {
-			done, _, err := futures.WsKlineServe(pair, period, func(event *futures.WsKlineEvent) {
+			done, stop, err := b.wsKlineServe(pair, period, func(event *futures.WsKlineEvent) {
 				ba.Reset()
 				candle := FutureCandleFromWsKline(pair, event.Kline)
 
@@ -470,13 +705,21 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 					}
 				}
 
-				ccandle <- candle
-
+				select {
+				case ccandle <- candle:
+				case <-ctx.Done():
+				}
 			}, func(err error) {
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 			})
 			if err != nil {
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 				close(cerr)
 				close(ccandle)
 				return
@@ -484,6 +727,8 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 
 			select {
 			case <-ctx.Done():
+				close(stop)
+				<-done
 				close(cerr)
 				close(ccandle)
 				return
@@ -496,7 +741,155 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 	return ccandle, cerr
 }
 
+// TradesSubscription streams the aggregated trades of a pair, it reconnects when the websocket is closed
+func (b *BinanceFuture) TradesSubscription(ctx context.Context, pair string) (chan model.Trade, chan error) {
+	ctrade := make(chan model.Trade)
+	cerr := make(chan error)
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		for {
+			done, stop, err := b.wsAggTradeServe(pair, func(event *futures.WsAggTradeEvent) {
+				ba.Reset()
+				select {
+				case ctrade <- FutureTradeFromWsAggTrade(pair, event):
+				case <-ctx.Done():
+				}
+			}, func(err error) {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+				close(cerr)
+				close(ctrade)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				close(stop)
+				<-done
+				close(cerr)
+				close(ctrade)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return ctrade, cerr
+}
+
+func (b *BinanceFuture) wsKlineServe(pair, period string, handler futures.WsKlineHandler,
+	errHandler futures.ErrHandler) (chan struct{}, chan struct{}, error) {
+
+	if b.WsURL == "" {
+		return futures.WsKlineServe(pair, period, handler, errHandler)
+	}
+	return wsServeEvent(fmt.Sprintf("%s/%s@kline_%s", b.WsURL, strings.ToLower(pair), period), handler, errHandler)
+}
+
+func (b *BinanceFuture) wsAggTradeServe(pair string, handler futures.WsAggTradeHandler,
+	errHandler futures.ErrHandler) (chan struct{}, chan struct{}, error) {
+
+	if b.WsURL == "" {
+		return futures.WsAggTradeServe(pair, handler, errHandler)
+	}
+	return wsServeEvent(fmt.Sprintf("%s/%s@aggTrade", b.WsURL, strings.ToLower(pair)), handler, errHandler)
+}
+
+// wsServeEvent reads the events of a websocket stream until stop is closed, like the serve functions of
+// the futures client, which do not allow custom endpoints
+func wsServeEvent[T any](endpoint string, handler func(*T), errHandler futures.ErrHandler) (
+	done, stop chan struct{}, err error) {
+
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done = make(chan struct{})
+	stop = make(chan struct{})
+	go func() {
+		defer close(done)
+
+		stopped := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+				close(stopped)
+			case <-done:
+			}
+			conn.Close()
+		}()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-stopped:
+				default:
+					errHandler(err)
+				}
+				return
+			}
+
+			event := new(T)
+			if err := json.Unmarshal(message, event); err != nil {
+				errHandler(err)
+				continue
+			}
+			handler(event)
+		}
+	}()
+	return done, stop, nil
+}
+
+// OrderBookSubscription streams the order book of a pair, kept from the diff depth stream and synced
+// with snapshots of the REST API. It reconnects when the websocket is closed.
+func (b *BinanceFuture) OrderBookSubscription(ctx context.Context, pair string) (chan model.OrderBook, chan error) {
+	serve := func(pair string, handler func(model.DepthUpdate), errHandler func(error)) (
+		chan struct{}, chan struct{}, error) {
+
+		return futures.WsDiffDepthServeWithRate(pair, 100*time.Millisecond, func(event *futures.WsDepthEvent) {
+			handler(FutureDepthUpdateFromWsDepth(pair, event))
+		}, errHandler)
+	}
+
+	return orderBookSubscription(ctx, pair, serve, b.orderBook)
+}
+
+func (b *BinanceFuture) orderBook(ctx context.Context, pair string) (model.OrderBook, error) {
+	depth, err := b.client.NewDepthService().Symbol(pair).Limit(1000).Do(ctx)
+	if err != nil {
+		return model.OrderBook{}, err
+	}
+
+	return model.OrderBook{
+		Pair:         pair,
+		Time:         time.Unix(0, depth.Time*int64(time.Millisecond)),
+		LastUpdateID: depth.LastUpdateID,
+		Bids:         PriceLevels(depth.Bids),
+		Asks:         PriceLevels(depth.Asks),
+	}, nil
+}
+
 func (b *BinanceFuture) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	if source, ok := binanceFutureResampler.source(period); ok {
+		return binanceFutureResampler.CandlesByLimit(ctx, b, pair, source, period, limit)
+	}
+
 	candles := make([]model.Candle, 0)
 	klineService := b.client.NewKlinesService()
 	ha := model.NewHeikinAshi()
@@ -527,6 +920,10 @@ func (b *BinanceFuture) CandlesByLimit(ctx context.Context, pair, period string,
 func (b *BinanceFuture) CandlesByPeriod(ctx context.Context, pair, period string,
 	start, end time.Time) ([]model.Candle, error) {
 
+	if source, ok := binanceFutureResampler.source(period); ok {
+		return binanceFutureResampler.CandlesByPeriod(ctx, b, pair, source, period, start, end)
+	}
+
 	candles := make([]model.Candle, 0)
 	klineService := b.client.NewKlinesService()
 	ha := model.NewHeikinAshi()
@@ -591,3 +988,30 @@ func FutureCandleFromWsKline(pair string, k futures.WsKline) model.Candle {
 	candle.Metadata = make(map[string]float64)
 	return candle
 }
+
+func FutureTradeFromWsAggTrade(pair string, event *futures.WsAggTradeEvent) model.Trade {
+	var err error
+	trade := model.Trade{
+		ID:           event.AggregateTradeID,
+		Pair:         pair,
+		Time:         time.Unix(0, event.TradeTime*int64(time.Millisecond)),
+		IsBuyerMaker: event.Maker,
+	}
+	trade.Price, err = strconv.ParseFloat(event.Price, 64)
+	log.CheckErr(log.WarnLevel, err)
+	trade.Quantity, err = strconv.ParseFloat(event.Quantity, 64)
+	log.CheckErr(log.WarnLevel, err)
+	return trade
+}
+
+func FutureDepthUpdateFromWsDepth(pair string, event *futures.WsDepthEvent) model.DepthUpdate {
+	return model.DepthUpdate{
+		Pair:              pair,
+		Time:              time.Unix(0, event.Time*int64(time.Millisecond)),
+		FirstUpdateID:     event.FirstUpdateID,
+		FinalUpdateID:     event.LastUpdateID,
+		PrevFinalUpdateID: event.PrevLastUpdateID,
+		Bids:              PriceLevels(event.Bids),
+		Asks:              PriceLevels(event.Asks),
+	}
+}
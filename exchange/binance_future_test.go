@@ -0,0 +1,261 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange/binancetest"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestBinanceFuture_PositionInfo(t *testing.T) {
+	response := `[{"symbol":"BTCUSDT","positionAmt":"-0.500","entryPrice":"20000.0","markPrice":"19000.0",` +
+		`"unRealizedProfit":"500.0","liquidationPrice":"23500.0","leverage":"5","marginType":"isolated",` +
+		`"isolatedMargin":"2500.0","positionSide":"BOTH","notional":"-9500.0"}]`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/fapi/v2/positionRisk", r.URL.Path)
+		if r.URL.Query().Get("symbol") != "BTCUSDT" {
+			_, _ = w.Write([]byte(`[{"symbol":"ETHUSDT","positionAmt":"0","leverage":"20",` +
+				`"marginType":"cross","positionSide":"BOTH","notional":"0"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	client := futures.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	exchange := BinanceFuture{ctx: context.Background(), client: client}
+
+	position, err := exchange.PositionInfo("BTCUSDT")
+	require.NoError(t, err)
+	require.Equal(t, model.Position{
+		Pair:             "BTCUSDT",
+		Side:             model.PositionSideTypeShort,
+		Quantity:         0.5,
+		EntryPrice:       20000,
+		MarkPrice:        19000,
+		Leverage:         5,
+		MarginType:       model.MarginTypeIsolated,
+		Margin:           2500,
+		UnrealizedPnL:    500,
+		LiquidationPrice: 23500,
+	}, position)
+
+	position, err = exchange.PositionInfo("ETHUSDT")
+	require.NoError(t, err)
+	require.False(t, position.IsOpen())
+	require.Equal(t, 20.0, position.Leverage)
+	require.Equal(t, model.MarginTypeCrossed, position.MarginType)
+}
+
+func TestBinanceFuture_CreateOrderStop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/fapi/v1/order", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "STOP_MARKET", r.Form.Get("type"))
+		require.Equal(t, "100", r.Form.Get("stopPrice"))
+		require.Empty(t, r.Form.Get("price"))
+		require.Empty(t, r.Form.Get("timeInForce"))
+
+		_, _ = w.Write([]byte(`{"symbol":"BTCUSDT","orderId":1,"updateTime":1000,"price":"0",` +
+			`"stopPrice":"100","origQty":"0.5","executedQty":"0","status":"NEW","type":"STOP_MARKET",` +
+			`"side":"SELL"}`))
+	}))
+	defer server.Close()
+
+	client := futures.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	exchange := BinanceFuture{
+		ctx:    context.Background(),
+		client: client,
+		assetsInfo: map[string]model.AssetInfo{
+			"BTCUSDT": {MinQuantity: 0.1, MaxQuantity: 100, StepSize: 0.1, TickSize: 0.1,
+				BaseAssetPrecision: 1, QuotePrecision: 1},
+		},
+	}
+
+	order, err := exchange.CreateOrderStop("BTCUSDT", 0.5, 100)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderType(futures.OrderTypeStopMarket), order.Type)
+	require.Equal(t, 100.0, order.Price)
+	require.Equal(t, 100.0, *order.Stop)
+}
+
+func TestNewFutureOrder(t *testing.T) {
+	order := newFutureOrder(&futures.Order{Symbol: "BTCUSDT", OrderID: 1, Price: "0", StopPrice: "100",
+		OrigQuantity: "0.5", Type: futures.OrderTypeStopMarket, Status: futures.OrderStatusTypeNew})
+	require.Equal(t, 100.0, order.Price)
+	require.Equal(t, 100.0, *order.Stop)
+
+	order = newFutureOrder(&futures.Order{Symbol: "BTCUSDT", OrderID: 1, Price: "90", StopPrice: "0",
+		OrigQuantity: "0.5", Type: futures.OrderTypeLimit, Status: futures.OrderStatusTypeNew})
+	require.Equal(t, 90.0, order.Price)
+	require.Nil(t, order.Stop)
+}
+
+func TestBinanceFuture_FakeServer(t *testing.T) {
+	server := binancetest.NewServer(
+		binancetest.WithSymbol(binancetest.Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+			MinQuantity: 0.001, StepSize: 0.001, TickSize: 0.1, MinNotional: 5}),
+		binancetest.WithFuturesBalance("USDT", 1000),
+	)
+	defer server.Close()
+	server.Play("BTCUSDT", time.Hour, 90, 95, 100)
+
+	options := []BinanceFutureOption{
+		WithBinanceFutureCredentials(server.APIKey, server.APISecret),
+		WithBinanceFutureAPIEndpoint(server.URL, server.FuturesWsURL),
+		WithBinanceFutureLeverage("BTCUSDT", 10, MarginTypeIsolated),
+	}
+	exchange, err := NewBinanceFuture(context.Background(), options...)
+	require.NoError(t, err)
+
+	// margin type already changed
+	_, err = NewBinanceFuture(context.Background(), options...)
+	require.NoError(t, err)
+
+	t.Run("candles", func(t *testing.T) {
+		candles, err := exchange.CandlesByLimit(context.Background(), "BTCUSDT", "2h", 1)
+		require.NoError(t, err)
+		require.Len(t, candles, 1)
+		require.Equal(t, 90.0, candles[0].Open)
+		require.Equal(t, 95.0, candles[0].Close)
+	})
+
+	t.Run("positions", func(t *testing.T) {
+		order, err := exchange.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 100.0, order.Price)
+
+		server.Trade("BTCUSDT", 110, 1)
+		position, err := exchange.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeLong, position.Side)
+		require.Equal(t, 1.0, position.Quantity)
+		require.Equal(t, 100.0, position.EntryPrice)
+		require.Equal(t, 10.0, position.UnrealizedPnL)
+		require.Equal(t, 10.0, position.Leverage)
+		require.Equal(t, model.MarginTypeIsolated, position.MarginType)
+		require.Equal(t, 10.0, position.Margin)
+
+		order, err = exchange.CreateOrder(model.NewOrderRequest(model.SideTypeSell, "BTCUSDT", 1,
+			model.WithLimitPrice(120), model.WithReduceOnly()))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+
+		server.Trade("BTCUSDT", 121, 1)
+		order, err = exchange.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+
+		account, err := exchange.Account()
+		require.NoError(t, err)
+		require.Len(t, account.Balances, 1)
+		require.Equal(t, 1020.0, account.Balances[0].Free)
+
+		// reduce-only without position
+		_, err = exchange.CreateOrder(model.NewOrderRequest(model.SideTypeSell, "BTCUSDT", 1,
+			model.WithLimitPrice(130), model.WithReduceOnly()))
+		var apiErr *common.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, int64(-2022), apiErr.Code)
+	})
+
+	t.Run("stop and post-only orders", func(t *testing.T) {
+		order, err := exchange.CreateOrderStop("BTCUSDT", 0.5, 100)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderType(futures.OrderTypeStopMarket), order.Type)
+
+		server.Trade("BTCUSDT", 99, 1)
+		order, err = exchange.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 99.0, order.Price)
+
+		account, err := exchange.Account()
+		require.NoError(t, err)
+		btc, _ := account.Balance("BTC", "USDT")
+		require.Equal(t, -0.5, btc.Free)
+
+		// post-only orders that would take liquidity are expired
+		order, err = exchange.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 0.5,
+			model.WithLimitPrice(100), model.WithPostOnly()))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeLimitMaker, order.Type)
+		require.Equal(t, model.OrderStatusTypeExpired, order.Status)
+
+		order, err = exchange.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.5, 90)
+		require.NoError(t, err)
+		replaced, err := exchange.ReplaceOrder(order, 95, 0.5)
+		require.NoError(t, err)
+		require.NotEqual(t, order.ExchangeID, replaced.ExchangeID)
+
+		server.Trade("BTCUSDT", 94, 1)
+		position, err := exchange.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Zero(t, position.Quantity)
+
+		orders, err := exchange.Orders("BTCUSDT", 10)
+		require.NoError(t, err)
+		require.Len(t, orders, 6)
+		require.Equal(t, model.OrderStatusTypeCanceled, orders[4].Status)
+		require.Equal(t, model.OrderStatusTypeFilled, orders[5].Status)
+	})
+
+	t.Run("candles subscription", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		candles, errs := exchange.CandlesSubscription(ctx, "BTCUSDT", "1m")
+		require.Eventually(t, func() bool {
+			return server.Subscribers("btcusdt@kline_1m") == 1
+		}, time.Second, 10*time.Millisecond)
+
+		server.Trade("BTCUSDT", 200, 1)
+		candle := <-candles
+		require.False(t, candle.Complete)
+		require.Equal(t, 200.0, candle.Close)
+
+		server.Advance(time.Minute)
+		candle = <-candles
+		require.True(t, candle.Complete)
+		require.Equal(t, 200.0, candle.High)
+
+		cancel()
+		for range errs {
+		}
+		for range candles {
+		}
+	})
+
+	t.Run("trades subscription", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		trades, errs := exchange.TradesSubscription(ctx, "BTCUSDT")
+		require.Eventually(t, func() bool {
+			return server.Subscribers("btcusdt@aggTrade") == 1
+		}, time.Second, 10*time.Millisecond)
+
+		server.Trade("BTCUSDT", 210, 2)
+		trade := <-trades
+		require.Equal(t, 210.0, trade.Price)
+		require.Equal(t, 2.0, trade.Quantity)
+
+		cancel()
+		for range errs {
+		}
+		for range trades {
+		}
+	})
+}
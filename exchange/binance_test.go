@@ -1,11 +1,18 @@
 package exchange
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
 	"github.com/stretchr/testify/require"
 
+	"github.com/rodrigo-brito/ninjabot/exchange/binancetest"
 	"github.com/rodrigo-brito/ninjabot/model"
 )
 
@@ -51,3 +58,376 @@ func TestFormatQuantity(t *testing.T) {
 		})
 	}
 }
+
+func TestBinance_ReplaceOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/api/v3/order/cancelReplace", r.URL.Path)
+		require.Equal(t, "key", r.Header.Get("X-MBX-APIKEY"))
+		require.NotEmpty(t, r.URL.Query().Get("signature"))
+		require.Equal(t, "STOP_ON_FAILURE", r.URL.Query().Get("cancelReplaceMode"))
+		require.Equal(t, "GTC", r.URL.Query().Get("timeInForce"))
+		require.Equal(t, "1.5", r.URL.Query().Get("quantity"))
+
+		if r.URL.Query().Get("cancelOrderId") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":-2022,"msg":"Order cancel-replace failed.",` +
+				`"data":{"cancelResult":"FAILURE","newOrderResult":"NOT_ATTEMPTED"}}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"cancelResult":"SUCCESS","newOrderResult":"SUCCESS",` +
+			`"newOrderResponse":{"symbol":"BTCUSDT","orderId":2,"clientOrderId":"abc","transactTime":1000,` +
+			`"price":"90.00","origQty":"1.5","executedQty":"0","cummulativeQuoteQty":"0","status":"NEW",` +
+			`"timeInForce":"GTC","type":"LIMIT","side":"BUY"}}`))
+	}))
+	defer server.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	exchange := Binance{
+		ctx:    context.Background(),
+		client: client,
+		assetsInfo: map[string]model.AssetInfo{
+			"BTCUSDT": {MinQuantity: 0.1, MaxQuantity: 100, StepSize: 0.1, TickSize: 0.01,
+				BaseAssetPrecision: 1, QuotePrecision: 2},
+		},
+	}
+
+	order := model.Order{ExchangeID: 1, Pair: "BTCUSDT", Side: model.SideTypeBuy, Type: model.OrderTypeLimit,
+		Tag: "entry"}
+	newOrder, err := exchange.ReplaceOrder(order, 90, 1.5)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), newOrder.ExchangeID)
+	require.Equal(t, model.OrderStatusTypeNew, newOrder.Status)
+	require.Equal(t, 90.0, newOrder.Price)
+	require.Equal(t, 1.5, newOrder.Quantity)
+	require.Equal(t, "entry", newOrder.Tag)
+
+	order.ExchangeID = 3
+	_, err = exchange.ReplaceOrder(order, 90, 1.5)
+	var apiErr *common.APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, int64(-2022), apiErr.Code)
+
+	order.Type = model.OrderTypeMarket
+	_, err = exchange.ReplaceOrder(order, 90, 1.5)
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestBinance_CreateOrderStop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/order", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "STOP_LOSS", r.Form.Get("type"))
+		require.Equal(t, "100", r.Form.Get("stopPrice"))
+		require.Empty(t, r.Form.Get("price"))
+		require.Empty(t, r.Form.Get("timeInForce"))
+
+		_, _ = w.Write([]byte(`{"symbol":"BTCUSDT","orderId":1,"transactTime":1000,"price":"0.00",` +
+			`"origQty":"0.5","executedQty":"0","status":"NEW","type":"STOP_LOSS","side":"SELL"}`))
+	}))
+	defer server.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	exchange := Binance{
+		ctx:    context.Background(),
+		client: client,
+		assetsInfo: map[string]model.AssetInfo{
+			"BTCUSDT": {MinQuantity: 0.1, MaxQuantity: 100, StepSize: 0.1, TickSize: 0.01,
+				BaseAssetPrecision: 1, QuotePrecision: 2},
+		},
+	}
+
+	order, err := exchange.CreateOrderStop("BTCUSDT", 0.5, 100)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderTypeStopLoss, order.Type)
+	require.Equal(t, 100.0, order.Price)
+	require.Equal(t, 100.0, *order.Stop)
+	require.Equal(t, 0.5, order.Quantity)
+}
+
+func TestNewOrder(t *testing.T) {
+	order := newOrder(&binance.Order{Symbol: "BTCUSDT", OrderID: 1, Price: "0.00", StopPrice: "100.00",
+		OrigQuantity: "0.5", Type: binance.OrderTypeStopLoss, Status: binance.OrderStatusTypeNew})
+	require.Equal(t, 100.0, order.Price)
+	require.Equal(t, 100.0, *order.Stop)
+
+	// executed orders keep the average price
+	order = newOrder(&binance.Order{Symbol: "BTCUSDT", OrderID: 1, Price: "0.00", StopPrice: "100.00",
+		OrigQuantity: "0.5", ExecutedQuantity: "0.5", CummulativeQuoteQuantity: "49.5",
+		Type: binance.OrderTypeStopLoss, Status: binance.OrderStatusTypeFilled})
+	require.Equal(t, 99.0, order.Price)
+	require.Equal(t, 100.0, *order.Stop)
+
+	order = newOrder(&binance.Order{Symbol: "BTCUSDT", OrderID: 1, Price: "90.00", StopPrice: "0.00",
+		OrigQuantity: "0.5", Type: binance.OrderTypeLimit, Status: binance.OrderStatusTypeNew})
+	require.Equal(t, 90.0, order.Price)
+	require.Nil(t, order.Stop)
+}
+
+func TestBinance_CandlesSubscription(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	wsURL := binance.BaseWsMainURL
+	defer func() {
+		binance.BaseWsMainURL = wsURL
+	}()
+	binance.BaseWsMainURL = "ws" + server.URL[len("http"):] + "/ws"
+
+	// the channels are closed even if nobody reads the connection error after the cancel
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	candles, errs := (&Binance{}).CandlesSubscription(ctx, "BTCUSDT", "1m")
+	for range candles {
+	}
+	for range errs {
+	}
+}
+
+func TestBinance_TradesSubscription(t *testing.T) {
+	server, exchange := newFakeBinance(t,
+		binancetest.WithSymbol(binancetest.Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+			MinQuantity: 0.0001, StepSize: 0.0001, TickSize: 0.01, MinNotional: 10}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	trades, errs := exchange.TradesSubscription(ctx, "BTCUSDT")
+	require.Eventually(t, func() bool {
+		return server.Subscribers("btcusdt@aggTrade") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	server.Trade("BTCUSDT", 100, 1)
+	trade := <-trades
+	require.Equal(t, 100.0, trade.Price)
+
+	// the handler is blocked by a trade nobody reads when the subscription is canceled
+	server.Trade("BTCUSDT", 101, 1)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	for range errs {
+	}
+	for range trades {
+	}
+}
+
+// newFakeBinance creates a Binance exchange connected to a fake server, the server and the custom
+// endpoints are restored at the end of the test
+func newFakeBinance(t *testing.T, options ...binancetest.Option) (*binancetest.Server, *Binance) {
+	server := binancetest.NewServer(options...)
+	apiURL, wsURL, combinedURL := binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL
+	t.Cleanup(func() {
+		server.Close()
+		binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL = apiURL, wsURL, combinedURL
+	})
+
+	exchange, err := NewBinance(context.Background(),
+		WithBinanceCredentials(server.APIKey, server.APISecret),
+		WithCustomMainAPIEndpoint(server.URL, server.WsURL, server.CombinedURL),
+	)
+	require.NoError(t, err)
+	return server, exchange
+}
+
+func TestBinance_FakeServer(t *testing.T) {
+	server, exchange := newFakeBinance(t,
+		binancetest.WithSymbol(binancetest.Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+			MinQuantity: 0.0001, StepSize: 0.0001, TickSize: 0.01, MinNotional: 10}),
+		binancetest.WithBalance("USDT", 10000),
+	)
+	server.Play("BTCUSDT", time.Minute, 100, 101, 102)
+
+	require.Equal(t, 10.0, exchange.AssetsInfo("BTCUSDT").MinNotional)
+	require.Equal(t, 0.01, exchange.AssetsInfo("BTCUSDT").TickSize)
+
+	t.Run("candles", func(t *testing.T) {
+		candles, err := exchange.CandlesByLimit(context.Background(), "BTCUSDT", "1m", 2)
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		require.Equal(t, binancetest.DefaultStartTime.Add(time.Minute).Unix(), candles[0].Time.Unix())
+		require.Equal(t, 101.0, candles[0].Close)
+		require.Equal(t, 102.0, candles[1].Close)
+
+		candles, err = exchange.CandlesByPeriod(context.Background(), "BTCUSDT", "1m",
+			binancetest.DefaultStartTime, binancetest.DefaultStartTime.Add(time.Minute))
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		require.Equal(t, 100.0, candles[0].Open)
+
+		quote, err := exchange.LastQuote(context.Background(), "BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 102.0, quote)
+	})
+
+	t.Run("market and limit orders", func(t *testing.T) {
+		order, err := exchange.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 102.0, order.Price)
+
+		order, err = exchange.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 0.5, 110)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+
+		account, err := exchange.Account()
+		require.NoError(t, err)
+		btc, usdt := account.Balance("BTC", "USDT")
+		require.Equal(t, 0.5, btc.Free)
+		require.Equal(t, 0.5, btc.Lock)
+		require.Equal(t, 9898.0, usdt.Free)
+
+		server.Trade("BTCUSDT", 111, 1)
+		order, err = exchange.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 110.0, order.Price)
+
+		order, err = exchange.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 55.5)
+		require.NoError(t, err)
+		require.Equal(t, 0.5, order.Quantity)
+		require.Equal(t, 111.0, order.Price)
+
+		asset, quote, err := exchange.Position("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, asset)
+		require.Equal(t, 9897.5, quote)
+	})
+
+	t.Run("stop order", func(t *testing.T) {
+		order, err := exchange.CreateOrderStop("BTCUSDT", 0.5, 100)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeStopLoss, order.Type)
+		require.Equal(t, 100.0, *order.Stop)
+
+		server.Trade("BTCUSDT", 99, 1)
+		order, err = exchange.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 99.0, order.Price)
+	})
+
+	t.Run("oco", func(t *testing.T) {
+		orders, err := exchange.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.1, 120, 90, 89)
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		require.Equal(t, model.OrderTypeStopLossLimit, orders[0].Type)
+		require.Equal(t, model.OrderTypeLimitMaker, orders[1].Type)
+		require.Equal(t, *orders[0].GroupID, *orders[1].GroupID)
+
+		server.Trade("BTCUSDT", 121, 1)
+		stop, err := exchange.Order("BTCUSDT", orders[0].ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeExpired, stop.Status)
+		limit, err := exchange.Order("BTCUSDT", orders[1].ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, limit.Status)
+		require.Equal(t, 120.0, limit.Price)
+
+		// stop triggered and executed as taker of the stop limit
+		orders, err = exchange.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.1, 130, 110, 105)
+		require.NoError(t, err)
+		server.Trade("BTCUSDT", 108, 1)
+		stop, err = exchange.Order("BTCUSDT", orders[0].ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, stop.Status)
+		require.Equal(t, 108.0, stop.Price)
+		limit, err = exchange.Order("BTCUSDT", orders[1].ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeExpired, limit.Status)
+
+		// cancel of an order cancels the list
+		orders, err = exchange.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.1, 130, 100, 99)
+		require.NoError(t, err)
+		require.NoError(t, exchange.Cancel(orders[1]))
+		stop, err = exchange.Order("BTCUSDT", orders[0].ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, stop.Status)
+
+		free, locked := server.Balance("BTC")
+		require.InDelta(t, 0.3, free, 1e-9)
+		require.Zero(t, locked)
+	})
+
+	t.Run("replace and cancel", func(t *testing.T) {
+		order, err := exchange.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 80)
+		require.NoError(t, err)
+
+		replaced, err := exchange.ReplaceOrder(order, 85, 2)
+		require.NoError(t, err)
+		require.NotEqual(t, order.ExchangeID, replaced.ExchangeID)
+		require.Equal(t, 85.0, replaced.Price)
+
+		order, err = exchange.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, order.Status)
+
+		_, locked := server.Balance("USDT")
+		require.Equal(t, 170.0, locked)
+
+		require.NoError(t, exchange.Cancel(replaced))
+		var apiErr *common.APIError
+		require.ErrorAs(t, exchange.Cancel(replaced), &apiErr)
+		require.Equal(t, int64(-2011), apiErr.Code)
+
+		orders, err := exchange.Orders("BTCUSDT", 3)
+		require.NoError(t, err)
+		require.Len(t, orders, 3)
+		require.Equal(t, replaced.ExchangeID, orders[2].ExchangeID)
+	})
+
+	t.Run("rejected orders", func(t *testing.T) {
+		var apiErr *common.APIError
+		_, err := exchange.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1000, 100)
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, int64(-2010), apiErr.Code)
+
+		_, err = exchange.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 0.5,
+			model.WithLimitPrice(110), model.WithPostOnly()))
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, int64(-2010), apiErr.Code)
+
+		_, err = exchange.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.01, 100)
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, "Filter failure: NOTIONAL", apiErr.Message)
+	})
+
+	t.Run("candles subscription", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		candles, errs := exchange.CandlesSubscription(ctx, "BTCUSDT", "1m")
+		require.Eventually(t, func() bool {
+			return server.Subscribers("btcusdt@kline_1m") == 1
+		}, time.Second, 10*time.Millisecond)
+
+		server.Trade("BTCUSDT", 130, 1)
+		candle := <-candles
+		require.False(t, candle.Complete)
+		require.Equal(t, 130.0, candle.Close)
+
+		server.Advance(time.Minute)
+		candle = <-candles
+		require.True(t, candle.Complete)
+		require.Equal(t, 130.0, candle.High)
+
+		// reconnects when the connection is closed
+		server.Disconnect()
+		require.Error(t, <-errs)
+		require.Eventually(t, func() bool {
+			return server.Subscribers("btcusdt@kline_1m") == 1
+		}, time.Second, 10*time.Millisecond)
+
+		server.Trade("BTCUSDT", 131, 1)
+		candle = <-candles
+		require.Equal(t, 131.0, candle.Close)
+
+		cancel()
+		for range candles {
+		}
+		require.Eventually(t, func() bool {
+			return server.Subscribers("btcusdt@kline_1m") == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,475 @@
+package binancetest
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const (
+	defaultLeverage = 20
+	marginCrossed   = "CROSSED"
+	marginIsolated  = "ISOLATED"
+)
+
+var futuresOrderParams = []string{"quantity", "price", "stopPrice"}
+
+// position of a futures market in one-way mode, the amount is negative for shorts
+type position struct {
+	amount     float64
+	entry      float64
+	leverage   int
+	marginType string
+}
+
+func (p *position) unrealized(price float64) float64 {
+	return p.amount * (price - p.entry)
+}
+
+func (p *position) margin() float64 {
+	return math.Abs(p.amount) * p.entry / float64(p.leverage)
+}
+
+func (s *Server) position(symbol string) *position {
+	p, ok := s.positions[symbol]
+	if !ok {
+		p = &position{leverage: defaultLeverage, marginType: marginCrossed}
+		s.positions[symbol] = p
+	}
+	return p
+}
+
+// available returns the balance of an asset not used as margin of positions
+func (s *Server) available(asset string) float64 {
+	available := s.wallet[asset]
+	for symbol, p := range s.positions {
+		if s.symbols[symbol].QuoteAsset != asset {
+			continue
+		}
+		price, _ := s.price(symbol)
+		available += p.unrealized(price) - p.margin()
+	}
+	return available
+}
+
+// fillFutures updates the position with an execution, it returns the executed quantity, limited
+// to the position size for reduce-only orders
+func (s *Server) fillFutures(o *order, quantity, price float64) float64 {
+	p := s.position(o.symbol)
+	if o.reduceOnly {
+		if p.amount == 0 || (p.amount > 0) == (o.side == sideBuy) {
+			return 0
+		}
+		quantity = min(quantity, math.Abs(p.amount))
+	}
+
+	signed := quantity
+	if o.side == sideSell {
+		signed = -quantity
+	}
+
+	if p.amount == 0 || (p.amount > 0) == (signed > 0) {
+		p.entry = (p.entry*math.Abs(p.amount) + price*quantity) / (math.Abs(p.amount) + quantity)
+		p.amount += signed
+		return quantity
+	}
+
+	closed := min(quantity, math.Abs(p.amount))
+	s.wallet[s.symbols[o.symbol].QuoteAsset] += closed * (price - p.entry) * math.Copysign(1, p.amount)
+	flipped := math.Abs(signed) > math.Abs(p.amount)
+	p.amount += signed
+	switch {
+	case math.Abs(p.amount) < 1e-12:
+		p.amount, p.entry = 0, 0
+	case flipped:
+		p.entry = price
+	}
+	return quantity
+}
+
+func (s *Server) futuresRoutes() {
+	s.route(http.MethodGet, "/fapi/v1/ping", false, func(url.Values) (interface{}, *apiError) {
+		return struct{}{}, nil
+	})
+	s.route(http.MethodGet, "/fapi/v1/time", false, func(url.Values) (interface{}, *apiError) {
+		return map[string]int64{"serverTime": millis(s.now)}, nil
+	})
+	s.route(http.MethodGet, "/fapi/v1/exchangeInfo", false, s.futuresExchangeInfo)
+	s.route(http.MethodGet, "/fapi/v1/klines", false, s.klines)
+	s.route(http.MethodPost, "/fapi/v1/leverage", true, s.futuresLeverage)
+	s.route(http.MethodPost, "/fapi/v1/marginType", true, s.futuresMarginType)
+	s.route(http.MethodPost, "/fapi/v1/order", true, s.futuresCreateOrder)
+	s.route(http.MethodGet, "/fapi/v1/order", true, s.futuresGetOrder)
+	s.route(http.MethodDelete, "/fapi/v1/order", true, s.futuresCancelOrder)
+	s.route(http.MethodGet, "/fapi/v1/allOrders", true, s.futuresListOrders)
+	s.route(http.MethodGet, "/fapi/v2/account", true, s.futuresAccount)
+	s.route(http.MethodGet, "/fapi/v2/positionRisk", true, s.futuresPositionRisk)
+}
+
+// precision returns the number of decimals of a step
+func precision(step float64) int {
+	return max(0, int(-math.Round(math.Log10(step))))
+}
+
+func (s *Server) futuresExchangeInfo(url.Values) (interface{}, *apiError) {
+	info := futures.ExchangeInfo{Timezone: "UTC", ServerTime: millis(s.now)}
+	for _, symbol := range s.sortedSymbols() {
+		info.Symbols = append(info.Symbols, futures.Symbol{
+			Symbol:             symbol.Name,
+			Pair:               symbol.Name,
+			ContractType:       futures.ContractTypePerpetual,
+			Status:             "TRADING",
+			PricePrecision:     precision(symbol.TickSize),
+			QuantityPrecision:  precision(symbol.StepSize),
+			BaseAssetPrecision: 8,
+			QuotePrecision:     8,
+			OrderType:          []futures.OrderType{typeLimit, typeMarket, typeStopMarket},
+			TimeInForce:        []futures.TimeInForceType{tifGTC, tifIOC, tifFOK, tifGTX},
+			QuoteAsset:         symbol.QuoteAsset,
+			MarginAsset:        symbol.QuoteAsset,
+			BaseAsset:          symbol.BaseAsset,
+			Filters: []map[string]interface{}{
+				{
+					"filterType": string(futures.SymbolFilterTypePrice),
+					"minPrice":   formatFloat(symbol.MinPrice),
+					"maxPrice":   formatFloat(symbol.MaxPrice),
+					"tickSize":   formatFloat(symbol.TickSize),
+				},
+				{
+					"filterType": string(futures.SymbolFilterTypeLotSize),
+					"minQty":     formatFloat(symbol.MinQuantity),
+					"maxQty":     formatFloat(symbol.MaxQuantity),
+					"stepSize":   formatFloat(symbol.StepSize),
+				},
+				{
+					"filterType": string(futures.SymbolFilterTypeMinNotional),
+					"notional":   formatFloat(symbol.MinNotional),
+				},
+			},
+		})
+	}
+	return info, nil
+}
+
+func (s *Server) futuresLeverage(params url.Values) (interface{}, *apiError) {
+	symbol, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	leverage, err := strconv.Atoi(params.Get("leverage"))
+	if err != nil || leverage < 1 || leverage > 125 {
+		return nil, errorf(http.StatusBadRequest, -4028, "Leverage %s is not valid", params.Get("leverage"))
+	}
+
+	s.position(symbol.Name).leverage = leverage
+	return futures.SymbolLeverage{Leverage: leverage, MaxNotionalValue: "1000000", Symbol: symbol.Name}, nil
+}
+
+func (s *Server) futuresMarginType(params url.Values) (interface{}, *apiError) {
+	symbol, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	marginType := params.Get("marginType")
+	if marginType != marginCrossed && marginType != marginIsolated {
+		return nil, errorf(http.StatusBadRequest, -1102, "Invalid marginType.")
+	}
+
+	p := s.position(symbol.Name)
+	if p.marginType == marginType {
+		return nil, errorf(http.StatusBadRequest, -4046, "No need to change margin type.")
+	}
+	if p.amount != 0 {
+		return nil, errorf(http.StatusBadRequest, -4048, "Margin type cannot be changed if there exists position.")
+	}
+
+	p.marginType = marginType
+	return map[string]interface{}{"code": 200, "msg": "success"}, nil
+}
+
+func (s *Server) futuresCreateOrder(params url.Values) (interface{}, *apiError) {
+	symbol, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	o := &order{
+		listID:      -1,
+		clientID:    params.Get("newClientOrderId"),
+		symbol:      symbol.Name,
+		side:        params.Get("side"),
+		typ:         params.Get("type"),
+		timeInForce: params.Get("timeInForce"),
+		futures:     true,
+		reduceOnly:  params.Get("reduceOnly") == "true",
+	}
+	if o.side != sideBuy && o.side != sideSell {
+		return nil, errorf(http.StatusBadRequest, -1117, "Invalid side.")
+	}
+
+	var err *apiError
+	switch o.typ {
+	case typeLimit:
+		err = required(params, []string{"timeInForce", "quantity", "price"}, nil, futuresOrderParams)
+	case typeMarket:
+		err = required(params, []string{"quantity"}, nil, futuresOrderParams)
+	case typeStopMarket:
+		err = required(params, []string{"quantity", "stopPrice"}, nil, futuresOrderParams)
+	default:
+		return nil, errorf(http.StatusBadRequest, -1116, "Invalid orderType.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.timeInForce {
+	case "", tifGTC, tifIOC, tifFOK, tifGTX:
+	default:
+		return nil, errorf(http.StatusBadRequest, -1115, "Invalid timeInForce.")
+	}
+
+	values, err := floatParams(params, "quantity", "price", "stopPrice")
+	if err != nil {
+		return nil, err
+	}
+	o.quantity, o.price, o.stop = values[0], values[1], values[2]
+
+	last, hasPrice := s.price(symbol.Name)
+	if o.typ == typeMarket && !hasPrice {
+		return nil, errorf(http.StatusBadRequest, -2010, "Market has no price.")
+	}
+
+	notionalPrice := o.price
+	if notionalPrice == 0 {
+		notionalPrice = max(o.stop, last)
+	}
+	if err := symbol.check(o.quantity, o.price, notionalPrice); err != nil {
+		return nil, err
+	}
+
+	p := s.position(symbol.Name)
+	if o.reduceOnly && (p.amount == 0 || (p.amount > 0) == (o.side == sideBuy)) {
+		return nil, errorf(http.StatusBadRequest, -2022, "ReduceOnly Order is rejected.")
+	}
+	if o.stop > 0 && hasPrice && stopHit(o.side, last, o.stop) {
+		return nil, errorf(http.StatusBadRequest, -2021, "Order would immediately trigger.")
+	}
+
+	// margin of the quantity that increases the position
+	increase := o.quantity
+	if p.amount != 0 && (p.amount > 0) != (o.side == sideBuy) {
+		increase = max(0, o.quantity-math.Abs(p.amount))
+	}
+	if !o.reduceOnly && increase*notionalPrice/float64(p.leverage) > s.available(symbol.QuoteAsset)+1e-9 {
+		return nil, errorf(http.StatusBadRequest, -2019, "Margin is insufficient.")
+	}
+
+	s.addOrder(o)
+	if hasPrice {
+		// post-only orders that would be executed as taker are expired
+		if o.timeInForce == tifGTX && crosses(o.side, last, o.price) {
+			s.close(o, statusExpired)
+		} else {
+			s.execute(o, last)
+		}
+	}
+	return s.futuresOrderResponse(o), nil
+}
+
+func (s *Server) futuresGetOrder(params url.Values) (interface{}, *apiError) {
+	o, err := s.findOrder(params, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.futuresOrder(o), nil
+}
+
+func (s *Server) futuresCancelOrder(params url.Values) (interface{}, *apiError) {
+	o, err := s.findOrder(params, true)
+	if err != nil {
+		return nil, err
+	}
+	if !o.open() {
+		return nil, errorf(http.StatusBadRequest, -2011, "Unknown order sent.")
+	}
+
+	s.close(o, statusCanceled)
+	return s.futuresOrder(o), nil
+}
+
+func (s *Server) futuresListOrders(params url.Values) (interface{}, *apiError) {
+	orders, err := s.listOrders(params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]futures.Order, 0, len(orders))
+	for _, o := range orders {
+		result = append(result, s.futuresOrder(o))
+	}
+	return result, nil
+}
+
+func (s *Server) sortedPositions() []string {
+	symbols := make([]string, 0, len(s.positions))
+	for symbol := range s.positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (s *Server) futuresAccount(url.Values) (interface{}, *apiError) {
+	assets := make([]string, 0, len(s.wallet))
+	for asset := range s.wallet {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	account := futures.Account{CanTrade: true, UpdateTime: millis(s.now)}
+	for _, asset := range assets {
+		var unrealized, margin float64
+		for symbol, p := range s.positions {
+			if s.symbols[symbol].QuoteAsset == asset {
+				price, _ := s.price(symbol)
+				unrealized += p.unrealized(price)
+				margin += p.margin()
+			}
+		}
+
+		account.Assets = append(account.Assets, &futures.AccountAsset{
+			Asset:                 asset,
+			WalletBalance:         formatFloat(s.wallet[asset]),
+			UnrealizedProfit:      formatFloat(unrealized),
+			MarginBalance:         formatFloat(s.wallet[asset] + unrealized),
+			PositionInitialMargin: formatFloat(margin),
+			InitialMargin:         formatFloat(margin),
+			AvailableBalance:      formatFloat(s.available(asset)),
+			MaxWithdrawAmount:     formatFloat(s.available(asset)),
+			UpdateTime:            millis(s.now),
+		})
+	}
+
+	for _, symbol := range s.sortedPositions() {
+		p := s.positions[symbol]
+		price, _ := s.price(symbol)
+		account.Positions = append(account.Positions, &futures.AccountPosition{
+			Isolated:         p.marginType == marginIsolated,
+			Leverage:         strconv.Itoa(p.leverage),
+			InitialMargin:    formatFloat(p.margin()),
+			Symbol:           symbol,
+			UnrealizedProfit: formatFloat(p.unrealized(price)),
+			EntryPrice:       formatFloat(p.entry),
+			PositionSide:     futures.PositionSideTypeBoth,
+			PositionAmt:      formatFloat(p.amount),
+			Notional:         formatFloat(p.amount * price),
+			UpdateTime:       millis(s.now),
+		})
+	}
+	return account, nil
+}
+
+// futuresPositionRisk returns the positions, the liquidation price is an approximation without
+// maintenance margin
+func (s *Server) futuresPositionRisk(params url.Values) (interface{}, *apiError) {
+	symbols := s.sortedPositions()
+	if symbol := params.Get("symbol"); symbol != "" {
+		if _, ok := s.symbols[symbol]; !ok {
+			return nil, errInvalidSymbol()
+		}
+		s.position(symbol)
+		symbols = []string{symbol}
+	}
+
+	risks := make([]futures.PositionRisk, 0, len(symbols))
+	for _, symbol := range symbols {
+		p := s.positions[symbol]
+		price, _ := s.price(symbol)
+
+		var liquidation, isolatedMargin float64
+		if p.amount != 0 {
+			liquidation = p.entry * (1 - math.Copysign(1, p.amount)/float64(p.leverage))
+		}
+		marginType := "cross"
+		if p.marginType == marginIsolated {
+			marginType = "isolated"
+			isolatedMargin = p.margin()
+		}
+
+		risks = append(risks, futures.PositionRisk{
+			EntryPrice:       formatFloat(p.entry),
+			MarginType:       marginType,
+			IsolatedMargin:   formatFloat(isolatedMargin),
+			Leverage:         strconv.Itoa(p.leverage),
+			LiquidationPrice: formatFloat(liquidation),
+			MarkPrice:        formatFloat(price),
+			PositionAmt:      formatFloat(p.amount),
+			Symbol:           symbol,
+			UnRealizedProfit: formatFloat(p.unrealized(price)),
+			PositionSide:     string(futures.PositionSideTypeBoth),
+			Notional:         formatFloat(p.amount * price),
+		})
+	}
+	return risks, nil
+}
+
+func (s *Server) futuresOrder(o *order) futures.Order {
+	var average float64
+	if o.executed > 0 {
+		average = o.cost / o.executed
+	}
+
+	return futures.Order{
+		Symbol:           o.symbol,
+		OrderID:          o.id,
+		ClientOrderID:    o.clientID,
+		Price:            formatFloat(o.price),
+		ReduceOnly:       o.reduceOnly,
+		OrigQuantity:     formatFloat(o.quantity),
+		ExecutedQuantity: formatFloat(o.executed),
+		CumQuantity:      formatFloat(o.executed),
+		CumQuote:         formatFloat(o.cost),
+		Status:           futures.OrderStatusType(o.status),
+		TimeInForce:      futures.TimeInForceType(o.timeInForce),
+		Type:             futures.OrderType(o.typ),
+		Side:             futures.SideType(o.side),
+		StopPrice:        formatFloat(o.stop),
+		Time:             millis(o.created),
+		UpdateTime:       millis(o.updated),
+		WorkingType:      futures.WorkingTypeContractPrice,
+		AvgPrice:         formatFloat(average),
+		OrigType:         futures.OrderType(o.typ),
+		PositionSide:     futures.PositionSideTypeBoth,
+	}
+}
+
+func (s *Server) futuresOrderResponse(o *order) futures.CreateOrderResponse {
+	order := s.futuresOrder(o)
+	return futures.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          order.OrderID,
+		ClientOrderID:    order.ClientOrderID,
+		Price:            order.Price,
+		OrigQuantity:     order.OrigQuantity,
+		ExecutedQuantity: order.ExecutedQuantity,
+		CumQuote:         order.CumQuote,
+		ReduceOnly:       order.ReduceOnly,
+		Status:           order.Status,
+		StopPrice:        order.StopPrice,
+		TimeInForce:      order.TimeInForce,
+		Type:             order.Type,
+		Side:             order.Side,
+		UpdateTime:       order.UpdateTime,
+		WorkingType:      order.WorkingType,
+		AvgPrice:         order.AvgPrice,
+		PositionSide:     order.PositionSide,
+		CumQty:           order.CumQuantity,
+		OrigType:         order.OrigType,
+	}
+}
@@ -0,0 +1,377 @@
+package binancetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
+)
+
+var intervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"3m":  3 * time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"2h":  2 * time.Hour,
+	"4h":  4 * time.Hour,
+	"6h":  6 * time.Hour,
+	"8h":  8 * time.Hour,
+	"12h": 12 * time.Hour,
+	"1d":  24 * time.Hour,
+	"3d":  72 * time.Hour,
+}
+
+// candle of one minute, the candles of other intervals are aggregated from them
+type candle struct {
+	open                          time.Time
+	o, h, l, c                    float64
+	volume, quoteVolume           float64
+	trades, firstTrade, lastTrade int64
+}
+
+func (c *candle) merge(other *candle) {
+	c.h = max(c.h, other.h)
+	c.l = min(c.l, other.l)
+	c.c = other.c
+	c.volume += other.volume
+	c.quoteVolume += other.quoteVolume
+	c.trades += other.trades
+	if c.firstTrade == 0 {
+		c.firstTrade = other.firstTrade
+	}
+	if other.lastTrade != 0 {
+		c.lastTrade = other.lastTrade
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 8, 64)
+}
+
+// periodStart returns the open time of the candle of an interval that contains the time
+func periodStart(t time.Time, interval time.Duration) time.Time {
+	ms := millis(t)
+	return time.UnixMilli(ms - ms%interval.Milliseconds()).UTC()
+}
+
+func (s *Server) price(symbol string) (float64, bool) {
+	candles := s.candles[strings.ToUpper(symbol)]
+	if len(candles) == 0 {
+		return 0, false
+	}
+	return candles[len(candles)-1].c, true
+}
+
+func (s *Server) trade(symbol string, price, quantity float64) {
+	s.trades[symbol]++
+	id := s.trades[symbol]
+	minute := s.now.Truncate(time.Minute)
+
+	candles := s.candles[symbol]
+	if len(candles) == 0 || candles[len(candles)-1].open.Before(minute) {
+		candles = append(candles, &candle{open: minute, o: price, h: price, l: price, c: price})
+		s.candles[symbol] = candles
+	}
+
+	last := candles[len(candles)-1]
+	last.merge(&candle{h: price, l: price, c: price, volume: quantity, quoteVolume: price * quantity,
+		trades: 1, firstTrade: id, lastTrade: id})
+
+	stream := strings.ToLower(symbol)
+	s.publish(stream+"@aggTrade", binance.WsAggTradeEvent{
+		Event:                 "aggTrade",
+		Time:                  millis(s.now),
+		Symbol:                symbol,
+		AggTradeID:            id,
+		Price:                 formatFloat(price),
+		Quantity:              formatFloat(quantity),
+		FirstBreakdownTradeID: id,
+		LastBreakdownTradeID:  id,
+		TradeTime:             millis(s.now),
+	})
+
+	for name := range s.subscribers {
+		if interval, ok := strings.CutPrefix(name, stream+"@kline_"); ok {
+			s.publishKline(symbol, interval, periodStart(s.now, intervals[interval]), false)
+		}
+	}
+}
+
+func (s *Server) advance(d time.Duration) {
+	previous := s.now
+	s.now = s.now.Add(d)
+
+	// candles without trades keep the last price
+	minute := s.now.Truncate(time.Minute)
+	for symbol, candles := range s.candles {
+		last := candles[len(candles)-1]
+		for t := last.open.Add(time.Minute); !t.After(minute); t = t.Add(time.Minute) {
+			candles = append(candles, &candle{open: t, o: last.c, h: last.c, l: last.c, c: last.c})
+		}
+		s.candles[symbol] = candles
+	}
+
+	for name := range s.subscribers {
+		symbol, interval, ok := strings.Cut(name, "@kline_")
+		if !ok {
+			continue
+		}
+		duration := intervals[interval]
+		for start := periodStart(previous, duration); !start.Add(duration).After(s.now); start = start.Add(duration) {
+			s.publishKline(strings.ToUpper(symbol), interval, start, true)
+		}
+	}
+}
+
+// kline aggregates the candles of a period, it returns false when there is no candle in the period
+func (s *Server) kline(symbol string, start time.Time, interval time.Duration) (*candle, bool) {
+	candles := s.candles[symbol]
+	i := sort.Search(len(candles), func(i int) bool {
+		return !candles[i].open.Before(start)
+	})
+	if i == len(candles) || !candles[i].open.Before(start.Add(interval)) {
+		return nil, false
+	}
+
+	result := *candles[i]
+	result.open = start
+	for _, c := range candles[i+1:] {
+		if !c.open.Before(start.Add(interval)) {
+			break
+		}
+		result.merge(c)
+	}
+	return &result, true
+}
+
+func (s *Server) publishKline(symbol, interval string, start time.Time, final bool) {
+	duration := intervals[interval]
+	k, ok := s.kline(symbol, start, duration)
+	if !ok {
+		return
+	}
+
+	s.publish(strings.ToLower(symbol)+"@kline_"+interval, binance.WsKlineEvent{
+		Event:  "kline",
+		Time:   millis(s.now),
+		Symbol: symbol,
+		Kline: binance.WsKline{
+			StartTime:    millis(k.open),
+			EndTime:      millis(k.open.Add(duration)) - 1,
+			Symbol:       symbol,
+			Interval:     interval,
+			FirstTradeID: k.firstTrade,
+			LastTradeID:  k.lastTrade,
+			Open:         formatFloat(k.o),
+			Close:        formatFloat(k.c),
+			High:         formatFloat(k.h),
+			Low:          formatFloat(k.l),
+			Volume:       formatFloat(k.volume),
+			TradeNum:     k.trades,
+			IsFinal:      final,
+			QuoteVolume:  formatFloat(k.quoteVolume),
+		},
+	})
+}
+
+// klines serves the candles of spot and futures markets, including the candle in progress
+func (s *Server) klines(params url.Values) (interface{}, *apiError) {
+	symbol := params.Get("symbol")
+	if _, ok := s.symbols[symbol]; !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	interval, ok := intervals[params.Get("interval")]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, -1120, "Invalid interval.")
+	}
+
+	limit := 500
+	if value := params.Get("limit"); value != "" {
+		limit, _ = strconv.Atoi(value)
+		limit = min(max(limit, 1), 1000)
+	}
+
+	startTime, hasStart := parseMillis(params.Get("startTime"))
+	endTime, hasEnd := parseMillis(params.Get("endTime"))
+
+	klines := make([]*candle, 0)
+	for _, c := range s.candles[symbol] {
+		start := periodStart(c.open, interval)
+		if len(klines) > 0 && klines[len(klines)-1].open.Equal(start) {
+			continue
+		}
+		if (hasStart && start.Before(startTime)) || (hasEnd && start.After(endTime)) {
+			continue
+		}
+		k, _ := s.kline(symbol, start, interval)
+		klines = append(klines, k)
+	}
+
+	if len(klines) > limit {
+		if hasStart {
+			klines = klines[:limit]
+		} else {
+			klines = klines[len(klines)-limit:]
+		}
+	}
+
+	result := make([][]interface{}, 0, len(klines))
+	for _, k := range klines {
+		result = append(result, []interface{}{
+			millis(k.open), formatFloat(k.o), formatFloat(k.h), formatFloat(k.l), formatFloat(k.c),
+			formatFloat(k.volume), millis(k.open.Add(interval)) - 1, formatFloat(k.quoteVolume), k.trades,
+			"0", "0", "0",
+		})
+	}
+	return result, nil
+}
+
+func parseMillis(value string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms).UTC(), true
+}
+
+// subscriber is a websocket client, messages are queued to not block the server
+type subscriber struct {
+	conn     *websocket.Conn
+	streams  []string
+	combined bool
+
+	mtx    sync.Mutex
+	queue  [][]byte
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func (c *subscriber) push(stream string, data []byte) {
+	if c.combined {
+		data, _ = json.Marshal(struct {
+			Stream string          `json:"stream"`
+			Data   json.RawMessage `json:"data"`
+		}{stream, data})
+	}
+
+	c.mtx.Lock()
+	c.queue = append(c.queue, data)
+	c.mtx.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *subscriber) write() {
+	for {
+		select {
+		case <-c.notify:
+			c.mtx.Lock()
+			queue := c.queue
+			c.queue = nil
+			c.mtx.Unlock()
+
+			for _, data := range queue {
+				if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (s *Server) publish(stream string, event interface{}) {
+	if len(s.subscribers[stream]) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+	for sub := range s.subscribers[stream] {
+		sub.push(stream, data)
+	}
+}
+
+func validStream(stream string) bool {
+	symbol, kind, ok := strings.Cut(stream, "@")
+	if !ok || symbol == "" {
+		return false
+	}
+	if interval, ok := strings.CutPrefix(kind, "kline_"); ok {
+		_, ok = intervals[interval]
+		return ok
+	}
+	return kind == "aggTrade"
+}
+
+// serveStream serves raw streams in /ws/<stream> and combined streams in /stream?streams=<a>/<b>,
+// the futures streams are served in /fws/<stream> and /fstream?streams=<a>/<b>
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	sub := &subscriber{notify: make(chan struct{}, 1), done: make(chan struct{})}
+	if _, stream, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/"); ok {
+		sub.streams = []string{stream}
+	} else {
+		sub.streams = strings.Split(r.URL.Query().Get("streams"), "/")
+		sub.combined = true
+	}
+
+	for _, stream := range sub.streams {
+		if !validStream(stream) {
+			http.Error(w, fmt.Sprintf("invalid stream %q", stream), http.StatusBadRequest)
+			return
+		}
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	sub.conn = conn
+
+	s.mtx.Lock()
+	for _, stream := range sub.streams {
+		if s.subscribers[stream] == nil {
+			s.subscribers[stream] = make(map[*subscriber]bool)
+		}
+		s.subscribers[stream][sub] = true
+	}
+	s.mtx.Unlock()
+
+	go sub.write()
+
+	// read until the client disconnects, answering the keepalive pings
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mtx.Lock()
+	for _, stream := range sub.streams {
+		delete(s.subscribers[stream], sub)
+		if len(s.subscribers[stream]) == 0 {
+			delete(s.subscribers, stream)
+		}
+	}
+	s.mtx.Unlock()
+
+	close(sub.done)
+	conn.Close()
+}
@@ -0,0 +1,306 @@
+package binancetest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	sideBuy  = "BUY"
+	sideSell = "SELL"
+
+	statusNew      = "NEW"
+	statusFilled   = "FILLED"
+	statusCanceled = "CANCELED"
+	statusExpired  = "EXPIRED"
+
+	typeLimit         = "LIMIT"
+	typeLimitMaker    = "LIMIT_MAKER"
+	typeMarket        = "MARKET"
+	typeStopLoss      = "STOP_LOSS"
+	typeStopLossLimit = "STOP_LOSS_LIMIT"
+	typeStopMarket    = "STOP_MARKET"
+
+	tifGTC = "GTC"
+	tifIOC = "IOC"
+	tifFOK = "FOK"
+	tifGTX = "GTX"
+)
+
+// order of spot or futures markets, it is always filled in a single execution
+type order struct {
+	id          int64
+	listID      int64
+	clientID    string
+	symbol      string
+	side        string
+	typ         string
+	timeInForce string
+	futures     bool
+	reduceOnly  bool
+
+	price         float64
+	stop          float64
+	quantity      float64
+	quoteQuantity float64
+	executed      float64
+	cost          float64
+	status        string
+	triggered     bool
+	created       time.Time
+	updated       time.Time
+
+	// amount of spot balance locked by the order, shared by the orders of an OCO list
+	locked float64
+}
+
+func (o *order) open() bool {
+	return o.status == statusNew
+}
+
+func (o *order) working() bool {
+	return (o.typ != typeStopLoss && o.typ != typeStopLossLimit && o.typ != typeStopMarket) || o.triggered
+}
+
+// crosses returns true when a limit order is executable at the price
+func crosses(side string, price, limit float64) bool {
+	if side == sideBuy {
+		return price <= limit
+	}
+	return price >= limit
+}
+
+// stopHit returns true when a stop order is triggered at the price
+func stopHit(side string, price, stop float64) bool {
+	if side == sideBuy {
+		return price >= stop
+	}
+	return price <= stop
+}
+
+func (s *Server) addOrder(o *order) {
+	o.id = s.nextID
+	s.nextID++
+	if o.clientID == "" {
+		o.clientID = fmt.Sprintf("binancetest%d", o.id)
+	}
+	o.status = statusNew
+	o.created = s.now
+	o.updated = s.now
+	s.orders[o.id] = o
+	s.orderIDs = append(s.orderIDs, o.id)
+}
+
+// findOrder returns an order of the market by id or client id
+func (s *Server) findOrder(params url.Values, futures bool) (*order, *apiError) {
+	symbol := params.Get("symbol")
+	if _, ok := s.symbols[symbol]; !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	if value := params.Get("orderId"); value != "" {
+		id, _ := strconv.ParseInt(value, 10, 64)
+		if o, ok := s.orders[id]; ok && o.symbol == symbol && o.futures == futures {
+			return o, nil
+		}
+	} else if clientID := params.Get("origClientOrderId"); clientID != "" {
+		for _, id := range s.orderIDs {
+			o := s.orders[id]
+			if o.clientID == clientID && o.symbol == symbol && o.futures == futures {
+				return o, nil
+			}
+		}
+	} else {
+		return nil, errMandatory("orderId")
+	}
+
+	return nil, errorf(http.StatusBadRequest, -2013, "Order does not exist.")
+}
+
+// listOrders returns the orders of a market from an id, or the last ones
+func (s *Server) listOrders(params url.Values, futures bool) ([]*order, *apiError) {
+	symbol := params.Get("symbol")
+	if _, ok := s.symbols[symbol]; !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	limit := 500
+	if value := params.Get("limit"); value != "" {
+		limit, _ = strconv.Atoi(value)
+		limit = min(max(limit, 1), 1000)
+	}
+	fromID, _ := strconv.ParseInt(params.Get("orderId"), 10, 64)
+
+	orders := make([]*order, 0)
+	for _, id := range s.orderIDs {
+		o := s.orders[id]
+		if o.symbol == symbol && o.futures == futures && o.id >= fromID {
+			orders = append(orders, o)
+		}
+	}
+
+	if len(orders) > limit {
+		if fromID > 0 {
+			return orders[:limit], nil
+		}
+		return orders[len(orders)-limit:], nil
+	}
+	return orders, nil
+}
+
+// match executes the open orders of a symbol at a new price, resting limit orders are filled
+// at their limit price and triggered stop orders at the market price
+func (s *Server) match(symbol string, price float64) {
+	for _, id := range s.orderIDs {
+		o := s.orders[id]
+		if o.symbol != symbol || !o.open() {
+			continue
+		}
+
+		switch o.typ {
+		case typeLimit, typeLimitMaker:
+			if crosses(o.side, price, o.price) {
+				s.fill(o, o.price)
+			}
+		case typeStopLoss, typeStopMarket:
+			if stopHit(o.side, price, o.stop) {
+				o.triggered = true
+				s.fill(o, price)
+			}
+		case typeStopLossLimit:
+			if !o.triggered {
+				if !stopHit(o.side, price, o.stop) {
+					continue
+				}
+				o.triggered = true
+				o.updated = s.now
+				s.finishList(o)
+				if crosses(o.side, price, o.price) {
+					s.fill(o, price)
+				}
+			} else if crosses(o.side, price, o.price) {
+				s.fill(o, o.price)
+			}
+		}
+	}
+}
+
+// execute matches a new order with the current price as a taker
+func (s *Server) execute(o *order, price float64) {
+	switch o.typ {
+	case typeMarket:
+		s.fill(o, price)
+	case typeLimit:
+		if crosses(o.side, price, o.price) {
+			s.fill(o, price)
+		} else if o.timeInForce == tifIOC || o.timeInForce == tifFOK {
+			s.close(o, statusExpired)
+		}
+	}
+}
+
+func (s *Server) fill(o *order, price float64) {
+	quantity := o.quantity - o.executed
+	if o.futures {
+		if quantity = s.fillFutures(o, quantity, price); quantity == 0 {
+			s.close(o, statusExpired)
+			return
+		}
+	} else {
+		s.fillSpot(o, quantity, price)
+	}
+
+	o.executed += quantity
+	o.cost += quantity * price
+	o.status = statusFilled
+	o.updated = s.now
+	s.finishList(o)
+}
+
+// close finishes an open order, releasing its locked balance
+func (s *Server) close(o *order, status string) {
+	if o.locked > 0 {
+		b := s.balance(s.lockedAsset(o))
+		b.locked -= o.locked
+		b.free += o.locked
+		o.locked = 0
+	}
+	o.status = status
+	o.updated = s.now
+}
+
+// finishList expires the other orders of an OCO list when an order is filled or triggered
+func (s *Server) finishList(o *order) {
+	if o.listID < 0 {
+		return
+	}
+	for _, id := range s.orderIDs {
+		other := s.orders[id]
+		if other.listID == o.listID && other.id != o.id && other.open() {
+			other.locked = 0
+			other.status = statusExpired
+			other.updated = s.now
+		}
+	}
+}
+
+// check validates the filters of a symbol, the price is optional
+func (symbol Symbol) check(quantity, price, notionalPrice float64) *apiError {
+	if quantity < symbol.MinQuantity || quantity > symbol.MaxQuantity || !multiple(quantity, symbol.StepSize) {
+		return errorf(http.StatusBadRequest, -1013, "Filter failure: LOT_SIZE")
+	}
+	if price != 0 && (price < symbol.MinPrice || price > symbol.MaxPrice || !multiple(price, symbol.TickSize)) {
+		return errorf(http.StatusBadRequest, -1013, "Filter failure: PRICE_FILTER")
+	}
+	if quantity*notionalPrice < symbol.MinNotional {
+		return errorf(http.StatusBadRequest, -1013, "Filter failure: NOTIONAL")
+	}
+	return nil
+}
+
+func multiple(value, step float64) bool {
+	ratio := value / step
+	return math.Abs(ratio-math.Round(ratio)) < 1e-6
+}
+
+// floatParams parses the given optional float parameters
+func floatParams(params url.Values, names ...string) ([]float64, *apiError) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		values[i], err = strconv.ParseFloat(value, 64)
+		if err != nil || values[i] < 0 {
+			return nil, errorf(http.StatusBadRequest, -1100, "Illegal characters found in parameter '%s'.", name)
+		}
+	}
+	return values, nil
+}
+
+// required returns an error for the first missing parameter, or for a parameter sent when not required
+func required(params url.Values, names []string, optional []string, all []string) *apiError {
+	for _, name := range names {
+		if params.Get(name) == "" {
+			return errMandatory(name)
+		}
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range append(names, optional...) {
+		allowed[name] = true
+	}
+	for _, name := range all {
+		if params.Get(name) != "" && !allowed[name] {
+			return errorf(http.StatusBadRequest, -1106, "Parameter '%s' sent when not required.", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+// Package binancetest provides an in-process fake of the Binance spot and futures APIs for integration
+// tests. It serves the REST endpoints used by the exchange adapters, the kline and aggregated trade
+// streams of the spot and futures websockets and matches orders against a scripted price path.
+package binancetest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultStartTime is the initial time of the server clock
+var DefaultStartTime = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Symbol is a market available in spot and futures, with the filters of exchange info
+type Symbol struct {
+	Name        string
+	BaseAsset   string
+	QuoteAsset  string
+	MinQuantity float64
+	MaxQuantity float64
+	StepSize    float64
+	MinPrice    float64
+	MaxPrice    float64
+	TickSize    float64
+	MinNotional float64
+}
+
+// Server is a fake Binance exchange. Prices only change with Trade, Play and Advance, so the tests
+// control the price path and the clock of candles and orders.
+type Server struct {
+	// URL is the base of the REST API
+	URL string
+	// WsURL is the base of the websocket streams, eg. WsURL + "/btcusdt@kline_1m"
+	WsURL string
+	// CombinedURL is the base of the combined websocket streams
+	CombinedURL string
+	// FuturesWsURL and FuturesCombinedURL are the bases of the futures streams, with the same market data
+	FuturesWsURL       string
+	FuturesCombinedURL string
+
+	APIKey    string
+	APISecret string
+
+	mtx      sync.Mutex
+	http     *httptest.Server
+	routes   map[string]handler
+	now      time.Time
+	symbols  map[string]Symbol
+	candles  map[string][]*candle
+	trades   map[string]int64
+	balances map[string]*balance
+	wallet   map[string]float64
+
+	orders    map[int64]*order
+	orderIDs  []int64
+	nextID    int64
+	nextList  int64
+	positions map[string]*position
+
+	upgrader    websocket.Upgrader
+	subscribers map[string]map[*subscriber]bool
+}
+
+type Option func(*Server)
+
+// WithCredentials sets the API key and secret required in signed requests
+func WithCredentials(key, secret string) Option {
+	return func(s *Server) {
+		s.APIKey = key
+		s.APISecret = secret
+	}
+}
+
+// WithSymbol adds a market, the filters not set are permissive
+func WithSymbol(symbol Symbol) Option {
+	return func(s *Server) {
+		symbol.Name = strings.ToUpper(symbol.Name)
+		if symbol.MaxQuantity == 0 {
+			symbol.MaxQuantity = 9000000
+		}
+		if symbol.StepSize == 0 {
+			symbol.StepSize = 0.00000001
+		}
+		if symbol.MaxPrice == 0 {
+			symbol.MaxPrice = 1000000
+		}
+		if symbol.TickSize == 0 {
+			symbol.TickSize = 0.00000001
+		}
+		s.symbols[symbol.Name] = symbol
+	}
+}
+
+// WithBalance sets the free balance of an asset in the spot account
+func WithBalance(asset string, amount float64) Option {
+	return func(s *Server) {
+		s.balances[asset] = &balance{free: amount}
+	}
+}
+
+// WithFuturesBalance sets the wallet balance of an asset in the futures account
+func WithFuturesBalance(asset string, amount float64) Option {
+	return func(s *Server) {
+		s.wallet[asset] = amount
+	}
+}
+
+// WithStartTime sets the initial time of the server clock, default is DefaultStartTime
+func WithStartTime(t time.Time) Option {
+	return func(s *Server) {
+		s.now = t.UTC()
+	}
+}
+
+// NewServer starts a fake exchange, it must be closed by the caller
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		APIKey:      "key",
+		APISecret:   "secret",
+		now:         DefaultStartTime,
+		symbols:     make(map[string]Symbol),
+		candles:     make(map[string][]*candle),
+		trades:      make(map[string]int64),
+		balances:    make(map[string]*balance),
+		wallet:      make(map[string]float64),
+		orders:      make(map[int64]*order),
+		nextID:      1,
+		nextList:    1,
+		positions:   make(map[string]*position),
+		subscribers: make(map[string]map[*subscriber]bool),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	s.routes = make(map[string]handler)
+	s.spotRoutes()
+	s.futuresRoutes()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/", s.serveStream)
+	mux.HandleFunc("/stream", s.serveStream)
+	mux.HandleFunc("/fws/", s.serveStream)
+	mux.HandleFunc("/fstream", s.serveStream)
+	mux.HandleFunc("/", s.serveAPI)
+	s.http = httptest.NewServer(mux)
+
+	s.URL = s.http.URL
+	wsURL := "ws" + strings.TrimPrefix(s.http.URL, "http")
+	s.WsURL = wsURL + "/ws"
+	s.CombinedURL = wsURL + "/stream?streams="
+	s.FuturesWsURL = wsURL + "/fws"
+	s.FuturesCombinedURL = wsURL + "/fstream?streams="
+	return s
+}
+
+// Close disconnects the websocket clients and shuts down the server
+func (s *Server) Close() {
+	s.Disconnect()
+	s.http.Close()
+}
+
+// Now returns the time of the server clock
+func (s *Server) Now() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.now
+}
+
+// Price returns the last traded price of a symbol
+func (s *Server) Price(symbol string) float64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	price, _ := s.price(symbol)
+	return price
+}
+
+// Balance returns the free and locked amounts of an asset in the spot account
+func (s *Server) Balance(asset string) (free, locked float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if b, ok := s.balances[asset]; ok {
+		return b.free, b.locked
+	}
+	return 0, 0
+}
+
+// Trade executes a trade of the market at the current time, updating the candles, matching the open
+// orders and publishing the kline and trade streams
+func (s *Server) Trade(symbol string, price, quantity float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	symbol = strings.ToUpper(symbol)
+	s.trade(symbol, price, quantity)
+	s.match(symbol, price)
+}
+
+// Advance moves the clock forward, candles without trades repeat the last price and the streams
+// publish the candles closed in the period
+func (s *Server) Advance(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.advance(d)
+}
+
+// Play executes a trade of one unit for each price, advancing the clock by step after each one
+func (s *Server) Play(symbol string, step time.Duration, prices ...float64) {
+	for _, price := range prices {
+		s.Trade(symbol, price, 1)
+		s.Advance(step)
+	}
+}
+
+// Subscribers returns the number of websocket clients of a stream, eg. btcusdt@kline_1m
+func (s *Server) Subscribers(stream string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.subscribers[stream])
+}
+
+// Disconnect closes the connections of all websocket clients, eg. to test reconnections
+func (s *Server) Disconnect() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for stream, subscribers := range s.subscribers {
+		for sub := range subscribers {
+			sub.conn.Close()
+		}
+		delete(s.subscribers, stream)
+	}
+}
+
+// handler of an endpoint, called with the server locked
+type handler struct {
+	signed bool
+	fn     func(params url.Values) (interface{}, *apiError)
+}
+
+type apiError struct {
+	status int
+	common.APIError
+}
+
+func errorf(status int, code int64, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, APIError: common.APIError{Code: code, Message: fmt.Sprintf(format, args...)}}
+}
+
+func errMandatory(name string) *apiError {
+	return errorf(http.StatusBadRequest, -1102,
+		"Mandatory parameter '%s' was not sent, was empty/null, or malformed.", name)
+}
+
+func errInvalidSymbol() *apiError {
+	return errorf(http.StatusBadRequest, -1121, "Invalid symbol.")
+}
+
+func (s *Server) route(method, path string, signed bool, fn func(params url.Values) (interface{}, *apiError)) {
+	s.routes[method+" "+path] = handler{signed: signed, fn: fn}
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	route, ok := s.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorf(http.StatusNotFound, -1000, "Unknown endpoint %s %s",
+			r.Method, r.URL.Path).APIError)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, common.APIError{Code: -1000, Message: err.Error()})
+		return
+	}
+
+	params, apiErr := s.params(r, string(body), route.signed)
+	if apiErr != nil {
+		writeJSON(w, apiErr.status, apiErr.APIError)
+		return
+	}
+
+	s.mtx.Lock()
+	result, apiErr := route.fn(params)
+	s.mtx.Unlock()
+	if apiErr != nil {
+		writeJSON(w, apiErr.status, apiErr.APIError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// params merges the query and form parameters, checking the signature of signed endpoints
+func (s *Server) params(r *http.Request, body string, signed bool) (url.Values, *apiError) {
+	params, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, -1100, "Illegal characters found in parameter: %s", err)
+	}
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, -1100, "Illegal characters found in parameter: %s", err)
+	}
+	for key, values := range form {
+		params[key] = append(params[key], values...)
+	}
+
+	if !signed {
+		return params, nil
+	}
+
+	if r.Header.Get("X-MBX-APIKEY") != s.APIKey {
+		return nil, errorf(http.StatusUnauthorized, -2015, "Invalid API-key, IP, or permissions for action.")
+	}
+	if params.Get("timestamp") == "" {
+		return nil, errMandatory("timestamp")
+	}
+
+	// the signature is the last parameter, computed from the query and body
+	query := r.URL.RawQuery
+	if i := strings.LastIndex(query, "signature="); i >= 0 {
+		query = strings.TrimSuffix(query[:i], "&")
+	}
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write([]byte(query + body))
+	if params.Get("signature") != hex.EncodeToString(mac.Sum(nil)) {
+		return nil, errorf(http.StatusBadRequest, -1022, "Signature for this request is not valid.")
+	}
+	return params, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// millis converts a time to the unix milliseconds of Binance
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,119 @@
+package binancetest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Signature(t *testing.T) {
+	server := NewServer(WithCredentials("api-key", "api-secret"), WithBalance("USDT", 100))
+	defer server.Close()
+
+	client := binance.NewClient("api-key", "api-secret")
+	client.BaseURL = server.URL
+	account, err := client.NewGetAccountService().Do(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []binance.Balance{{Asset: "USDT", Free: "100.00000000", Locked: "0.00000000"}},
+		account.Balances)
+
+	var apiErr *common.APIError
+	client = binance.NewClient("api-key", "invalid")
+	client.BaseURL = server.URL
+	_, err = client.NewGetAccountService().Do(context.Background())
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, int64(-1022), apiErr.Code)
+
+	client = binance.NewClient("invalid", "api-secret")
+	client.BaseURL = server.URL
+	_, err = client.NewGetAccountService().Do(context.Background())
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, int64(-2015), apiErr.Code)
+}
+
+func TestServer_Klines(t *testing.T) {
+	server := NewServer(WithSymbol(Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT"}))
+	defer server.Close()
+
+	server.Play("BTCUSDT", 20*time.Minute, 10, 12, 8, 11, 15)
+	require.Equal(t, DefaultStartTime.Add(100*time.Minute), server.Now())
+	require.Equal(t, 15.0, server.Price("BTCUSDT"))
+
+	client := binance.NewClient("", "")
+	client.BaseURL = server.URL
+	klines, err := client.NewKlinesService().Symbol("BTCUSDT").Interval("1h").Do(context.Background())
+	require.NoError(t, err)
+	require.Len(t, klines, 2)
+	require.Equal(t, millis(DefaultStartTime), klines[0].OpenTime)
+	require.Equal(t, millis(DefaultStartTime.Add(time.Hour))-1, klines[0].CloseTime)
+	require.Equal(t, []string{"10.00000000", "12.00000000", "8.00000000", "8.00000000", "3.00000000"},
+		[]string{klines[0].Open, klines[0].High, klines[0].Low, klines[0].Close, klines[0].Volume})
+	require.Equal(t, "15.00000000", klines[1].Close)
+
+	klines, err = client.NewKlinesService().Symbol("BTCUSDT").Interval("1m").Limit(3).
+		StartTime(millis(DefaultStartTime.Add(time.Minute))).Do(context.Background())
+	require.NoError(t, err)
+	require.Len(t, klines, 3)
+	require.Equal(t, "10.00000000", klines[0].Open)
+	require.Equal(t, "0.00000000", klines[0].Volume)
+
+	_, err = client.NewKlinesService().Symbol("BTCUSDT").Interval("1w").Do(context.Background())
+	require.Error(t, err)
+}
+
+func TestServer_CombinedStream(t *testing.T) {
+	server := NewServer(WithSymbol(Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT"}))
+	defer server.Close()
+
+	_, _, err := websocket.DefaultDialer.Dial(server.CombinedURL+"btcusdt@ticker", nil)
+	require.Error(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.CombinedURL+"btcusdt@kline_5m/btcusdt@aggTrade", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return server.Subscribers("btcusdt@aggTrade") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	server.Trade("BTCUSDT", 100, 2)
+	server.Advance(5 * time.Minute)
+
+	var event struct {
+		Stream string          `json:"stream"`
+		Data   json.RawMessage `json:"data"`
+	}
+	streams := make([]string, 0)
+	finals := 0
+	for i := 0; i < 3; i++ {
+		require.NoError(t, conn.ReadJSON(&event))
+		streams = append(streams, event.Stream)
+		if event.Stream == "btcusdt@kline_5m" {
+			var kline binance.WsKlineEvent
+			require.NoError(t, json.Unmarshal(event.Data, &kline))
+			require.Equal(t, "2.00000000", kline.Kline.Volume)
+			if kline.Kline.IsFinal {
+				finals++
+			}
+		}
+	}
+	require.ElementsMatch(t, []string{"btcusdt@aggTrade", "btcusdt@kline_5m", "btcusdt@kline_5m"}, streams)
+	require.Equal(t, 1, finals)
+
+	// futures streams share the market data
+	futures, _, err := websocket.DefaultDialer.Dial(server.FuturesCombinedURL+"btcusdt@aggTrade", nil)
+	require.NoError(t, err)
+	defer futures.Close()
+	require.Eventually(t, func() bool {
+		return server.Subscribers("btcusdt@aggTrade") == 2
+	}, time.Second, 10*time.Millisecond)
+
+	server.Trade("BTCUSDT", 101, 1)
+	require.NoError(t, futures.ReadJSON(&event))
+	require.Equal(t, "btcusdt@aggTrade", event.Stream)
+}
@@ -0,0 +1,546 @@
+package binancetest
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+var orderParams = []string{"quantity", "quoteOrderQty", "price", "stopPrice", "timeInForce"}
+
+type balance struct {
+	free, locked float64
+}
+
+func (s *Server) balance(asset string) *balance {
+	b, ok := s.balances[asset]
+	if !ok {
+		b = &balance{}
+		s.balances[asset] = b
+	}
+	return b
+}
+
+// lockedAsset returns the asset locked by a spot order
+func (s *Server) lockedAsset(o *order) string {
+	if o.side == sideBuy {
+		return s.symbols[o.symbol].QuoteAsset
+	}
+	return s.symbols[o.symbol].BaseAsset
+}
+
+func (s *Server) lock(o *order, amount float64) *apiError {
+	b := s.balance(s.lockedAsset(o))
+	if b.free < amount-1e-9 {
+		return errorf(http.StatusBadRequest, -2010, "Account has insufficient balance for requested action.")
+	}
+	b.free -= amount
+	b.locked += amount
+	o.locked = amount
+	return nil
+}
+
+func (s *Server) fillSpot(o *order, quantity, price float64) {
+	symbol := s.symbols[o.symbol]
+	base, quote := s.balance(symbol.BaseAsset), s.balance(symbol.QuoteAsset)
+	cost := quantity * price
+	if o.side == sideBuy {
+		quote.locked -= o.locked
+		quote.free += o.locked - cost
+		base.free += quantity
+	} else {
+		base.locked -= o.locked
+		base.free += o.locked - quantity
+		quote.free += cost
+	}
+	o.locked = 0
+}
+
+func (s *Server) spotRoutes() {
+	s.route(http.MethodGet, "/api/v3/ping", false, func(url.Values) (interface{}, *apiError) {
+		return struct{}{}, nil
+	})
+	s.route(http.MethodGet, "/api/v3/time", false, func(url.Values) (interface{}, *apiError) {
+		return map[string]int64{"serverTime": millis(s.now)}, nil
+	})
+	s.route(http.MethodGet, "/api/v3/exchangeInfo", false, s.spotExchangeInfo)
+	s.route(http.MethodGet, "/api/v3/klines", false, s.klines)
+	s.route(http.MethodGet, "/api/v3/account", true, s.spotAccount)
+	s.route(http.MethodPost, "/api/v3/order", true, s.spotCreateOrder)
+	s.route(http.MethodGet, "/api/v3/order", true, s.spotGetOrder)
+	s.route(http.MethodDelete, "/api/v3/order", true, s.spotCancelOrder)
+	s.route(http.MethodGet, "/api/v3/allOrders", true, s.spotListOrders)
+	s.route(http.MethodPost, "/api/v3/order/oco", true, s.spotCreateOCO)
+	s.route(http.MethodDelete, "/api/v3/orderList", true, s.spotCancelOCO)
+	s.route(http.MethodPost, "/api/v3/order/cancelReplace", true, s.spotCancelReplace)
+}
+
+func (s *Server) sortedSymbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.symbols))
+	for _, symbol := range s.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
+func (s *Server) spotExchangeInfo(url.Values) (interface{}, *apiError) {
+	info := binance.ExchangeInfo{Timezone: "UTC", ServerTime: millis(s.now)}
+	for _, symbol := range s.sortedSymbols() {
+		info.Symbols = append(info.Symbols, binance.Symbol{
+			Symbol:                     symbol.Name,
+			Status:                     "TRADING",
+			BaseAsset:                  symbol.BaseAsset,
+			BaseAssetPrecision:         8,
+			QuoteAsset:                 symbol.QuoteAsset,
+			QuotePrecision:             8,
+			QuoteAssetPrecision:        8,
+			OrderTypes:                 []string{typeLimit, typeLimitMaker, typeMarket, typeStopLoss, typeStopLossLimit},
+			OcoAllowed:                 true,
+			QuoteOrderQtyMarketAllowed: true,
+			IsSpotTradingAllowed:       true,
+			Permissions:                []string{"SPOT"},
+			Filters: []map[string]interface{}{
+				{
+					"filterType": string(binance.SymbolFilterTypePriceFilter),
+					"minPrice":   formatFloat(symbol.MinPrice),
+					"maxPrice":   formatFloat(symbol.MaxPrice),
+					"tickSize":   formatFloat(symbol.TickSize),
+				},
+				{
+					"filterType": string(binance.SymbolFilterTypeLotSize),
+					"minQty":     formatFloat(symbol.MinQuantity),
+					"maxQty":     formatFloat(symbol.MaxQuantity),
+					"stepSize":   formatFloat(symbol.StepSize),
+				},
+				{
+					"filterType":       "NOTIONAL",
+					"minNotional":      formatFloat(symbol.MinNotional),
+					"applyMinToMarket": true,
+				},
+			},
+		})
+	}
+	return info, nil
+}
+
+func (s *Server) spotAccount(url.Values) (interface{}, *apiError) {
+	assets := make([]string, 0, len(s.balances))
+	for asset := range s.balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	account := binance.Account{CanTrade: true, AccountType: "SPOT", Permissions: []string{"SPOT"},
+		UpdateTime: uint64(millis(s.now))}
+	for _, asset := range assets {
+		b := s.balances[asset]
+		account.Balances = append(account.Balances, binance.Balance{
+			Asset:  asset,
+			Free:   formatFloat(b.free),
+			Locked: formatFloat(b.locked),
+		})
+	}
+	return account, nil
+}
+
+func (s *Server) spotCreateOrder(params url.Values) (interface{}, *apiError) {
+	o, err := s.newSpotOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.spotOrderResponse(o), nil
+}
+
+// newSpotOrder validates, registers and executes a new order
+func (s *Server) newSpotOrder(params url.Values) (*order, *apiError) {
+	symbol, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	o := &order{
+		listID:      -1,
+		clientID:    params.Get("newClientOrderId"),
+		symbol:      symbol.Name,
+		side:        params.Get("side"),
+		typ:         params.Get("type"),
+		timeInForce: params.Get("timeInForce"),
+	}
+	if o.side != sideBuy && o.side != sideSell {
+		return nil, errorf(http.StatusBadRequest, -1117, "Invalid side.")
+	}
+
+	var err *apiError
+	switch o.typ {
+	case typeLimit:
+		err = required(params, []string{"timeInForce", "quantity", "price"}, nil, orderParams)
+	case typeLimitMaker:
+		err = required(params, []string{"quantity", "price"}, nil, orderParams)
+	case typeMarket:
+		if params.Get("quantity") == "" && params.Get("quoteOrderQty") == "" {
+			return nil, errMandatory("quantity")
+		}
+		err = required(params, nil, []string{"quantity", "quoteOrderQty"}, orderParams)
+	case typeStopLoss:
+		err = required(params, []string{"quantity", "stopPrice"}, nil, orderParams)
+	case typeStopLossLimit:
+		err = required(params, []string{"timeInForce", "quantity", "price", "stopPrice"}, nil, orderParams)
+	default:
+		return nil, errorf(http.StatusBadRequest, -1116, "Invalid orderType.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.timeInForce != "" && o.timeInForce != tifGTC && o.timeInForce != tifIOC && o.timeInForce != tifFOK {
+		return nil, errorf(http.StatusBadRequest, -1115, "Invalid timeInForce.")
+	}
+
+	values, err := floatParams(params, "quantity", "quoteOrderQty", "price", "stopPrice")
+	if err != nil {
+		return nil, err
+	}
+	o.quantity, o.quoteQuantity, o.price, o.stop = values[0], values[1], values[2], values[3]
+
+	last, hasPrice := s.price(symbol.Name)
+	if o.typ == typeMarket && !hasPrice {
+		return nil, errorf(http.StatusBadRequest, -2010, "Market has no price.")
+	}
+	if o.quoteQuantity > 0 {
+		o.quantity = math.Floor(o.quoteQuantity/last/symbol.StepSize+1e-9) * symbol.StepSize
+	}
+
+	notionalPrice := o.price
+	if notionalPrice == 0 {
+		notionalPrice = max(o.stop, last)
+	}
+	if err := symbol.check(o.quantity, o.price, notionalPrice); err != nil {
+		return nil, err
+	}
+	if o.stop > 0 && !multiple(o.stop, symbol.TickSize) {
+		return nil, errorf(http.StatusBadRequest, -1013, "Filter failure: PRICE_FILTER")
+	}
+
+	if hasPrice {
+		if o.stop > 0 && stopHit(o.side, last, o.stop) {
+			return nil, errorf(http.StatusBadRequest, -2010, "Stop price would trigger immediately.")
+		}
+		if o.typ == typeLimitMaker && crosses(o.side, last, o.price) {
+			return nil, errorf(http.StatusBadRequest, -2010, "Order would immediately match and take.")
+		}
+	}
+
+	// buy orders lock the quote at the limit price, the stop price or the market price
+	amount := o.quantity
+	if o.side == sideBuy {
+		switch o.typ {
+		case typeMarket:
+			amount = o.quantity * last
+		case typeStopLoss:
+			amount = o.quantity * o.stop
+		default:
+			amount = o.quantity * o.price
+		}
+	}
+	if err := s.lock(o, amount); err != nil {
+		return nil, err
+	}
+
+	s.addOrder(o)
+	if hasPrice {
+		s.execute(o, last)
+	}
+	return o, nil
+}
+
+func (s *Server) spotGetOrder(params url.Values) (interface{}, *apiError) {
+	o, err := s.findOrder(params, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.spotOrder(o), nil
+}
+
+func (s *Server) spotCancelOrder(params url.Values) (interface{}, *apiError) {
+	o, err := s.findOrder(params, false)
+	if err != nil {
+		return nil, err
+	}
+	if !o.open() {
+		return nil, errorf(http.StatusBadRequest, -2011, "Unknown order sent.")
+	}
+
+	s.cancel(o)
+	return binance.CancelOrderResponse{
+		Symbol:                   o.symbol,
+		OrigClientOrderID:        o.clientID,
+		OrderID:                  o.id,
+		OrderListID:              o.listID,
+		ClientOrderID:            o.clientID,
+		TransactTime:             millis(s.now),
+		Price:                    formatFloat(o.price),
+		OrigQuantity:             formatFloat(o.quantity),
+		ExecutedQuantity:         formatFloat(o.executed),
+		CummulativeQuoteQuantity: formatFloat(o.cost),
+		Status:                   binance.OrderStatusType(o.status),
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.typ),
+		Side:                     binance.SideType(o.side),
+	}, nil
+}
+
+// cancel cancels an order, or all orders of its OCO list
+func (s *Server) cancel(o *order) {
+	if o.listID < 0 {
+		s.close(o, statusCanceled)
+		return
+	}
+
+	// the orders of a list share the locked balance
+	released := false
+	for _, other := range s.ocoOrders(o.listID) {
+		if !other.open() {
+			continue
+		}
+		if released {
+			other.locked = 0
+		}
+		s.close(other, statusCanceled)
+		released = true
+	}
+}
+
+// ocoOrders returns the orders of an OCO list, the stop order first
+func (s *Server) ocoOrders(listID int64) []*order {
+	orders := make([]*order, 0, 2)
+	for _, id := range s.orderIDs {
+		if o := s.orders[id]; o.listID == listID {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+func (s *Server) spotListOrders(params url.Values) (interface{}, *apiError) {
+	orders, err := s.listOrders(params, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]binance.Order, 0, len(orders))
+	for _, o := range orders {
+		result = append(result, s.spotOrder(o))
+	}
+	return result, nil
+}
+
+func (s *Server) spotCreateOCO(params url.Values) (interface{}, *apiError) {
+	symbol, ok := s.symbols[params.Get("symbol")]
+	if !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	side := params.Get("side")
+	if side != sideBuy && side != sideSell {
+		return nil, errorf(http.StatusBadRequest, -1117, "Invalid side.")
+	}
+	if err := required(params, []string{"quantity", "price", "stopPrice"}, nil, nil); err != nil {
+		return nil, err
+	}
+
+	values, err := floatParams(params, "quantity", "price", "stopPrice", "stopLimitPrice")
+	if err != nil {
+		return nil, err
+	}
+	quantity, price, stop, stopLimit := values[0], values[1], values[2], values[3]
+	if stopLimit > 0 && params.Get("stopLimitTimeInForce") == "" {
+		return nil, errMandatory("stopLimitTimeInForce")
+	}
+
+	for _, value := range []float64{price, stopLimit} {
+		if err := symbol.check(quantity, value, price); err != nil {
+			return nil, err
+		}
+	}
+	if !multiple(stop, symbol.TickSize) {
+		return nil, errorf(http.StatusBadRequest, -1013, "Filter failure: PRICE_FILTER")
+	}
+
+	// the limit order is above the market and the stop below for sells, the opposite for buys
+	last, hasPrice := s.price(symbol.Name)
+	if hasPrice && (crosses(side, last, price) || stopHit(side, last, stop)) {
+		return nil, errorf(http.StatusBadRequest, -2010,
+			"The relationship of the prices for the orders is not correct.")
+	}
+
+	listID := s.nextList
+	stopOrder := &order{listID: listID, symbol: symbol.Name, side: side, typ: typeStopLoss,
+		quantity: quantity, stop: stop}
+	if stopLimit > 0 {
+		stopOrder.typ = typeStopLossLimit
+		stopOrder.price = stopLimit
+		stopOrder.timeInForce = params.Get("stopLimitTimeInForce")
+	}
+	limitOrder := &order{listID: listID, symbol: symbol.Name, side: side, typ: typeLimitMaker,
+		quantity: quantity, price: price}
+
+	amount := quantity
+	if side == sideBuy {
+		amount = quantity * max(price, stop, stopLimit)
+	}
+	if err := s.lock(stopOrder, amount); err != nil {
+		return nil, err
+	}
+	limitOrder.locked = amount
+	s.nextList++
+
+	s.addOrder(stopOrder)
+	s.addOrder(limitOrder)
+	return s.ocoResponse(listID), nil
+}
+
+func (s *Server) spotCancelOCO(params url.Values) (interface{}, *apiError) {
+	if _, ok := s.symbols[params.Get("symbol")]; !ok {
+		return nil, errInvalidSymbol()
+	}
+
+	listID, err := strconv.ParseInt(params.Get("orderListId"), 10, 64)
+	if err != nil {
+		return nil, errMandatory("orderListId")
+	}
+	orders := s.ocoOrders(listID)
+	if len(orders) == 0 || (!orders[0].open() && !orders[1].open()) {
+		return nil, errorf(http.StatusBadRequest, -2011, "Unknown order list sent.")
+	}
+
+	s.cancel(orders[0])
+	return s.ocoResponse(orders[0].listID), nil
+}
+
+func (s *Server) ocoResponse(listID int64) binance.CreateOCOResponse {
+	orders := s.ocoOrders(listID)
+	response := binance.CreateOCOResponse{
+		OrderListID:       listID,
+		ContingencyType:   "OCO",
+		ListStatusType:    "EXEC_STARTED",
+		ListOrderStatus:   "EXECUTING",
+		ListClientOrderID: orders[0].clientID,
+		TransactionTime:   millis(s.now),
+		Symbol:            orders[0].symbol,
+	}
+	if !orders[0].open() && !orders[1].open() {
+		response.ListStatusType = "ALL_DONE"
+		response.ListOrderStatus = "ALL_DONE"
+	}
+
+	for _, o := range orders {
+		response.Orders = append(response.Orders, &binance.OCOOrder{
+			Symbol:        o.symbol,
+			OrderID:       o.id,
+			ClientOrderID: o.clientID,
+		})
+		response.OrderReports = append(response.OrderReports, &binance.OCOOrderReport{
+			Symbol:                   o.symbol,
+			OrderID:                  o.id,
+			OrderListID:              o.listID,
+			ClientOrderID:            o.clientID,
+			TransactionTime:          millis(o.updated),
+			Price:                    formatFloat(o.price),
+			OrigQuantity:             formatFloat(o.quantity),
+			ExecutedQuantity:         formatFloat(o.executed),
+			CummulativeQuoteQuantity: formatFloat(o.cost),
+			Status:                   binance.OrderStatusType(o.status),
+			TimeInForce:              binance.TimeInForceType(o.timeInForce),
+			Type:                     binance.OrderType(o.typ),
+			Side:                     binance.SideType(o.side),
+			StopPrice:                formatFloat(o.stop),
+		})
+	}
+	return response
+}
+
+// spotCancelReplace cancels an order and creates a new one, the new order is not created when the
+// cancellation fails
+func (s *Server) spotCancelReplace(params url.Values) (interface{}, *apiError) {
+	if params.Get("cancelReplaceMode") != "STOP_ON_FAILURE" {
+		return nil, errorf(http.StatusBadRequest, -1102, "Unsupported cancelReplaceMode.")
+	}
+
+	cancelParams := url.Values{"symbol": {params.Get("symbol")}, "orderId": {params.Get("cancelOrderId")}}
+	o, err := s.findOrder(cancelParams, false)
+	if err != nil || !o.open() {
+		return nil, errorf(http.StatusBadRequest, -2022, "Order cancel-replace failed.")
+	}
+	s.cancel(o)
+
+	params.Del("cancelReplaceMode")
+	params.Del("cancelOrderId")
+	newOrder, err := s.newSpotOrder(params)
+	if err != nil {
+		return nil, errorf(http.StatusConflict, -2021, "Order cancel-replace partially failed.")
+	}
+
+	return map[string]interface{}{
+		"cancelResult":     "SUCCESS",
+		"newOrderResult":   "SUCCESS",
+		"cancelResponse":   s.spotOrder(o),
+		"newOrderResponse": s.spotOrderResponse(newOrder),
+	}, nil
+}
+
+func (s *Server) spotOrder(o *order) binance.Order {
+	return binance.Order{
+		Symbol:                   o.symbol,
+		OrderID:                  o.id,
+		OrderListId:              o.listID,
+		ClientOrderID:            o.clientID,
+		Price:                    formatFloat(o.price),
+		OrigQuantity:             formatFloat(o.quantity),
+		ExecutedQuantity:         formatFloat(o.executed),
+		CummulativeQuoteQuantity: formatFloat(o.cost),
+		Status:                   binance.OrderStatusType(o.status),
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.typ),
+		Side:                     binance.SideType(o.side),
+		StopPrice:                formatFloat(o.stop),
+		IcebergQuantity:          formatFloat(0),
+		Time:                     millis(o.created),
+		UpdateTime:               millis(o.updated),
+		IsWorking:                o.working(),
+		OrigQuoteOrderQuantity:   formatFloat(o.quoteQuantity),
+	}
+}
+
+func (s *Server) spotOrderResponse(o *order) binance.CreateOrderResponse {
+	response := binance.CreateOrderResponse{
+		Symbol:                   o.symbol,
+		OrderID:                  o.id,
+		ClientOrderID:            o.clientID,
+		TransactTime:             millis(o.created),
+		Price:                    formatFloat(o.price),
+		OrigQuantity:             formatFloat(o.quantity),
+		ExecutedQuantity:         formatFloat(o.executed),
+		CummulativeQuoteQuantity: formatFloat(o.cost),
+		Status:                   binance.OrderStatusType(o.status),
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.typ),
+		Side:                     binance.SideType(o.side),
+		Fills:                    make([]*binance.Fill, 0),
+	}
+	if o.executed > 0 {
+		response.Fills = append(response.Fills, &binance.Fill{
+			Price:           formatFloat(o.cost / o.executed),
+			Quantity:        formatFloat(o.executed),
+			Commission:      formatFloat(0),
+			CommissionAsset: s.symbols[o.symbol].QuoteAsset,
+		})
+	}
+	return response
+}
@@ -0,0 +1,412 @@
+package exchange
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// Binary candle file layout, all numbers are little endian:
+//
+//	magic "NJBC" | version uint8 | compression uint8
+//	pair string | timeframe string | start int64 | end int64 | rows uint64
+//	metadata count uint16 | metadata names []string
+//	columns: time []int64, open, close, low, high, volume and metadata []float64
+//
+// Strings are prefixed by their uint16 length. The header is never compressed, so it can be
+// read without decoding the columns. Missing metadata values are stored as NaN.
+const (
+	binaryMagic   = "NJBC"
+	binaryVersion = 1
+)
+
+// binaryChunkSize is the number of values of the first column read at once. The column grows while it
+// is read, so a corrupted rows count in the header fails with EOF instead of allocating the whole count.
+// maxBinaryRows is the limit of rows of a binary file.
+const (
+	binaryChunkSize = 4096
+	maxBinaryRows   = math.MaxInt32
+)
+
+var ErrInvalidBinaryFile = errors.New("invalid binary candle file")
+
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+)
+
+// BinaryHeader describes the content of a binary candle file
+type BinaryHeader struct {
+	Pair        string
+	Timeframe   string
+	Start       time.Time
+	End         time.Time
+	Rows        int
+	Compression Compression
+	Metadata    []string
+}
+
+// WriteBinaryCandles writes candles in the binary columnar format. The time range, the number of rows
+// and the metadata columns of the header are filled from the candles.
+func WriteBinaryCandles(w io.Writer, header BinaryHeader, candles []model.Candle) error {
+	header.Rows = len(candles)
+	header.Metadata = metadataColumns(candles)
+	if len(candles) > 0 {
+		header.Start = candles[0].Time
+		header.End = candles[len(candles)-1].Time
+	}
+
+	buffer := bufio.NewWriter(w)
+	if err := writeBinaryHeader(buffer, header); err != nil {
+		return err
+	}
+
+	var body io.Writer = buffer
+	var gz *gzip.Writer
+	switch header.Compression {
+	case CompressionNone:
+	case CompressionGzip:
+		gz = gzip.NewWriter(buffer)
+		body = gz
+	default:
+		return fmt.Errorf("%w: unknown compression %d", ErrInvalidBinaryFile, header.Compression)
+	}
+
+	times := make([]int64, len(candles))
+	for i, candle := range candles {
+		times[i] = candle.Time.Unix()
+	}
+	if err := binary.Write(body, binary.LittleEndian, times); err != nil {
+		return err
+	}
+
+	columns := []func(model.Candle) float64{
+		func(c model.Candle) float64 { return c.Open },
+		func(c model.Candle) float64 { return c.Close },
+		func(c model.Candle) float64 { return c.Low },
+		func(c model.Candle) float64 { return c.High },
+		func(c model.Candle) float64 { return c.Volume },
+	}
+	for _, name := range header.Metadata {
+		name := name
+		columns = append(columns, func(c model.Candle) float64 {
+			if value, ok := c.Metadata[name]; ok {
+				return value
+			}
+			return math.NaN()
+		})
+	}
+
+	values := make([]float64, len(candles))
+	for _, column := range columns {
+		for i, candle := range candles {
+			values[i] = column(candle)
+		}
+		if err := binary.Write(body, binary.LittleEndian, values); err != nil {
+			return err
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	return buffer.Flush()
+}
+
+// metadataColumns returns the sorted metadata keys of all candles
+func metadataColumns(candles []model.Candle) []string {
+	set := make(map[string]bool)
+	for _, candle := range candles {
+		for key := range candle.Metadata {
+			set[key] = true
+		}
+	}
+
+	columns := make([]string, 0, len(set))
+	for key := range set {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func writeBinaryHeader(w io.Writer, header BinaryHeader) error {
+	if len(header.Metadata) > math.MaxUint16 {
+		return fmt.Errorf("%w: too many metadata columns", ErrInvalidBinaryFile)
+	}
+
+	if _, err := io.WriteString(w, binaryMagic); err != nil {
+		return err
+	}
+
+	fields := []any{
+		uint8(binaryVersion), uint8(header.Compression),
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	for _, value := range []string{header.Pair, header.Timeframe} {
+		if err := writeBinaryString(w, value); err != nil {
+			return err
+		}
+	}
+
+	fields = []any{
+		header.Start.Unix(), header.End.Unix(), uint64(header.Rows), uint16(len(header.Metadata)),
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range header.Metadata {
+		if err := writeBinaryString(w, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeBinaryString(w io.Writer, value string) error {
+	if len(value) > math.MaxUint16 {
+		return fmt.Errorf("%w: string too long", ErrInvalidBinaryFile)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(value))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, value)
+	return err
+}
+
+func readBinaryString(r io.Reader) (string, error) {
+	var size uint16
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+
+	value := make([]byte, size)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func readBinaryHeader(r io.Reader) (BinaryHeader, error) {
+	var header BinaryHeader
+
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != binaryMagic {
+		return header, ErrInvalidBinaryFile
+	}
+
+	var version, compression uint8
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return header, err
+	}
+	if version != binaryVersion {
+		return header, fmt.Errorf("%w: unsupported version %d", ErrInvalidBinaryFile, version)
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &compression); err != nil {
+		return header, err
+	}
+	header.Compression = Compression(compression)
+
+	var err error
+	if header.Pair, err = readBinaryString(r); err != nil {
+		return header, err
+	}
+	if header.Timeframe, err = readBinaryString(r); err != nil {
+		return header, err
+	}
+
+	var start, end int64
+	var rows uint64
+	var metadata uint16
+	for _, field := range []any{&start, &end, &rows, &metadata} {
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return header, err
+		}
+	}
+	if rows > maxBinaryRows {
+		return header, fmt.Errorf("%w: %d rows", ErrInvalidBinaryFile, rows)
+	}
+	header.Start = time.Unix(start, 0).UTC()
+	header.End = time.Unix(end, 0).UTC()
+	header.Rows = int(rows)
+
+	header.Metadata = make([]string, metadata)
+	for i := range header.Metadata {
+		if header.Metadata[i], err = readBinaryString(r); err != nil {
+			return header, err
+		}
+	}
+
+	return header, nil
+}
+
+// readBinaryColumn reads the first column of a file by chunks, the following columns have the same
+// size, so they are allocated only after the rows count is confirmed by the data
+func readBinaryColumn[T int64 | float64](r io.Reader, rows int) ([]T, error) {
+	column := make([]T, 0, min(rows, binaryChunkSize))
+	chunk := make([]T, min(rows, binaryChunkSize))
+	for len(column) < rows {
+		size := min(rows-len(column), len(chunk))
+		if err := binary.Read(r, binary.LittleEndian, chunk[:size]); err != nil {
+			return nil, err
+		}
+		column = append(column, chunk[:size]...)
+	}
+	return column, nil
+}
+
+// ReadBinaryCandles decodes a binary candle file, candles are returned with the pair of the header
+func ReadBinaryCandles(r io.Reader) (BinaryHeader, []model.Candle, error) {
+	buffer := bufio.NewReader(r)
+	header, err := readBinaryHeader(buffer)
+	if err != nil {
+		return header, nil, err
+	}
+
+	var body io.Reader = buffer
+	switch header.Compression {
+	case CompressionNone:
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffer)
+		if err != nil {
+			return header, nil, err
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return header, nil, fmt.Errorf("%w: unknown compression %d", ErrInvalidBinaryFile, header.Compression)
+	}
+
+	times, err := readBinaryColumn[int64](body, header.Rows)
+	if err != nil {
+		return header, nil, err
+	}
+
+	candles := make([]model.Candle, header.Rows)
+	for i, timestamp := range times {
+		t := time.Unix(timestamp, 0).UTC()
+		candles[i] = model.Candle{
+			Pair:      header.Pair,
+			Time:      t,
+			UpdatedAt: t,
+			Complete:  true,
+		}
+	}
+
+	columns := []func(*model.Candle, float64){
+		func(c *model.Candle, v float64) { c.Open = v },
+		func(c *model.Candle, v float64) { c.Close = v },
+		func(c *model.Candle, v float64) { c.Low = v },
+		func(c *model.Candle, v float64) { c.High = v },
+		func(c *model.Candle, v float64) { c.Volume = v },
+	}
+	for _, name := range header.Metadata {
+		name := name
+		columns = append(columns, func(c *model.Candle, v float64) {
+			if math.IsNaN(v) {
+				return
+			}
+			if c.Metadata == nil {
+				c.Metadata = make(map[string]float64)
+			}
+			c.Metadata[name] = v
+		})
+	}
+
+	values := make([]float64, header.Rows)
+	for _, column := range columns {
+		if err := binary.Read(body, binary.LittleEndian, values); err != nil {
+			return header, nil, err
+		}
+		for i, value := range values {
+			column(&candles[i], value)
+		}
+	}
+
+	return header, candles, nil
+}
+
+// ReadBinaryHeader reads only the header of a binary candle file
+func ReadBinaryHeader(path string) (BinaryHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return BinaryHeader{}, err
+	}
+	defer file.Close()
+
+	return readBinaryHeader(bufio.NewReader(file))
+}
+
+func readBinaryFeed(feed PairFeed) ([]model.Candle, error) {
+	file, err := os.Open(feed.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, candles, err := ReadBinaryCandles(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", feed.File, err)
+	}
+
+	ha := model.NewHeikinAshi()
+	for i := range candles {
+		candles[i].Pair = feed.Pair
+		if feed.HeikinAshi {
+			candles[i] = candles[i].ToHeikinAshi(ha)
+		}
+	}
+
+	return candles, nil
+}
+
+// NewBinaryFeed creates a data feed from binary candle files, see WriteBinaryCandles. The pair and
+// the timeframe of a feed are read from the file header when empty. Candles are kept in memory and
+// resampled to the target timeframe, as in CSVFeed.
+func NewBinaryFeed(targetTimeframe string, feeds ...PairFeed) (*CSVFeed, error) {
+	feeds = append([]PairFeed(nil), feeds...)
+	for i, feed := range feeds {
+		header, err := ReadBinaryHeader(feed.File)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", feed.File, err)
+		}
+
+		if feed.Pair == "" {
+			feeds[i].Pair = header.Pair
+		}
+
+		if feed.Timeframe == "" {
+			feeds[i].Timeframe = header.Timeframe
+		} else if header.Timeframe != "" && feed.Timeframe != header.Timeframe {
+			return nil, fmt.Errorf("%w: %s has timeframe %s, expected %s", ErrInvalidBinaryFile,
+				feed.File, header.Timeframe, feed.Timeframe)
+		}
+	}
+
+	return newMemoryFeed(targetTimeframe, feeds, readBinaryFeed)
+}
@@ -0,0 +1,118 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestBinaryCandles(t *testing.T) {
+	candles, err := ReadCSVCandles(PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d-header.csv", Timeframe: "1d"})
+	require.NoError(t, err)
+	delete(candles[1].Metadata, "lsr")
+
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		var buffer bytes.Buffer
+		err := WriteBinaryCandles(&buffer, BinaryHeader{
+			Pair:        "BTCUSDT",
+			Timeframe:   "1d",
+			Compression: compression,
+		}, candles)
+		require.NoError(t, err)
+
+		header, result, err := ReadBinaryCandles(&buffer)
+		require.NoError(t, err)
+		require.Equal(t, BinaryHeader{
+			Pair:        "BTCUSDT",
+			Timeframe:   "1d",
+			Start:       candles[0].Time,
+			End:         candles[len(candles)-1].Time,
+			Rows:        len(candles),
+			Compression: compression,
+			Metadata:    []string{"lsr", "trades"},
+		}, header)
+		require.Equal(t, candles, result)
+		require.NotContains(t, result[1].Metadata, "lsr")
+	}
+
+	t.Run("invalid file", func(t *testing.T) {
+		_, _, err := ReadBinaryCandles(bytes.NewBufferString("time,open,close"))
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+	})
+
+	t.Run("corrupted rows", func(t *testing.T) {
+		var buffer bytes.Buffer
+		header := BinaryHeader{Pair: "BTCUSDT", Timeframe: "1d", Rows: math.MaxInt32}
+		require.NoError(t, writeBinaryHeader(&buffer, header))
+		buffer.Write(make([]byte, 16)) // only two timestamps
+
+		_, _, err := ReadBinaryCandles(&buffer)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestNewBinaryFeed(t *testing.T) {
+	ctx := context.Background()
+	tt := []struct {
+		name   string
+		target string
+		feed   PairFeed
+	}{
+		{"custom header", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d-header.csv", Timeframe: "1d"}},
+		{"resample", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"}},
+		{"heikin ashi", "4h", PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h",
+			HeikinAshi: true}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			candles, err := ReadCSVCandles(PairFeed{Pair: tc.feed.Pair, File: tc.feed.File})
+			require.NoError(t, err)
+
+			output := filepath.Join(t.TempDir(), "candles.bin")
+			file, err := os.Create(output)
+			require.NoError(t, err)
+			err = WriteBinaryCandles(file, BinaryHeader{
+				Pair:        tc.feed.Pair,
+				Timeframe:   tc.feed.Timeframe,
+				Compression: CompressionGzip,
+			}, candles)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+
+			expected, err := NewCSVFeed(tc.target, tc.feed)
+			require.NoError(t, err)
+
+			// pair and timeframe are read from the header
+			feed, err := NewBinaryFeed(tc.target, PairFeed{File: output, HeikinAshi: tc.feed.HeikinAshi})
+			require.NoError(t, err)
+			require.Equal(t, expected.CandlePairTimeFrame, feed.CandlePairTimeFrame)
+
+			result, err := feed.CandlesByPeriod(ctx, tc.feed.Pair, tc.target, time.Time{}, time.Now())
+			require.NoError(t, err)
+			require.NotEmpty(t, result)
+		})
+	}
+
+	t.Run("timeframe mismatch", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "candles.bin")
+		file, err := os.Create(output)
+		require.NoError(t, err)
+		err = WriteBinaryCandles(file, BinaryHeader{Pair: "BTCUSDT", Timeframe: "1h"},
+			[]model.Candle{{Time: time.Now()}})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		_, err = NewBinaryFeed("1d", PairFeed{File: output, Timeframe: "1d"})
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+	})
+}
@@ -0,0 +1,1103 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+const (
+	bybitAPIURL        = "https://api.bybit.com"
+	bybitWsURL         = "wss://stream.bybit.com/v5/public/spot"
+	bybitTestnetAPIURL = "https://api-testnet.bybit.com"
+	bybitTestnetWsURL  = "wss://stream-testnet.bybit.com/v5/public/spot"
+
+	bybitRecvWindow   = "5000"
+	bybitPingInterval = 20 * time.Second
+	bybitKlineLimit   = 1000
+)
+
+// bybitIntervals are the kline intervals of Bybit for each timeframe
+var bybitIntervals = map[string]string{
+	"1m": "1", "3m": "3", "5m": "5", "15m": "15", "30m": "30", "1h": "60", "2h": "120", "4h": "240",
+	"6h": "360", "12h": "720", "1d": "D", "1w": "W", "1M": "M",
+}
+
+var bybitResampler = liveResampler{
+	timeframes: []string{"1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "12h", "1d", "1w", "1M"},
+	options:    []ResamplerOption{WithWeekStart(time.Monday)},
+}
+
+// BybitAPIError is an error returned by the Bybit API
+type BybitAPIError struct {
+	Code    int
+	Message string
+}
+
+func (e BybitAPIError) Error() string {
+	return fmt.Sprintf("bybit: code=%d, msg=%s", e.Code, e.Message)
+}
+
+// Bybit is a spot market adapter of the Bybit V5 API, for unified trading accounts
+type Bybit struct {
+	ctx        context.Context
+	httpClient *http.Client
+	assetsInfo map[string]model.AssetInfo
+	timeOffset int64
+	HeikinAshi bool
+
+	APIURL    string
+	WsURL     string
+	APIKey    string
+	APISecret string
+
+	MetadataFetchers []MetadataFetchers
+}
+
+type BybitOption func(*Bybit)
+
+// WithBybitCredentials will set Bybit credentials
+func WithBybitCredentials(key, secret string) BybitOption {
+	return func(b *Bybit) {
+		b.APIKey = key
+		b.APISecret = secret
+	}
+}
+
+// WithBybitHeikinAshiCandle will convert candle to Heikin Ashi
+func WithBybitHeikinAshiCandle() BybitOption {
+	return func(b *Bybit) {
+		b.HeikinAshi = true
+	}
+}
+
+// WithBybitMetadataFetcher will include additional information about the market in complete candles
+func WithBybitMetadataFetcher(fetcher MetadataFetchers) BybitOption {
+	return func(b *Bybit) {
+		b.MetadataFetchers = append(b.MetadataFetchers, fetcher)
+	}
+}
+
+// WithBybitTestnet activates the Bybit testnet
+func WithBybitTestnet() BybitOption {
+	return func(b *Bybit) {
+		b.APIURL = bybitTestnetAPIURL
+		b.WsURL = bybitTestnetWsURL
+	}
+}
+
+// WithBybitEndpoint will set custom endpoints of the REST API and the public spot websocket
+func WithBybitEndpoint(apiURL, wsURL string) BybitOption {
+	return func(b *Bybit) {
+		b.APIURL = apiURL
+		b.WsURL = wsURL
+	}
+}
+
+// NewBybit creates a new Bybit exchange instance. The pairs of Bybit are registered, so they can be
+// split with SplitAssetQuote.
+func NewBybit(ctx context.Context, options ...BybitOption) (*Bybit, error) {
+	exchange := &Bybit{
+		ctx:        ctx,
+		httpClient: http.DefaultClient,
+		APIURL:     bybitAPIURL,
+		WsURL:      bybitWsURL,
+	}
+	for _, option := range options {
+		option(exchange)
+	}
+
+	var serverTime struct {
+		TimeNano string `json:"timeNano"`
+	}
+	if err := exchange.request(ctx, http.MethodGet, "/v5/market/time", nil, false, &serverTime); err != nil {
+		return nil, fmt.Errorf("bybit ping fail: %w", err)
+	}
+	if nano, err := strconv.ParseInt(serverTime.TimeNano, 10, 64); err == nil {
+		exchange.timeOffset = nano/int64(time.Millisecond) - time.Now().UnixMilli()
+	}
+
+	// Initialize with orders precision and assets limits
+	exchange.assetsInfo = make(map[string]model.AssetInfo)
+	params := url.Values{"category": {"spot"}, "limit": {"1000"}}
+	for {
+		var result struct {
+			List           []bybitInstrument `json:"list"`
+			NextPageCursor string            `json:"nextPageCursor"`
+		}
+		err := exchange.request(ctx, http.MethodGet, "/v5/market/instruments-info", params, false, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instrument := range result.List {
+			exchange.assetsInfo[instrument.Symbol] = instrument.assetInfo()
+			RegisterPair(instrument.Symbol, instrument.BaseCoin, instrument.QuoteCoin)
+		}
+
+		if result.NextPageCursor == "" || len(result.List) == 0 {
+			break
+		}
+		params.Set("cursor", result.NextPageCursor)
+	}
+
+	log.Info("[SETUP] Using Bybit exchange")
+
+	return exchange, nil
+}
+
+type bybitInstrument struct {
+	Symbol        string `json:"symbol"`
+	BaseCoin      string `json:"baseCoin"`
+	QuoteCoin     string `json:"quoteCoin"`
+	LotSizeFilter struct {
+		BasePrecision  string `json:"basePrecision"`
+		QuotePrecision string `json:"quotePrecision"`
+		MinOrderQty    string `json:"minOrderQty"`
+		MaxOrderQty    string `json:"maxOrderQty"`
+		MinOrderAmt    string `json:"minOrderAmt"`
+	} `json:"lotSizeFilter"`
+	PriceFilter struct {
+		TickSize string `json:"tickSize"`
+	} `json:"priceFilter"`
+}
+
+// assetInfo converts the filters of an instrument, Bybit sends the precision as the smallest step,
+// eg. 0.000001
+func (i bybitInstrument) assetInfo() model.AssetInfo {
+	info := model.AssetInfo{
+		BaseAsset:          i.BaseCoin,
+		QuoteAsset:         i.QuoteCoin,
+		BaseAssetPrecision: stepDecimals(i.LotSizeFilter.BasePrecision),
+		QuotePrecision:     stepDecimals(i.LotSizeFilter.QuotePrecision),
+	}
+	info.StepSize, _ = strconv.ParseFloat(i.LotSizeFilter.BasePrecision, 64)
+	info.MinQuantity, _ = strconv.ParseFloat(i.LotSizeFilter.MinOrderQty, 64)
+	info.MaxQuantity, _ = strconv.ParseFloat(i.LotSizeFilter.MaxOrderQty, 64)
+	info.MinNotional, _ = strconv.ParseFloat(i.LotSizeFilter.MinOrderAmt, 64)
+	info.TickSize, _ = strconv.ParseFloat(i.PriceFilter.TickSize, 64)
+	return info
+}
+
+// stepDecimals returns the number of decimals of a step, eg. 3 for 0.001
+func stepDecimals(step string) int {
+	_, decimals, ok := strings.Cut(step, ".")
+	if !ok {
+		return 0
+	}
+	return len(strings.TrimRight(decimals, "0"))
+}
+
+// formatStep rounds a value down to a multiple of the step and formats it with the step decimals
+func formatStep(value, step float64, decimals int) string {
+	if step > 0 {
+		value = math.Floor(value/step+1e-9) * step
+	}
+	formatted := strconv.FormatFloat(value, 'f', decimals, 64)
+	if strings.Contains(formatted, ".") {
+		formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	}
+	return formatted
+}
+
+func (b *Bybit) formatPrice(pair string, value float64) string {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return formatStep(value, info.TickSize, stepDecimals(strconv.FormatFloat(info.TickSize, 'f', -1, 64)))
+}
+
+func (b *Bybit) formatQuantity(pair string, value float64) string {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return formatStep(value, info.StepSize, info.BaseAssetPrecision)
+}
+
+func (b *Bybit) formatQuote(pair string, value float64) string {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return formatStep(value, math.Pow10(-info.QuotePrecision), info.QuotePrecision)
+}
+
+// bybitResponse is the envelope of all responses of the V5 API
+type bybitResponse struct {
+	RetCode int             `json:"retCode"`
+	RetMsg  string          `json:"retMsg"`
+	Result  json.RawMessage `json:"result"`
+	Time    int64           `json:"time"`
+}
+
+// request calls an endpoint of the V5 API, signed requests send the query of GET requests or
+// the JSON body of POST requests in the signature
+func (b *Bybit) request(ctx context.Context, method, endpoint string, params interface{}, signed bool,
+	result interface{}) error {
+
+	var query, payload string
+	var body io.Reader
+	switch method {
+	case http.MethodGet:
+		if values, ok := params.(url.Values); ok {
+			query = values.Encode()
+		}
+		payload = query
+	default:
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		payload = string(data)
+		body = bytes.NewReader(data)
+	}
+
+	fullURL := b.APIURL + endpoint
+	if query != "" {
+		fullURL += "?" + query
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, fullURL, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if signed {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli()+b.timeOffset, 10)
+		mac := hmac.New(sha256.New, []byte(b.APISecret))
+		mac.Write([]byte(timestamp + b.APIKey + bybitRecvWindow + payload))
+
+		request.Header.Set("X-BAPI-API-KEY", b.APIKey)
+		request.Header.Set("X-BAPI-TIMESTAMP", timestamp)
+		request.Header.Set("X-BAPI-RECV-WINDOW", bybitRecvWindow)
+		request.Header.Set("X-BAPI-SIGN", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := b.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	var envelope bybitResponse
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("bybit: status %d: %s", response.StatusCode, data)
+	}
+
+	if envelope.RetCode != 0 {
+		return BybitAPIError{Code: envelope.RetCode, Message: envelope.RetMsg}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+func (b *Bybit) AssetsInfo(pair string) model.AssetInfo {
+	return b.assetsInfo[pair]
+}
+
+func (b *Bybit) LastQuote(ctx context.Context, pair string) (float64, error) {
+	var result struct {
+		List []struct {
+			LastPrice string `json:"lastPrice"`
+		} `json:"list"`
+	}
+	params := url.Values{"category": {"spot"}, "symbol": {pair}}
+	if err := b.request(ctx, http.MethodGet, "/v5/market/tickers", params, false, &result); err != nil {
+		return 0, err
+	}
+	if len(result.List) == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+	}
+	return strconv.ParseFloat(result.List[0].LastPrice, 64)
+}
+
+func (b *Bybit) validate(pair string, quantity float64) error {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return ErrInvalidAsset
+	}
+
+	if quantity < info.MinQuantity || (info.MaxQuantity > 0 && quantity > info.MaxQuantity) {
+		return &OrderError{
+			Err:      fmt.Errorf("%w: min: %f max: %f", ErrInvalidQuantity, info.MinQuantity, info.MaxQuantity),
+			Pair:     pair,
+			Quantity: quantity,
+		}
+	}
+
+	return nil
+}
+
+// bybitOrderRequest is the body of a new order
+type bybitOrderRequest struct {
+	Category     string `json:"category"`
+	Symbol       string `json:"symbol"`
+	Side         string `json:"side"`
+	OrderType    string `json:"orderType"`
+	Qty          string `json:"qty"`
+	Price        string `json:"price,omitempty"`
+	TimeInForce  string `json:"timeInForce,omitempty"`
+	OrderLinkID  string `json:"orderLinkId,omitempty"`
+	MarketUnit   string `json:"marketUnit,omitempty"`
+	OrderFilter  string `json:"orderFilter,omitempty"`
+	TriggerPrice string `json:"triggerPrice,omitempty"`
+}
+
+// bybitOrder is an order of the realtime and history endpoints, times are in milliseconds
+type bybitOrder struct {
+	OrderID      string `json:"orderId"`
+	OrderLinkID  string `json:"orderLinkId"`
+	Symbol       string `json:"symbol"`
+	Price        string `json:"price"`
+	Qty          string `json:"qty"`
+	Side         string `json:"side"`
+	OrderStatus  string `json:"orderStatus"`
+	OrderType    string `json:"orderType"`
+	TimeInForce  string `json:"timeInForce"`
+	CumExecQty   string `json:"cumExecQty"`
+	CumExecValue string `json:"cumExecValue"`
+	TriggerPrice string `json:"triggerPrice"`
+	CreatedTime  string `json:"createdTime"`
+	UpdatedTime  string `json:"updatedTime"`
+}
+
+func bybitSide(side model.SideType) string {
+	if side == model.SideTypeSell {
+		return "Sell"
+	}
+	return "Buy"
+}
+
+// bybitStatus converts the status of an order, conditional orders waiting the trigger are new
+func bybitStatus(status string) model.OrderStatusType {
+	switch status {
+	case "New", "Untriggered", "Triggered":
+		return model.OrderStatusTypeNew
+	case "PartiallyFilled":
+		return model.OrderStatusTypePartiallyFilled
+	case "Filled":
+		return model.OrderStatusTypeFilled
+	case "Rejected":
+		return model.OrderStatusTypeRejected
+	default:
+		return model.OrderStatusTypeCanceled
+	}
+}
+
+func newBybitOrder(order bybitOrder) (model.Order, error) {
+	id, err := strconv.ParseInt(order.OrderID, 10, 64)
+	if err != nil {
+		return model.Order{}, fmt.Errorf("bybit order id %s: %w", order.OrderID, err)
+	}
+
+	var price float64
+	cost, _ := strconv.ParseFloat(order.CumExecValue, 64)
+	quantity, _ := strconv.ParseFloat(order.CumExecQty, 64)
+	if cost > 0 && quantity > 0 {
+		price = cost / quantity
+	} else {
+		price, _ = strconv.ParseFloat(order.Price, 64)
+		quantity, _ = strconv.ParseFloat(order.Qty, 64)
+	}
+
+	createdAt, _ := strconv.ParseInt(order.CreatedTime, 10, 64)
+	updatedAt, _ := strconv.ParseInt(order.UpdatedTime, 10, 64)
+	result := model.Order{
+		ExchangeID:  id,
+		Pair:        order.Symbol,
+		CreatedAt:   time.UnixMilli(createdAt),
+		UpdatedAt:   time.UnixMilli(updatedAt),
+		Side:        model.SideType(strings.ToUpper(order.Side)),
+		Type:        model.OrderTypeLimit,
+		Status:      bybitStatus(order.OrderStatus),
+		Price:       price,
+		Quantity:    quantity,
+		TimeInForce: model.TimeInForceType(order.TimeInForce),
+		ClientID:    order.OrderLinkID,
+	}
+
+	trigger, _ := strconv.ParseFloat(order.TriggerPrice, 64)
+	switch {
+	case trigger > 0:
+		result.Stop = &trigger
+		result.Type = model.OrderTypeStopLossLimit
+		if order.OrderType == "Market" {
+			result.Type = model.OrderTypeStopLoss
+			if price == 0 {
+				result.Price = trigger
+			}
+		}
+	case order.OrderType == "Market":
+		result.Type = model.OrderTypeMarket
+	case order.TimeInForce == "PostOnly":
+		result.Type = model.OrderTypeLimitMaker
+		result.TimeInForce = model.TimeInForceTypeGTC
+	}
+
+	return result, nil
+}
+
+// createOrder sends a new order and returns it as stored by the exchange
+func (b *Bybit) createOrder(request bybitOrderRequest) (model.Order, error) {
+	request.Category = "spot"
+	var result struct {
+		OrderID string `json:"orderId"`
+	}
+	if err := b.request(b.ctx, http.MethodPost, "/v5/order/create", request, true, &result); err != nil {
+		return model.Order{}, err
+	}
+
+	id, err := strconv.ParseInt(result.OrderID, 10, 64)
+	if err != nil {
+		return model.Order{}, fmt.Errorf("bybit order id %s: %w", result.OrderID, err)
+	}
+	return b.Order(request.Symbol, id)
+}
+
+// CreateOrderOCO is not supported in Bybit spot market
+func (b *Bybit) CreateOrderOCO(_ model.SideType, pair string, size, _, _, _ float64) ([]model.Order, error) {
+	return nil, &OrderError{
+		Err:      fmt.Errorf("%w: OCO orders in Bybit", ErrNotSupported),
+		Pair:     pair,
+		Quantity: size,
+	}
+}
+
+// CreateOrderStop creates a conditional market sell order, triggered when the price falls to the limit
+func (b *Bybit) CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error) {
+	err := b.validate(pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	return b.createOrder(bybitOrderRequest{
+		Symbol:       pair,
+		Side:         bybitSide(model.SideTypeSell),
+		OrderType:    "Market",
+		Qty:          b.formatQuantity(pair, quantity),
+		MarketUnit:   "baseCoin",
+		OrderFilter:  "StopOrder",
+		TriggerPrice: b.formatPrice(pair, limit),
+	})
+}
+
+func (b *Bybit) CreateOrderLimit(side model.SideType, pair string,
+	quantity float64, limit float64) (model.Order, error) {
+
+	err := b.validate(pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	return b.createOrder(bybitOrderRequest{
+		Symbol:      pair,
+		Side:        bybitSide(side),
+		OrderType:   "Limit",
+		Qty:         b.formatQuantity(pair, quantity),
+		Price:       b.formatPrice(pair, limit),
+		TimeInForce: string(model.TimeInForceTypeGTC),
+	})
+}
+
+func (b *Bybit) CreateOrderMarket(side model.SideType, pair string, quantity float64) (model.Order, error) {
+	err := b.validate(pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	return b.createOrder(bybitOrderRequest{
+		Symbol:     pair,
+		Side:       bybitSide(side),
+		OrderType:  "Market",
+		Qty:        b.formatQuantity(pair, quantity),
+		MarketUnit: "baseCoin",
+	})
+}
+
+// CreateOrderMarketQuote creates a market order of a quote amount, eg. 100 USDT of BTCUSDT
+func (b *Bybit) CreateOrderMarketQuote(side model.SideType, pair string, quote float64) (model.Order, error) {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return model.Order{}, ErrInvalidAsset
+	}
+
+	if quote < info.MinNotional {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: min notional: %f", ErrInvalidQuantity, info.MinNotional),
+			Pair:     pair,
+			Quantity: quote,
+		}
+	}
+
+	return b.createOrder(bybitOrderRequest{
+		Symbol:     pair,
+		Side:       bybitSide(side),
+		OrderType:  "Market",
+		Qty:        b.formatQuote(pair, quote),
+		MarketUnit: "quoteCoin",
+	})
+}
+
+// CreateOrder creates an order given a generic request. Post-only orders are sent with the PostOnly
+// time in force. Reduce-only orders are not supported in spot market.
+func (b *Bybit) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	err := request.Validate()
+	if err != nil {
+		return model.Order{}, &OrderError{Err: err, Pair: request.Pair, Quantity: request.Quantity}
+	}
+
+	if request.ReduceOnly {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: reduce-only in spot market", ErrNotSupported),
+			Pair:     request.Pair,
+			Quantity: request.Quantity,
+		}
+	}
+
+	err = b.validate(request.Pair, request.Quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	order := bybitOrderRequest{
+		Symbol:      request.Pair,
+		Side:        bybitSide(request.Side),
+		Qty:         b.formatQuantity(request.Pair, request.Quantity),
+		OrderLinkID: request.ClientID,
+	}
+
+	switch {
+	case request.Type == model.OrderTypeMarket:
+		order.OrderType = "Market"
+		order.MarketUnit = "baseCoin"
+	case request.IsPostOnly():
+		order.OrderType = "Limit"
+		order.Price = b.formatPrice(request.Pair, request.Price)
+		order.TimeInForce = "PostOnly"
+	default:
+		order.OrderType = "Limit"
+		order.Price = b.formatPrice(request.Pair, request.Price)
+		order.TimeInForce = string(model.TimeInForceTypeGTC)
+		if request.TimeInForce != "" {
+			order.TimeInForce = string(request.TimeInForce)
+		}
+	}
+
+	result, err := b.createOrder(order)
+	if err != nil {
+		return model.Order{}, err
+	}
+	result.Tag = request.Tag
+	return result, nil
+}
+
+func (b *Bybit) Cancel(order model.Order) error {
+	return b.request(b.ctx, http.MethodPost, "/v5/order/cancel", map[string]string{
+		"category": "spot",
+		"symbol":   order.Pair,
+		"orderId":  strconv.FormatInt(order.ExchangeID, 10),
+	}, true, nil)
+}
+
+// ReplaceOrder cancels an open limit order and creates a new one with the given price and quantity.
+// Bybit amends orders in place, so the replacement is sent as a new order to get a new ID. The new
+// order is not created if the cancellation fails.
+func (b *Bybit) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	if order.Type != model.OrderTypeLimit && order.Type != model.OrderTypeLimitMaker {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: replace %s order", ErrNotSupported, order.Type),
+			Pair:     order.Pair,
+			Quantity: quantity,
+		}
+	}
+
+	err := b.validate(order.Pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	if err := b.Cancel(order); err != nil {
+		return model.Order{}, err
+	}
+
+	request := model.NewOrderRequest(order.Side, order.Pair, quantity, model.WithLimitPrice(price),
+		model.WithTag(order.Tag))
+	request.PostOnly = order.Type == model.OrderTypeLimitMaker
+	if order.TimeInForce != "" && !request.PostOnly {
+		request.TimeInForce = order.TimeInForce
+	}
+	return b.CreateOrder(request)
+}
+
+// orders returns the orders of an endpoint, the realtime endpoint has the open and recent orders
+func (b *Bybit) orders(endpoint string, params url.Values) ([]model.Order, error) {
+	var result struct {
+		List []bybitOrder `json:"list"`
+	}
+	params.Set("category", "spot")
+	if err := b.request(b.ctx, http.MethodGet, endpoint, params, true, &result); err != nil {
+		return nil, err
+	}
+
+	orders := make([]model.Order, 0, len(result.List))
+	for _, item := range result.List {
+		order, err := newBybitOrder(item)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (b *Bybit) Order(pair string, id int64) (model.Order, error) {
+	params := url.Values{"symbol": {pair}, "orderId": {strconv.FormatInt(id, 10)}}
+	for _, endpoint := range []string{"/v5/order/realtime", "/v5/order/history"} {
+		orders, err := b.orders(endpoint, params)
+		if err != nil {
+			return model.Order{}, err
+		}
+		if len(orders) > 0 {
+			return orders[0], nil
+		}
+	}
+	return model.Order{}, fmt.Errorf("bybit: order %d of %s not found", id, pair)
+}
+
+// Orders returns the last orders of a pair, open and closed, sorted by creation time
+func (b *Bybit) Orders(pair string, limit int) ([]model.Order, error) {
+	open, err := b.orders("/v5/order/realtime", url.Values{"symbol": {pair}, "openOnly": {"0"}})
+	if err != nil {
+		return nil, err
+	}
+
+	closed, err := b.orders("/v5/order/history", url.Values{
+		"symbol": {pair},
+		"limit":  {strconv.Itoa(min(max(limit, 1), 50))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	orders := make([]model.Order, 0, len(open)+len(closed))
+	for _, order := range append(open, closed...) {
+		if !seen[order.ExchangeID] {
+			seen[order.ExchangeID] = true
+			orders = append(orders, order)
+		}
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].ExchangeID < orders[j].ExchangeID
+		}
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	if limit > 0 && len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
+}
+
+// WithContext returns a copy of the exchange that sends the account and order requests with ctx
+func (b *Bybit) WithContext(ctx context.Context) service.Exchange {
+	exchange := *b
+	exchange.ctx = ctx
+	return &exchange
+}
+
+func (b *Bybit) Account() (model.Account, error) {
+	var result struct {
+		List []struct {
+			Coin []struct {
+				Coin          string `json:"coin"`
+				WalletBalance string `json:"walletBalance"`
+				Locked        string `json:"locked"`
+			} `json:"coin"`
+		} `json:"list"`
+	}
+	params := url.Values{"accountType": {"UNIFIED"}}
+	if err := b.request(b.ctx, http.MethodGet, "/v5/account/wallet-balance", params, true, &result); err != nil {
+		return model.Account{}, err
+	}
+
+	balances := make([]model.Balance, 0)
+	for _, account := range result.List {
+		for _, coin := range account.Coin {
+			total, err := strconv.ParseFloat(coin.WalletBalance, 64)
+			if err != nil {
+				return model.Account{}, err
+			}
+			locked, _ := strconv.ParseFloat(coin.Locked, 64)
+			balances = append(balances, model.Balance{
+				Asset: coin.Coin,
+				Free:  total - locked,
+				Lock:  locked,
+			})
+		}
+	}
+
+	return model.Account{
+		Balances: balances,
+	}, nil
+}
+
+func (b *Bybit) Position(pair string) (asset, quote float64, err error) {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+	}
+
+	acc, err := b.Account()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	assetBalance, quoteBalance := acc.Balance(info.BaseAsset, info.QuoteAsset)
+
+	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
+}
+
+// PositionInfo returns the asset balance of a pair as a long position without leverage.
+// The entry price is not available in spot market.
+func (b *Bybit) PositionInfo(pair string) (model.Position, error) {
+	asset, _, err := b.Position(pair)
+	if err != nil {
+		return model.Position{}, err
+	}
+
+	position := model.Position{Pair: pair, Leverage: 1}
+	if asset <= 0 {
+		return position, nil
+	}
+
+	price, err := b.LastQuote(b.ctx, pair)
+	if err != nil {
+		return model.Position{}, err
+	}
+
+	position.Side = model.PositionSideTypeLong
+	position.Quantity = asset
+	position.MarkPrice = price
+	position.Margin = asset * price
+	return position, nil
+}
+
+// klinePage returns the candles of a request to the kline endpoint sorted by time, Bybit sends the
+// newest first
+func (b *Bybit) klinePage(ctx context.Context, pair, period string, params url.Values) ([]model.Candle, error) {
+	interval, ok := bybitIntervals[period]
+	if !ok {
+		return nil, fmt.Errorf("invalid timeframe: %s", period)
+	}
+
+	var result struct {
+		List [][]string `json:"list"`
+	}
+	params.Set("category", "spot")
+	params.Set("symbol", pair)
+	params.Set("interval", interval)
+	if err := b.request(ctx, http.MethodGet, "/v5/market/kline", params, false, &result); err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, 0, len(result.List))
+	for i := len(result.List) - 1; i >= 0; i-- {
+		candle, err := CandleFromBybitKline(pair, result.List[i])
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// klines returns the candles of a request to the kline endpoint sorted by time
+func (b *Bybit) klines(ctx context.Context, pair, period string, params url.Values) ([]model.Candle, error) {
+	candles, err := b.klinePage(ctx, pair, period, params)
+	if err != nil {
+		return nil, err
+	}
+	return b.heikinAshi(candles), nil
+}
+
+func (b *Bybit) heikinAshi(candles []model.Candle) []model.Candle {
+	if !b.HeikinAshi {
+		return candles
+	}
+
+	ha := model.NewHeikinAshi()
+	for i := range candles {
+		candles[i] = candles[i].ToHeikinAshi(ha)
+	}
+	return candles
+}
+
+func (b *Bybit) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	if source, ok := bybitResampler.source(period); ok {
+		return bybitResampler.CandlesByLimit(ctx, b, pair, source, period, limit)
+	}
+
+	candles, err := b.klines(ctx, pair, period, url.Values{"limit": {strconv.Itoa(limit + 1)}})
+	if err != nil {
+		return nil, err
+	}
+
+	// discard last candle, because it is incomplete
+	if len(candles) == 0 {
+		return candles, nil
+	}
+	return candles[:len(candles)-1], nil
+}
+
+func (b *Bybit) CandlesByPeriod(ctx context.Context, pair, period string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	if source, ok := bybitResampler.source(period); ok {
+		return bybitResampler.CandlesByPeriod(ctx, b, pair, source, period, start, end)
+	}
+
+	// Bybit returns the newest klines of the period, so the pages are requested backwards from the end
+	pages := make([][]model.Candle, 0)
+	for cursor := end; !cursor.Before(start); {
+		page, err := b.klinePage(ctx, pair, period, url.Values{
+			"start": {strconv.FormatInt(start.UnixMilli(), 10)},
+			"end":   {strconv.FormatInt(cursor.UnixMilli(), 10)},
+			"limit": {strconv.Itoa(bybitKlineLimit)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, page)
+		if len(page) < bybitKlineLimit || !page[0].Time.Before(cursor) {
+			break
+		}
+		cursor = page[0].Time.Add(-time.Millisecond)
+	}
+
+	candles := make([]model.Candle, 0)
+	for i := len(pages) - 1; i >= 0; i-- {
+		candles = append(candles, pages[i]...)
+	}
+	return b.heikinAshi(candles), nil
+}
+
+// bybitWsMessage is a message of the public websocket, a command response or a topic update
+type bybitWsMessage struct {
+	Op      string          `json:"op"`
+	Success *bool           `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type bybitWsKline struct {
+	Start   int64  `json:"start"`
+	Open    string `json:"open"`
+	Close   string `json:"close"`
+	High    string `json:"high"`
+	Low     string `json:"low"`
+	Volume  string `json:"volume"`
+	Confirm bool   `json:"confirm"`
+}
+
+// serve subscribes a topic of the public websocket and calls the handler with its updates, until
+// the connection is closed or the context is canceled
+func (b *Bybit) serve(ctx context.Context, topic string, handler func(json.RawMessage)) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, b.WsURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{"op": "subscribe", "args": []string{topic}})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(bybitPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-ticker.C:
+				if err := conn.WriteJSON(map[string]string{"op": "ping"}); err != nil {
+					log.Warnf("bybit ping: %v", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		var message bybitWsMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if message.Op == "subscribe" && message.Success != nil && !*message.Success {
+			return fmt.Errorf("bybit subscribe %s: %s", topic, message.RetMsg)
+		}
+
+		if message.Topic == topic {
+			handler(message.Data)
+		}
+	}
+}
+
+func (b *Bybit) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	if source, ok := bybitResampler.source(period); ok {
+		return bybitResampler.CandlesSubscription(ctx, b, pair, source, period)
+	}
+
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error)
+	ha := model.NewHeikinAshi()
+
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+
+		interval, ok := bybitIntervals[period]
+		if !ok {
+			select {
+			case cerr <- fmt.Errorf("invalid timeframe: %s", period):
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		topic := fmt.Sprintf("kline.%s.%s", interval, pair)
+		for {
+			err := b.serve(ctx, topic, func(data json.RawMessage) {
+				ba.Reset()
+				var klines []bybitWsKline
+				if err := json.Unmarshal(data, &klines); err != nil {
+					log.Warnf("bybit kline: %v", err)
+					return
+				}
+
+				for _, kline := range klines {
+					candle := CandleFromBybitWsKline(pair, kline)
+					if candle.Complete && b.HeikinAshi {
+						candle = candle.ToHeikinAshi(ha)
+					}
+
+					if candle.Complete {
+						// fetch aditional data if needed
+						for _, fetcher := range b.MetadataFetchers {
+							key, value := fetcher(pair, candle.Time)
+							candle.Metadata[key] = value
+						}
+					}
+
+					select {
+					case ccandle <- candle:
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ba.Duration()):
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
+
+// CandleFromBybitKline converts a kline of the REST API: start, open, high, low, close, volume and turnover
+func CandleFromBybitKline(pair string, kline []string) (model.Candle, error) {
+	if len(kline) < 6 {
+		return model.Candle{}, fmt.Errorf("bybit: invalid kline: %v", kline)
+	}
+
+	start, err := strconv.ParseInt(kline[0], 10, 64)
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	t := time.UnixMilli(start)
+	candle := model.Candle{Pair: pair, Time: t, UpdatedAt: t, Complete: true}
+	candle.Open, _ = strconv.ParseFloat(kline[1], 64)
+	candle.High, _ = strconv.ParseFloat(kline[2], 64)
+	candle.Low, _ = strconv.ParseFloat(kline[3], 64)
+	candle.Close, _ = strconv.ParseFloat(kline[4], 64)
+	candle.Volume, _ = strconv.ParseFloat(kline[5], 64)
+	candle.Metadata = make(map[string]float64)
+	return candle, nil
+}
+
+func CandleFromBybitWsKline(pair string, kline bybitWsKline) model.Candle {
+	t := time.UnixMilli(kline.Start)
+	candle := model.Candle{Pair: pair, Time: t, UpdatedAt: t}
+	candle.Open, _ = strconv.ParseFloat(kline.Open, 64)
+	candle.Close, _ = strconv.ParseFloat(kline.Close, 64)
+	candle.High, _ = strconv.ParseFloat(kline.High, 64)
+	candle.Low, _ = strconv.ParseFloat(kline.Low, 64)
+	candle.Volume, _ = strconv.ParseFloat(kline.Volume, 64)
+	candle.Complete = kline.Confirm
+	candle.Metadata = make(map[string]float64)
+	return candle
+}
@@ -0,0 +1,652 @@
+package exchange
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+var _ service.Exchange = &Bybit{}
+
+// bybitMock is an in-process server of the Bybit V5 API for spot market, with hourly klines of
+// BTCUSDT, a wallet and orders filled at the current price
+type bybitMock struct {
+	t      *testing.T
+	server *httptest.Server
+	key    string
+	secret string
+
+	mtx      sync.Mutex
+	start    time.Time
+	klines   int
+	price    float64
+	balances map[string]float64
+	locked   map[string]float64
+	orders   []*bybitOrder
+	nextID   int64
+	wsKlines []bybitWsKline
+	requests []string
+}
+
+func newBybitMock(t *testing.T) *bybitMock {
+	mock := &bybitMock{
+		t:        t,
+		key:      "key",
+		secret:   "secret",
+		start:    time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		klines:   48,
+		price:    40000,
+		balances: map[string]float64{"USDT": 10000},
+		locked:   make(map[string]float64),
+		nextID:   1600000000000000000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v5/market/time", func(w http.ResponseWriter, r *http.Request) {
+		mock.reply(w, map[string]string{"timeNano": strconv.FormatInt(time.Now().UnixNano(), 10)})
+	})
+	mux.HandleFunc("/v5/market/instruments-info", mock.instruments)
+	mux.HandleFunc("/v5/market/kline", mock.kline)
+	mux.HandleFunc("/v5/market/tickers", func(w http.ResponseWriter, r *http.Request) {
+		mock.mtx.Lock()
+		defer mock.mtx.Unlock()
+		mock.reply(w, map[string]interface{}{"list": []map[string]string{
+			{"symbol": r.URL.Query().Get("symbol"), "lastPrice": strconv.FormatFloat(mock.price, 'f', -1, 64)},
+		}})
+	})
+	mux.HandleFunc("/v5/order/create", mock.signed(mock.create))
+	mux.HandleFunc("/v5/order/cancel", mock.signed(mock.cancel))
+	mux.HandleFunc("/v5/order/realtime", mock.signed(mock.orderList(true)))
+	mux.HandleFunc("/v5/order/history", mock.signed(mock.orderList(false)))
+	mux.HandleFunc("/v5/account/wallet-balance", mock.signed(mock.wallet))
+	mux.HandleFunc("/v5/public/spot", mock.websocket)
+
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+	return mock
+}
+
+func (m *bybitMock) exchange(t *testing.T, options ...BybitOption) *Bybit {
+	wsURL := "ws" + strings.TrimPrefix(m.server.URL, "http") + "/v5/public/spot"
+	options = append([]BybitOption{
+		WithBybitEndpoint(m.server.URL, wsURL),
+		WithBybitCredentials(m.key, m.secret),
+	}, options...)
+
+	bybit, err := NewBybit(context.Background(), options...)
+	require.NoError(t, err)
+	return bybit
+}
+
+func (m *bybitMock) reply(w http.ResponseWriter, result interface{}) {
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"retCode": 0, "retMsg": "OK", "result": result, "time": time.Now().UnixMilli(),
+	})
+	require.NoError(m.t, err)
+}
+
+func (m *bybitMock) fail(w http.ResponseWriter, code int, message string) {
+	err := json.NewEncoder(w).Encode(map[string]interface{}{"retCode": code, "retMsg": message, "result": struct{}{}})
+	require.NoError(m.t, err)
+}
+
+// signed checks the signature of private endpoints
+func (m *bybitMock) signed(handler func(http.ResponseWriter, *http.Request, []byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(m.t, err)
+
+		payload := r.URL.RawQuery
+		if r.Method == http.MethodPost {
+			payload = string(body)
+		}
+
+		mac := hmac.New(sha256.New, []byte(m.secret))
+		mac.Write([]byte(r.Header.Get("X-BAPI-TIMESTAMP") + m.key + r.Header.Get("X-BAPI-RECV-WINDOW") + payload))
+		if r.Header.Get("X-BAPI-API-KEY") != m.key || r.Header.Get("X-BAPI-SIGN") != hex.EncodeToString(mac.Sum(nil)) {
+			m.fail(w, 10004, "error sign!")
+			return
+		}
+
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+		m.requests = append(m.requests, r.URL.Path)
+		handler(w, r, body)
+	}
+}
+
+func (m *bybitMock) instruments(w http.ResponseWriter, r *http.Request) {
+	instrument := func(symbol, base string, precision, minQty, tick string) map[string]interface{} {
+		return map[string]interface{}{
+			"symbol": symbol, "baseCoin": base, "quoteCoin": "USDT", "status": "Trading",
+			"lotSizeFilter": map[string]string{
+				"basePrecision": precision, "quotePrecision": "0.0000001", "minOrderQty": minQty,
+				"maxOrderQty": "100", "minOrderAmt": "1", "maxOrderAmt": "2000000",
+			},
+			"priceFilter": map[string]string{"tickSize": tick},
+		}
+	}
+
+	// instruments are paginated
+	if r.URL.Query().Get("cursor") == "" {
+		m.reply(w, map[string]interface{}{
+			"list":           []interface{}{instrument("BTCUSDT", "BTC", "0.000001", "0.000048", "0.01")},
+			"nextPageCursor": "page2",
+		})
+		return
+	}
+	m.reply(w, map[string]interface{}{
+		"list":           []interface{}{instrument("MNTUSDT", "MNT", "0.01", "1", "0.0001")},
+		"nextPageCursor": "",
+	})
+}
+
+// candle returns the kline of an hour after the start, the price grows 10 each hour
+func (m *bybitMock) candle(i int) []string {
+	open := 40000 + float64(i)*10
+	return []string{
+		strconv.FormatInt(m.start.Add(time.Duration(i)*time.Hour).UnixMilli(), 10),
+		strconv.FormatFloat(open, 'f', -1, 64),
+		strconv.FormatFloat(open+20, 'f', -1, 64),
+		strconv.FormatFloat(open-5, 'f', -1, 64),
+		strconv.FormatFloat(open+10, 'f', -1, 64),
+		"1.5", "60000",
+	}
+}
+
+func (m *bybitMock) kline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("interval") != "60" {
+		m.fail(w, 10001, "invalid interval")
+		return
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+	end, err := strconv.ParseInt(query.Get("end"), 10, 64)
+	if err != nil {
+		end = m.start.Add(time.Duration(m.klines) * time.Hour).UnixMilli()
+	}
+
+	list := make([][]string, 0)
+	for i := m.klines - 1; i >= 0 && len(list) < limit; i-- {
+		t := m.start.Add(time.Duration(i) * time.Hour).UnixMilli()
+		if t >= start && t <= end {
+			list = append(list, m.candle(i))
+		}
+	}
+	m.reply(w, map[string]interface{}{"symbol": query.Get("symbol"), "category": "spot", "list": list})
+}
+
+func (m *bybitMock) create(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var request bybitOrderRequest
+	require.NoError(m.t, json.Unmarshal(body, &request))
+	require.Equal(m.t, "spot", request.Category)
+
+	qty, _ := strconv.ParseFloat(request.Qty, 64)
+	price, _ := strconv.ParseFloat(request.Price, 64)
+	if request.OrderType == "Market" && request.MarketUnit == "quoteCoin" {
+		qty /= m.price
+	}
+
+	m.nextID++
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	order := &bybitOrder{
+		OrderID: strconv.FormatInt(m.nextID, 10), OrderLinkID: request.OrderLinkID, Symbol: request.Symbol,
+		Price: request.Price, Qty: strconv.FormatFloat(qty, 'f', -1, 64), Side: request.Side,
+		OrderStatus: "New", OrderType: request.OrderType, TimeInForce: request.TimeInForce,
+		TriggerPrice: request.TriggerPrice, CumExecQty: "0", CumExecValue: "0", CreatedTime: now, UpdatedTime: now,
+	}
+
+	base := strings.TrimSuffix(request.Symbol, "USDT")
+	switch {
+	case request.TriggerPrice != "":
+		order.OrderStatus = "Untriggered"
+	case request.OrderType == "Market":
+		m.fill(order, m.price)
+	case request.TimeInForce == "PostOnly" && (request.Side == "Buy") == (price >= m.price):
+		order.OrderStatus = "Cancelled"
+	case request.Side == "Buy":
+		if m.balances["USDT"]-m.locked["USDT"] < qty*price {
+			m.fail(w, 170131, "Insufficient balance.")
+			return
+		}
+		m.locked["USDT"] += qty * price
+	default:
+		m.locked[base] += qty
+	}
+
+	m.orders = append(m.orders, order)
+	m.reply(w, map[string]string{"orderId": order.OrderID, "orderLinkId": order.OrderLinkID})
+}
+
+// fill executes an order at a price and updates the wallet
+func (m *bybitMock) fill(order *bybitOrder, price float64) {
+	qty, _ := strconv.ParseFloat(order.Qty, 64)
+	limit, _ := strconv.ParseFloat(order.Price, 64)
+	base := strings.TrimSuffix(order.Symbol, "USDT")
+
+	if order.Side == "Buy" {
+		if order.OrderType == "Limit" {
+			m.locked["USDT"] -= qty * limit
+		}
+		m.balances["USDT"] -= qty * price
+		m.balances[base] += qty
+	} else {
+		if order.OrderType == "Limit" {
+			m.locked[base] -= qty
+		}
+		m.balances[base] -= qty
+		m.balances["USDT"] += qty * price
+	}
+
+	order.OrderStatus = "Filled"
+	order.CumExecQty = order.Qty
+	order.CumExecValue = strconv.FormatFloat(qty*price, 'f', -1, 64)
+	order.UpdatedTime = strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+// setPrice moves the price, filling limit orders crossed and triggering stop orders
+func (m *bybitMock) setPrice(price float64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.price = price
+	for _, order := range m.orders {
+		limit, _ := strconv.ParseFloat(order.Price, 64)
+		trigger, _ := strconv.ParseFloat(order.TriggerPrice, 64)
+		switch {
+		case order.OrderStatus == "Untriggered" && price <= trigger:
+			m.fill(order, price)
+		case order.OrderStatus == "New" && order.Side == "Buy" && price <= limit,
+			order.OrderStatus == "New" && order.Side == "Sell" && price >= limit:
+			m.fill(order, limit)
+		}
+	}
+}
+
+func (m *bybitMock) cancel(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var request map[string]string
+	require.NoError(m.t, json.Unmarshal(body, &request))
+
+	for _, order := range m.orders {
+		if order.OrderID != request["orderId"] {
+			continue
+		}
+		if order.OrderStatus != "New" && order.OrderStatus != "Untriggered" {
+			break
+		}
+
+		qty, _ := strconv.ParseFloat(order.Qty, 64)
+		price, _ := strconv.ParseFloat(order.Price, 64)
+		if order.OrderType == "Limit" && order.Side == "Buy" {
+			m.locked["USDT"] -= qty * price
+		} else if order.OrderType == "Limit" {
+			m.locked[strings.TrimSuffix(order.Symbol, "USDT")] -= qty
+		}
+		order.OrderStatus = "Cancelled"
+		m.reply(w, map[string]string{"orderId": order.OrderID})
+		return
+	}
+	m.fail(w, 170213, "Order does not exist.")
+}
+
+func (m *bybitMock) orderList(open bool) func(http.ResponseWriter, *http.Request, []byte) {
+	return func(w http.ResponseWriter, r *http.Request, _ []byte) {
+		query := r.URL.Query()
+		list := make([]bybitOrder, 0)
+		for i := len(m.orders) - 1; i >= 0; i-- {
+			order := m.orders[i]
+			active := order.OrderStatus == "New" || order.OrderStatus == "Untriggered"
+			if active != open || order.Symbol != query.Get("symbol") {
+				continue
+			}
+			if id := query.Get("orderId"); id != "" && id != order.OrderID {
+				continue
+			}
+			list = append(list, *order)
+		}
+		m.reply(w, map[string]interface{}{"category": "spot", "list": list})
+	}
+}
+
+func (m *bybitMock) wallet(w http.ResponseWriter, r *http.Request, _ []byte) {
+	require.Equal(m.t, "UNIFIED", r.URL.Query().Get("accountType"))
+	coins := make([]map[string]string, 0)
+	for coin, balance := range m.balances {
+		coins = append(coins, map[string]string{
+			"coin":          coin,
+			"walletBalance": strconv.FormatFloat(balance, 'f', -1, 64),
+			"locked":        strconv.FormatFloat(m.locked[coin], 'f', -1, 64),
+		})
+	}
+	m.reply(w, map[string]interface{}{"list": []interface{}{map[string]interface{}{"coin": coins}}})
+}
+
+// websocket accepts kline subscriptions and sends the scripted klines
+func (m *bybitMock) websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	require.NoError(m.t, err)
+	defer conn.Close()
+
+	for {
+		var request struct {
+			Op   string   `json:"op"`
+			Args []string `json:"args"`
+		}
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+
+		switch request.Op {
+		case "ping":
+			_ = conn.WriteJSON(map[string]interface{}{"op": "pong", "success": true})
+		case "subscribe":
+			topic := request.Args[0]
+			if !strings.HasPrefix(topic, "kline.60.") {
+				_ = conn.WriteJSON(map[string]interface{}{"op": "subscribe", "success": false,
+					"ret_msg": "error:handler not found,topic:" + topic})
+				continue
+			}
+
+			_ = conn.WriteJSON(map[string]interface{}{"op": "subscribe", "success": true})
+			m.mtx.Lock()
+			klines := m.wsKlines
+			m.mtx.Unlock()
+			for _, kline := range klines {
+				err := conn.WriteJSON(map[string]interface{}{
+					"topic": topic, "type": "snapshot", "ts": time.Now().UnixMilli(), "data": []bybitWsKline{kline},
+				})
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestBybit_AssetsInfo(t *testing.T) {
+	mock := newBybitMock(t)
+	bybit := mock.exchange(t)
+
+	info := bybit.AssetsInfo("BTCUSDT")
+	require.Equal(t, model.AssetInfo{
+		BaseAsset: "BTC", QuoteAsset: "USDT", MinQuantity: 0.000048, MaxQuantity: 100, StepSize: 0.000001,
+		TickSize: 0.01, MinNotional: 1, QuotePrecision: 7, BaseAssetPrecision: 6,
+	}, info)
+
+	// pairs of the second page, not listed by Binance
+	require.Equal(t, "MNT", bybit.AssetsInfo("MNTUSDT").BaseAsset)
+	asset, quote := SplitAssetQuote("MNTUSDT")
+	require.Equal(t, "MNT", asset)
+	require.Equal(t, "USDT", quote)
+
+	require.Equal(t, "0.123456", bybit.formatQuantity("BTCUSDT", 0.1234567))
+	require.Equal(t, "12.3", bybit.formatQuantity("MNTUSDT", 12.309))
+	require.Equal(t, "40000.12", bybit.formatPrice("BTCUSDT", 40000.129))
+	require.Equal(t, "0.8", bybit.formatPrice("MNTUSDT", 0.80001))
+	require.Equal(t, "100", bybit.formatQuantity("MNTUSDT", 100))
+
+	_, err := NewBybit(context.Background(), WithBybitEndpoint("http://127.0.0.1:1", ""))
+	require.Error(t, err)
+}
+
+func TestBybit_Candles(t *testing.T) {
+	ctx := context.Background()
+	mock := newBybitMock(t)
+	bybit := mock.exchange(t)
+
+	t.Run("period", func(t *testing.T) {
+		candles, err := bybit.CandlesByPeriod(ctx, "BTCUSDT", "1h", mock.start, mock.start.Add(9*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 10)
+		for i, candle := range candles {
+			require.Equal(t, mock.start.Add(time.Duration(i)*time.Hour), candle.Time.UTC())
+			require.True(t, candle.Complete)
+		}
+		require.Equal(t, 40000.0, candles[0].Open)
+		require.Equal(t, 40020.0, candles[0].High)
+		require.Equal(t, 39995.0, candles[0].Low)
+		require.Equal(t, 40010.0, candles[0].Close)
+		require.Equal(t, 1.5, candles[0].Volume)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		candles, err := bybit.CandlesByLimit(ctx, "BTCUSDT", "1h", 5)
+		require.NoError(t, err)
+		require.Len(t, candles, 5)
+		// the last kline is open
+		require.Equal(t, mock.start.Add(time.Duration(mock.klines-2)*time.Hour), candles[4].Time.UTC())
+	})
+
+	t.Run("resampled", func(t *testing.T) {
+		candles, err := bybit.CandlesByPeriod(ctx, "BTCUSDT", "3h", mock.start, mock.start.Add(8*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 3)
+		require.Equal(t, 40000.0, candles[0].Open)
+		require.Equal(t, 40030.0, candles[0].Close)
+		require.Equal(t, 4.5, candles[0].Volume)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		mock := newBybitMock(t)
+		mock.klines = 2500
+		bybit := mock.exchange(t)
+
+		end := mock.start.Add(2499 * time.Hour)
+		candles, err := bybit.CandlesByPeriod(ctx, "BTCUSDT", "1h", mock.start, end)
+		require.NoError(t, err)
+		require.Len(t, candles, 2500)
+		for i, candle := range candles {
+			require.Equal(t, mock.start.Add(time.Duration(i)*time.Hour), candle.Time.UTC())
+		}
+
+		candles, err = bybit.CandlesByPeriod(ctx, "BTCUSDT", "3h", mock.start, end.Add(-time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 833)
+		require.Equal(t, mock.start, candles[0].Time.UTC())
+	})
+
+	t.Run("last quote", func(t *testing.T) {
+		price, err := bybit.LastQuote(ctx, "BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 40000.0, price)
+	})
+
+	_, err := bybit.CandlesByPeriod(ctx, "BTCUSDT", "1s", mock.start, mock.start.Add(time.Hour))
+	require.Error(t, err)
+}
+
+func TestBybit_CandlesSubscription(t *testing.T) {
+	mock := newBybitMock(t)
+	start := mock.start.UnixMilli()
+	mock.wsKlines = []bybitWsKline{
+		{Start: start, Open: "100", Close: "101", High: "102", Low: "99", Volume: "1"},
+		{Start: start, Open: "100", Close: "103", High: "104", Low: "99", Volume: "2", Confirm: true},
+		{Start: start + time.Hour.Milliseconds(), Open: "103", Close: "103", High: "103", Low: "103", Volume: "0"},
+	}
+
+	bybit := mock.exchange(t, WithBybitMetadataFetcher(func(pair string, _ time.Time) (string, float64) {
+		return "funding_rate", 0.01
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("feed", func(t *testing.T) {
+		ccandle, _ := bybit.CandlesSubscription(ctx, "BTCUSDT", "1h")
+		candles := make([]model.Candle, 0)
+		for candle := range ccandle {
+			candles = append(candles, candle)
+			if len(candles) == len(mock.wsKlines) {
+				break
+			}
+		}
+
+		require.False(t, candles[0].Complete)
+		require.True(t, candles[1].Complete)
+		require.Equal(t, 103.0, candles[1].Close)
+		require.Equal(t, 0.01, candles[1].Metadata["funding_rate"])
+		require.NotContains(t, candles[0].Metadata, "funding_rate")
+		require.Equal(t, mock.start.Add(time.Hour), candles[2].Time.UTC())
+	})
+
+	t.Run("data feed", func(t *testing.T) {
+		feed := NewDataFeed(bybit)
+		received := make(chan model.Candle, 10)
+		feed.Subscribe("BTCUSDT", "1h", func(candle model.Candle) {
+			received <- candle
+		}, true)
+		feed.Start(false)
+
+		select {
+		case candle := <-received:
+			require.Equal(t, 103.0, candle.Close)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "candle not received")
+		}
+	})
+
+	t.Run("invalid topic", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		_, cerr := bybit.CandlesSubscription(ctx, "BTCUSDT", "1m")
+		err := <-cerr
+		require.ErrorContains(t, err, "handler not found")
+	})
+}
+
+func TestBybit_Orders(t *testing.T) {
+	mock := newBybitMock(t)
+	bybit := mock.exchange(t)
+
+	t.Run("market", func(t *testing.T) {
+		order, err := bybit.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.1)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, model.OrderTypeMarket, order.Type)
+		require.Equal(t, model.SideTypeBuy, order.Side)
+		require.Equal(t, 40000.0, order.Price)
+		require.Equal(t, 0.1, order.Quantity)
+
+		asset, quote, err := bybit.Position("BTCUSDT")
+		require.NoError(t, err)
+		require.InDelta(t, 0.1, asset, 1e-9)
+		require.InDelta(t, 6000, quote, 1e-6)
+
+		order, err = bybit.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 400)
+		require.NoError(t, err)
+		require.InDelta(t, 0.01, order.Quantity, 1e-9)
+
+		position, err := bybit.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeLong, position.Side)
+		require.InDelta(t, 0.11, position.Quantity, 1e-9)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		order, err := bybit.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 0.05, 41000)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+		require.Equal(t, model.OrderTypeLimit, order.Type)
+
+		account, err := bybit.Account()
+		require.NoError(t, err)
+		btc, _ := account.Balance("BTC", "USDT")
+		require.InDelta(t, 0.05, btc.Lock, 1e-9)
+
+		// the order is filled when the price reaches the limit
+		mock.setPrice(41500)
+		order, err = bybit.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 41000.0, order.Price)
+	})
+
+	t.Run("replace and cancel", func(t *testing.T) {
+		order, err := bybit.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 0.01,
+			model.WithLimitPrice(30000), model.WithPostOnly(), model.WithClientID("entry-1"), model.WithTag("entry")))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeLimitMaker, order.Type)
+		require.Equal(t, "entry-1", order.ClientID)
+		require.Equal(t, "entry", order.Tag)
+
+		replaced, err := bybit.ReplaceOrder(order, 31000, 0.02)
+		require.NoError(t, err)
+		require.NotEqual(t, order.ExchangeID, replaced.ExchangeID)
+		require.Equal(t, model.OrderTypeLimitMaker, replaced.Type)
+		require.Equal(t, 31000.0, replaced.Price)
+		require.Equal(t, 0.02, replaced.Quantity)
+		require.Equal(t, "entry", replaced.Tag)
+
+		order, err = bybit.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, order.Status)
+
+		require.NoError(t, bybit.Cancel(replaced))
+		var apiErr BybitAPIError
+		require.ErrorAs(t, bybit.Cancel(replaced), &apiErr)
+		require.Equal(t, 170213, apiErr.Code)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		order, err := bybit.CreateOrderStop("BTCUSDT", 0.02, 39000)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeStopLoss, order.Type)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+		require.Equal(t, 39000.0, *order.Stop)
+
+		mock.setPrice(38900)
+		order, err = bybit.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 38900.0, order.Price)
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		orders, err := bybit.Orders("BTCUSDT", 3)
+		require.NoError(t, err)
+		require.Len(t, orders, 3)
+		require.Equal(t, model.OrderTypeStopLoss, orders[2].Type)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := bybit.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.00001)
+		require.ErrorIs(t, err, ErrInvalidQuantity)
+
+		_, err = bybit.CreateOrderMarket(model.SideTypeBuy, "ETHBTC", 1)
+		require.ErrorIs(t, err, ErrInvalidAsset)
+
+		_, err = bybit.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.01, 42000, 39000, 38900)
+		require.ErrorIs(t, err, ErrNotSupported)
+
+		_, err = bybit.CreateOrder(model.NewOrderRequest(model.SideTypeSell, "BTCUSDT", 0.01, model.WithReduceOnly()))
+		require.ErrorIs(t, err, ErrNotSupported)
+
+		_, err = bybit.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 10, 40000)
+		require.ErrorContains(t, err, "Insufficient balance")
+
+		wrong := mock.exchange(t, WithBybitCredentials("key", "wrong"))
+		_, err = wrong.Account()
+		var apiErr BybitAPIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, 10004, apiErr.Code)
+	})
+}
@@ -5,13 +5,14 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/samber/lo"
-	"github.com/xhit/go-str2duration/v2"
 
 	"github.com/rodrigo-brito/ninjabot/model"
 )
@@ -23,6 +24,17 @@ type PairFeed struct {
 	File       string
 	Timeframe  string
 	HeikinAshi bool
+	// WeekStart is the first day of resampled weekly candles, default is Sunday
+	WeekStart time.Weekday
+	// Offset shifts the start of resampled candles, eg. 8h for daily candles starting at 08:00 UTC
+	Offset time.Duration
+	// Schema is the layout of the CSV file, it is detected from the first rows when empty
+	Schema *CSVSchema
+}
+
+// resampler returns the resampler of the feed timeframe to a target timeframe
+func (p PairFeed) resampler(targetTimeframe string) (*Resampler, error) {
+	return NewResampler(p.Timeframe, targetTimeframe, WithWeekStart(p.WeekStart), WithAnchorOffset(p.Offset))
 }
 
 type CSVFeed struct {
@@ -31,6 +43,11 @@ type CSVFeed struct {
 }
 
 func (c CSVFeed) AssetsInfo(pair string) model.AssetInfo {
+	return DefaultAssetsInfo(pair)
+}
+
+// DefaultAssetsInfo returns the asset information used by offline feeds, with 8 decimal places precision
+func DefaultAssetsInfo(pair string) model.AssetInfo {
 	asset, quote := SplitAssetQuote(pair)
 	return model.AssetInfo{
 		BaseAsset:          asset,
@@ -44,110 +61,225 @@ func (c CSVFeed) AssetsInfo(pair string) model.AssetInfo {
 	}
 }
 
-func parseHeaders(headers []string) (index map[string]int, additional []string, ok bool) {
-	headerMap := map[string]int{
-		"time": 0, "open": 1, "close": 2, "low": 3, "high": 4, "volume": 5,
+// csvCandleReader reads the candles of a CSV file one row at a time
+type csvCandleReader struct {
+	feed      PairFeed
+	schema    CSVSchema
+	file      *os.File
+	reader    *csv.Reader
+	index     map[string]int
+	metadata  []string
+	firstLine []string
+	ha        *model.HeikinAshi
+}
+
+func newCSVCandleReader(feed PairFeed) (*csvCandleReader, error) {
+	schema := feed.Schema
+	if schema == nil {
+		detected, err := DetectCSVSchema(feed.File)
+		if err != nil {
+			return nil, err
+		}
+		schema = &detected
 	}
 
-	_, err := strconv.Atoi(headers[0])
-	if err == nil {
-		return headerMap, additional, false
+	file, err := os.Open(feed.File)
+	if err != nil {
+		return nil, err
 	}
 
-	for index, h := range headers {
-		if _, ok := headerMap[h]; !ok {
-			additional = append(additional, h)
-		}
-		headerMap[h] = index
+	reader := &csvCandleReader{
+		feed:   feed,
+		schema: *schema,
+		file:   file,
+		reader: csv.NewReader(file),
+		ha:     model.NewHeikinAshi(),
+	}
+	reader.reader.FieldsPerRecord = -1
+	if schema.Delimiter != 0 {
+		reader.reader.Comma = schema.Delimiter
 	}
 
-	return headerMap, additional, true
-}
+	firstLine, err := reader.reader.Read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
-// NewCSVFeed creates a new data feed from CSV files and resample
-func NewCSVFeed(targetTimeframe string, feeds ...PairFeed) (*CSVFeed, error) {
-	csvFeed := &CSVFeed{
-		Feeds:               make(map[string]PairFeed),
-		CandlePairTimeFrame: make(map[string][]model.Candle),
+	// map each candle field and metadata column with its index
+	reader.index, reader.metadata, err = schema.indexes(firstLine)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", feed.File, err)
 	}
 
-	for _, feed := range feeds {
-		csvFeed.Feeds[feed.Pair] = feed
+	if len(schema.Header) > 0 {
+		reader.firstLine = firstLine
+	}
 
-		csvFile, err := os.Open(feed.File)
+	return reader, nil
+}
+
+// Read returns the next candle of the file, or io.EOF at the end
+func (r *csvCandleReader) Read() (model.Candle, error) {
+	line := r.firstLine
+	if line != nil {
+		r.firstLine = nil
+	} else {
+		var err error
+		line, err = r.reader.Read()
 		if err != nil {
-			return nil, err
+			return model.Candle{}, err
 		}
+	}
 
-		csvLines, err := csv.NewReader(csvFile).ReadAll()
-		if err != nil {
-			return nil, err
+	candle, err := r.parse(line)
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	if r.feed.HeikinAshi {
+		candle = candle.ToHeikinAshi(r.ha)
+	}
+
+	return candle, nil
+}
+
+func (r *csvCandleReader) parse(line []string) (model.Candle, error) {
+	column := func(name string) (string, error) {
+		index := r.index[name]
+		if index >= len(line) {
+			return "", fmt.Errorf("%s: missing column %s", r.feed.File, name)
 		}
+		return strings.TrimSpace(line[index]), nil
+	}
 
-		var candles []model.Candle
-		ha := model.NewHeikinAshi()
+	value, err := column("time")
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	t, err := r.schema.parseTime(value)
+	if err != nil {
+		return model.Candle{}, err
+	}
 
-		// map each header label with its index
-		headerMap, additionalHeaders, hasCustomHeaders := parseHeaders(csvLines[0])
-		if hasCustomHeaders {
-			csvLines = csvLines[1:]
+	candle := model.Candle{
+		Time:      t,
+		UpdatedAt: t,
+		Pair:      r.feed.Pair,
+		Complete:  true,
+	}
+
+	fields := map[string]*float64{
+		"open": &candle.Open, "close": &candle.Close, "low": &candle.Low, "high": &candle.High,
+		"volume": &candle.Volume,
+	}
+	for _, name := range candleFields[1:] {
+		if value, err = column(name); err != nil {
+			return model.Candle{}, err
 		}
 
-		for _, line := range csvLines {
-			timestamp, err := strconv.Atoi(line[headerMap["time"]])
-			if err != nil {
-				return nil, err
-			}
+		if *fields[name], err = strconv.ParseFloat(value, 64); err != nil {
+			return model.Candle{}, err
+		}
+	}
 
-			candle := model.Candle{
-				Time:      time.Unix(int64(timestamp), 0).UTC(),
-				UpdatedAt: time.Unix(int64(timestamp), 0).UTC(),
-				Pair:      feed.Pair,
-				Complete:  true,
+	if len(r.metadata) > 0 {
+		candle.Metadata = make(map[string]float64)
+		for _, name := range r.metadata {
+			if value, err = column(name); err != nil {
+				return model.Candle{}, err
 			}
 
-			candle.Open, err = strconv.ParseFloat(line[headerMap["open"]], 64)
-			if err != nil {
-				return nil, err
+			if candle.Metadata[name], err = strconv.ParseFloat(value, 64); err != nil {
+				return model.Candle{}, err
 			}
+		}
+	}
 
-			candle.Close, err = strconv.ParseFloat(line[headerMap["close"]], 64)
-			if err != nil {
-				return nil, err
-			}
+	return candle, nil
+}
 
-			candle.Low, err = strconv.ParseFloat(line[headerMap["low"]], 64)
-			if err != nil {
-				return nil, err
-			}
+// Last returns the candle of the last row, reading only the end of the file
+func (r *csvCandleReader) Last() (model.Candle, error) {
+	info, err := r.file.Stat()
+	if err != nil {
+		return model.Candle{}, err
+	}
 
-			candle.High, err = strconv.ParseFloat(line[headerMap["high"]], 64)
-			if err != nil {
-				return nil, err
-			}
+	size := info.Size()
+	for chunk := int64(4096); ; chunk *= 2 {
+		offset := max(size-chunk, 0)
+		content := make([]byte, size-offset)
+		if _, err := r.file.ReadAt(content, offset); err != nil && err != io.EOF {
+			return model.Candle{}, err
+		}
 
-			candle.Volume, err = strconv.ParseFloat(line[headerMap["volume"]], 64)
-			if err != nil {
-				return nil, err
-			}
+		lines := strings.Split(strings.TrimRight(string(content), "\r\n"), "\n")
+		if len(lines) < 2 && offset > 0 {
+			continue
+		}
 
-			if hasCustomHeaders {
-				candle.Metadata = make(map[string]float64)
-				for _, header := range additionalHeaders {
-					candle.Metadata[header], err = strconv.ParseFloat(line[headerMap[header]], 64)
-					if err != nil {
-						return nil, err
-					}
-				}
-			}
+		reader := csv.NewReader(strings.NewReader(lines[len(lines)-1]))
+		reader.Comma = r.reader.Comma
+		reader.FieldsPerRecord = -1
+		line, err := reader.Read()
+		if err != nil {
+			return model.Candle{}, err
+		}
+		return r.parse(line)
+	}
+}
 
-			if feed.HeikinAshi {
-				candle = candle.ToHeikinAshi(ha)
-			}
+func (r *csvCandleReader) Close() error {
+	return r.file.Close()
+}
 
-			candles = append(candles, candle)
+// ReadCSVCandles reads all candles of a CSV file, including the additional columns as metadata
+func ReadCSVCandles(feed PairFeed) ([]model.Candle, error) {
+	reader, err := newCSVCandleReader(feed)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var candles []model.Candle
+	for {
+		candle, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+
+	return candles, nil
+}
+
+// NewCSVFeed creates a new data feed from CSV files and resample
+func NewCSVFeed(targetTimeframe string, feeds ...PairFeed) (*CSVFeed, error) {
+	return newMemoryFeed(targetTimeframe, feeds, ReadCSVCandles)
+}
+
+// newMemoryFeed loads the candles of each feed in memory and resamples them to the target timeframe
+func newMemoryFeed(targetTimeframe string, feeds []PairFeed,
+	load func(feed PairFeed) ([]model.Candle, error)) (*CSVFeed, error) {
+
+	csvFeed := &CSVFeed{
+		Feeds:               make(map[string]PairFeed),
+		CandlePairTimeFrame: make(map[string][]model.Candle),
+	}
+
+	for _, feed := range feeds {
+		candles, err := load(feed)
+		if err != nil {
+			return nil, err
 		}
 
+		csvFeed.Feeds[feed.Pair] = feed
 		csvFeed.CandlePairTimeFrame[csvFeed.feedTimeframeKey(feed.Pair, feed.Timeframe)] = candles
 
 		err = csvFeed.resample(feed.Pair, feed.Timeframe, targetTimeframe)
@@ -177,95 +309,24 @@ func (c *CSVFeed) Limit(duration time.Duration) *CSVFeed {
 	return c
 }
 
-func isFistCandlePeriod(t time.Time, fromTimeframe, targetTimeframe string) (bool, error) {
-	fromDuration, err := str2duration.ParseDuration(fromTimeframe)
-	if err != nil {
-		return false, err
-	}
-
-	prev := t.Add(-fromDuration).UTC()
-
-	return isLastCandlePeriod(prev, fromTimeframe, targetTimeframe)
-}
-
-func isLastCandlePeriod(t time.Time, fromTimeframe, targetTimeframe string) (bool, error) {
-	if fromTimeframe == targetTimeframe {
-		return true, nil
-	}
-
-	fromDuration, err := str2duration.ParseDuration(fromTimeframe)
-	if err != nil {
-		return false, err
-	}
-
-	next := t.Add(fromDuration).UTC()
-
-	switch targetTimeframe {
-	case "1m":
-		return next.Second()%60 == 0, nil
-	case "5m":
-		return next.Minute()%5 == 0, nil
-	case "10m":
-		return next.Minute()%10 == 0, nil
-	case "15m":
-		return next.Minute()%15 == 0, nil
-	case "30m":
-		return next.Minute()%30 == 0, nil
-	case "1h":
-		return next.Minute()%60 == 0, nil
-	case "2h":
-		return next.Minute() == 0 && next.Hour()%2 == 0, nil
-	case "4h":
-		return next.Minute() == 0 && next.Hour()%4 == 0, nil
-	case "12h":
-		return next.Minute() == 0 && next.Hour()%12 == 0, nil
-	case "1d":
-		return next.Minute() == 0 && next.Hour()%24 == 0, nil
-	case "1w":
-		return next.Minute() == 0 && next.Hour()%24 == 0 && next.Weekday() == time.Sunday, nil
-	}
-
-	return false, fmt.Errorf("invalid timeframe: %s", targetTimeframe)
-}
-
 func (c *CSVFeed) resample(pair, sourceTimeframe, targetTimeframe string) error {
 	sourceKey := c.feedTimeframeKey(pair, sourceTimeframe)
 	targetKey := c.feedTimeframeKey(pair, targetTimeframe)
 
-	var i int
-	for ; i < len(c.CandlePairTimeFrame[sourceKey]); i++ {
-		if ok, err := isFistCandlePeriod(c.CandlePairTimeFrame[sourceKey][i].Time, sourceTimeframe,
-			targetTimeframe); err != nil {
-			return err
-		} else if ok {
-			break
-		}
+	r, err := c.Feeds[pair].resampler(targetTimeframe)
+	if err != nil {
+		return err
 	}
 
 	candles := make([]model.Candle, 0)
-	for ; i < len(c.CandlePairTimeFrame[sourceKey]); i++ {
-		candle := c.CandlePairTimeFrame[sourceKey][i]
-		if last, err := isLastCandlePeriod(candle.Time, sourceTimeframe, targetTimeframe); err != nil {
-			return err
-		} else if last {
-			candle.Complete = true
-		} else {
-			candle.Complete = false
-		}
-
-		lastIndex := len(candles) - 1
-		if lastIndex >= 0 && !candles[lastIndex].Complete {
-			candle.Time = candles[lastIndex].Time
-			candle.Open = candles[lastIndex].Open
-			candle.High = math.Max(candles[lastIndex].High, candle.High)
-			candle.Low = math.Min(candles[lastIndex].Low, candle.Low)
-			candle.Volume += candles[lastIndex].Volume
+	for _, candle := range c.CandlePairTimeFrame[sourceKey] {
+		if candle, ok := r.Next(candle); ok {
+			candles = append(candles, candle)
 		}
-		candles = append(candles, candle)
 	}
 
 	// remove last candle if not complete
-	if !candles[len(candles)-1].Complete {
+	if len(candles) > 0 && !candles[len(candles)-1].Complete {
 		candles = candles[:len(candles)-1]
 	}
 
@@ -298,6 +359,11 @@ func (c *CSVFeed) CandlesByLimit(_ context.Context, pair, timeframe string, limi
 	return result, nil
 }
 
+// CandleCount returns the number of candles sent by CandlesSubscription
+func (c CSVFeed) CandleCount(_ context.Context, pair, timeframe string) (int, error) {
+	return len(c.CandlePairTimeFrame[c.feedTimeframeKey(pair, timeframe)]), nil
+}
+
 func (c CSVFeed) CandlesSubscription(_ context.Context, pair, timeframe string) (chan model.Candle, chan error) {
 	ccandle := make(chan model.Candle)
 	cerr := make(chan error)
@@ -0,0 +1,273 @@
+package exchange
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Epoch units of CSVSchema.TimeFormat
+const (
+	EpochSeconds      = "s"
+	EpochMilliseconds = "ms"
+	EpochMicroseconds = "us"
+	EpochNanoseconds  = "ns"
+)
+
+var candleFields = []string{"time", "open", "close", "low", "high", "volume"}
+
+// CSVSchema describes the layout of a CSV candle file
+type CSVSchema struct {
+	// Columns maps the candle fields time, open, close, low, high and volume to column names.
+	// Fields not mapped are read from the column of the same name.
+	Columns map[string]string
+	// Header has the column names of files without a header row, the first row is data when set
+	Header []string
+	// Metadata has the columns loaded as candle metadata. When empty, all other columns of files
+	// with a header row are loaded.
+	Metadata []string
+	// TimeFormat is a layout of time.Parse, eg. time.RFC3339, or an epoch unit: s, ms, us or ns.
+	// Default is s.
+	TimeFormat string
+	// Location is the time zone of times without offset, default is UTC
+	Location *time.Location
+	// Delimiter of columns, default is comma
+	Delimiter rune
+}
+
+// DefaultCSVSchema is the layout of files without header: time, open, close, low, high and volume,
+// with times in unix seconds. It can be set in PairFeed to skip the schema detection.
+var DefaultCSVSchema = CSVSchema{
+	Header:     candleFields,
+	TimeFormat: EpochSeconds,
+}
+
+// binanceKlineHeader is the layout of Binance kline dumps, see https://data.binance.vision
+var binanceKlineHeader = []string{"open_time", "open", "high", "low", "close", "volume", "close_time",
+	"quote_volume", "count", "taker_buy_volume", "taker_buy_quote_volume", "ignore"}
+
+var columnAliases = map[string][]string{
+	"time":   {"time", "date", "datetime", "date time", "timestamp", "open time", "open_time", "gmt time", "unix"},
+	"open":   {"open", "o"},
+	"close":  {"close", "c", "last"},
+	"low":    {"low", "l"},
+	"high":   {"high", "h"},
+	"volume": {"volume", "vol", "v"},
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006.01.02 15:04:05",
+	"02.01.2006 15:04:05.000",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+	"01/02/2006",
+	"20060102 150405",
+	"20060102",
+}
+
+// DetectCSVSchema guesses the schema of a CSV candle file from its first rows. It detects the
+// delimiter, common column names, eg. Date,Open,High,Low,Close,Adj Close,Volume, the time format
+// and Binance kline dumps without header.
+func DetectCSVSchema(path string) (CSVSchema, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return CSVSchema{}, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return CSVSchema{}, fmt.Errorf("%s: empty file", path)
+	}
+
+	schema := CSVSchema{Delimiter: ','}
+	for _, delimiter := range []rune{';', '\t', '|'} {
+		if strings.Count(line, string(delimiter)) > strings.Count(line, string(schema.Delimiter)) {
+			schema.Delimiter = delimiter
+		}
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return CSVSchema{}, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.Comma = schema.Delimiter
+	reader.FieldsPerRecord = -1
+	first, err := reader.Read()
+	if err != nil {
+		return CSVSchema{}, err
+	}
+
+	data := first
+	if _, err := strconv.ParseFloat(strings.TrimSpace(first[0]), 64); err == nil {
+		schema.Header = candleFields
+		if len(first) >= len(binanceKlineHeader) {
+			schema.Header = binanceKlineHeader
+			schema.Columns = map[string]string{"time": "open_time"}
+		}
+	} else {
+		schema.Columns = make(map[string]string)
+		for _, field := range candleFields {
+			name, ok := findColumn(first, field)
+			if !ok {
+				return CSVSchema{}, fmt.Errorf("%s: missing column %s", path, field)
+			}
+			schema.Columns[field] = name
+		}
+
+		if data, err = reader.Read(); err != nil {
+			return CSVSchema{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	index, _, err := schema.indexes(first)
+	if err != nil {
+		return CSVSchema{}, err
+	}
+	schema.TimeFormat, err = detectTimeFormat(data[index["time"]])
+	if err != nil {
+		return CSVSchema{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return schema, nil
+}
+
+// findColumn returns the header name of a candle field, names are compared ignoring case and spaces
+func findColumn(header []string, field string) (string, bool) {
+	for _, alias := range columnAliases[field] {
+		for _, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), alias) {
+				return name, true
+			}
+		}
+	}
+
+	// eg. Volume BTC
+	for _, name := range header {
+		if field == "volume" && strings.HasPrefix(strings.ToLower(strings.TrimSpace(name)), "volume") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func detectTimeFormat(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch {
+		case epoch < 1e11:
+			return EpochSeconds, nil
+		case epoch < 1e14:
+			return EpochMilliseconds, nil
+		case epoch < 1e17:
+			return EpochMicroseconds, nil
+		default:
+			return EpochNanoseconds, nil
+		}
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return EpochSeconds, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return layout, nil
+		}
+	}
+	return "", fmt.Errorf("unknown time format: %s", value)
+}
+
+// indexes returns the column index of each candle field and the metadata columns, from the header row
+// or from the schema header
+func (s CSVSchema) indexes(header []string) (map[string]int, []string, error) {
+	if len(s.Header) > 0 {
+		header = s.Header
+	}
+
+	positions := make(map[string]int)
+	for i, name := range header {
+		positions[strings.TrimSpace(name)] = i
+	}
+
+	index := make(map[string]int)
+	for _, field := range candleFields {
+		name := field
+		if column, ok := s.Columns[field]; ok {
+			name = column
+		}
+
+		position, ok := positions[strings.TrimSpace(name)]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing column %s", name)
+		}
+		index[field] = position
+	}
+
+	metadata := s.Metadata
+	if len(metadata) == 0 && len(s.Header) == 0 {
+		mapped := make(map[int]bool)
+		for _, position := range index {
+			mapped[position] = true
+		}
+		for i, name := range header {
+			if !mapped[i] {
+				metadata = append(metadata, strings.TrimSpace(name))
+			}
+		}
+	}
+
+	for _, name := range metadata {
+		position, ok := positions[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing column %s", name)
+		}
+		index[name] = position
+	}
+
+	return index, metadata, nil
+}
+
+// parseTime parses a time column with the schema format
+func (s CSVSchema) parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch s.TimeFormat {
+	case "", EpochSeconds, EpochMilliseconds, EpochMicroseconds, EpochNanoseconds:
+		unit := map[string]int64{
+			"": 1e9, EpochSeconds: 1e9, EpochMilliseconds: 1e6, EpochMicroseconds: 1e3, EpochNanoseconds: 1,
+		}[s.TimeFormat]
+
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(0, epoch*unit).UTC(), nil
+		}
+
+		epoch, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(math.Round(epoch*float64(unit)))).UTC(), nil
+	}
+
+	location := s.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	t, err := time.ParseInLocation(s.TimeFormat, value, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,107 @@
+package exchange
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCSV(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "candles.csv")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestDetectCSVSchema(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tt := []struct {
+		name     string
+		content  string
+		schema   CSVSchema
+		metadata map[string]float64
+	}{
+		{
+			name: "yahoo",
+			content: "Date,Open,High,Low,Close,Adj Close,Volume\n" +
+				"2022-01-01,10,12,9,11,11.5,100\n2022-01-02,11,13,10,12,12.5,100\n",
+			schema: CSVSchema{
+				Columns: map[string]string{"time": "Date", "open": "Open", "close": "Close", "low": "Low",
+					"high": "High", "volume": "Volume"},
+				TimeFormat: "2006-01-02",
+				Delimiter:  ',',
+			},
+			metadata: map[string]float64{"Adj Close": 11.5},
+		},
+		{
+			name:    "semicolon and milliseconds",
+			content: "timestamp;open;high;low;close;volume\n1640995200000;10;12;9;11;100\n",
+			schema: CSVSchema{
+				Columns: map[string]string{"time": "timestamp", "open": "open", "close": "close", "low": "low",
+					"high": "high", "volume": "volume"},
+				TimeFormat: EpochMilliseconds,
+				Delimiter:  ';',
+			},
+		},
+		{
+			name:    "binance dump",
+			content: "1640995200000,10,12,9,11,100,1640998799999,1000,10,50,500,0\n",
+			schema: CSVSchema{
+				Columns:    map[string]string{"time": "open_time"},
+				Header:     binanceKlineHeader,
+				TimeFormat: EpochMilliseconds,
+				Delimiter:  ',',
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			file := writeCSV(t, tc.content)
+			schema, err := DetectCSVSchema(file)
+			require.NoError(t, err)
+			require.Equal(t, tc.schema, schema)
+
+			candles, err := ReadCSVCandles(PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1d"})
+			require.NoError(t, err)
+			require.Equal(t, day, candles[0].Time)
+			require.Equal(t, 10.0, candles[0].Open)
+			require.Equal(t, 11.0, candles[0].Close)
+			require.Equal(t, 9.0, candles[0].Low)
+			require.Equal(t, 12.0, candles[0].High)
+			require.Equal(t, 100.0, candles[0].Volume)
+			require.Equal(t, tc.metadata, candles[0].Metadata)
+		})
+	}
+
+	t.Run("missing column", func(t *testing.T) {
+		_, err := DetectCSVSchema(writeCSV(t, "date,open,high,low,close\n2022-01-01,1,1,1,1\n"))
+		require.Error(t, err)
+	})
+}
+
+func TestCSVSchema(t *testing.T) {
+	file := writeCSV(t, "day|o|h|l|c|v|funding\n01/01/2022 08:00|10|12|9|11|100|0.01\n")
+	schema := &CSVSchema{
+		Columns: map[string]string{"time": "day", "open": "o", "close": "c", "low": "l", "high": "h",
+			"volume": "v"},
+		Metadata:   []string{"funding"},
+		TimeFormat: "02/01/2006 15:04",
+		Location:   time.FixedZone("UTC-3", -3*60*60),
+		Delimiter:  '|',
+	}
+
+	feed, err := NewCSVFeed("1d", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1d", Schema: schema})
+	require.NoError(t, err)
+
+	candles := feed.CandlePairTimeFrame["BTCUSDT--1d"]
+	require.Len(t, candles, 1)
+	require.Equal(t, time.Date(2022, 1, 1, 11, 0, 0, 0, time.UTC), candles[0].Time)
+	require.Equal(t, 0.01, candles[0].Metadata["funding"])
+
+	schema.TimeFormat = EpochSeconds
+	_, err = ReadCSVCandles(PairFeed{Pair: "BTCUSDT", File: file, Schema: schema})
+	require.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// CSVStream is a data feed that reads CSV files lazily and resamples candles on the fly.
+// It returns the same candles of CSVFeed, but keeps only a few candles in memory,
+// so it supports large datasets, eg. years of 1m candles for many pairs.
+type CSVStream struct {
+	mtx        sync.Mutex
+	Feeds      map[string]PairFeed
+	limit      time.Duration
+	limitStart map[string]time.Time
+	offset     map[string]int
+}
+
+// NewCSVStream creates a streaming data feed from CSV files. The target timeframe is validated
+// against the timeframe of each file, candles are resampled when requested.
+func NewCSVStream(targetTimeframe string, feeds ...PairFeed) (*CSVStream, error) {
+	stream := &CSVStream{
+		Feeds:      make(map[string]PairFeed),
+		limitStart: make(map[string]time.Time),
+		offset:     make(map[string]int),
+	}
+
+	for _, feed := range feeds {
+		if _, err := feed.resampler(targetTimeframe); err != nil {
+			return nil, err
+		}
+
+		reader, err := newCSVCandleReader(feed)
+		if err != nil {
+			return nil, err
+		}
+		reader.Close()
+
+		stream.Feeds[feed.Pair] = feed
+	}
+
+	return stream, nil
+}
+
+func (c *CSVStream) feedTimeframeKey(pair, timeframe string) string {
+	return fmt.Sprintf("%s--%s", pair, timeframe)
+}
+
+func (c *CSVStream) AssetsInfo(pair string) model.AssetInfo {
+	return DefaultAssetsInfo(pair)
+}
+
+func (c *CSVStream) LastQuote(_ context.Context, _ string) (float64, error) {
+	return 0, errors.New("invalid operation")
+}
+
+// Limit keeps only the candles of the last period of each feed, like CSVFeed.Limit
+func (c *CSVStream) Limit(duration time.Duration) *CSVStream {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.limit = duration
+	c.limitStart = make(map[string]time.Time)
+	return c
+}
+
+// iterate reads the candles of a pair in a timeframe and calls fn for each one, until it returns false.
+// The candles consumed by CandlesByLimit and the candles removed by Limit are skipped.
+func (c *CSVStream) iterate(ctx context.Context, pair, timeframe string, fn func(model.Candle) bool) error {
+	key := c.feedTimeframeKey(pair, timeframe)
+
+	c.mtx.Lock()
+	offset := c.offset[key]
+	start, hasStart := c.limitStart[key]
+	limit := c.limit
+	c.mtx.Unlock()
+
+	if limit > 0 && !hasStart {
+		var last time.Time
+		err := c.read(ctx, pair, timeframe, func(candle model.Candle) bool {
+			last = candle.Time
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		start, hasStart = last.Add(-limit), true
+		c.mtx.Lock()
+		c.limitStart[key] = start
+		c.mtx.Unlock()
+	}
+
+	return c.read(ctx, pair, timeframe, func(candle model.Candle) bool {
+		if hasStart && !candle.Time.After(start) {
+			return true
+		}
+
+		if offset > 0 {
+			offset--
+			return true
+		}
+
+		return fn(candle)
+	})
+}
+
+// read resamples the candles of the pair file to a timeframe. A candle is sent only after the next one
+// is read, so the last incomplete candle of the file is discarded as in CSVFeed.
+func (c *CSVStream) read(ctx context.Context, pair, timeframe string, fn func(model.Candle) bool) error {
+	feed, ok := c.Feeds[pair]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+	}
+
+	reader, err := newCSVCandleReader(feed)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	r, err := feed.resampler(timeframe)
+	if err != nil {
+		return err
+	}
+
+	var pending *model.Candle
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		candle, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		candle, ok := r.Next(candle)
+		if !ok {
+			continue
+		}
+
+		if pending != nil && !fn(*pending) {
+			return nil
+		}
+		pending = &candle
+	}
+
+	if pending != nil && pending.Complete {
+		fn(*pending)
+	}
+
+	return nil
+}
+
+func (c *CSVStream) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	candles := make([]model.Candle, 0)
+	err := c.iterate(ctx, pair, timeframe, func(candle model.Candle) bool {
+		if candle.Time.After(end) {
+			return false
+		}
+
+		if !candle.Time.Before(start) {
+			candles = append(candles, candle)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return candles, nil
+}
+
+// CandlesByLimit returns the first candles of the feed, they are not sent again by the stream
+func (c *CSVStream) CandlesByLimit(ctx context.Context, pair, timeframe string, limit int) ([]model.Candle, error) {
+	candles := make([]model.Candle, 0, limit)
+	if limit <= 0 {
+		return candles, nil
+	}
+
+	err := c.iterate(ctx, pair, timeframe, func(candle model.Candle) bool {
+		candles = append(candles, candle)
+		return len(candles) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candles) < limit {
+		return nil, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+
+	c.mtx.Lock()
+	c.offset[c.feedTimeframeKey(pair, timeframe)] += limit
+	c.mtx.Unlock()
+
+	return candles, nil
+}
+
+// CandleCount returns an upper bound of the number of candles sent by CandlesSubscription, eg. for a
+// progress bar. Each row sends at most one candle, so it counts the periods between the first and the
+// last rows of the file, without reading the whole file.
+func (c *CSVStream) CandleCount(_ context.Context, pair, timeframe string) (int, error) {
+	feed, ok := c.Feeds[pair]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+	}
+
+	source, err := parseTimeframe(feed.Timeframe)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := feed.resampler(timeframe)
+	if err != nil {
+		return 0, err
+	}
+
+	reader, err := newCSVCandleReader(feed)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	first, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	last, err := reader.Last()
+	if err != nil {
+		return 0, err
+	}
+
+	key := c.feedTimeframeKey(pair, timeframe)
+	c.mtx.Lock()
+	offset := c.offset[key]
+	start, hasStart := c.limitStart[key]
+	limit := c.limit
+	c.mtx.Unlock()
+
+	if limit > 0 && !hasStart {
+		// the last complete candle starts at most one period before the period of the last row
+		start, hasStart = r.target.add(r.PeriodStart(last.Time), -1).Add(-limit), true
+	}
+
+	from := first.Time
+	if hasStart && start.After(from) {
+		from = start
+	}
+
+	return max(source.periods(from, last.Time)-offset, 0), nil
+}
+
+func (c *CSVStream) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error) {
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error, 1)
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+
+		err := c.iterate(ctx, pair, timeframe, func(candle model.Candle) bool {
+			select {
+			case ccandle <- candle:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			cerr <- err
+		}
+	}()
+	return ccandle, cerr
+}
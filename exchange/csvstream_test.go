@@ -0,0 +1,177 @@
+package exchange
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func collectCandles(ccandle chan model.Candle, cerr chan error) ([]model.Candle, error) {
+	candles := make([]model.Candle, 0)
+	for candle := range ccandle {
+		candles = append(candles, candle)
+	}
+	for err := range cerr {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return candles, nil
+}
+
+func requireCandleCount(t *testing.T, expected int, counter CandleCounter, pair, timeframe string) {
+	t.Helper()
+	count, err := counter.CandleCount(context.Background(), pair, timeframe)
+	require.NoError(t, err)
+	require.Equal(t, expected, count)
+}
+
+func requireCandleEstimate(t *testing.T, expected int, stream *CSVStream, pair, timeframe string) {
+	t.Helper()
+	count, err := stream.CandleCount(context.Background(), pair, timeframe)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, count, expected)
+	require.InEpsilon(t, expected, count, 0.2)
+}
+
+func TestCSVStream(t *testing.T) {
+	ctx := context.Background()
+	tt := []struct {
+		name   string
+		target string
+		feed   PairFeed
+	}{
+		{"no header", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d.csv", Timeframe: "1d"}},
+		{"custom header", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d-header.csv", Timeframe: "1d"}},
+		{"resample", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"}},
+		{"resample 4h", "4h", PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h"}},
+		{"heikin ashi", "1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h",
+			HeikinAshi: true}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			feed, err := NewCSVFeed(tc.target, tc.feed)
+			require.NoError(t, err)
+			stream, err := NewCSVStream(tc.target, tc.feed)
+			require.NoError(t, err)
+
+			expected, err := collectCandles(feed.CandlesSubscription(ctx, tc.feed.Pair, tc.target))
+			require.NoError(t, err)
+			candles, err := collectCandles(stream.CandlesSubscription(ctx, tc.feed.Pair, tc.target))
+			require.NoError(t, err)
+			require.NotEmpty(t, candles)
+			require.Equal(t, expected, candles)
+			requireCandleCount(t, len(candles), feed, tc.feed.Pair, tc.target)
+			requireCandleEstimate(t, len(candles), stream, tc.feed.Pair, tc.target)
+
+			// warmup candles are not sent again
+			expected, err = feed.CandlesByLimit(ctx, tc.feed.Pair, tc.target, 5)
+			require.NoError(t, err)
+			candles, err = stream.CandlesByLimit(ctx, tc.feed.Pair, tc.target, 5)
+			require.NoError(t, err)
+			require.Equal(t, expected, candles)
+
+			expected, err = collectCandles(feed.CandlesSubscription(ctx, tc.feed.Pair, tc.target))
+			require.NoError(t, err)
+			candles, err = collectCandles(stream.CandlesSubscription(ctx, tc.feed.Pair, tc.target))
+			require.NoError(t, err)
+			require.Equal(t, expected, candles)
+			requireCandleCount(t, len(candles), feed, tc.feed.Pair, tc.target)
+			requireCandleEstimate(t, len(candles), stream, tc.feed.Pair, tc.target)
+		})
+	}
+
+	t.Run("limit and period", func(t *testing.T) {
+		pairFeed := PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"}
+		feed, err := NewCSVFeed("1d", pairFeed)
+		require.NoError(t, err)
+		stream, err := NewCSVStream("1d", pairFeed)
+		require.NoError(t, err)
+
+		feed.Limit(10 * 24 * time.Hour)
+		stream.Limit(10 * 24 * time.Hour)
+		expected, err := collectCandles(feed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		candles, err := collectCandles(stream.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		require.Equal(t, expected, candles)
+		requireCandleEstimate(t, len(candles), stream, "BTCUSDT", "1d")
+
+		start, end := candles[10].Time, candles[100].Time
+		expected, err = feed.CandlesByPeriod(ctx, "BTCUSDT", "1d", start, end)
+		require.NoError(t, err)
+		candles, err = stream.CandlesByPeriod(ctx, "BTCUSDT", "1d", start, end)
+		require.NoError(t, err)
+		require.Equal(t, expected, candles)
+	})
+
+	t.Run("data feed count", func(t *testing.T) {
+		stream, err := NewCSVStream("1d",
+			PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"},
+			PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h"},
+		)
+		require.NoError(t, err)
+
+		dataFeed := NewDataFeed(NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 100), WithDataFeed(stream)))
+		received := 0
+		dataFeed.Subscribe("BTCUSDT", "1d", func(model.Candle) { received++ }, false)
+		dataFeed.Subscribe("ETHUSDT", "1d", func(model.Candle) { received++ }, false)
+
+		count, ok := dataFeed.CandleCount(ctx)
+		require.True(t, ok)
+		dataFeed.StartMerged()
+		require.NotZero(t, received)
+		require.GreaterOrEqual(t, count, received)
+		require.InEpsilon(t, received, count, 0.2)
+
+		// paper wallet without a counter feeder
+		dataFeed = NewDataFeed(NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 100)))
+		dataFeed.Subscribe("BTCUSDT", "1d", func(model.Candle) {}, false)
+		_, ok = dataFeed.CandleCount(ctx)
+		require.False(t, ok)
+	})
+
+	t.Run("invalid timeframe", func(t *testing.T) {
+		_, err := NewCSVStream("1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "invalid"})
+		require.Error(t, err)
+	})
+
+	t.Run("insufficient data", func(t *testing.T) {
+		stream, err := NewCSVStream("1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d.csv", Timeframe: "1d"})
+		require.NoError(t, err)
+		_, err = stream.CandlesByLimit(ctx, "BTCUSDT", "1d", 100)
+		require.ErrorIs(t, err, ErrInsufficientData)
+	})
+}
+
+func TestMergeCandles(t *testing.T) {
+	ctx := context.Background()
+	feed, err := NewCSVFeed("1d",
+		PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"},
+		PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h"},
+	)
+	require.NoError(t, err)
+
+	btc, _ := feed.CandlesSubscription(ctx, "BTCUSDT", "1d")
+	eth, _ := feed.CandlesSubscription(ctx, "ETHUSDT", "1d")
+
+	// candles sorted by time, keeping the file order of candles with the same time
+	expected := make([]model.Candle, 0)
+	expected = append(expected, feed.CandlePairTimeFrame["BTCUSDT--1d"]...)
+	expected = append(expected, feed.CandlePairTimeFrame["ETHUSDT--1d"]...)
+	sort.SliceStable(expected, func(i, j int) bool {
+		return expected[i].Less(expected[j])
+	})
+
+	candles := make([]model.Candle, 0)
+	for candle := range MergeCandles(btc, eth) {
+		candles = append(candles, candle)
+	}
+	require.Equal(t, expected, candles)
+}
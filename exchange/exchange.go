@@ -18,6 +18,10 @@ var (
 	ErrInvalidQuantity   = errors.New("invalid quantity")
 	ErrInsufficientFunds = errors.New("insufficient funds or locked")
 	ErrInvalidAsset      = errors.New("invalid asset")
+	ErrPostOnlyRejected  = errors.New("post-only order would immediately match")
+	ErrReduceOnly        = errors.New("reduce-only order would increase position")
+	ErrNotSupported      = errors.New("operation not supported")
+	ErrOrderNotOpen      = errors.New("order is not open")
 )
 
 type DataFeed struct {
@@ -47,8 +51,18 @@ func (o *OrderError) Error() string {
 	return fmt.Sprintf("order error: %v", o.Err)
 }
 
+func (o *OrderError) Unwrap() error {
+	return o.Err
+}
+
 type DataFeedConsumer func(model.Candle)
 
+// CandleCounter is a historical feed that knows how many candles CandlesSubscription sends,
+// eg. to show the progress of a backtest
+type CandleCounter interface {
+	CandleCount(ctx context.Context, pair, timeframe string) (int, error)
+}
+
 func NewDataFeed(exchange service.Exchange) *DataFeedSubscription {
 	return &DataFeedSubscription{
 		exchange:                exchange,
@@ -90,6 +104,33 @@ func (d *DataFeedSubscription) Preload(pair, timeframe string, candles []model.C
 	}
 }
 
+// CandleCount returns the number of candles of all subscribed feeds. It returns false when the
+// exchange can not count the candles of a feed.
+func (d *DataFeedSubscription) CandleCount(ctx context.Context) (int, bool) {
+	counter, ok := d.exchange.(CandleCounter)
+	if !ok {
+		return 0, false
+	}
+
+	total := 0
+	for feed := range d.Feeds.Iter() {
+		if !ok {
+			continue // drain the iterator
+		}
+
+		pair, timeframe := d.pairTimeframeFromKey(feed)
+		count, err := counter.CandleCount(ctx, pair, timeframe)
+		if err != nil {
+			log.Warnf("dataFeedSubscription/count: %v", err)
+			ok = false
+			continue
+		}
+		total += count
+	}
+
+	return total, ok
+}
+
 func (d *DataFeedSubscription) Connect() {
 	log.Infof("Connecting to the exchange.")
 	for feed := range d.Feeds.Iter() {
@@ -135,3 +176,34 @@ func (d *DataFeedSubscription) Start(loadSync bool) {
 		wg.Wait()
 	}
 }
+
+// StartMerged delivers the candles of all feeds in time order, merging the sorted feeds with a k-way merge.
+// It blocks until all feeds are consumed and it is used in backtesting, where candles are read from files.
+func (d *DataFeedSubscription) StartMerged() {
+	d.Connect()
+
+	keys := make([]string, 0, len(d.DataFeeds))
+	channels := make([]chan model.Candle, 0, len(d.DataFeeds))
+	for key := range d.Feeds.Iter() {
+		keys = append(keys, key)
+		channels = append(channels, d.DataFeeds[key].Data)
+	}
+
+	log.Infof("Data feed connected.")
+	for item := range mergeSources(channels) {
+		for _, subscription := range d.SubscriptionsByDataFeed[keys[item.source]] {
+			if subscription.onCandleClose && !item.candle.Complete {
+				continue
+			}
+			subscription.consumer(item.candle)
+		}
+	}
+
+	for _, key := range keys {
+		for err := range d.DataFeeds[key].Err {
+			if err != nil {
+				log.Error("dataFeedSubscription/start: ", err)
+			}
+		}
+	}
+}
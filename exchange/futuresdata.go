@@ -0,0 +1,346 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/samber/lo"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// Metadata keys of futures market data
+const (
+	MetadataFundingRate    = "funding_rate"
+	MetadataOpenInterest   = "open_interest"
+	MetadataMarkPrice      = "mark_price"
+	MetadataIndexPrice     = "index_price"
+	MetadataLongShortRatio = "long_short_ratio"
+)
+
+var futuresMetrics = []string{MetadataFundingRate, MetadataOpenInterest, MetadataMarkPrice, MetadataIndexPrice,
+	MetadataLongShortRatio}
+
+// futuresStatsRetention is the history kept by Binance of open interest and long/short ratio
+const futuresStatsRetention = 30 * 24 * time.Hour
+
+// futuresStatsPeriods are the periods of open interest and long/short ratio history
+var futuresStatsPeriods = []string{"5m", "15m", "30m", "1h", "2h", "4h", "6h", "12h", "1d"}
+
+// metricPoint is a value of a metric and the time it is known
+type metricPoint struct {
+	time  time.Time
+	value float64
+}
+
+// FuturesData provides Binance futures market data as candle metadata: funding rate, open interest,
+// mark and index price and long/short account ratio. It uses public endpoints, so it also enriches
+// candles of the spot market. Binance keeps only 30 days of open interest and long/short ratio history.
+type FuturesData struct {
+	client  *futures.Client
+	metrics []string
+	now     func() time.Time
+
+	mtx  sync.Mutex
+	last map[string]float64
+}
+
+type FuturesDataOption func(*FuturesData)
+
+// WithFuturesMetrics sets the metrics of candles, default is all of them
+func WithFuturesMetrics(metrics ...string) FuturesDataOption {
+	return func(f *FuturesData) {
+		f.metrics = metrics
+	}
+}
+
+// WithFuturesDataEndpoint sets the URL of the futures API, eg. a testnet or a mock server
+func WithFuturesDataEndpoint(apiURL string) FuturesDataOption {
+	return func(f *FuturesData) {
+		f.client.BaseURL = apiURL
+	}
+}
+
+// NewFuturesData creates a source of futures market data
+func NewFuturesData(options ...FuturesDataOption) (*FuturesData, error) {
+	data := &FuturesData{
+		client:  futures.NewClient("", ""),
+		metrics: futuresMetrics,
+		now:     time.Now,
+		last:    make(map[string]float64),
+	}
+	for _, option := range options {
+		option(data)
+	}
+
+	for _, metric := range data.metrics {
+		if !lo.Contains(futuresMetrics, metric) {
+			return nil, fmt.Errorf("invalid futures metric: %s", metric)
+		}
+	}
+	return data, nil
+}
+
+// Fetchers returns a metadata fetcher of each metric, to stream the current values into live candles
+// with WithMetadataFetcher or WithBinanceFutureMetadataFetcher. The previous value is kept when a
+// request fails.
+func (f *FuturesData) Fetchers() []MetadataFetchers {
+	fetchers := make([]MetadataFetchers, 0, len(f.metrics))
+	for _, metric := range f.metrics {
+		fetchers = append(fetchers, f.fetcher(metric))
+	}
+	return fetchers
+}
+
+func (f *FuturesData) fetcher(metric string) MetadataFetchers {
+	return func(pair string, _ time.Time) (string, float64) {
+		key := pair + "--" + metric
+		value, err := f.Last(context.Background(), pair, metric)
+
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		if err != nil {
+			log.Warnf("futures data of %s: %v", key, err)
+			if last, ok := f.last[key]; ok {
+				return metric, last
+			}
+			return metric, math.NaN()
+		}
+		f.last[key] = value
+		return metric, value
+	}
+}
+
+// Last returns the current value of a metric, the funding rate is the last settled one
+func (f *FuturesData) Last(ctx context.Context, pair, metric string) (float64, error) {
+	switch metric {
+	case MetadataFundingRate:
+		rates, err := f.client.NewFundingRateService().Symbol(pair).Limit(1).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(rates) == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+		}
+		return strconv.ParseFloat(rates[len(rates)-1].FundingRate, 64)
+	case MetadataMarkPrice, MetadataIndexPrice:
+		index, err := f.client.NewPremiumIndexService().Symbol(pair).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(index) == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+		}
+		if metric == MetadataMarkPrice {
+			return strconv.ParseFloat(index[0].MarkPrice, 64)
+		}
+		return strconv.ParseFloat(index[0].IndexPrice, 64)
+	case MetadataOpenInterest:
+		interest, err := f.client.NewGetOpenInterestService().Symbol(pair).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseFloat(interest.OpenInterest, 64)
+	case MetadataLongShortRatio:
+		ratios, err := f.client.NewLongShortRatioService().Symbol(pair).Period(futuresStatsPeriods[0]).
+			Limit(1).Do(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(ratios) == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+		}
+		return strconv.ParseFloat(ratios[len(ratios)-1].LongShortRatio, 64)
+	}
+	return 0, fmt.Errorf("invalid futures metric: %s", metric)
+}
+
+// AddMetadata sets the metrics in the metadata of candles of a timeframe, eg. to download them as
+// CSV columns. Each candle has the last value known at its close, or NaN when there is none, as
+// the open interest and long/short ratio of candles older than the history kept by Binance.
+func (f *FuturesData) AddMetadata(ctx context.Context, pair, timeframe string, candles []model.Candle) error {
+	return f.AddMetadataWait(ctx, pair, timeframe, candles, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+}
+
+// AddMetadataWait is AddMetadata calling wait before each request, eg. to share the rate limit of a download
+func (f *FuturesData) AddMetadataWait(ctx context.Context, pair, timeframe string, candles []model.Candle,
+	wait func(context.Context) error) error {
+
+	if len(candles) == 0 {
+		return nil
+	}
+
+	tf, err := parseTimeframe(timeframe)
+	if err != nil {
+		return err
+	}
+
+	start, end := candles[0].Time, tf.add(candles[len(candles)-1].Time, 1)
+	for _, metric := range f.metrics {
+		points, err := f.history(ctx, wait, pair, metric, timeframe, start, end)
+		if err != nil {
+			return fmt.Errorf("%s: %w", metric, err)
+		}
+
+		i := -1
+		for j := range candles {
+			closeTime := tf.add(candles[j].Time, 1)
+			for i+1 < len(points) && !points[i+1].time.After(closeTime) {
+				i++
+			}
+
+			if candles[j].Metadata == nil {
+				candles[j].Metadata = make(map[string]float64)
+			}
+			candles[j].Metadata[metric] = math.NaN()
+			if i >= 0 {
+				candles[j].Metadata[metric] = points[i].value
+			}
+		}
+	}
+	return nil
+}
+
+// statsPeriod returns the longest period of open interest history that divides the timeframe
+func statsPeriod(timeframe string) string {
+	for i := len(futuresStatsPeriods) - 1; i >= 0; i-- {
+		if _, err := NewResampler(futuresStatsPeriods[i], timeframe); err == nil {
+			return futuresStatsPeriods[i]
+		}
+	}
+	return futuresStatsPeriods[0]
+}
+
+// history returns the values of a metric from before start until end, sorted by time. The open
+// interest and long/short ratio start at the retention window of Binance.
+func (f *FuturesData) history(ctx context.Context, wait func(context.Context) error, pair, metric, timeframe string,
+	start, end time.Time) ([]metricPoint, error) {
+
+	interval := timeframe
+	if source, ok := binanceFutureResampler.source(timeframe); ok {
+		interval = source
+	}
+	period := statsPeriod(timeframe)
+
+	var (
+		limit int
+		fetch func(from int64) ([]metricPoint, error)
+	)
+
+	switch metric {
+	case MetadataFundingRate:
+		limit = 1000
+		start = start.Add(-24 * time.Hour)
+		fetch = func(from int64) ([]metricPoint, error) {
+			rates, err := f.client.NewFundingRateService().Symbol(pair).StartTime(from).
+				EndTime(end.UnixMilli()).Limit(limit).Do(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return lo.Map(rates, func(rate *futures.FundingRate, _ int) metricPoint {
+				return newMetricPoint(rate.FundingTime, rate.FundingRate)
+			}), nil
+		}
+	case MetadataMarkPrice, MetadataIndexPrice:
+		limit = 1500
+		tf, err := parseTimeframe(interval)
+		if err != nil {
+			return nil, err
+		}
+		start = tf.add(start, -1)
+		fetch = func(from int64) ([]metricPoint, error) {
+			var klines []*futures.Kline
+			var err error
+			if metric == MetadataMarkPrice {
+				klines, err = f.client.NewMarkPriceKlinesService().Symbol(pair).Interval(interval).
+					StartTime(from).EndTime(end.UnixMilli()).Limit(limit).Do(ctx)
+			} else {
+				klines, err = f.client.NewIndexPriceKlinesService().Pair(pair).Interval(interval).
+					StartTime(from).EndTime(end.UnixMilli()).Limit(limit).Do(ctx)
+			}
+			if err != nil {
+				return nil, err
+			}
+			// the close price is known at the close time
+			return lo.Map(klines, func(kline *futures.Kline, _ int) metricPoint {
+				return newMetricPoint(kline.CloseTime+1, kline.Close)
+			}), nil
+		}
+	case MetadataOpenInterest:
+		limit = 500
+		start = maxTime(start.Add(-24*time.Hour), f.now().Add(-futuresStatsRetention))
+		fetch = func(from int64) ([]metricPoint, error) {
+			stats, err := f.client.NewOpenInterestStatisticsService().Symbol(pair).Period(period).
+				StartTime(from).EndTime(end.UnixMilli()).Limit(limit).Do(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return lo.Map(stats, func(stat *futures.OpenInterestStatistic, _ int) metricPoint {
+				return newMetricPoint(stat.Timestamp, stat.SumOpenInterest)
+			}), nil
+		}
+	case MetadataLongShortRatio:
+		limit = 500
+		start = maxTime(start.Add(-24*time.Hour), f.now().Add(-futuresStatsRetention))
+		fetch = func(from int64) ([]metricPoint, error) {
+			ratios, err := f.client.NewLongShortRatioService().Symbol(pair).Period(period).
+				StartTime(from).EndTime(end.UnixMilli()).Limit(limit).Do(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return lo.Map(ratios, func(ratio *futures.LongShortRatio, _ int) metricPoint {
+				return newMetricPoint(ratio.Timestamp, ratio.LongShortRatio)
+			}), nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid futures metric: %s", metric)
+	}
+
+	points := make([]metricPoint, 0)
+	for from := start.UnixMilli(); from < end.UnixMilli(); {
+		if err := wait(ctx); err != nil {
+			return nil, err
+		}
+
+		batch, err := fetch(from)
+		if err != nil {
+			return nil, err
+		}
+
+		count := len(points)
+		for _, point := range batch {
+			if len(points) == 0 || point.time.After(points[len(points)-1].time) {
+				points = append(points, point)
+			}
+		}
+
+		if len(batch) < limit || len(points) == count {
+			break
+		}
+		from = points[len(points)-1].time.UnixMilli()
+	}
+	return points, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func newMetricPoint(timestamp int64, value string) metricPoint {
+	point := metricPoint{time: time.UnixMilli(timestamp), value: math.NaN()}
+	if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+		point.value = parsed
+	}
+	return point
+}
@@ -0,0 +1,194 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// futuresDataServer serves hourly mark and index price klines, funding rates every 8 hours and hourly
+// open interest and long/short ratio, where values are the unix hour of their time or kline open
+func futuresDataServer(t *testing.T, requests map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("startTime"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("endTime"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		hours := func(step int64) []int64 {
+			values := make([]int64, 0)
+			first := (start + time.Hour.Milliseconds() - 1) / time.Hour.Milliseconds()
+			for hour := first; hour*time.Hour.Milliseconds() <= end && len(values) < limit; hour++ {
+				if hour%step == 0 {
+					values = append(values, hour)
+				}
+			}
+			return values
+		}
+
+		response := make([]any, 0)
+		if query.Get("symbol") == "ETHUSDT" {
+			require.NoError(t, json.NewEncoder(w).Encode(response))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/fapi/v1/markPriceKlines", "/fapi/v1/indexPriceKlines":
+			require.Equal(t, "1h", query.Get("interval"))
+			for _, hour := range hours(1) {
+				open := hour * time.Hour.Milliseconds()
+				price := strconv.FormatInt(hour, 10)
+				response = append(response, []any{open, price, price, price, price, "0",
+					open + time.Hour.Milliseconds() - 1, "0", 0, "0", "0", "0"})
+			}
+		case "/fapi/v1/fundingRate":
+			for _, hour := range hours(8) {
+				response = append(response, map[string]any{"symbol": "BTCUSDT", "fundingRate": fmt.Sprint(hour),
+					"fundingTime": hour * time.Hour.Milliseconds()})
+			}
+		case "/futures/data/openInterestHist":
+			require.Equal(t, "1h", query.Get("period"))
+			for _, hour := range hours(1) {
+				response = append(response, map[string]any{"symbol": "BTCUSDT", "sumOpenInterest": fmt.Sprint(hour),
+					"timestamp": hour * time.Hour.Milliseconds()})
+			}
+		case "/futures/data/globalLongShortAccountRatio":
+			for _, hour := range hours(1) {
+				response = append(response, map[string]any{"symbol": "BTCUSDT", "longShortRatio": fmt.Sprint(hour),
+					"timestamp": hour * time.Hour.Milliseconds()})
+			}
+		case "/fapi/v1/premiumIndex":
+			_, _ = w.Write([]byte(`{"symbol":"BTCUSDT","markPrice":"20000.5","indexPrice":"20001.5"}`))
+			return
+		case "/fapi/v1/openInterest":
+			_, _ = w.Write([]byte(`{"symbol":"BTCUSDT","openInterest":"1234.5"}`))
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+}
+
+func TestFuturesData_AddMetadata(t *testing.T) {
+	requests := make(map[string]int)
+	server := futuresDataServer(t, requests)
+	defer server.Close()
+
+	data, err := NewFuturesData(WithFuturesDataEndpoint(server.URL))
+	require.NoError(t, err)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	data.now = func() time.Time { return start.Add(2000 * time.Hour) }
+	candles := make([]model.Candle, 0)
+	for i := 0; i < 2000; i++ {
+		candles = append(candles, model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	waits := 0
+	err = data.AddMetadataWait(context.Background(), "BTCUSDT", "1h", candles, func(context.Context) error {
+		waits++
+		return nil
+	})
+	require.NoError(t, err)
+
+	// values known at the candle close: the kline of the candle and the stats of the next hour
+	hour := float64(start.Unix() / 3600)
+	for i, candle := range candles[:10] {
+		require.Equal(t, hour+float64(i), candle.Metadata[MetadataMarkPrice])
+		require.Equal(t, hour+float64(i), candle.Metadata[MetadataIndexPrice])
+	}
+	for i := 1279; i < 2000; i++ {
+		require.Equal(t, hour+float64(i+1), candles[i].Metadata[MetadataOpenInterest])
+		require.Equal(t, hour+float64(i+1), candles[i].Metadata[MetadataLongShortRatio])
+	}
+	require.Equal(t, hour+1999, candles[1999].Metadata[MetadataMarkPrice])
+
+	// stats older than 30 days are not requested
+	require.True(t, math.IsNaN(candles[0].Metadata[MetadataOpenInterest]))
+	require.True(t, math.IsNaN(candles[1278].Metadata[MetadataLongShortRatio]))
+
+	// a wait before each request
+	total := 0
+	for _, count := range requests {
+		total += count
+	}
+	require.Equal(t, total, waits)
+
+	// last settled funding rate, every 8 hours
+	require.Equal(t, hour, candles[0].Metadata[MetadataFundingRate])
+	require.Equal(t, hour, candles[6].Metadata[MetadataFundingRate])
+	require.Equal(t, hour+8, candles[7].Metadata[MetadataFundingRate])
+
+	// more klines than a request limit
+	require.Equal(t, 2, requests["/fapi/v1/markPriceKlines"])
+	require.Equal(t, 1, requests["/fapi/v1/fundingRate"])
+
+	t.Run("wait error", func(t *testing.T) {
+		err := data.AddMetadataWait(context.Background(), "BTCUSDT", "1h", candles[:1], func(context.Context) error {
+			return context.Canceled
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("selected metrics", func(t *testing.T) {
+		data, err := NewFuturesData(WithFuturesDataEndpoint(server.URL), WithFuturesMetrics(MetadataFundingRate))
+		require.NoError(t, err)
+
+		candles := []model.Candle{{Pair: "BTCUSDT", Time: start}}
+		require.NoError(t, data.AddMetadata(context.Background(), "BTCUSDT", "4h", candles))
+		require.Len(t, candles[0].Metadata, 1)
+
+		_, err = NewFuturesData(WithFuturesMetrics("invalid"))
+		require.Error(t, err)
+	})
+
+	t.Run("unknown values", func(t *testing.T) {
+		data, err := NewFuturesData(WithFuturesDataEndpoint(server.URL), WithFuturesMetrics(MetadataFundingRate))
+		require.NoError(t, err)
+
+		candles := []model.Candle{{Pair: "ETHUSDT", Time: start}}
+		require.NoError(t, data.AddMetadata(context.Background(), "ETHUSDT", "1h", candles))
+		require.True(t, math.IsNaN(candles[0].Metadata[MetadataFundingRate]))
+	})
+}
+
+func TestFuturesData_Fetchers(t *testing.T) {
+	server := futuresDataServer(t, make(map[string]int))
+	data, err := NewFuturesData(WithFuturesDataEndpoint(server.URL),
+		WithFuturesMetrics(MetadataMarkPrice, MetadataIndexPrice, MetadataOpenInterest))
+	require.NoError(t, err)
+
+	metadata := make(map[string]float64)
+	for _, fetcher := range data.Fetchers() {
+		key, value := fetcher("BTCUSDT", time.Now())
+		metadata[key] = value
+	}
+	require.Equal(t, map[string]float64{
+		MetadataMarkPrice:    20000.5,
+		MetadataIndexPrice:   20001.5,
+		MetadataOpenInterest: 1234.5,
+	}, metadata)
+
+	// the last value is kept on errors
+	server.Close()
+	key, value := data.Fetchers()[0]("BTCUSDT", time.Now())
+	require.Equal(t, MetadataMarkPrice, key)
+	require.Equal(t, 20000.5, value)
+
+	_, value = data.Fetchers()[0]("ETHUSDT", time.Now())
+	require.True(t, math.IsNaN(value))
+}
@@ -0,0 +1,51 @@
+package exchange
+
+import (
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// mergeItem is the head candle of a source channel in a k-way merge
+type mergeItem struct {
+	candle model.Candle
+	source int
+}
+
+func (m mergeItem) Less(other model.Item) bool {
+	return m.candle.Less(other.(mergeItem).candle)
+}
+
+// MergeCandles merges channels of sorted candles into a single channel in time order, using a k-way merge.
+// Only the next candle of each channel is kept in memory.
+func MergeCandles(channels ...chan model.Candle) chan model.Candle {
+	merged := make(chan model.Candle)
+	go func() {
+		defer close(merged)
+		for item := range mergeSources(channels) {
+			merged <- item.candle
+		}
+	}()
+	return merged
+}
+
+func mergeSources(channels []chan model.Candle) chan mergeItem {
+	merged := make(chan mergeItem)
+	go func() {
+		defer close(merged)
+
+		heads := model.NewPriorityQueue(nil)
+		for i, channel := range channels {
+			if candle, ok := <-channel; ok {
+				heads.Push(mergeItem{candle: candle, source: i})
+			}
+		}
+
+		for heads.Len() > 0 {
+			item := heads.Pop().(mergeItem)
+			merged <- item
+			if candle, ok := <-channels[item.source]; ok {
+				heads.Push(mergeItem{candle: candle, source: item.source})
+			}
+		}
+	}()
+	return merged
+}
@@ -0,0 +1,328 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// orderBookLevels is the number of levels of each side sent by live order book subscriptions
+const orderBookLevels = 50
+
+var ErrOrderBookGap = errors.New("order book update out of sequence")
+
+// LocalOrderBook keeps an order book from a snapshot and incremental updates, following the sync rules
+// of the Binance diff depth streams
+type LocalOrderBook struct {
+	pair         string
+	bids         map[float64]float64
+	asks         map[float64]float64
+	lastUpdateID int64
+	time         time.Time
+	synced       bool
+}
+
+// NewLocalOrderBook creates an empty order book of a pair
+func NewLocalOrderBook(pair string) *LocalOrderBook {
+	return &LocalOrderBook{
+		pair: pair,
+		bids: make(map[float64]float64),
+		asks: make(map[float64]float64),
+	}
+}
+
+// Reset replaces the book with a snapshot, the next update must follow its last update ID
+func (l *LocalOrderBook) Reset(snapshot model.OrderBook) {
+	l.bids = make(map[float64]float64)
+	l.asks = make(map[float64]float64)
+	updateLevels(l.bids, snapshot.Bids)
+	updateLevels(l.asks, snapshot.Asks)
+	l.lastUpdateID = snapshot.LastUpdateID
+	l.time = snapshot.Time
+	l.synced = false
+}
+
+// Apply updates the book, it returns false for updates older than the book and ErrOrderBookGap
+// when updates are missing, then the book must be reset with a new snapshot
+func (l *LocalOrderBook) Apply(update model.DepthUpdate) (bool, error) {
+	if update.FinalUpdateID <= l.lastUpdateID {
+		return false, nil
+	}
+
+	switch {
+	case !l.synced && update.FirstUpdateID > l.lastUpdateID+1,
+		l.synced && update.PrevFinalUpdateID != 0 && update.PrevFinalUpdateID != l.lastUpdateID,
+		l.synced && update.PrevFinalUpdateID == 0 && update.FirstUpdateID != l.lastUpdateID+1:
+		return false, fmt.Errorf("%w: %s expected %d, got %d", ErrOrderBookGap, l.pair,
+			l.lastUpdateID+1, update.FirstUpdateID)
+	}
+
+	updateLevels(l.bids, update.Bids)
+	updateLevels(l.asks, update.Asks)
+	l.lastUpdateID = update.FinalUpdateID
+	l.time = update.Time
+	l.synced = true
+	return true, nil
+}
+
+// Book returns the first levels of each side, or the full book when levels is zero
+func (l *LocalOrderBook) Book(levels int) model.OrderBook {
+	return model.OrderBook{
+		Pair:         l.pair,
+		Time:         l.time,
+		LastUpdateID: l.lastUpdateID,
+		Bids:         sortedLevels(l.bids, levels, true),
+		Asks:         sortedLevels(l.asks, levels, false),
+	}
+}
+
+func updateLevels(book map[float64]float64, levels []model.PriceLevel) {
+	for _, level := range levels {
+		if level.Quantity == 0 {
+			delete(book, level.Price)
+			continue
+		}
+		book[level.Price] = level.Quantity
+	}
+}
+
+func sortedLevels(book map[float64]float64, limit int, descending bool) []model.PriceLevel {
+	levels := make([]model.PriceLevel, 0, len(book))
+	for price, quantity := range book {
+		levels = append(levels, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+
+	if limit > 0 && len(levels) > limit {
+		levels = levels[:limit]
+	}
+	return levels
+}
+
+// depthServe starts a diff depth stream of a pair, as the websocket functions of go-binance
+type depthServe func(pair string, handler func(model.DepthUpdate), errHandler func(error)) (
+	done, stop chan struct{}, err error)
+
+// orderBookSubscription keeps a local order book from a diff depth stream, synced with REST snapshots.
+// The book is requested after the first update of each connection and again after a gap.
+func orderBookSubscription(ctx context.Context, pair string, serve depthServe,
+	snapshot func(ctx context.Context, pair string) (model.OrderBook, error)) (chan model.OrderBook, chan error) {
+
+	cbook := make(chan model.OrderBook)
+	cerr := make(chan error)
+
+	go func() {
+		defer close(cerr)
+		defer close(cbook)
+
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		sendErr := func(err error) {
+			select {
+			case cerr <- err:
+			case <-ctx.Done():
+			}
+		}
+
+		for {
+			book := NewLocalOrderBook(pair)
+			synced := false
+			done, stop, err := serve(pair, func(update model.DepthUpdate) {
+				ba.Reset()
+				if !synced {
+					data, err := snapshot(ctx, pair)
+					if err != nil {
+						sendErr(err)
+						return
+					}
+					book.Reset(data)
+					synced = true
+				}
+
+				applied, err := book.Apply(update)
+				if err != nil {
+					log.Warn(err)
+					synced = false
+					return
+				}
+
+				if applied {
+					select {
+					case cbook <- book.Book(orderBookLevels):
+					case <-ctx.Done():
+					}
+				}
+			}, sendErr)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				close(stop)
+				<-done
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return cbook, cerr
+}
+
+// OrderBookFile is a recorded order book of a pair, written by DepthRecorder
+type OrderBookFile struct {
+	Pair string
+	File string
+}
+
+// depthRecord is a line of a recorded order book file
+type depthRecord struct {
+	Snapshot *model.OrderBook   `json:"snapshot,omitempty"`
+	Update   *model.DepthUpdate `json:"update,omitempty"`
+}
+
+// DepthRecorder writes order book snapshots and updates as JSON lines, eg. to record the books of
+// OrderBookSubscription and replay them in tests with OrderBookReplay
+type DepthRecorder struct {
+	encoder *json.Encoder
+}
+
+// NewDepthRecorder creates a recorder to a writer
+func NewDepthRecorder(w io.Writer) *DepthRecorder {
+	return &DepthRecorder{encoder: json.NewEncoder(w)}
+}
+
+// WriteSnapshot records a full book, it replaces the replayed book
+func (d *DepthRecorder) WriteSnapshot(book model.OrderBook) error {
+	return d.encoder.Encode(depthRecord{Snapshot: &book})
+}
+
+// WriteUpdate records an incremental update of the book
+func (d *DepthRecorder) WriteUpdate(update model.DepthUpdate) error {
+	return d.encoder.Encode(depthRecord{Update: &update})
+}
+
+// OrderBookReplay is an order book feed of recorded files
+type OrderBookReplay struct {
+	Files  map[string]OrderBookFile
+	Levels int
+}
+
+// NewOrderBookReplay creates a replay of recorded order books, limited to a number of levels of each
+// side, or the full book when levels is zero
+func NewOrderBookReplay(levels int, files ...OrderBookFile) (*OrderBookReplay, error) {
+	replay := &OrderBookReplay{
+		Files:  make(map[string]OrderBookFile),
+		Levels: levels,
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file.File); err != nil {
+			return nil, err
+		}
+		replay.Files[file.Pair] = file
+	}
+	return replay, nil
+}
+
+// ReadOrderBooks returns the books of a recorded file, after each snapshot or update
+func (o *OrderBookReplay) ReadOrderBooks(pair string) ([]model.OrderBook, error) {
+	books := make([]model.OrderBook, 0)
+	err := o.replay(pair, func(book model.OrderBook) bool {
+		books = append(books, book)
+		return true
+	})
+	return books, err
+}
+
+func (o *OrderBookReplay) replay(pair string, send func(model.OrderBook) bool) error {
+	file, ok := o.Files[pair]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+	}
+
+	f, err := os.Open(file.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	book := NewLocalOrderBook(pair)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record depthRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s:%d: %w", file.File, line, err)
+		}
+
+		switch {
+		case record.Snapshot != nil:
+			record.Snapshot.Pair = pair
+			book.Reset(*record.Snapshot)
+		case record.Update != nil:
+			if _, err := book.Apply(*record.Update); err != nil {
+				return fmt.Errorf("%s:%d: %w", file.File, line, err)
+			}
+		default:
+			continue
+		}
+
+		if !send(book.Book(o.Levels)) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// OrderBookSubscription streams the recorded books of the pair, without delay
+func (o *OrderBookReplay) OrderBookSubscription(ctx context.Context, pair string) (chan model.OrderBook, chan error) {
+	cbook := make(chan model.OrderBook)
+	cerr := make(chan error, 1)
+
+	go func() {
+		defer close(cerr)
+		defer close(cbook)
+
+		err := o.replay(pair, func(book model.OrderBook) bool {
+			select {
+			case cbook <- book:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			cerr <- err
+		}
+	}()
+
+	return cbook, cerr
+}
@@ -0,0 +1,188 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func depthUpdate(first, final int64, bids, asks []model.PriceLevel) model.DepthUpdate {
+	return model.DepthUpdate{Pair: "BTCUSDT", FirstUpdateID: first, FinalUpdateID: final, Bids: bids, Asks: asks}
+}
+
+func TestLocalOrderBook(t *testing.T) {
+	snapshot := model.OrderBook{
+		Pair:         "BTCUSDT",
+		LastUpdateID: 100,
+		Bids:         []model.PriceLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 2}},
+		Asks:         []model.PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 2}},
+	}
+
+	t.Run("spot", func(t *testing.T) {
+		book := NewLocalOrderBook("BTCUSDT")
+		book.Reset(snapshot)
+
+		// older than the snapshot
+		applied, err := book.Apply(depthUpdate(90, 100, []model.PriceLevel{{Price: 99, Quantity: 5}}, nil))
+		require.NoError(t, err)
+		require.False(t, applied)
+
+		// first update overlaps the snapshot
+		bids := []model.PriceLevel{{Price: 99, Quantity: 0}, {Price: 100, Quantity: 3}}
+		applied, err = book.Apply(depthUpdate(95, 105, bids, nil))
+		require.NoError(t, err)
+		require.True(t, applied)
+
+		applied, err = book.Apply(depthUpdate(106, 110, nil, []model.PriceLevel{{Price: 100.5, Quantity: 1}}))
+		require.NoError(t, err)
+		require.True(t, applied)
+
+		result := book.Book(0)
+		require.Equal(t, int64(110), result.LastUpdateID)
+		require.Equal(t, []model.PriceLevel{{Price: 100, Quantity: 3}, {Price: 98, Quantity: 2}}, result.Bids)
+		require.Equal(t, []model.PriceLevel{{Price: 100.5, Quantity: 1}, {Price: 101, Quantity: 1},
+			{Price: 102, Quantity: 2}}, result.Asks)
+		require.Len(t, book.Book(1).Asks, 1)
+
+		_, err = book.Apply(depthUpdate(112, 115, nil, nil))
+		require.ErrorIs(t, err, ErrOrderBookGap)
+	})
+
+	t.Run("snapshot before stream", func(t *testing.T) {
+		book := NewLocalOrderBook("BTCUSDT")
+		book.Reset(snapshot)
+		_, err := book.Apply(depthUpdate(102, 105, nil, nil))
+		require.ErrorIs(t, err, ErrOrderBookGap)
+	})
+
+	t.Run("futures", func(t *testing.T) {
+		book := NewLocalOrderBook("BTCUSDT")
+		book.Reset(snapshot)
+
+		update := depthUpdate(95, 105, nil, nil)
+		update.PrevFinalUpdateID = 94
+		applied, err := book.Apply(update)
+		require.NoError(t, err)
+		require.True(t, applied)
+
+		// futures updates are chained by the previous final ID
+		update = depthUpdate(110, 120, nil, nil)
+		update.PrevFinalUpdateID = 105
+		applied, err = book.Apply(update)
+		require.NoError(t, err)
+		require.True(t, applied)
+
+		update = depthUpdate(125, 130, nil, nil)
+		update.PrevFinalUpdateID = 122
+		_, err = book.Apply(update)
+		require.ErrorIs(t, err, ErrOrderBookGap)
+	})
+}
+
+func TestOrderBookSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := []model.DepthUpdate{
+		depthUpdate(1, 10, []model.PriceLevel{{Price: 99, Quantity: 1}}, nil),
+		depthUpdate(11, 12, []model.PriceLevel{{Price: 99, Quantity: 2}}, nil),
+		// gap, the book is synced again with a new snapshot
+		depthUpdate(20, 22, []model.PriceLevel{{Price: 99, Quantity: 3}}, nil),
+		depthUpdate(23, 25, []model.PriceLevel{{Price: 99, Quantity: 4}}, nil),
+	}
+
+	var mu sync.Mutex
+	snapshots := []int64{10, 22}
+	snapshot := func(_ context.Context, pair string) (model.OrderBook, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(snapshots) == 0 {
+			return model.OrderBook{}, errors.New("no snapshot")
+		}
+		id := snapshots[0]
+		snapshots = snapshots[1:]
+		return model.OrderBook{Pair: pair, LastUpdateID: id,
+			Asks: []model.PriceLevel{{Price: 101, Quantity: 1}}}, nil
+	}
+
+	serve := func(_ string, handler func(model.DepthUpdate), _ func(error)) (chan struct{}, chan struct{}, error) {
+		done, stop := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, update := range updates {
+				handler(update)
+			}
+			<-stop
+		}()
+		return done, stop, nil
+	}
+
+	cbook, _ := orderBookSubscription(ctx, "BTCUSDT", serve, snapshot)
+
+	book := <-cbook
+	require.Equal(t, int64(12), book.LastUpdateID)
+	require.Equal(t, []model.PriceLevel{{Price: 99, Quantity: 2}}, book.Bids)
+
+	book = <-cbook
+	require.Equal(t, int64(25), book.LastUpdateID)
+	require.Equal(t, []model.PriceLevel{{Price: 99, Quantity: 4}}, book.Bids)
+	require.Equal(t, []model.PriceLevel{{Price: 101, Quantity: 1}}, book.Asks)
+
+	cancel()
+	_, ok := <-cbook
+	require.False(t, ok)
+}
+
+func TestOrderBookReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btc-depth.jsonl")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder := NewDepthRecorder(file)
+	require.NoError(t, recorder.WriteSnapshot(model.OrderBook{
+		Time:         start,
+		LastUpdateID: 10,
+		Bids:         []model.PriceLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 1}},
+		Asks:         []model.PriceLevel{{Price: 101, Quantity: 1}},
+	}))
+	update := depthUpdate(11, 12, []model.PriceLevel{{Price: 100, Quantity: 2}},
+		[]model.PriceLevel{{Price: 101, Quantity: 0}, {Price: 103, Quantity: 4}})
+	update.Time = start.Add(time.Second)
+	require.NoError(t, recorder.WriteUpdate(update))
+	require.NoError(t, file.Close())
+
+	replay, err := NewOrderBookReplay(2, OrderBookFile{Pair: "BTCUSDT", File: path})
+	require.NoError(t, err)
+
+	books, err := replay.ReadOrderBooks("BTCUSDT")
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	require.Equal(t, "BTCUSDT", books[0].Pair)
+	require.Equal(t, 100.0, books[0].MidPrice())
+	require.Equal(t, start.Add(time.Second), books[1].Time)
+	require.Equal(t, []model.PriceLevel{{Price: 100, Quantity: 2}, {Price: 99, Quantity: 1}}, books[1].Bids)
+	require.Equal(t, []model.PriceLevel{{Price: 103, Quantity: 4}}, books[1].Asks)
+
+	cbook, cerr := replay.OrderBookSubscription(context.Background(), "BTCUSDT")
+	count := 0
+	for range cbook {
+		count++
+	}
+	require.Equal(t, 2, count)
+	require.NoError(t, <-cerr)
+
+	_, err = replay.ReadOrderBooks("ETHUSDT")
+	require.ErrorIs(t, err, ErrInvalidAsset)
+
+	_, err = NewOrderBookReplay(0, OrderBookFile{Pair: "BTCUSDT", File: "invalid.jsonl"})
+	require.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/futures"
@@ -20,6 +21,7 @@ var (
 	//go:embed pairs.json
 	pairs             []byte
 	pairAssetQuoteMap = make(map[string]AssetQuote)
+	pairsMtx          sync.RWMutex
 )
 
 func init() {
@@ -30,10 +32,20 @@ func init() {
 }
 
 func SplitAssetQuote(pair string) (asset string, quote string) {
+	pairsMtx.RLock()
+	defer pairsMtx.RUnlock()
 	data := pairAssetQuoteMap[pair]
 	return data.Asset, data.Quote
 }
 
+// RegisterPair sets the asset and quote of a pair, eg. for symbols of other exchanges that are not
+// listed by Binance
+func RegisterPair(pair, asset, quote string) {
+	pairsMtx.Lock()
+	defer pairsMtx.Unlock()
+	pairAssetQuoteMap[pair] = AssetQuote{Asset: asset, Quote: quote}
+}
+
 func updatePairsFile() error {
 	client := binance.NewClient("", "")
 	sportInfo, err := client.NewExchangeInfoService().Do(context.Background())
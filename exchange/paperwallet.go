@@ -21,10 +21,7 @@ type assetInfo struct {
 	Lock float64
 }
 
-type AssetValue struct {
-	Time  time.Time
-	Value float64
-}
+type AssetValue = model.AssetValue
 
 type PaperWallet struct {
 	sync.Mutex
@@ -41,9 +38,11 @@ type PaperWallet struct {
 	avgLongPrice  map[string]float64
 	volume        map[string]float64
 	lastCandle    map[string]model.Candle
+	orderBooks    map[string]model.OrderBook
 	fistCandle    map[string]model.Candle
 	assetValues   map[string][]AssetValue
 	equityValues  []AssetValue
+	leverage      map[string]float64
 }
 
 func (p *PaperWallet) AssetsInfo(pair string) model.AssetInfo {
@@ -78,6 +77,14 @@ func WithPaperFee(maker, taker float64) PaperWalletOption {
 	}
 }
 
+// WithPaperLeverage sets the leverage of a pair, used to calculate the margin and liquidation price
+// of positions. Funds are still fully collateralized in the paper wallet.
+func WithPaperLeverage(pair string, leverage float64) PaperWalletOption {
+	return func(wallet *PaperWallet) {
+		wallet.leverage[pair] = leverage
+	}
+}
+
 func WithDataFeed(feeder service.Feeder) PaperWalletOption {
 	return func(wallet *PaperWallet) {
 		wallet.feeder = feeder
@@ -92,11 +99,13 @@ func NewPaperWallet(ctx context.Context, baseCoin string, options ...PaperWallet
 		assets:        make(map[string]*assetInfo),
 		fistCandle:    make(map[string]model.Candle),
 		lastCandle:    make(map[string]model.Candle),
+		orderBooks:    make(map[string]model.OrderBook),
 		avgShortPrice: make(map[string]float64),
 		avgLongPrice:  make(map[string]float64),
 		volume:        make(map[string]float64),
 		assetValues:   make(map[string][]AssetValue),
 		equityValues:  make([]AssetValue, 0),
+		leverage:      make(map[string]float64),
 	}
 
 	for _, option := range options {
@@ -124,6 +133,16 @@ func (p *PaperWallet) Pairs() []string {
 }
 
 func (p *PaperWallet) LastQuote(ctx context.Context, pair string) (float64, error) {
+	if p.feeder == nil {
+		p.Lock()
+		defer p.Unlock()
+
+		candle, ok := p.lastCandle[pair]
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+		}
+		return candle.Close, nil
+	}
 	return p.feeder.LastQuote(ctx, pair)
 }
 
@@ -136,31 +155,36 @@ func (p *PaperWallet) EquityValues() []AssetValue {
 }
 
 func (p *PaperWallet) MaxDrawdown() (float64, time.Time, time.Time) {
-	if len(p.equityValues) < 1 {
+	return MaxDrawdown(p.equityValues)
+}
+
+// MaxDrawdown returns the max drawdown rate of an equity curve and the period in which it happened
+func MaxDrawdown(values []AssetValue) (float64, time.Time, time.Time) {
+	if len(values) < 2 {
 		return 0, time.Time{}, time.Time{}
 	}
 
 	localMin := math.MaxFloat64
-	localMinBase := p.equityValues[0].Value
-	localMinStart := p.equityValues[0].Time
-	localMinEnd := p.equityValues[0].Time
+	localMinBase := values[0].Value
+	localMinStart := values[0].Time
+	localMinEnd := values[0].Time
 
 	globalMin := localMin
 	globalMinBase := localMinBase
 	globalMinStart := localMinStart
 	globalMinEnd := localMinEnd
 
-	for i := 1; i < len(p.equityValues); i++ {
-		diff := p.equityValues[i].Value - p.equityValues[i-1].Value
+	for i := 1; i < len(values); i++ {
+		diff := values[i].Value - values[i-1].Value
 
 		if localMin > 0 {
 			localMin = diff
-			localMinBase = p.equityValues[i-1].Value
-			localMinStart = p.equityValues[i-1].Time
-			localMinEnd = p.equityValues[i].Time
+			localMinBase = values[i-1].Value
+			localMinStart = values[i-1].Time
+			localMinEnd = values[i].Time
 		} else {
 			localMin += diff
-			localMinEnd = p.equityValues[i].Time
+			localMinEnd = values[i].Time
 		}
 
 		if localMin < globalMin {
@@ -475,6 +499,34 @@ func (p *PaperWallet) OnCandle(candle model.Candle) {
 	}
 }
 
+// OnOrderBook updates the order book of a pair, used to price market orders
+func (p *PaperWallet) OnOrderBook(book model.OrderBook) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.orderBooks[book.Pair] = book
+}
+
+// OrderBook returns the last order book of a pair, if any
+func (p *PaperWallet) OrderBook(pair string) (model.OrderBook, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	book, ok := p.orderBooks[pair]
+	return book, ok
+}
+
+// marketPrice returns the average price of a market order, walking the order book when available,
+// or the last close price
+func (p *PaperWallet) marketPrice(side model.SideType, pair string, size float64) float64 {
+	if book, ok := p.orderBooks[pair]; ok {
+		if price, ok := book.MarketPrice(side, size); ok {
+			return price
+		}
+	}
+	return p.lastCandle[pair].Close
+}
+
 func (p *PaperWallet) Account() (model.Account, error) {
 	balances := make([]model.Balance, 0)
 	for pair, info := range p.assets {
@@ -505,6 +557,48 @@ func (p *PaperWallet) Position(pair string) (asset, quote float64, err error) {
 	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
 }
 
+// PositionInfo returns the open position of a pair, using isolated margin and the last candle as mark price.
+// The liquidation price ignores the maintenance margin.
+func (p *PaperWallet) PositionInfo(pair string) (model.Position, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	leverage := 1.0
+	if value, ok := p.leverage[pair]; ok && value > 0 {
+		leverage = value
+	}
+
+	position := model.Position{
+		Pair:       pair,
+		Leverage:   leverage,
+		MarginType: model.MarginTypeIsolated,
+	}
+
+	asset, _ := SplitAssetQuote(pair)
+	info, ok := p.assets[asset]
+	if !ok || info.Free+info.Lock == 0 {
+		return position, nil
+	}
+
+	amount := info.Free + info.Lock
+	position.Quantity = math.Abs(amount)
+	position.MarkPrice = p.lastCandle[pair].Close
+	if amount > 0 {
+		position.Side = model.PositionSideTypeLong
+		position.EntryPrice = p.avgLongPrice[pair]
+		position.UnrealizedPnL = (position.MarkPrice - position.EntryPrice) * position.Quantity
+		position.LiquidationPrice = position.EntryPrice * (1 - 1/leverage)
+	} else {
+		position.Side = model.PositionSideTypeShort
+		position.EntryPrice = p.avgShortPrice[pair]
+		position.UnrealizedPnL = (position.EntryPrice - position.MarkPrice) * position.Quantity
+		position.LiquidationPrice = position.EntryPrice * (1 + 1/leverage)
+	}
+	position.Margin = position.EntryPrice * position.Quantity / leverage
+
+	return position, nil
+}
+
 func (p *PaperWallet) CreateOrderOCO(side model.SideType, pair string,
 	size, price, stop, stopLimit float64) ([]model.Order, error) {
 	p.Lock()
@@ -559,6 +653,11 @@ func (p *PaperWallet) CreateOrderLimit(side model.SideType, pair string,
 	p.Lock()
 	defer p.Unlock()
 
+	return p.createOrderLimit(side, pair, size, limit)
+}
+
+func (p *PaperWallet) createOrderLimit(side model.SideType, pair string,
+	size float64, limit float64) (model.Order, error) {
 	if size == 0 {
 		return model.Order{}, ErrInvalidQuantity
 	}
@@ -582,6 +681,107 @@ func (p *PaperWallet) CreateOrderLimit(side model.SideType, pair string,
 	return order, nil
 }
 
+// CreateOrder creates an order given a generic request. Market orders are priced from the order book
+// when one is available, while limit orders are matched against the last close price: post-only orders
+// are rejected if marketable, IOC and FOK orders are fully filled if marketable, otherwise they expire.
+func (p *PaperWallet) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	err := request.Validate()
+	if err != nil {
+		return model.Order{}, &OrderError{Err: err, Pair: request.Pair, Quantity: request.Quantity}
+	}
+
+	if request.ReduceOnly {
+		err = p.validateReduceOnly(request)
+		if err != nil {
+			return model.Order{}, err
+		}
+	}
+
+	var order model.Order
+	switch {
+	case request.Type == model.OrderTypeMarket:
+		order, err = p.createOrderMarket(request.Side, request.Pair, request.Quantity)
+	case request.IsPostOnly():
+		if p.isMarketable(request) {
+			return model.Order{}, &OrderError{Err: ErrPostOnlyRejected, Pair: request.Pair, Quantity: request.Quantity}
+		}
+		order, err = p.createOrderLimit(request.Side, request.Pair, request.Quantity, request.Price)
+		order.Type = model.OrderTypeLimitMaker
+	case request.TimeInForce == model.TimeInForceTypeIOC || request.TimeInForce == model.TimeInForceTypeFOK:
+		if p.isMarketable(request) {
+			order, err = p.createOrderMarket(request.Side, request.Pair, request.Quantity)
+		} else {
+			order = model.Order{
+				ExchangeID: p.ID(),
+				CreatedAt:  p.lastCandle[request.Pair].Time,
+				UpdatedAt:  p.lastCandle[request.Pair].Time,
+				Pair:       request.Pair,
+				Side:       request.Side,
+				Status:     model.OrderStatusTypeExpired,
+				Price:      request.Price,
+				Quantity:   request.Quantity,
+			}
+			p.orders = append(p.orders, order)
+		}
+		order.Type = model.OrderTypeLimit
+	default:
+		order, err = p.createOrderLimit(request.Side, request.Pair, request.Quantity, request.Price)
+	}
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	order.TimeInForce = request.TimeInForce
+	if order.TimeInForce == "" && order.Type != model.OrderTypeMarket {
+		order.TimeInForce = model.TimeInForceTypeGTC
+	}
+	order.ReduceOnly = request.ReduceOnly
+	order.ClientID = request.ClientID
+	order.Tag = request.Tag
+
+	for i := range p.orders {
+		if p.orders[i].ExchangeID == order.ExchangeID {
+			p.orders[i] = order
+			break
+		}
+	}
+
+	return order, nil
+}
+
+// isMarketable returns true if a limit order would match with the last price
+func (p *PaperWallet) isMarketable(request model.OrderRequest) bool {
+	candle, ok := p.lastCandle[request.Pair]
+	if !ok {
+		return false
+	}
+
+	if request.Side == model.SideTypeBuy {
+		return request.Price >= candle.Close
+	}
+	return request.Price <= candle.Close
+}
+
+// validateReduceOnly checks if an order only reduces the current position
+func (p *PaperWallet) validateReduceOnly(request model.OrderRequest) error {
+	asset, _ := SplitAssetQuote(request.Pair)
+
+	var position float64
+	if info, ok := p.assets[asset]; ok {
+		position = info.Free + info.Lock
+	}
+
+	if request.Side == model.SideTypeSell && request.Quantity <= position ||
+		request.Side == model.SideTypeBuy && request.Quantity <= -position {
+		return nil
+	}
+
+	return &OrderError{Err: ErrReduceOnly, Pair: request.Pair, Quantity: request.Quantity}
+}
+
 func (p *PaperWallet) CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
 	p.Lock()
 	defer p.Unlock()
@@ -623,7 +823,8 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		return model.Order{}, ErrInvalidQuantity
 	}
 
-	err := p.validateFunds(side, pair, size, p.lastCandle[pair].Close, true)
+	price := p.marketPrice(side, pair, size)
+	err := p.validateFunds(side, pair, size, price, true)
 	if err != nil {
 		return model.Order{}, err
 	}
@@ -632,7 +833,7 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		p.volume[pair] = 0
 	}
 
-	p.volume[pair] += p.lastCandle[pair].Close * size
+	p.volume[pair] += price * size
 
 	order := model.Order{
 		ExchangeID: p.ID(),
@@ -642,7 +843,7 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		Side:       side,
 		Type:       model.OrderTypeMarket,
 		Status:     model.OrderStatusTypeFilled,
-		Price:      p.lastCandle[pair].Close,
+		Price:      price,
 		Quantity:   size,
 	}
 
@@ -657,14 +858,67 @@ func (p *PaperWallet) CreateOrderMarketQuote(side model.SideType, pair string,
 	defer p.Unlock()
 
 	info := p.AssetsInfo(pair)
-	quantity := common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision, quoteQuantity/p.lastCandle[pair].Close)
+	quantity := common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision,
+		quoteQuantity/p.marketPrice(side, pair, 0))
 	return p.createOrderMarket(side, pair, quantity)
 }
 
+// ReplaceOrder cancels an open limit order and creates a new one with the given price and quantity
+func (p *PaperWallet) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if order.Type != model.OrderTypeLimit && order.Type != model.OrderTypeLimitMaker {
+		return model.Order{}, &OrderError{
+			Err:      fmt.Errorf("%w: replace %s order", ErrNotSupported, order.Type),
+			Pair:     order.Pair,
+			Quantity: quantity,
+		}
+	}
+
+	var original *model.Order
+	for i := range p.orders {
+		if p.orders[i].ExchangeID == order.ExchangeID {
+			original = &p.orders[i]
+			break
+		}
+	}
+
+	if original == nil || original.Status != model.OrderStatusTypeNew {
+		return model.Order{}, &OrderError{Err: ErrOrderNotOpen, Pair: order.Pair, Quantity: quantity}
+	}
+
+	request := model.NewOrderRequest(original.Side, original.Pair, quantity, model.WithLimitPrice(price))
+	if original.Type == model.OrderTypeLimitMaker && p.isMarketable(request) {
+		return model.Order{}, &OrderError{Err: ErrPostOnlyRejected, Pair: order.Pair, Quantity: quantity}
+	}
+
+	previous := *original
+	p.cancel(previous)
+
+	newOrder, err := p.createOrderLimit(previous.Side, previous.Pair, quantity, price)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	newOrder.Type = previous.Type
+	newOrder.TimeInForce = previous.TimeInForce
+	newOrder.ReduceOnly = previous.ReduceOnly
+	newOrder.Tag = previous.Tag
+	p.orders[len(p.orders)-1] = newOrder
+
+	return newOrder, nil
+}
+
 func (p *PaperWallet) Cancel(order model.Order) error {
 	p.Lock()
 	defer p.Unlock()
 
+	p.cancel(order)
+	return nil
+}
+
+func (p *PaperWallet) cancel(order model.Order) {
 	for i, o := range p.orders {
 		if o.ExchangeID == order.ExchangeID {
 			p.orders[i].Status = model.OrderStatusTypeCanceled
@@ -685,7 +939,24 @@ func (p *PaperWallet) Cancel(order model.Order) error {
 			}
 		}
 	}
-	return nil
+}
+
+// Orders returns the last orders of a pair, limited by the given size
+func (p *PaperWallet) Orders(pair string, limit int) ([]model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	orders := make([]model.Order, 0)
+	for _, order := range p.orders {
+		if order.Pair == pair {
+			orders = append(orders, order)
+		}
+	}
+
+	if limit > 0 && len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
 }
 
 func (p *PaperWallet) Order(_ string, id int64) (model.Order, error) {
@@ -706,6 +977,15 @@ func (p *PaperWallet) CandlesByLimit(ctx context.Context, pair, period string, l
 	return p.feeder.CandlesByLimit(ctx, pair, period, limit)
 }
 
+// CandleCount returns the number of candles of the data feed, when it is a CandleCounter
+func (p *PaperWallet) CandleCount(ctx context.Context, pair, timeframe string) (int, error) {
+	counter, ok := p.feeder.(CandleCounter)
+	if !ok {
+		return 0, fmt.Errorf("%w: candle count", ErrNotSupported)
+	}
+	return counter.CandleCount(ctx, pair, timeframe)
+}
+
 func (p *PaperWallet) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error) {
 	return p.feeder.CandlesSubscription(ctx, pair, timeframe)
 }
@@ -275,6 +275,38 @@ func TestPaperWallet_OrderMarket(t *testing.T) {
 	require.Equal(t, 50.0, wallet.avgLongPrice["BTCUSDT"])
 }
 
+func TestPaperWallet_OrderMarketOrderBook(t *testing.T) {
+	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000))
+	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+	wallet.OnOrderBook(model.OrderBook{
+		Pair: "BTCUSDT",
+		Bids: []model.PriceLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 1}},
+		Asks: []model.PriceLevel{{Price: 101, Quantity: 1}, {Price: 103, Quantity: 1}},
+	})
+
+	// buy walks the asks
+	order, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 2)
+	require.NoError(t, err)
+	require.Equal(t, 102.0, order.Price)
+	require.Equal(t, 796.0, wallet.assets["USDT"].Free)
+
+	// sell walks the bids
+	order, err = wallet.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 2)
+	require.NoError(t, err)
+	require.Equal(t, 98.5, order.Price)
+	require.Equal(t, 993.0, wallet.assets["USDT"].Free)
+
+	// quote orders are sized by the best price
+	order, err = wallet.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 101)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, order.Quantity)
+	require.Equal(t, 101.0, order.Price)
+
+	book, ok := wallet.OrderBook("BTCUSDT")
+	require.True(t, ok)
+	require.Equal(t, 2.0, book.Spread())
+}
+
 func TestPaperWallet_OrderOCO(t *testing.T) {
 	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 50))
 	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
@@ -314,6 +346,163 @@ func TestPaperWallet_OrderOCO(t *testing.T) {
 	require.Equal(t, wallet.orders[2].Status, model.OrderStatusTypeFilled)
 }
 
+func TestPaperWallet_CreateOrder(t *testing.T) {
+	t.Run("post-only", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
+
+		// would take liquidity
+		_, err := wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+			model.WithLimitPrice(60), model.WithPostOnly()))
+		require.ErrorIs(t, err, ErrPostOnlyRejected)
+		require.Empty(t, wallet.orders)
+
+		order, err := wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+			model.WithLimitPrice(40), model.WithPostOnly(), model.WithTag("entry")))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeLimitMaker, order.Type)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+		require.Equal(t, model.TimeInForceTypeGTC, order.TimeInForce)
+		require.Equal(t, "entry", order.Tag)
+		require.Equal(t, 60.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 40.0, wallet.assets["USDT"].Lock)
+
+		stored, err := wallet.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, order, stored)
+	})
+
+	t.Run("immediate or cancel", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
+
+		order, err := wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+			model.WithLimitPrice(40), model.WithTimeInForce(model.TimeInForceTypeIOC)))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeExpired, order.Status)
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+
+		order, err = wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+			model.WithLimitPrice(60), model.WithTimeInForce(model.TimeInForceTypeIOC)))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, model.OrderTypeLimit, order.Type)
+		require.Equal(t, model.TimeInForceTypeIOC, order.TimeInForce)
+		require.Equal(t, 50.0, order.Price)
+		require.Equal(t, 1.0, wallet.assets["BTC"].Free)
+	})
+
+	t.Run("reduce-only", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
+		_, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		_, err = wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+			model.WithReduceOnly()))
+		require.ErrorIs(t, err, ErrReduceOnly)
+
+		_, err = wallet.CreateOrder(model.NewOrderRequest(model.SideTypeSell, "BTCUSDT", 2,
+			model.WithReduceOnly()))
+		require.ErrorIs(t, err, ErrReduceOnly)
+
+		order, err := wallet.CreateOrder(model.NewOrderRequest(model.SideTypeSell, "BTCUSDT", 1,
+			model.WithReduceOnly()))
+		require.NoError(t, err)
+		require.True(t, order.ReduceOnly)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+	})
+}
+
+func TestPaperWallet_ReplaceOrder(t *testing.T) {
+	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
+
+	order, err := wallet.CreateOrder(model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 1,
+		model.WithLimitPrice(40), model.WithPostOnly(), model.WithTag("entry")))
+	require.NoError(t, err)
+
+	// post-only replacement that would take liquidity
+	_, err = wallet.ReplaceOrder(order, 60, 1)
+	require.ErrorIs(t, err, ErrPostOnlyRejected)
+
+	newOrder, err := wallet.ReplaceOrder(order, 45, 2)
+	require.NoError(t, err)
+	require.NotEqual(t, order.ExchangeID, newOrder.ExchangeID)
+	require.Equal(t, model.OrderTypeLimitMaker, newOrder.Type)
+	require.Equal(t, model.OrderStatusTypeNew, newOrder.Status)
+	require.Equal(t, "entry", newOrder.Tag)
+	require.Equal(t, 45.0, newOrder.Price)
+	require.Equal(t, 2.0, newOrder.Quantity)
+	require.Equal(t, 10.0, wallet.assets["USDT"].Free)
+	require.Equal(t, 90.0, wallet.assets["USDT"].Lock)
+
+	original, err := wallet.Order("BTCUSDT", order.ExchangeID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusTypeCanceled, original.Status)
+
+	// original order is not open anymore
+	_, err = wallet.ReplaceOrder(order, 45, 1)
+	require.ErrorIs(t, err, ErrOrderNotOpen)
+
+	// insufficient funds keeps the original order canceled
+	_, err = wallet.ReplaceOrder(newOrder, 45, 10)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+	require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+	require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+
+	// market orders can not be replaced
+	market, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+	require.NoError(t, err)
+	_, err = wallet.ReplaceOrder(market, 45, 1)
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestPaperWallet_PositionInfo(t *testing.T) {
+	t.Run("long", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000),
+			WithPaperLeverage("BTCUSDT", 10))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+
+		position, err := wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.False(t, position.IsOpen())
+		require.Equal(t, 10.0, position.Leverage)
+
+		_, err = wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 2)
+		require.NoError(t, err)
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 110})
+
+		position, err = wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeLong, position.Side)
+		require.Equal(t, 2.0, position.Quantity)
+		require.Equal(t, 100.0, position.EntryPrice)
+		require.Equal(t, 110.0, position.MarkPrice)
+		require.Equal(t, 20.0, position.UnrealizedPnL)
+		require.Equal(t, 20.0, position.Margin)
+		require.Equal(t, 90.0, position.LiquidationPrice)
+	})
+
+	t.Run("short without leverage", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+		_, err := wallet.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 2)
+		require.NoError(t, err)
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 110})
+
+		position, err := wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeShort, position.Side)
+		require.Equal(t, 2.0, position.Quantity)
+		require.Equal(t, 1.0, position.Leverage)
+		require.Equal(t, -20.0, position.UnrealizedPnL)
+		require.Equal(t, 200.0, position.Margin)
+		require.Equal(t, 200.0, position.LiquidationPrice)
+	})
+}
+
 func TestPaperWallet_Order(t *testing.T) {
 	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
 	expectOrder, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
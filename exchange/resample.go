@@ -0,0 +1,363 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/xhit/go-str2duration/v2"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// timeframe is a parsed candle timeframe, a fixed duration or a number of calendar months (eg. 1M)
+type timeframe struct {
+	duration time.Duration
+	months   int
+}
+
+func parseTimeframe(value string) (timeframe, error) {
+	if count, ok := strings.CutSuffix(value, "M"); ok {
+		months, err := strconv.Atoi(count)
+		if err != nil || months <= 0 {
+			return timeframe{}, fmt.Errorf("invalid timeframe: %s", value)
+		}
+		return timeframe{months: months}, nil
+	}
+
+	duration, err := str2duration.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return timeframe{}, fmt.Errorf("invalid timeframe: %s", value)
+	}
+	return timeframe{duration: duration}, nil
+}
+
+// add returns the time after n periods of the timeframe
+func (t timeframe) add(value time.Time, n int) time.Time {
+	if t.months > 0 {
+		return value.AddDate(0, t.months*n, 0)
+	}
+	return value.Add(time.Duration(n) * t.duration)
+}
+
+// periods returns the number of periods of the timeframe from one time to another, both included
+func (t timeframe) periods(from, to time.Time) int {
+	if to.Before(from) {
+		return 0
+	}
+
+	if t.months > 0 {
+		months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+		return months/t.months + 1
+	}
+	return int(to.Sub(from)/t.duration) + 1
+}
+
+// Resampler aggregates candles of a source timeframe into a target timeframe, one candle at a time.
+// The target can be any multiple of the source or a number of calendar months, eg. 3m, 6h, 3d, 1w or 1M.
+// Source candles can be updates of an open candle, as in live feeds.
+type Resampler struct {
+	source      timeframe
+	target      timeframe
+	passthrough bool
+	weekStart   time.Weekday
+	offset      time.Duration
+	partial     bool
+
+	started bool
+	period  time.Time
+	base    *model.Candle
+}
+
+type ResamplerOption func(*Resampler)
+
+// WithWeekStart sets the first day of weekly candles, default is Sunday
+func WithWeekStart(day time.Weekday) ResamplerOption {
+	return func(r *Resampler) {
+		r.weekStart = day
+	}
+}
+
+// WithAnchorOffset shifts the start of the target periods, eg. 8h for daily candles starting at 08:00 UTC
+func WithAnchorOffset(offset time.Duration) ResamplerOption {
+	return func(r *Resampler) {
+		r.offset = offset
+	}
+}
+
+// WithPartialCandles emits the aggregated candle of an open period on each source candle,
+// with Complete = false. It is enabled by default.
+func WithPartialCandles(enabled bool) ResamplerOption {
+	return func(r *Resampler) {
+		r.partial = enabled
+	}
+}
+
+// NewResampler creates a resampler from a source to a target timeframe
+func NewResampler(source, target string, options ...ResamplerOption) (*Resampler, error) {
+	r := &Resampler{
+		weekStart: time.Sunday,
+		partial:   true,
+	}
+
+	var err error
+	if r.source, err = parseTimeframe(source); err != nil {
+		return nil, err
+	}
+	if r.target, err = parseTimeframe(target); err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	switch {
+	case r.target.months > 0 && r.source.months > 0:
+		if r.target.months%r.source.months != 0 {
+			return nil, fmt.Errorf("timeframe %s is not a multiple of %s", target, source)
+		}
+	case r.target.months > 0:
+		if (24*time.Hour)%r.source.duration != 0 {
+			return nil, fmt.Errorf("timeframe %s does not fit in days to build %s", source, target)
+		}
+	case r.source.months > 0 || r.target.duration%r.source.duration != 0:
+		return nil, fmt.Errorf("timeframe %s is not a multiple of %s", target, source)
+	}
+
+	r.passthrough = r.source == r.target && r.offset == 0
+	return r, nil
+}
+
+// PeriodStart returns the start time of the target period that contains t
+func (r *Resampler) PeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	if r.target.months > 0 {
+		shifted := t.Add(-r.offset)
+		index := shifted.Year()*12 + int(shifted.Month()) - 1
+		index -= ((index % r.target.months) + r.target.months) % r.target.months
+		return time.Date(index/12, time.Month(index%12+1), 1, 0, 0, 0, 0, time.UTC).Add(r.offset)
+	}
+
+	anchor := time.Unix(0, 0).UTC().Add(r.offset)
+	if r.target.duration%(7*24*time.Hour) == 0 {
+		// unix epoch is a Thursday
+		anchor = anchor.AddDate(0, 0, (int(r.weekStart)-int(time.Thursday)+7)%7)
+	}
+
+	periods := int64(math.Floor(float64(t.Sub(anchor)) / float64(r.target.duration)))
+	return anchor.Add(time.Duration(periods) * r.target.duration)
+}
+
+// isLast returns true if the source candle of time t closes a target period
+func (r *Resampler) isLast(t time.Time) bool {
+	return r.passthrough || !r.PeriodStart(r.source.add(t, 1)).Equal(r.PeriodStart(t))
+}
+
+// isFirst returns true if the source candle of time t opens a target period
+func (r *Resampler) isFirst(t time.Time) bool {
+	return r.passthrough || !r.PeriodStart(r.source.add(t, -1)).Equal(r.PeriodStart(t))
+}
+
+// Next returns the aggregated candle of the period of the source candle. It returns false for candles
+// before the first complete period and for open periods when partial candles are disabled.
+func (r *Resampler) Next(candle model.Candle) (model.Candle, bool) {
+	if r.passthrough {
+		return candle, true
+	}
+
+	if !r.started {
+		if !r.isFirst(candle.Time) {
+			return model.Candle{}, false
+		}
+		r.started = true
+	}
+
+	period := r.PeriodStart(candle.Time)
+	if r.base != nil && !r.period.Equal(period) {
+		// the previous period was not closed, eg. gap in data
+		r.base = nil
+	}
+	r.period = period
+
+	aggregated := candle
+	if r.base != nil {
+		aggregated.Time = r.base.Time
+		aggregated.Open = r.base.Open
+		aggregated.High = math.Max(r.base.High, candle.High)
+		aggregated.Low = math.Min(r.base.Low, candle.Low)
+		aggregated.Volume += r.base.Volume
+	}
+	aggregated.Complete = candle.Complete && r.isLast(candle.Time)
+
+	switch {
+	case aggregated.Complete:
+		r.base = nil
+	case candle.Complete:
+		r.base = &aggregated
+	}
+
+	if !aggregated.Complete && !r.partial {
+		return model.Candle{}, false
+	}
+	return aggregated, true
+}
+
+// Resample aggregates a sorted list of source candles, the incomplete last period is discarded
+func Resample(candles []model.Candle, source, target string, options ...ResamplerOption) ([]model.Candle, error) {
+	r, err := NewResampler(source, target, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.Candle, 0)
+	for _, candle := range candles {
+		if candle, ok := r.Next(candle); ok {
+			result = append(result, candle)
+		}
+	}
+
+	if len(result) > 0 && !result[len(result)-1].Complete {
+		result = result[:len(result)-1]
+	}
+	return result, nil
+}
+
+const resampleBatchSize = 500
+
+// liveResampler builds candles of timeframes not offered by an exchange, from the largest supported
+// timeframe that divides the target timeframe
+type liveResampler struct {
+	// timeframes supported by the exchange, in ascending order
+	timeframes []string
+	options    []ResamplerOption
+}
+
+var (
+	binanceResampler = liveResampler{
+		timeframes: []string{"1s", "1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "8h", "12h",
+			"1d", "3d", "1w", "1M"},
+		options: []ResamplerOption{WithWeekStart(time.Monday)},
+	}
+	binanceFutureResampler = liveResampler{
+		timeframes: binanceResampler.timeframes[1:],
+		options:    binanceResampler.options,
+	}
+)
+
+// source returns the timeframe used to build the target, it returns false if the target is supported
+// by the exchange or if it can't be built
+func (l liveResampler) source(target string) (string, bool) {
+	if lo.Contains(l.timeframes, target) {
+		return "", false
+	}
+
+	for i := len(l.timeframes) - 1; i >= 0; i-- {
+		if _, err := NewResampler(l.timeframes[i], target, l.options...); err == nil {
+			return l.timeframes[i], true
+		}
+	}
+	return "", false
+}
+
+func (l liveResampler) CandlesSubscription(ctx context.Context, feeder service.Feeder, pair, source,
+	target string) (chan model.Candle, chan error) {
+
+	r, err := NewResampler(source, target, l.options...)
+	if err != nil {
+		ccandle, cerr := make(chan model.Candle), make(chan error, 1)
+		cerr <- err
+		close(ccandle)
+		close(cerr)
+		return ccandle, cerr
+	}
+
+	sourceCandles, cerr := feeder.CandlesSubscription(ctx, pair, source)
+	ccandle := make(chan model.Candle)
+	go func() {
+		defer close(ccandle)
+		for candle := range sourceCandles {
+			if candle, ok := r.Next(candle); ok {
+				ccandle <- candle
+			}
+		}
+	}()
+	return ccandle, cerr
+}
+
+// candles fetches the source candles of the target periods between start and end
+func (l liveResampler) candles(ctx context.Context, feeder service.Feeder, r *Resampler, pair, source string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	// candles of the current period are incomplete
+	if current := r.PeriodStart(time.Now()); !end.Before(current) {
+		end = current.Add(-time.Second)
+	}
+
+	sourceCandles := make([]model.Candle, 0)
+	for begin := r.PeriodStart(start); begin.Before(end); begin = r.source.add(begin, resampleBatchSize) {
+		batchEnd := r.source.add(begin, resampleBatchSize).Add(-time.Second)
+		if batchEnd.After(end) {
+			batchEnd = end
+		}
+
+		candles, err := feeder.CandlesByPeriod(ctx, pair, source, begin, batchEnd)
+		if err != nil {
+			return nil, err
+		}
+		sourceCandles = append(sourceCandles, candles...)
+	}
+
+	candles := make([]model.Candle, 0)
+	for _, candle := range sourceCandles {
+		if candle, ok := r.Next(candle); ok && candle.Complete {
+			candles = append(candles, candle)
+		}
+	}
+	return candles, nil
+}
+
+func (l liveResampler) CandlesByPeriod(ctx context.Context, feeder service.Feeder, pair, source, target string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	r, err := NewResampler(source, target, append([]ResamplerOption{WithPartialCandles(false)}, l.options...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := l.candles(ctx, feeder, r, pair, source, start, r.target.add(r.PeriodStart(end), 1).Add(-time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Filter(candles, func(candle model.Candle, _ int) bool {
+		return !candle.Time.Before(start) && !candle.Time.After(end)
+	}), nil
+}
+
+// CandlesByLimit returns the last complete candles of the target timeframe
+func (l liveResampler) CandlesByLimit(ctx context.Context, feeder service.Feeder, pair, source, target string,
+	limit int) ([]model.Candle, error) {
+
+	r, err := NewResampler(source, target, append([]ResamplerOption{WithPartialCandles(false)}, l.options...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	start := r.target.add(r.PeriodStart(now), -limit)
+	candles, err := l.candles(ctx, feeder, r, pair, source, start, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles, nil
+}
@@ -0,0 +1,248 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+func isLastCandlePeriod(t time.Time, source, target string) (bool, error) {
+	r, err := NewResampler(source, target)
+	if err != nil {
+		return false, err
+	}
+	return r.isLast(t), nil
+}
+
+func isFistCandlePeriod(t time.Time, source, target string) (bool, error) {
+	r, err := NewResampler(source, target)
+	if err != nil {
+		return false, err
+	}
+	return r.isFirst(t), nil
+}
+
+func hourlyCandles(start time.Time, hours int) []model.Candle {
+	candles := make([]model.Candle, 0, hours)
+	for i := 0; i < hours; i++ {
+		t := start.Add(time.Duration(i) * time.Hour)
+		candles = append(candles, model.Candle{
+			Pair: "BTCUSDT", Time: t, UpdatedAt: t, Complete: true,
+			Open: float64(i), Close: float64(i + 1), Low: float64(i), High: float64(i + 1), Volume: 1,
+		})
+	}
+	return candles
+}
+
+func TestResampler_PeriodStart(t *testing.T) {
+	monday := time.Date(2021, 11, 8, 0, 0, 0, 0, time.UTC)
+	tt := []struct {
+		target   string
+		options  []ResamplerOption
+		time     time.Time
+		expected time.Time
+	}{
+		{"3m", nil, monday.Add(13 * time.Minute), monday.Add(12 * time.Minute)},
+		{"6h", nil, monday.Add(13 * time.Hour), monday.Add(12 * time.Hour)},
+		{"8h", nil, monday.Add(23 * time.Hour), monday.Add(16 * time.Hour)},
+		{"1d", []ResamplerOption{WithAnchorOffset(8 * time.Hour)}, monday.Add(7 * time.Hour),
+			monday.Add(-16 * time.Hour)},
+		{"3d", nil, monday.Add(50 * time.Hour), monday},
+		{"1w", nil, monday, time.Date(2021, 11, 7, 0, 0, 0, 0, time.UTC)},
+		{"1w", []ResamplerOption{WithWeekStart(time.Monday)}, monday.Add(30 * time.Hour), monday},
+		{"1M", nil, monday, time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"3M", nil, monday, time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.target, func(t *testing.T) {
+			r, err := NewResampler("1m", tc.target, tc.options...)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, r.PeriodStart(tc.time))
+		})
+	}
+}
+
+func TestNewResampler(t *testing.T) {
+	tt := []struct {
+		source string
+		target string
+		valid  bool
+	}{
+		{"1h", "6h", true},
+		{"1d", "1M", true},
+		{"1M", "3M", true},
+		{"1h", "90m", false},
+		{"7h", "1M", false},
+		{"2M", "3M", false},
+		{"1M", "1y", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%s to %s", tc.source, tc.target), func(t *testing.T) {
+			_, err := NewResampler(tc.source, tc.target)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestResample(t *testing.T) {
+	start := time.Date(2021, 11, 7, 22, 0, 0, 0, time.UTC)
+
+	t.Run("multiple of source", func(t *testing.T) {
+		candles, err := Resample(hourlyCandles(start, 22), "1h", "6h")
+		require.NoError(t, err)
+
+		complete := make([]model.Candle, 0)
+		for _, candle := range candles {
+			if candle.Complete {
+				complete = append(complete, candle)
+			}
+		}
+
+		// first period starts at 00:00, the last incomplete period is discarded
+		require.Len(t, complete, 3)
+		require.Equal(t, start.Add(2*time.Hour), complete[0].Time)
+		require.Equal(t, 2.0, complete[0].Open)
+		require.Equal(t, 8.0, complete[0].Close)
+		require.Equal(t, 8.0, complete[0].High)
+		require.Equal(t, 2.0, complete[0].Low)
+		require.Equal(t, 6.0, complete[0].Volume)
+	})
+
+	t.Run("without partial candles", func(t *testing.T) {
+		candles, err := Resample(hourlyCandles(start, 26), "1h", "1d",
+			WithPartialCandles(false), WithAnchorOffset(-2*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 1)
+		require.Equal(t, start, candles[0].Time)
+		require.Equal(t, 24.0, candles[0].Volume)
+	})
+
+	t.Run("gap", func(t *testing.T) {
+		candles := hourlyCandles(start, 16)
+		// the last candle of the first period is missing
+		candles = append(candles[:5], candles[6:]...)
+		result, err := Resample(candles, "1h", "4h", WithPartialCandles(false))
+		require.NoError(t, err)
+		require.Len(t, result, 2)
+		require.Equal(t, start.Add(6*time.Hour), result[0].Time)
+	})
+
+	t.Run("live updates", func(t *testing.T) {
+		r, err := NewResampler("1h", "2h")
+		require.NoError(t, err)
+
+		update := func(hour int, close float64, complete bool) model.Candle {
+			candle, ok := r.Next(model.Candle{Time: start.Add(time.Duration(hour) * time.Hour),
+				Open: 1, Close: close, High: close, Low: 1, Volume: close, Complete: complete})
+			require.True(t, ok)
+			return candle
+		}
+
+		require.False(t, update(2, 2, false).Complete)
+		require.Equal(t, 3.0, update(2, 3, true).Volume)
+		require.Equal(t, 4.0, update(3, 1, false).Volume)
+
+		candle := update(3, 2, true)
+		require.True(t, candle.Complete)
+		require.Equal(t, 5.0, candle.Volume)
+		require.Equal(t, 3.0, candle.High)
+		require.Equal(t, start.Add(2*time.Hour), candle.Time)
+	})
+}
+
+// hourlyFeeder returns hourly candles of any period, and a subscription with an update of each candle
+type hourlyFeeder struct {
+	service.Feeder
+	candles []model.Candle
+}
+
+func (f hourlyFeeder) CandlesByPeriod(_ context.Context, _, timeframe string, start, end time.Time) ([]model.Candle, error) {
+	if timeframe != "1h" {
+		return nil, fmt.Errorf("invalid timeframe: %s", timeframe)
+	}
+
+	hours := int(end.Sub(start)/time.Hour) + 1
+	return hourlyCandles(start, hours), nil
+}
+
+func (f hourlyFeeder) CandlesSubscription(_ context.Context, _, _ string) (chan model.Candle, chan error) {
+	ccandle, cerr := make(chan model.Candle), make(chan error)
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+		for _, candle := range f.candles {
+			update := candle
+			update.Complete = false
+			ccandle <- update
+			ccandle <- candle
+		}
+	}()
+	return ccandle, cerr
+}
+
+func TestLiveResampler(t *testing.T) {
+	ctx := context.Background()
+
+	source, ok := binanceResampler.source("5h")
+	require.True(t, ok)
+	require.Equal(t, "1h", source)
+
+	source, ok = binanceResampler.source("2w")
+	require.True(t, ok)
+	require.Equal(t, "1w", source)
+
+	_, ok = binanceResampler.source("6h")
+	require.False(t, ok)
+
+	start := time.Date(2021, 11, 8, 0, 0, 0, 0, time.UTC)
+	feeder := hourlyFeeder{candles: hourlyCandles(start, 11)}
+
+	t.Run("subscription", func(t *testing.T) {
+		candles, err := collectCandles(binanceResampler.CandlesSubscription(ctx, feeder, "BTCUSDT", "1h", "5h"))
+		require.NoError(t, err)
+		// periods of 5h are aligned to unix epoch, the first one starts at 04:00
+		require.Len(t, candles, 14)
+
+		complete := lo.Filter(candles, func(candle model.Candle, _ int) bool {
+			return candle.Complete
+		})
+		require.Len(t, complete, 1)
+		require.Equal(t, start.Add(4*time.Hour), complete[0].Time)
+		require.Equal(t, 5.0, complete[0].Volume)
+	})
+
+	t.Run("by period", func(t *testing.T) {
+		candles, err := binanceResampler.CandlesByPeriod(ctx, feeder, "BTCUSDT", "1h", "5h",
+			start.Add(time.Hour), start.Add(20*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 4)
+		require.Equal(t, start.Add(4*time.Hour), candles[0].Time)
+		require.Equal(t, start.Add(19*time.Hour), candles[3].Time)
+		require.True(t, candles[3].Complete)
+	})
+
+	t.Run("by limit", func(t *testing.T) {
+		candles, err := binanceResampler.CandlesByLimit(ctx, feeder, "BTCUSDT", "1h", "5h", 3)
+		require.NoError(t, err)
+		require.Len(t, candles, 3)
+
+		r, err := NewResampler("1h", "5h")
+		require.NoError(t, err)
+		current := r.PeriodStart(time.Now())
+		require.Equal(t, current.Add(-5*time.Hour), candles[2].Time)
+		require.Equal(t, 5.0, candles[2].Volume)
+	})
+}
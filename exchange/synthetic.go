@@ -0,0 +1,263 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+const year = 365 * 24 * time.Hour
+
+// PriceProcess moves a price by one step of dt years, given a standard normal shock
+type PriceProcess interface {
+	Step(rng *rand.Rand, price, dt, shock float64) float64
+}
+
+// ResettablePriceProcess is a process with state, it is reset at the start of each generation
+type ResettablePriceProcess interface {
+	PriceProcess
+	Reset()
+}
+
+// GBM is a geometric Brownian motion, with yearly drift and volatility
+type GBM struct {
+	Drift      float64
+	Volatility float64
+}
+
+func (g GBM) Step(_ *rand.Rand, price, dt, shock float64) float64 {
+	return price * math.Exp((g.Drift-g.Volatility*g.Volatility/2)*dt+g.Volatility*math.Sqrt(dt)*shock)
+}
+
+// OrnsteinUhlenbeck is a mean-reverting process of the log price. Speed is the yearly rate of
+// reversion to the Mean price and Volatility is yearly.
+type OrnsteinUhlenbeck struct {
+	Mean       float64
+	Speed      float64
+	Volatility float64
+}
+
+func (o OrnsteinUhlenbeck) Step(_ *rand.Rand, price, dt, shock float64) float64 {
+	x := math.Log(price)
+	x += o.Speed*(math.Log(o.Mean)-x)*dt + o.Volatility*math.Sqrt(dt)*shock
+	return math.Exp(x)
+}
+
+// RegimeSwitching alternates between processes, eg. a trend and a choppy market. Each regime lasts
+// an exponentially distributed time with the given mean. It keeps the current regime, so each pair
+// needs its own instance. Generations start in the first regime.
+type RegimeSwitching struct {
+	Regimes      []PriceProcess
+	MeanDuration time.Duration
+
+	current int
+}
+
+func (r *RegimeSwitching) Step(rng *rand.Rand, price, dt, shock float64) float64 {
+	if len(r.Regimes) > 1 && r.MeanDuration > 0 && rng.Float64() < dt/(float64(r.MeanDuration)/float64(year)) {
+		r.current = (r.current + 1 + rng.Intn(len(r.Regimes)-1)) % len(r.Regimes)
+	}
+	return r.Regimes[r.current].Step(rng, price, dt, shock)
+}
+
+// Reset moves back to the first regime
+func (r *RegimeSwitching) Reset() {
+	r.current = 0
+}
+
+// Regime returns the index of the current regime
+func (r *RegimeSwitching) Regime() int {
+	return r.current
+}
+
+// Jumps are random price jumps, with an expected number of jumps per year and a mean size,
+// eg. 0.2 for moves of 20%
+type Jumps struct {
+	PerYear float64
+	Size    float64
+}
+
+// occurs returns the size of a jump in a period of dt years, or zero
+func (j Jumps) occurs(rng *rand.Rand, dt float64) float64 {
+	if j.PerYear <= 0 || rng.Float64() >= j.PerYear*dt {
+		return 0
+	}
+	return j.Size * (0.5 + rng.Float64())
+}
+
+// MarketEvent is a price change injected in the candle of a given time. A gap changes the
+// open price from the previous close, otherwise the price moves inside the candle, eg. -0.3 for a crash.
+type MarketEvent struct {
+	Time   time.Time
+	Change float64
+	Gap    bool
+}
+
+// SyntheticPair describes the price path of a pair
+type SyntheticPair struct {
+	Pair string
+	// Price is the initial price, default is 100
+	Price float64
+	// Process moves the price, default is a GBM with 80% volatility
+	Process PriceProcess
+	// Correlation is the loading of the pair on a common market shock, from -1 to 1. Two pairs have
+	// the correlation of the product of their loadings.
+	Correlation float64
+	// Volume is the mean volume of a candle, default is 1000. The volume grows with the candle move.
+	Volume float64
+	// Crashes are random drops inside candles and Gaps random jumps between candles, up or down
+	Crashes Jumps
+	Gaps    Jumps
+	Events  []MarketEvent
+}
+
+// SyntheticConfig describes a synthetic data set, the same seed generates the same candles
+type SyntheticConfig struct {
+	Start time.Time
+	End   time.Time
+	// Timeframe of the generated candles, other timeframes are resampled from it. Default is 1h.
+	Timeframe string
+	// Steps is the number of price steps of a candle, used to build high and low. Default is 10.
+	Steps int
+	Seed  int64
+	Pairs []SyntheticPair
+}
+
+// GenerateCandles returns the generated candles of each pair
+func GenerateCandles(config SyntheticConfig) (map[string][]model.Candle, error) {
+	if config.Timeframe == "" {
+		config.Timeframe = "1h"
+	}
+	if config.Steps <= 0 {
+		config.Steps = 10
+	}
+
+	tf, err := parseTimeframe(config.Timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	if !config.Start.Before(config.End) {
+		return nil, errors.New("synthetic data without period")
+	}
+
+	pairs := make([]SyntheticPair, len(config.Pairs))
+	for i, pair := range config.Pairs {
+		if pair.Pair == "" {
+			return nil, errors.New("synthetic pair without name")
+		}
+		if math.Abs(pair.Correlation) > 1 {
+			return nil, fmt.Errorf("invalid correlation of %s: %f", pair.Pair, pair.Correlation)
+		}
+
+		if pair.Price <= 0 {
+			pair.Price = 100
+		}
+		if pair.Process == nil {
+			pair.Process = GBM{Volatility: 0.8}
+		}
+		if pair.Volume <= 0 {
+			pair.Volume = 1000
+		}
+		if process, ok := pair.Process.(ResettablePriceProcess); ok {
+			process.Reset()
+		}
+		pairs[i] = pair
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	candles := make(map[string][]model.Candle)
+	prices := make([]float64, len(pairs))
+	for i, pair := range pairs {
+		prices[i] = pair.Price
+	}
+
+	start := config.Start.UTC()
+	for t := start; t.Before(config.End); t = tf.add(t, 1) {
+		next := tf.add(t, 1)
+		dt := float64(next.Sub(t)) / float64(year)
+
+		generated := make([]model.Candle, len(pairs))
+		for i, pair := range pairs {
+			change := pair.Gaps.occurs(rng, dt)
+			if rng.Intn(2) == 0 {
+				change = -change
+			}
+			for _, event := range pair.Events {
+				if event.Gap && !event.Time.Before(t) && event.Time.Before(next) {
+					change += event.Change
+				}
+			}
+			prices[i] *= math.Max(1+change, 0.01)
+
+			generated[i] = model.Candle{
+				Pair: pair.Pair, Time: t, UpdatedAt: t, Complete: true,
+				Open: prices[i], High: prices[i], Low: prices[i],
+			}
+		}
+
+		// crashes and events happen at a random step of the candle
+		crashSteps := make([]int, len(pairs))
+		crashes := make([]float64, len(pairs))
+		for i, pair := range pairs {
+			crashSteps[i] = rng.Intn(config.Steps)
+			crashes[i] = -pair.Crashes.occurs(rng, dt)
+			for _, event := range pair.Events {
+				if !event.Gap && !event.Time.Before(t) && event.Time.Before(next) {
+					crashes[i] += event.Change
+				}
+			}
+		}
+
+		for step := 0; step < config.Steps; step++ {
+			market := rng.NormFloat64()
+			for i, pair := range pairs {
+				shock := pair.Correlation*market + math.Sqrt(1-pair.Correlation*pair.Correlation)*rng.NormFloat64()
+				prices[i] = pair.Process.Step(rng, prices[i], dt/float64(config.Steps), shock)
+				if step == crashSteps[i] && crashes[i] != 0 {
+					prices[i] *= math.Max(1+crashes[i], 0.01)
+				}
+
+				generated[i].High = math.Max(generated[i].High, prices[i])
+				generated[i].Low = math.Min(generated[i].Low, prices[i])
+			}
+		}
+
+		for i, pair := range pairs {
+			candle := generated[i]
+			candle.Close = prices[i]
+			move := (candle.High - candle.Low) / candle.Open
+			candle.Volume = pair.Volume * (0.5 + rng.ExpFloat64()/2) * (1 + 20*move)
+			candles[pair.Pair] = append(candles[pair.Pair], candle)
+		}
+	}
+
+	return candles, nil
+}
+
+// NewSyntheticFeed creates a data feed of generated candles, resampled to the target timeframe
+// as in CSVFeed, eg. to test strategies in backtests under chosen market conditions
+func NewSyntheticFeed(targetTimeframe string, config SyntheticConfig) (*CSVFeed, error) {
+	candles, err := GenerateCandles(config)
+	if err != nil {
+		return nil, err
+	}
+
+	timeframe := config.Timeframe
+	if timeframe == "" {
+		timeframe = "1h"
+	}
+
+	feeds := make([]PairFeed, 0, len(config.Pairs))
+	for _, pair := range config.Pairs {
+		feeds = append(feeds, PairFeed{Pair: pair.Pair, Timeframe: timeframe})
+	}
+
+	return newMemoryFeed(targetTimeframe, feeds, func(feed PairFeed) ([]model.Candle, error) {
+		return candles[feed.Pair], nil
+	})
+}
@@ -0,0 +1,158 @@
+package exchange
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func logReturns(candles []model.Candle) []float64 {
+	returns := make([]float64, 0, len(candles))
+	for _, candle := range candles {
+		returns = append(returns, math.Log(candle.Close/candle.Open))
+	}
+	return returns
+}
+
+func correlation(a, b []float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i] / float64(len(a))
+		meanB += b[i] / float64(len(b))
+	}
+
+	var cov, varA, varB float64
+	for i := range a {
+		cov += (a[i] - meanA) * (b[i] - meanB)
+		varA += (a[i] - meanA) * (a[i] - meanA)
+		varB += (b[i] - meanB) * (b[i] - meanB)
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+func TestGenerateCandles(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := SyntheticConfig{
+		Start: start,
+		End:   start.AddDate(0, 3, 0),
+		Seed:  42,
+		Pairs: []SyntheticPair{
+			{Pair: "BTCUSDT", Price: 40000, Correlation: 0.9},
+			{Pair: "ETHUSDT", Price: 3000, Correlation: 0.9},
+			{Pair: "XRPUSDT", Correlation: -0.9},
+		},
+	}
+
+	candles, err := GenerateCandles(config)
+	require.NoError(t, err)
+	require.Len(t, candles["BTCUSDT"], 90*24)
+	require.Equal(t, 40000.0, candles["BTCUSDT"][0].Open)
+
+	for _, candle := range candles["BTCUSDT"] {
+		require.True(t, candle.Complete)
+		require.GreaterOrEqual(t, candle.High, math.Max(candle.Open, candle.Close))
+		require.LessOrEqual(t, candle.Low, math.Min(candle.Open, candle.Close))
+		require.Positive(t, candle.Volume)
+	}
+
+	// correlations are the product of the loadings
+	btc, eth, xrp := logReturns(candles["BTCUSDT"]), logReturns(candles["ETHUSDT"]), logReturns(candles["XRPUSDT"])
+	require.InDelta(t, 0.81, correlation(btc, eth), 0.05)
+	require.InDelta(t, -0.81, correlation(btc, xrp), 0.05)
+
+	t.Run("seed", func(t *testing.T) {
+		same, err := GenerateCandles(config)
+		require.NoError(t, err)
+		require.Equal(t, candles, same)
+
+		config.Seed = 7
+		other, err := GenerateCandles(config)
+		require.NoError(t, err)
+		require.NotEqual(t, candles["BTCUSDT"][10].Close, other["BTCUSDT"][10].Close)
+	})
+
+	t.Run("mean reversion", func(t *testing.T) {
+		candles, err := GenerateCandles(SyntheticConfig{
+			Start: start, End: start.AddDate(0, 6, 0), Timeframe: "1d",
+			Pairs: []SyntheticPair{
+				{Pair: "BTCUSDT", Price: 200, Process: OrnsteinUhlenbeck{Mean: 100, Speed: 50, Volatility: 0.1}},
+			},
+		})
+		require.NoError(t, err)
+		require.InDelta(t, 100, candles["BTCUSDT"][len(candles["BTCUSDT"])-1].Close, 10)
+	})
+
+	t.Run("events", func(t *testing.T) {
+		crash, gap := start.Add(10*time.Hour), start.Add(20*time.Hour)
+		candles, err := GenerateCandles(SyntheticConfig{
+			Start: start, End: start.AddDate(0, 0, 1),
+			Pairs: []SyntheticPair{{
+				Pair:    "BTCUSDT",
+				Process: GBM{Volatility: 0.01},
+				Events: []MarketEvent{
+					{Time: crash.Add(time.Minute), Change: -0.5},
+					{Time: gap, Change: 0.2, Gap: true},
+				},
+			}},
+		})
+		require.NoError(t, err)
+
+		series := candles["BTCUSDT"]
+		require.InDelta(t, 50, series[10].Low, 1)
+		require.InDelta(t, 50, series[10].Close, 1)
+		require.InDelta(t, series[19].Close*1.2, series[20].Open, 0.001)
+	})
+
+	t.Run("regime switching", func(t *testing.T) {
+		regimes := &RegimeSwitching{
+			Regimes:      []PriceProcess{GBM{Drift: 2, Volatility: 0.3}, OrnsteinUhlenbeck{Mean: 100, Speed: 10, Volatility: 1}},
+			MeanDuration: 7 * 24 * time.Hour,
+		}
+
+		rng := rand.New(rand.NewSource(1))
+		seen := make(map[int]bool)
+		for i := 0; i < 1000; i++ {
+			regimes.Step(rng, 100, 1.0/365/24, 0)
+			seen[regimes.Regime()] = true
+		}
+		require.Len(t, seen, 2)
+
+		// the same seed generates the same candles with a reused process
+		config := SyntheticConfig{Start: start, End: start.AddDate(0, 1, 0), Seed: 1,
+			Pairs: []SyntheticPair{{Pair: "BTCUSDT", Process: regimes}}}
+		first, err := GenerateCandles(config)
+		require.NoError(t, err)
+		second, err := GenerateCandles(config)
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+	})
+
+	_, err = GenerateCandles(SyntheticConfig{Start: start, End: start, Pairs: config.Pairs})
+	require.Error(t, err)
+	_, err = GenerateCandles(SyntheticConfig{Start: start, End: start.AddDate(0, 0, 1),
+		Pairs: []SyntheticPair{{Pair: "BTCUSDT", Correlation: 2}}})
+	require.Error(t, err)
+}
+
+func TestNewSyntheticFeed(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	feed, err := NewSyntheticFeed("1d", SyntheticConfig{
+		Start: start, End: start.AddDate(0, 0, 30), Timeframe: "15m", Seed: 1,
+		Pairs: []SyntheticPair{{Pair: "BTCUSDT", Crashes: Jumps{PerYear: 12, Size: 0.2}}},
+	})
+	require.NoError(t, err)
+
+	// partial candles are kept, as in CSVFeed
+	candles := lo.Filter(feed.CandlePairTimeFrame["BTCUSDT--1d"], func(candle model.Candle, _ int) bool {
+		return candle.Complete
+	})
+	require.Len(t, candles, 30)
+	require.Equal(t, start, candles[0].Time)
+	require.Equal(t, feed.CandlePairTimeFrame["BTCUSDT--15m"][95].Close, candles[0].Close)
+}
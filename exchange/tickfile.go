@@ -0,0 +1,476 @@
+package exchange
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// Binary tick file layout, all numbers are little endian:
+//
+//	magic "NJBT" | version uint8 | compression uint8 | pair string | rows uint64
+//	columns: id []int64, time []int64 (unix nanoseconds), price, quantity []float64, buyer maker []uint8
+//
+// As in binary candle files, only the columns are compressed.
+const (
+	tickMagic   = "NJBT"
+	tickVersion = 1
+)
+
+// TradeFile is a tick file of a pair, in CSV or binary format
+//
+// CSV files can have a header with the columns id, time, price, quantity and is_buyer_maker, in any
+// order. Files without header follow the layout of Binance aggTrades dumps: agg_trade_id, price,
+// quantity, first_trade_id, last_trade_id, transact_time and is_buyer_maker. Times are unix epochs
+// in milliseconds or microseconds.
+type TradeFile struct {
+	Pair string
+	File string
+}
+
+// tradeReader reads the trades of a tick file one at a time
+type tradeReader interface {
+	Read() (model.Trade, error)
+	Close() error
+}
+
+var tradeHeaderAliases = map[string]string{
+	"agg_trade_id":  "id",
+	"trade_id":      "id",
+	"transact_time": "time",
+	"timestamp":     "time",
+	"qty":           "quantity",
+	"buyer_maker":   "is_buyer_maker",
+}
+
+type csvTradeReader struct {
+	pair      string
+	file      *os.File
+	reader    *csv.Reader
+	headerMap map[string]int
+	firstLine []string
+}
+
+func newCSVTradeReader(tradeFile TradeFile) (*csvTradeReader, error) {
+	file, err := os.Open(tradeFile.File)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &csvTradeReader{
+		pair:   tradeFile.Pair,
+		file:   file,
+		reader: csv.NewReader(file),
+		headerMap: map[string]int{
+			"id": 0, "price": 1, "quantity": 2, "time": 5, "is_buyer_maker": 6,
+		},
+	}
+	reader.reader.FieldsPerRecord = -1
+
+	firstLine, err := reader.reader.Read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := strconv.ParseFloat(firstLine[0], 64); err == nil {
+		reader.firstLine = firstLine
+		return reader, nil
+	}
+
+	reader.headerMap = make(map[string]int)
+	for index, header := range firstLine {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if alias, ok := tradeHeaderAliases[header]; ok {
+			header = alias
+		}
+		reader.headerMap[header] = index
+	}
+
+	for _, required := range []string{"time", "price", "quantity"} {
+		if _, ok := reader.headerMap[required]; !ok {
+			file.Close()
+			return nil, fmt.Errorf("%s: missing column %s", tradeFile.File, required)
+		}
+	}
+
+	return reader, nil
+}
+
+// Read returns the next trade of the file, or io.EOF at the end
+func (r *csvTradeReader) Read() (model.Trade, error) {
+	line := r.firstLine
+	if line != nil {
+		r.firstLine = nil
+	} else {
+		var err error
+		line, err = r.reader.Read()
+		if err != nil {
+			return model.Trade{}, err
+		}
+	}
+
+	return r.parse(line)
+}
+
+func (r *csvTradeReader) column(line []string, name string) (string, bool) {
+	index, ok := r.headerMap[name]
+	if !ok || index >= len(line) {
+		return "", false
+	}
+	return line[index], true
+}
+
+func (r *csvTradeReader) parse(line []string) (model.Trade, error) {
+	trade := model.Trade{Pair: r.pair}
+
+	value, _ := r.column(line, "time")
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return model.Trade{}, err
+	}
+	trade.Time = tradeTime(timestamp)
+
+	value, _ = r.column(line, "price")
+	if trade.Price, err = strconv.ParseFloat(value, 64); err != nil {
+		return model.Trade{}, err
+	}
+
+	value, _ = r.column(line, "quantity")
+	if trade.Quantity, err = strconv.ParseFloat(value, 64); err != nil {
+		return model.Trade{}, err
+	}
+
+	if value, ok := r.column(line, "id"); ok {
+		if trade.ID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return model.Trade{}, err
+		}
+	}
+
+	if value, ok := r.column(line, "is_buyer_maker"); ok {
+		if trade.IsBuyerMaker, err = strconv.ParseBool(strings.ToLower(value)); err != nil {
+			return model.Trade{}, err
+		}
+	}
+
+	return trade, nil
+}
+
+func (r *csvTradeReader) Close() error {
+	return r.file.Close()
+}
+
+// tradeTime converts an epoch in milliseconds or microseconds
+func tradeTime(timestamp int64) time.Time {
+	if timestamp > 1e14 {
+		return time.UnixMicro(timestamp).UTC()
+	}
+	return time.UnixMilli(timestamp).UTC()
+}
+
+// WriteBinaryTrades writes trades of a pair in the binary tick format
+func WriteBinaryTrades(w io.Writer, pair string, compression Compression, trades []model.Trade) error {
+	buffer := bufio.NewWriter(w)
+	if _, err := io.WriteString(buffer, tickMagic); err != nil {
+		return err
+	}
+
+	for _, field := range []any{uint8(tickVersion), uint8(compression)} {
+		if err := binary.Write(buffer, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	if err := writeBinaryString(buffer, pair); err != nil {
+		return err
+	}
+	if err := binary.Write(buffer, binary.LittleEndian, uint64(len(trades))); err != nil {
+		return err
+	}
+
+	var body io.Writer = buffer
+	var gz *gzip.Writer
+	switch compression {
+	case CompressionNone:
+	case CompressionGzip:
+		gz = gzip.NewWriter(buffer)
+		body = gz
+	default:
+		return fmt.Errorf("%w: unknown compression %d", ErrInvalidBinaryFile, compression)
+	}
+
+	ids := make([]int64, len(trades))
+	times := make([]int64, len(trades))
+	prices := make([]float64, len(trades))
+	quantities := make([]float64, len(trades))
+	makers := make([]uint8, len(trades))
+	for i, trade := range trades {
+		ids[i] = trade.ID
+		times[i] = trade.Time.UnixNano()
+		prices[i] = trade.Price
+		quantities[i] = trade.Quantity
+		if trade.IsBuyerMaker {
+			makers[i] = 1
+		}
+	}
+
+	for _, column := range []any{ids, times, prices, quantities, makers} {
+		if err := binary.Write(body, binary.LittleEndian, column); err != nil {
+			return err
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	return buffer.Flush()
+}
+
+// ReadBinaryTrades decodes a binary tick file and returns the pair of the file and its trades
+func ReadBinaryTrades(r io.Reader) (string, []model.Trade, error) {
+	buffer := bufio.NewReader(r)
+
+	magic := make([]byte, len(tickMagic))
+	if _, err := io.ReadFull(buffer, magic); err != nil || string(magic) != tickMagic {
+		return "", nil, ErrInvalidBinaryFile
+	}
+
+	var version, compression uint8
+	for _, field := range []any{&version, &compression} {
+		if err := binary.Read(buffer, binary.LittleEndian, field); err != nil {
+			return "", nil, err
+		}
+	}
+	if version != tickVersion {
+		return "", nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBinaryFile, version)
+	}
+
+	pair, err := readBinaryString(buffer)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var rows uint64
+	if err := binary.Read(buffer, binary.LittleEndian, &rows); err != nil {
+		return "", nil, err
+	}
+	if rows > maxBinaryRows {
+		return "", nil, fmt.Errorf("%w: %d rows", ErrInvalidBinaryFile, rows)
+	}
+
+	var body io.Reader = buffer
+	switch Compression(compression) {
+	case CompressionNone:
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffer)
+		if err != nil {
+			return "", nil, err
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return "", nil, fmt.Errorf("%w: unknown compression %d", ErrInvalidBinaryFile, compression)
+	}
+
+	ids, err := readBinaryColumn[int64](body, int(rows))
+	if err != nil {
+		return "", nil, err
+	}
+
+	times := make([]int64, rows)
+	prices := make([]float64, rows)
+	quantities := make([]float64, rows)
+	makers := make([]uint8, rows)
+	for _, column := range []any{times, prices, quantities, makers} {
+		if err := binary.Read(body, binary.LittleEndian, column); err != nil {
+			return "", nil, err
+		}
+	}
+
+	trades := make([]model.Trade, rows)
+	for i := range trades {
+		trades[i] = model.Trade{
+			ID:           ids[i],
+			Pair:         pair,
+			Time:         time.Unix(0, times[i]).UTC(),
+			Price:        prices[i],
+			Quantity:     quantities[i],
+			IsBuyerMaker: makers[i] == 1,
+		}
+	}
+
+	return pair, trades, nil
+}
+
+// isBinaryTradeFile returns true if the file starts with the binary tick file magic
+func isBinaryTradeFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(tickMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false, nil
+	}
+	return string(magic) == tickMagic, nil
+}
+
+// sliceTradeReader reads trades loaded in memory
+type sliceTradeReader struct {
+	trades []model.Trade
+}
+
+func (r *sliceTradeReader) Read() (model.Trade, error) {
+	if len(r.trades) == 0 {
+		return model.Trade{}, io.EOF
+	}
+
+	trade := r.trades[0]
+	r.trades = r.trades[1:]
+	return trade, nil
+}
+
+func (r *sliceTradeReader) Close() error {
+	return nil
+}
+
+func newTradeReader(tradeFile TradeFile) (tradeReader, error) {
+	isBinary, err := isBinaryTradeFile(tradeFile.File)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBinary {
+		return newCSVTradeReader(tradeFile)
+	}
+
+	file, err := os.Open(tradeFile.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pair, trades, err := ReadBinaryTrades(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tradeFile.File, err)
+	}
+
+	if tradeFile.Pair != "" && tradeFile.Pair != pair {
+		for i := range trades {
+			trades[i].Pair = tradeFile.Pair
+		}
+	}
+	return &sliceTradeReader{trades: trades}, nil
+}
+
+// ReadTrades reads all trades of a CSV or binary tick file
+func ReadTrades(tradeFile TradeFile) ([]model.Trade, error) {
+	reader, err := newTradeReader(tradeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	trades := make([]model.Trade, 0)
+	for {
+		trade, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, trade)
+	}
+
+	return trades, nil
+}
+
+// TradeFeed replays the trades of tick files, CSV files are read lazily
+type TradeFeed struct {
+	Files map[string]TradeFile
+}
+
+// NewTradeFeed creates a trade feed from tick files. The pair of a binary file is read from
+// its header when empty.
+func NewTradeFeed(files ...TradeFile) (*TradeFeed, error) {
+	feed := &TradeFeed{Files: make(map[string]TradeFile)}
+	for _, tradeFile := range files {
+		reader, err := newTradeReader(tradeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if tradeFile.Pair == "" {
+			trade, err := reader.Read()
+			if err != nil {
+				reader.Close()
+				return nil, fmt.Errorf("%s: missing pair", tradeFile.File)
+			}
+			tradeFile.Pair = trade.Pair
+		}
+		reader.Close()
+
+		if tradeFile.Pair == "" {
+			return nil, fmt.Errorf("%s: missing pair", tradeFile.File)
+		}
+		feed.Files[tradeFile.Pair] = tradeFile
+	}
+
+	return feed, nil
+}
+
+func (t *TradeFeed) TradesSubscription(ctx context.Context, pair string) (chan model.Trade, chan error) {
+	ctrade := make(chan model.Trade)
+	cerr := make(chan error, 1)
+	go func() {
+		defer close(cerr)
+		defer close(ctrade)
+
+		tradeFile, ok := t.Files[pair]
+		if !ok {
+			cerr <- fmt.Errorf("%w: %s", ErrInvalidAsset, pair)
+			return
+		}
+
+		reader, err := newTradeReader(tradeFile)
+		if err != nil {
+			cerr <- err
+			return
+		}
+		defer reader.Close()
+
+		for {
+			trade, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				cerr <- err
+				return
+			}
+
+			select {
+			case ctrade <- trade:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctrade, cerr
+}
@@ -0,0 +1,113 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestReadTrades(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("binance dump", func(t *testing.T) {
+		file := filepath.Join(dir, "binance.csv")
+		content := "1,100.5,0.1,10,11,1640995200000,true,true\n" +
+			"2,101,0.2,12,12,1640995200500123,false,true\n"
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+		trades, err := ReadTrades(TradeFile{Pair: "BTCUSDT", File: file})
+		require.NoError(t, err)
+		require.Equal(t, []model.Trade{
+			{ID: 1, Pair: "BTCUSDT", Time: time.UnixMilli(1640995200000).UTC(), Price: 100.5, Quantity: 0.1,
+				IsBuyerMaker: true},
+			{ID: 2, Pair: "BTCUSDT", Time: time.UnixMicro(1640995200500123).UTC(), Price: 101, Quantity: 0.2},
+		}, trades)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		file := filepath.Join(dir, "header.csv")
+		content := "price,qty,transact_time\n100,1,1640995200000\n"
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+		trades, err := ReadTrades(TradeFile{Pair: "BTCUSDT", File: file})
+		require.NoError(t, err)
+		require.Len(t, trades, 1)
+		require.Equal(t, 100.0, trades[0].Price)
+		require.Equal(t, 1.0, trades[0].Quantity)
+
+		require.NoError(t, os.WriteFile(file, []byte("price,qty\n100,1\n"), 0600))
+		_, err = ReadTrades(TradeFile{Pair: "BTCUSDT", File: file})
+		require.Error(t, err)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		trades := []model.Trade{
+			{ID: 1, Pair: "ETHUSDT", Time: start, Price: 10, Quantity: 1, IsBuyerMaker: true},
+			{ID: 2, Pair: "ETHUSDT", Time: start.Add(time.Microsecond), Price: 11, Quantity: 2},
+		}
+
+		for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+			var buffer bytes.Buffer
+			require.NoError(t, WriteBinaryTrades(&buffer, "ETHUSDT", compression, trades))
+
+			file := filepath.Join(dir, "trades.bin")
+			require.NoError(t, os.WriteFile(file, buffer.Bytes(), 0600))
+
+			feed, err := NewTradeFeed(TradeFile{File: file})
+			require.NoError(t, err)
+			require.Contains(t, feed.Files, "ETHUSDT")
+
+			result, err := ReadTrades(feed.Files["ETHUSDT"])
+			require.NoError(t, err)
+			require.Equal(t, trades, result)
+		}
+
+		_, _, err := ReadBinaryTrades(bytes.NewBufferString("batata"))
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+
+		// corrupted rows count after magic, version, compression and pair
+		var buffer bytes.Buffer
+		require.NoError(t, WriteBinaryTrades(&buffer, "ETHUSDT", CompressionNone, trades))
+		rows := buffer.Bytes()[len(tickMagic)+2+2+len("ETHUSDT"):]
+
+		binary.LittleEndian.PutUint64(rows, math.MaxUint64)
+		_, _, err = ReadBinaryTrades(bytes.NewReader(buffer.Bytes()))
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+
+		binary.LittleEndian.PutUint64(rows, math.MaxInt32)
+		_, _, err = ReadBinaryTrades(bytes.NewReader(buffer.Bytes()))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestTradeFeed_TradesSubscription(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trades.csv")
+	content := "id,time,price,quantity\n1,1640995200000,100,1\n2,1640995201000,101,1\n3,1640995202000,102,1\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+
+	feed, err := NewTradeFeed(TradeFile{Pair: "BTCUSDT", File: file})
+	require.NoError(t, err)
+
+	ctrade, cerr := feed.TradesSubscription(context.Background(), "BTCUSDT")
+	trades := make([]model.Trade, 0)
+	for trade := range ctrade {
+		trades = append(trades, trade)
+	}
+	require.NoError(t, <-cerr)
+	require.Len(t, trades, 3)
+	require.Equal(t, int64(3), trades[2].ID)
+
+	_, cerr = feed.TradesSubscription(context.Background(), "ETHUSDT")
+	require.ErrorIs(t, <-cerr, ErrInvalidAsset)
+}
@@ -0,0 +1,333 @@
+package exchange
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+var ErrTimeSeriesOrder = errors.New("time series point out of order")
+
+// TimePoint is a value of an external time series at a time
+type TimePoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// TimeSeries is an external series, eg. an on-chain metric, a sentiment index or a macro series,
+// joined into candle metadata with the given name
+type TimeSeries struct {
+	Name string
+	// Pair of the series, empty for all pairs. A series of a pair replaces a global one of the same name.
+	Pair string
+	// Points sorted by time
+	Points []TimePoint
+	// Delay is the publication lag, the value of a time is only known after the delay, eg. 24h
+	// for daily values published the next day
+	Delay time.Duration
+}
+
+// TimeSeriesFile is a CSV file of external series, with a time column and a column of values of
+// each series. Empty and invalid values are skipped.
+type TimeSeriesFile struct {
+	File string
+	// Pair of the series, empty for all pairs
+	Pair string
+	// Columns loaded as series, default is all columns except the time
+	Columns []string
+	// TimeColumn is the name of the time column, default is the first time alias, eg. time or date
+	TimeColumn string
+	// TimeFormat is a layout of time.Parse or an epoch unit as in CSVSchema, default is detected
+	TimeFormat string
+	// Location is the time zone of times without offset, default is UTC
+	Location *time.Location
+	// Delay is the publication lag of the series
+	Delay time.Duration
+}
+
+// ReadTimeSeries reads the series of a CSV file with header, one series of each value column
+func ReadTimeSeries(file TimeSeriesFile) ([]TimeSeries, error) {
+	f, err := os.Open(file.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.File, err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	timeColumn := file.TimeColumn
+	if timeColumn == "" {
+		var ok bool
+		if timeColumn, ok = findColumn(header, "time"); !ok {
+			return nil, fmt.Errorf("%s: missing time column", file.File)
+		}
+	}
+
+	positions := make(map[string]int)
+	for i, name := range header {
+		positions[name] = i
+	}
+
+	timeIndex, ok := positions[timeColumn]
+	if !ok {
+		return nil, fmt.Errorf("%s: missing column %s", file.File, timeColumn)
+	}
+
+	columns := file.Columns
+	if len(columns) == 0 {
+		for _, name := range header {
+			if name != timeColumn {
+				columns = append(columns, name)
+			}
+		}
+	}
+
+	series := make([]TimeSeries, len(columns))
+	indexes := make([]int, len(columns))
+	for i, name := range columns {
+		if indexes[i], ok = positions[name]; !ok {
+			return nil, fmt.Errorf("%s: missing column %s", file.File, name)
+		}
+		series[i] = TimeSeries{Name: name, Pair: file.Pair, Delay: file.Delay}
+	}
+
+	schema := CSVSchema{TimeFormat: file.TimeFormat, Location: file.Location}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.File, err)
+		}
+		if timeIndex >= len(record) {
+			return nil, fmt.Errorf("%s:%d: missing column %s", file.File, line, timeColumn)
+		}
+
+		if schema.TimeFormat == "" {
+			if schema.TimeFormat, err = detectTimeFormat(record[timeIndex]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file.File, line, err)
+			}
+		}
+
+		t, err := schema.parseTime(record[timeIndex])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file.File, line, err)
+		}
+
+		for i, index := range indexes {
+			if index >= len(record) {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(record[index]), 64)
+			if err != nil {
+				continue
+			}
+			series[i].Points = append(series[i].Points, TimePoint{Time: t, Value: value})
+		}
+	}
+
+	for i := range series {
+		sort.SliceStable(series[i].Points, func(a, b int) bool {
+			return series[i].Points[a].Time.Before(series[i].Points[b].Time)
+		})
+	}
+	return series, nil
+}
+
+// ExternalData joins external time series into candle metadata. Each candle has the last value
+// known when it is received: at the close of complete candles and at the update of partial ones,
+// so there is no look-ahead. Candles without a known value have NaN.
+type ExternalData struct {
+	mtx        sync.RWMutex
+	series     map[string]*TimeSeries
+	names      []string
+	timeframes map[string]timeframe
+}
+
+// NewExternalData creates a join of external series
+func NewExternalData(series ...TimeSeries) (*ExternalData, error) {
+	data := &ExternalData{
+		series:     make(map[string]*TimeSeries),
+		timeframes: make(map[string]timeframe),
+	}
+	if err := data.Register(series...); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// NewExternalDataFromFiles creates a join of the series of CSV files
+func NewExternalDataFromFiles(files ...TimeSeriesFile) (*ExternalData, error) {
+	series := make([]TimeSeries, 0)
+	for _, file := range files {
+		fileSeries, err := ReadTimeSeries(file)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, fileSeries...)
+	}
+	return NewExternalData(series...)
+}
+
+func seriesKey(pair, name string) string {
+	return pair + "--" + name
+}
+
+// Register adds series, replacing the series of the same pair and name
+func (e *ExternalData) Register(series ...TimeSeries) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	for _, s := range series {
+		if s.Name == "" {
+			return errors.New("time series without name")
+		}
+
+		points := make([]TimePoint, len(s.Points))
+		copy(points, s.Points)
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].Time.Before(points[j].Time)
+		})
+		s.Points = points
+
+		if !lo.Contains(e.names, s.Name) {
+			e.names = append(e.names, s.Name)
+		}
+		registered := s
+		e.series[seriesKey(s.Pair, s.Name)] = &registered
+	}
+	return nil
+}
+
+// Append adds a new value to a series, eg. from a live source. The series is created when it does
+// not exist and the time must not be before its last point.
+func (e *ExternalData) Append(pair, name string, t time.Time, value float64) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	series, ok := e.series[seriesKey(pair, name)]
+	if !ok {
+		if name == "" {
+			return errors.New("time series without name")
+		}
+		series = &TimeSeries{Name: name, Pair: pair}
+		e.series[seriesKey(pair, name)] = series
+		if !lo.Contains(e.names, name) {
+			e.names = append(e.names, name)
+		}
+	}
+
+	if len(series.Points) > 0 && t.Before(series.Points[len(series.Points)-1].Time) {
+		return fmt.Errorf("%w: %s %s at %s", ErrTimeSeriesOrder, pair, name, t)
+	}
+	series.Points = append(series.Points, TimePoint{Time: t, Value: value})
+	return nil
+}
+
+// Value returns the last value of a series of the pair known at a time, or false when there is none
+func (e *ExternalData) Value(pair, name string, t time.Time) (float64, bool) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.value(pair, name, t)
+}
+
+func (e *ExternalData) value(pair, name string, t time.Time) (float64, bool) {
+	series, ok := e.series[seriesKey(pair, name)]
+	if !ok {
+		if series, ok = e.series[seriesKey("", name)]; !ok {
+			return 0, false
+		}
+	}
+
+	// first point not known at the time
+	i := sort.Search(len(series.Points), func(i int) bool {
+		return series.Points[i].Time.Add(series.Delay).After(t)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return series.Points[i-1].Value, true
+}
+
+// Join returns the candle with the values of all series in its metadata, the candle metadata
+// is copied
+func (e *ExternalData) Join(candle model.Candle, timeframe string) (model.Candle, error) {
+	known := candle.UpdatedAt
+	if candle.Complete || known.IsZero() {
+		tf, err := e.timeframe(timeframe)
+		if err != nil {
+			return candle, err
+		}
+		known = tf.add(candle.Time, 1)
+	}
+
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	metadata := make(map[string]float64, len(candle.Metadata)+len(e.names))
+	for key, value := range candle.Metadata {
+		metadata[key] = value
+	}
+	for _, name := range e.names {
+		metadata[name] = math.NaN()
+		if value, ok := e.value(candle.Pair, name, known); ok {
+			metadata[name] = value
+		}
+	}
+	candle.Metadata = metadata
+	return candle, nil
+}
+
+func (e *ExternalData) timeframe(value string) (timeframe, error) {
+	e.mtx.RLock()
+	tf, ok := e.timeframes[value]
+	e.mtx.RUnlock()
+	if ok {
+		return tf, nil
+	}
+
+	tf, err := parseTimeframe(value)
+	if err != nil {
+		return timeframe{}, err
+	}
+
+	e.mtx.Lock()
+	e.timeframes[value] = tf
+	e.mtx.Unlock()
+	return tf, nil
+}
+
+// AddMetadata joins the series into complete candles of a timeframe, eg. to download them as CSV
+// columns with download.WithMetadata
+func (e *ExternalData) AddMetadata(_ context.Context, _, timeframe string, candles []model.Candle) error {
+	for i := range candles {
+		candle, err := e.Join(candles[i], timeframe)
+		if err != nil {
+			return err
+		}
+		candles[i].Metadata = candle.Metadata
+	}
+	return nil
+}
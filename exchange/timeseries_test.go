@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestReadTimeSeries(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "macro.csv")
+	err := os.WriteFile(file, []byte("Date,fear_greed,dxy\n"+
+		"2022-01-03,30,96.1\n"+
+		"2022-01-01,25,\n"+
+		"2022-01-02,28,95.9\n"), 0o600)
+	require.NoError(t, err)
+
+	series, err := ReadTimeSeries(TimeSeriesFile{File: file, Pair: "BTCUSDT", Delay: time.Hour})
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, "fear_greed", series[0].Name)
+	require.Equal(t, "BTCUSDT", series[0].Pair)
+	require.Equal(t, time.Hour, series[0].Delay)
+	require.Equal(t, []TimePoint{
+		{Time: day, Value: 25},
+		{Time: day.AddDate(0, 0, 1), Value: 28},
+		{Time: day.AddDate(0, 0, 2), Value: 30},
+	}, series[0].Points)
+
+	// empty values are skipped
+	require.Equal(t, "dxy", series[1].Name)
+	require.Len(t, series[1].Points, 2)
+
+	series, err = ReadTimeSeries(TimeSeriesFile{File: file, Columns: []string{"dxy"}})
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+
+	_, err = ReadTimeSeries(TimeSeriesFile{File: file, Columns: []string{"vix"}})
+	require.Error(t, err)
+}
+
+func TestExternalData_Join(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err := NewExternalData(
+		TimeSeries{Name: "sentiment", Points: []TimePoint{
+			{Time: day.Add(5 * time.Hour), Value: 2},
+			{Time: day.Add(time.Hour), Value: 1},
+		}},
+		TimeSeries{Name: "sentiment", Pair: "ETHUSDT", Points: []TimePoint{{Time: day, Value: 10}}},
+		TimeSeries{Name: "cpi", Delay: 2 * time.Hour, Points: []TimePoint{{Time: day, Value: 7.5}}},
+	)
+	require.NoError(t, err)
+
+	t.Run("as-of without look-ahead", func(t *testing.T) {
+		candle, err := data.Join(model.Candle{Pair: "BTCUSDT", Time: day, Complete: true}, "1h")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, candle.Metadata["sentiment"])
+		require.True(t, math.IsNaN(candle.Metadata["cpi"]))
+
+		candle, err = data.Join(model.Candle{Pair: "BTCUSDT", Time: day.Add(time.Hour), Complete: true}, "1h")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, candle.Metadata["sentiment"])
+		require.Equal(t, 7.5, candle.Metadata["cpi"])
+
+		candle, err = data.Join(model.Candle{Pair: "BTCUSDT", Time: day.Add(4 * time.Hour), Complete: true}, "1h")
+		require.NoError(t, err)
+		require.Equal(t, 2.0, candle.Metadata["sentiment"])
+	})
+
+	t.Run("partial candle", func(t *testing.T) {
+		candle, err := data.Join(model.Candle{
+			Pair: "BTCUSDT", Time: day, UpdatedAt: day.Add(30 * time.Minute),
+		}, "1h")
+		require.NoError(t, err)
+		require.True(t, math.IsNaN(candle.Metadata["sentiment"]))
+	})
+
+	t.Run("pair series", func(t *testing.T) {
+		metadata := map[string]float64{"trades": 3}
+		candle, err := data.Join(model.Candle{Pair: "ETHUSDT", Time: day, Complete: true, Metadata: metadata}, "1d")
+		require.NoError(t, err)
+		require.Equal(t, 10.0, candle.Metadata["sentiment"])
+		require.Equal(t, 3.0, candle.Metadata["trades"])
+		require.Len(t, metadata, 1)
+	})
+
+	t.Run("append", func(t *testing.T) {
+		require.NoError(t, data.Append("", "funding", day.Add(time.Hour), 0.01))
+		require.ErrorIs(t, data.Append("", "funding", day, 0.02), ErrTimeSeriesOrder)
+
+		value, ok := data.Value("BTCUSDT", "funding", day.Add(time.Hour))
+		require.True(t, ok)
+		require.Equal(t, 0.01, value)
+
+		_, ok = data.Value("BTCUSDT", "funding", day)
+		require.False(t, ok)
+	})
+
+	t.Run("add metadata", func(t *testing.T) {
+		candles := []model.Candle{
+			{Pair: "BTCUSDT", Time: day, Complete: true},
+			{Pair: "BTCUSDT", Time: day.Add(4 * time.Hour), Complete: true},
+		}
+		require.NoError(t, data.AddMetadata(context.Background(), "BTCUSDT", "1h", candles))
+		require.Equal(t, 1.0, candles[0].Metadata["sentiment"])
+		require.Equal(t, 2.0, candles[1].Metadata["sentiment"])
+		require.Equal(t, 0.01, candles[1].Metadata["funding"])
+	})
+
+	_, err = NewExternalData(TimeSeries{})
+	require.Error(t, err)
+
+	_, err = data.Join(model.Candle{Time: day, Complete: true}, "invalid")
+	require.Error(t, err)
+}
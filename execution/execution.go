@@ -0,0 +1,562 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+type Algorithm string
+
+const (
+	AlgorithmTWAP    Algorithm = "TWAP"
+	AlgorithmVWAP    Algorithm = "VWAP"
+	AlgorithmIceberg Algorithm = "ICEBERG"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "RUNNING"
+	StatusCompleted Status = "COMPLETED"
+	StatusCanceled  Status = "CANCELED"
+	StatusFailed    Status = "FAILED"
+)
+
+var (
+	ErrInvalidQuantity = errors.New("invalid quantity")
+	ErrInvalidPeriod   = errors.New("invalid execution period")
+	ErrInvalidSlices   = errors.New("invalid number of slices")
+	ErrInvalidPrice    = errors.New("invalid price")
+	ErrOrderNotFound   = errors.New("parent order not found")
+	ErrOrderNotRunning = errors.New("parent order is not running")
+)
+
+// quantityPrecision avoids rounding leftovers when comparing executed and requested quantities
+const quantityPrecision = 1e-9
+
+// ParentOrder is a snapshot of an order executed in multiple child orders
+type ParentOrder struct {
+	ID        int64
+	Algorithm Algorithm
+	Pair      string
+	Side      model.SideType
+	Status    Status
+	Quantity  float64
+	Executed  float64
+	Cost      float64
+	Children  []model.Order
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Err       error
+}
+
+// Progress returns the executed rate of the parent order, between 0 and 1
+func (p ParentOrder) Progress() float64 {
+	return p.Executed / p.Quantity
+}
+
+// AveragePrice returns the average price of the executed child orders
+func (p ParentOrder) AveragePrice() float64 {
+	if p.Executed == 0 {
+		return 0
+	}
+	return p.Cost / p.Executed
+}
+
+func (p ParentOrder) String() string {
+	return fmt.Sprintf("[%s] %s %s %s | ID: %d, %f/%f (%.2f%%) x $%f, %d orders",
+		p.Status, p.Algorithm, p.Side, p.Pair, p.ID, p.Executed, p.Quantity, p.Progress()*100,
+		p.AveragePrice(), len(p.Children))
+}
+
+// slice is a scheduled child order
+type slice struct {
+	At       time.Time
+	Quantity float64
+}
+
+type parentOrder struct {
+	ParentOrder
+
+	// TWAP and VWAP orders
+	schedule []slice
+	sent     float64
+	open     []model.Order
+
+	// Iceberg orders
+	price   float64
+	visible float64
+	working *model.Order
+}
+
+func (p *parentOrder) remaining() float64 {
+	return p.Quantity - p.Executed
+}
+
+func (p *parentOrder) snapshot() ParentOrder {
+	order := p.ParentOrder
+	order.Children = make([]model.Order, len(p.Children))
+	copy(order.Children, p.Children)
+	return order
+}
+
+// Executor slices large orders in child orders sent to a broker over time.
+// The time is driven by candles, so it must receive the candles of the traded pairs in OnCandle.
+// It can be used in backtesting, paper wallet or live trading.
+type Executor struct {
+	mtx      sync.Mutex
+	broker   service.Broker
+	notifier service.Notifier
+	orders   map[int64]*parentOrder
+	lastID   int64
+	lastTime map[string]time.Time
+}
+
+type Option func(*Executor)
+
+// WithNotifier sends the final report of parent orders to a notifier
+func WithNotifier(notifier service.Notifier) Option {
+	return func(e *Executor) {
+		e.notifier = notifier
+	}
+}
+
+// NewExecutor creates an executor of parent orders using the given broker, eg. order.Controller
+func NewExecutor(broker service.Broker, options ...Option) *Executor {
+	executor := &Executor{
+		broker:   broker,
+		orders:   make(map[int64]*parentOrder),
+		lastTime: make(map[string]time.Time),
+	}
+
+	for _, option := range options {
+		option(executor)
+	}
+
+	return executor
+}
+
+func (e *Executor) now(pair string) time.Time {
+	if t, ok := e.lastTime[pair]; ok {
+		return t
+	}
+	return time.Now()
+}
+
+func (e *Executor) add(order *parentOrder) ParentOrder {
+	e.lastID++
+	order.ID = e.lastID
+	order.Status = StatusRunning
+	order.CreatedAt = e.now(order.Pair)
+	order.UpdatedAt = order.CreatedAt
+	e.orders[order.ID] = order
+	log.Infof("[EXECUTION] Starting %s", order.ParentOrder)
+	return order.snapshot()
+}
+
+// TWAP executes a quantity in equal market orders between start and end.
+// The first order is sent at start and the last one at the beginning of the last slice.
+func (e *Executor) TWAP(side model.SideType, pair string, quantity float64, start, end time.Time,
+	slices int) (ParentOrder, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if quantity <= 0 {
+		return ParentOrder{}, ErrInvalidQuantity
+	}
+
+	if !end.After(start) {
+		return ParentOrder{}, ErrInvalidPeriod
+	}
+
+	if slices <= 0 {
+		return ParentOrder{}, ErrInvalidSlices
+	}
+
+	weights := make([]float64, slices)
+	times := make([]time.Time, slices)
+	interval := end.Sub(start) / time.Duration(slices)
+	for i := range weights {
+		weights[i] = 1
+		times[i] = start.Add(interval * time.Duration(i))
+	}
+
+	return e.add(&parentOrder{
+		ParentOrder: ParentOrder{
+			Algorithm: AlgorithmTWAP,
+			Pair:      pair,
+			Side:      side,
+			Quantity:  quantity,
+		},
+		schedule: newSchedule(quantity, times, weights),
+	}), nil
+}
+
+// VWAP executes a quantity in market orders between start and end, one order for each bucket of the
+// volume profile. The size of each order is proportional to the historical volume of its bucket.
+func (e *Executor) VWAP(side model.SideType, pair string, quantity float64, start, end time.Time,
+	profile VolumeProfile) (ParentOrder, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if quantity <= 0 {
+		return ParentOrder{}, ErrInvalidQuantity
+	}
+
+	if !end.After(start) || profile.Bucket <= 0 {
+		return ParentOrder{}, ErrInvalidPeriod
+	}
+
+	var times []time.Time
+	var weights []float64
+	for t := start; t.Before(end); t = t.Add(profile.Bucket) {
+		times = append(times, t)
+		weights = append(weights, profile.Weight(t))
+	}
+
+	return e.add(&parentOrder{
+		ParentOrder: ParentOrder{
+			Algorithm: AlgorithmVWAP,
+			Pair:      pair,
+			Side:      side,
+			Quantity:  quantity,
+		},
+		schedule: newSchedule(quantity, times, weights),
+	}), nil
+}
+
+// Iceberg executes a quantity in limit orders at the given price, showing only the visible size
+// to the market. A new slice is created when the previous one is filled.
+func (e *Executor) Iceberg(side model.SideType, pair string, quantity, price, visible float64) (ParentOrder, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if quantity <= 0 || visible <= 0 {
+		return ParentOrder{}, ErrInvalidQuantity
+	}
+
+	if price <= 0 {
+		return ParentOrder{}, ErrInvalidPrice
+	}
+
+	order := &parentOrder{
+		ParentOrder: ParentOrder{
+			Algorithm: AlgorithmIceberg,
+			Pair:      pair,
+			Side:      side,
+			Quantity:  quantity,
+		},
+		price:   price,
+		visible: visible,
+	}
+
+	e.add(order)
+	e.executeIceberg(order)
+	return order.snapshot(), order.Err
+}
+
+// newSchedule splits a quantity proportionally to the given weights.
+// If all weights are zero, the quantity is split in equal parts.
+func newSchedule(quantity float64, times []time.Time, weights []float64) []slice {
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(len(weights))
+	}
+
+	schedule := make([]slice, 0, len(times))
+	var planned float64
+	for i, t := range times {
+		size := quantity * weights[i] / total
+		// last slice receives the rounding leftovers
+		if i == len(times)-1 {
+			size = quantity - planned
+		}
+
+		if size <= 0 {
+			continue
+		}
+
+		planned += size
+		schedule = append(schedule, slice{At: t, Quantity: size})
+	}
+
+	return schedule
+}
+
+// Order returns the current state of a parent order
+func (e *Executor) Order(id int64) (ParentOrder, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	order, ok := e.orders[id]
+	if !ok {
+		return ParentOrder{}, ErrOrderNotFound
+	}
+	return order.snapshot(), nil
+}
+
+// Orders returns the state of all parent orders
+func (e *Executor) Orders() []ParentOrder {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	orders := make([]ParentOrder, 0, len(e.orders))
+	for id := int64(1); id <= e.lastID; id++ {
+		if order, ok := e.orders[id]; ok {
+			orders = append(orders, order.snapshot())
+		}
+	}
+	return orders
+}
+
+// Cancel stops a running parent order. Executed child orders are kept and the working
+// slice of iceberg orders is canceled, keeping its filled part.
+func (e *Executor) Cancel(id int64) (ParentOrder, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	order, ok := e.orders[id]
+	if !ok {
+		return ParentOrder{}, ErrOrderNotFound
+	}
+
+	if order.Status != StatusRunning {
+		return ParentOrder{}, ErrOrderNotRunning
+	}
+
+	if order.working != nil {
+		err := e.broker.Cancel(*order.working)
+		if err != nil {
+			return ParentOrder{}, err
+		}
+
+		// the slice may be filled, or partially filled, before the cancellation
+		order.Status = StatusCanceled
+		e.updateWorkingOrder(order)
+		order.working = nil
+	}
+
+	// market orders already sent are not canceled, keep what was filled until now
+	order.Status = StatusCanceled
+	e.updateOpenOrders(order)
+
+	e.finish(order, StatusCanceled, nil)
+	return order.snapshot(), nil
+}
+
+// OnCandle sends the scheduled child orders and checks the status of working orders
+func (e *Executor) OnCandle(candle model.Candle) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.lastTime[candle.Pair] = candle.Time
+	for id := int64(1); id <= e.lastID; id++ {
+		order, ok := e.orders[id]
+		if !ok || order.Pair != candle.Pair || order.Status != StatusRunning {
+			continue
+		}
+
+		if order.Algorithm == AlgorithmIceberg {
+			e.executeIceberg(order)
+		} else {
+			e.executeSchedule(order, candle.Time)
+		}
+	}
+}
+
+// executeSchedule sends a market order with all pending slices until the given time.
+// The order is completed when all slices are sent and all child orders are filled.
+func (e *Executor) executeSchedule(order *parentOrder, t time.Time) {
+	e.updateOpenOrders(order)
+	if order.Status != StatusRunning {
+		return
+	}
+
+	if len(order.schedule) == 0 {
+		if len(order.open) == 0 {
+			e.finish(order, StatusCompleted, nil)
+		}
+		return
+	}
+
+	var size float64
+	pending := 0
+	for pending < len(order.schedule) && !order.schedule[pending].At.After(t) {
+		size += order.schedule[pending].Quantity
+		pending++
+	}
+
+	if pending == 0 {
+		return
+	}
+
+	// send the rounding leftovers in the last slice, child orders not filled yet are already counted
+	if pending == len(order.schedule) {
+		size = order.Quantity - order.sent
+	}
+
+	if size > quantityPrecision {
+		child, err := e.broker.CreateOrderMarket(order.Side, order.Pair, size)
+		if err != nil {
+			e.finish(order, StatusFailed, err)
+			return
+		}
+		order.sent += size
+		e.addChild(order, child, size)
+	}
+
+	order.schedule = order.schedule[pending:]
+	order.UpdatedAt = t
+	if len(order.schedule) == 0 && len(order.open) == 0 {
+		e.finish(order, StatusCompleted, nil)
+	}
+}
+
+// updateOpenOrders fetches the child orders of a schedule that were not filled when created
+func (e *Executor) updateOpenOrders(order *parentOrder) {
+	open := order.open[:0]
+	for _, sent := range order.open {
+		child, err := e.broker.Order(order.Pair, sent.ExchangeID)
+		if err != nil {
+			log.WithField("id", sent.ExchangeID).Error("execution/order: ", err)
+			open = append(open, sent)
+			continue
+		}
+
+		e.replaceChild(order, child)
+		switch child.Status {
+		case model.OrderStatusTypeFilled:
+			e.fill(order, child.Quantity, child.Price)
+		case model.OrderStatusTypeCanceled, model.OrderStatusTypeRejected, model.OrderStatusTypeExpired:
+			e.fill(order, filledQuantity(sent, child), child.Price)
+			if order.Status == StatusRunning {
+				e.finish(order, StatusFailed, fmt.Errorf("child order %d %s", child.ExchangeID, child.Status))
+			}
+		default:
+			open = append(open, sent)
+		}
+	}
+	order.open = open
+}
+
+// executeIceberg checks the working slice and creates the next one when it is filled
+func (e *Executor) executeIceberg(order *parentOrder) {
+	if order.working != nil {
+		e.updateWorkingOrder(order)
+		if order.Status != StatusRunning || order.working != nil {
+			return
+		}
+	}
+
+	if order.remaining() <= quantityPrecision {
+		e.finish(order, StatusCompleted, nil)
+		return
+	}
+
+	size := math.Min(order.visible, order.remaining())
+	child, err := e.broker.CreateOrderLimit(order.Side, order.Pair, size, order.price)
+	if err != nil {
+		e.finish(order, StatusFailed, err)
+		return
+	}
+
+	order.working = &child
+	order.Children = append(order.Children, child)
+	order.UpdatedAt = e.now(order.Pair)
+}
+
+// updateWorkingOrder fetches the working slice of an iceberg order
+func (e *Executor) updateWorkingOrder(order *parentOrder) {
+	child, err := e.broker.Order(order.Pair, order.working.ExchangeID)
+	if err != nil {
+		log.WithField("id", order.working.ExchangeID).Error("execution/order: ", err)
+		return
+	}
+
+	switch child.Status {
+	case model.OrderStatusTypeFilled:
+		order.working = nil
+		e.replaceChild(order, child)
+		e.fill(order, child.Quantity, child.Price)
+		order.UpdatedAt = e.now(order.Pair)
+	case model.OrderStatusTypeCanceled, model.OrderStatusTypeRejected, model.OrderStatusTypeExpired:
+		// keep the part filled before the slice was closed
+		e.fill(order, filledQuantity(*order.working, child), child.Price)
+		order.working = nil
+		e.replaceChild(order, child)
+		if order.Status == StatusRunning {
+			e.finish(order, StatusFailed, fmt.Errorf("child order %d %s", child.ExchangeID, child.Status))
+		}
+	}
+}
+
+// addChild registers a child order of a schedule, orders not filled yet are checked in the next candles
+func (e *Executor) addChild(order *parentOrder, child model.Order, size float64) {
+	order.Children = append(order.Children, child)
+	if child.Status == model.OrderStatusTypeFilled {
+		e.fill(order, child.Quantity, child.Price)
+		return
+	}
+
+	sent := child
+	sent.Quantity = size
+	order.open = append(order.open, sent)
+}
+
+func (e *Executor) fill(order *parentOrder, quantity, price float64) {
+	order.Executed += quantity
+	order.Cost += quantity * price
+}
+
+// filledQuantity returns the executed part of a closed child order. Exchanges report the executed
+// quantity of orders with fills and the requested quantity of orders without fills.
+func filledQuantity(sent, closed model.Order) float64 {
+	if closed.Status == model.OrderStatusTypeRejected || closed.Quantity >= sent.Quantity-quantityPrecision {
+		return 0
+	}
+	return closed.Quantity
+}
+
+func (e *Executor) replaceChild(order *parentOrder, child model.Order) {
+	for i := range order.Children {
+		if order.Children[i].ExchangeID == child.ExchangeID {
+			child.ID = order.Children[i].ID
+			order.Children[i] = child
+			return
+		}
+	}
+}
+
+func (e *Executor) finish(order *parentOrder, status Status, err error) {
+	order.Status = status
+	order.Err = err
+	order.UpdatedAt = e.now(order.Pair)
+
+	if err != nil {
+		log.Errorf("[EXECUTION] %s: %v", order.ParentOrder, err)
+		if e.notifier != nil {
+			e.notifier.OnError(fmt.Errorf("execution %d: %w", order.ID, err))
+		}
+		return
+	}
+
+	log.Infof("[EXECUTION] %s", order.ParentOrder)
+	if e.notifier != nil {
+		e.notifier.Notify(order.ParentOrder.String())
+	}
+}
@@ -0,0 +1,272 @@
+package execution
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/testdata/mocks"
+)
+
+func newCandle(t time.Time, price float64) model.Candle {
+	return model.Candle{Pair: "BTCUSDT", Time: t, Close: price, Low: price, High: price, Complete: true}
+}
+
+func TestExecutor_TWAP(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 10000))
+	executor := NewExecutor(wallet)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := executor.TWAP(model.SideTypeBuy, "BTCUSDT", 0, start, start.Add(time.Hour), 4)
+	require.ErrorIs(t, err, ErrInvalidQuantity)
+	_, err = executor.TWAP(model.SideTypeBuy, "BTCUSDT", 1, start, start, 4)
+	require.ErrorIs(t, err, ErrInvalidPeriod)
+	_, err = executor.TWAP(model.SideTypeBuy, "BTCUSDT", 1, start, start.Add(time.Hour), 0)
+	require.ErrorIs(t, err, ErrInvalidSlices)
+
+	order, err := executor.TWAP(model.SideTypeBuy, "BTCUSDT", 4, start, start.Add(time.Hour), 4)
+	require.NoError(t, err)
+	require.Equal(t, StatusRunning, order.Status)
+
+	prices := []float64{100, 200, 300, 400}
+	for i, price := range prices {
+		candle := newCandle(start.Add(15*time.Minute*time.Duration(i)), price)
+		wallet.OnCandle(candle)
+		executor.OnCandle(candle)
+
+		order, err = executor.Order(order.ID)
+		require.NoError(t, err)
+		require.Len(t, order.Children, i+1)
+		require.Equal(t, float64(i+1)/4, order.Progress())
+	}
+
+	require.Equal(t, StatusCompleted, order.Status)
+	require.Equal(t, 4.0, order.Executed)
+	require.Equal(t, 250.0, order.AveragePrice())
+
+	asset, _, err := wallet.Position("BTCUSDT")
+	require.NoError(t, err)
+	require.Equal(t, 4.0, asset)
+}
+
+func TestExecutor_TWAP_CatchUp(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 10000))
+	executor := NewExecutor(wallet)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	order, err := executor.TWAP(model.SideTypeBuy, "BTCUSDT", 4, start, start.Add(time.Hour), 4)
+	require.NoError(t, err)
+
+	// candles before the start are ignored
+	candle := newCandle(start.Add(-time.Minute), 100)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+
+	// pending slices are sent together
+	candle = newCandle(start.Add(30*time.Minute), 100)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Len(t, order.Children, 1)
+	require.Equal(t, 3.0, order.Children[0].Quantity)
+
+	order, err = executor.Cancel(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCanceled, order.Status)
+	require.Equal(t, 3.0, order.Executed)
+
+	_, err = executor.Cancel(order.ID)
+	require.ErrorIs(t, err, ErrOrderNotRunning)
+
+	// canceled orders are not executed
+	candle = newCandle(start.Add(45*time.Minute), 100)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Len(t, order.Children, 1)
+}
+
+func TestExecutor_TWAP_OpenChildren(t *testing.T) {
+	broker := mocks.NewBroker(t)
+	executor := NewExecutor(broker)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	order, err := executor.TWAP(model.SideTypeBuy, "BTCUSDT", 2, start, start.Add(time.Hour), 2)
+	require.NoError(t, err)
+
+	first := model.Order{ExchangeID: 1, Pair: "BTCUSDT", Status: model.OrderStatusTypeNew, Quantity: 1}
+	broker.On("CreateOrderMarket", model.SideTypeBuy, "BTCUSDT", 1.0).Return(first, nil).Once()
+	executor.OnCandle(newCandle(start, 100))
+
+	// the last slice does not repeat the quantity of the child order not filled yet
+	second := model.Order{ExchangeID: 2, Pair: "BTCUSDT", Status: model.OrderStatusTypeFilled, Quantity: 1, Price: 200}
+	broker.On("Order", "BTCUSDT", int64(1)).Return(first, nil).Once()
+	broker.On("CreateOrderMarket", model.SideTypeBuy, "BTCUSDT", 1.0).Return(second, nil).Once()
+	executor.OnCandle(newCandle(start.Add(30*time.Minute), 200))
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRunning, order.Status)
+	require.Equal(t, 1.0, order.Executed)
+
+	filled := first
+	filled.Status = model.OrderStatusTypeFilled
+	filled.Price = 100
+	broker.On("Order", "BTCUSDT", int64(1)).Return(filled, nil).Once()
+	executor.OnCandle(newCandle(start.Add(45*time.Minute), 200))
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, order.Status)
+	require.Equal(t, 2.0, order.Executed)
+	require.Equal(t, 150.0, order.AveragePrice())
+	require.Equal(t, model.OrderStatusTypeFilled, order.Children[0].Status)
+}
+
+func TestExecutor_VWAP(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 10000))
+	executor := NewExecutor(wallet)
+
+	// historical volume: 10% at 00:00, 30% at 01:00, 60% at 02:00
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := []model.Candle{
+		{Time: day, Volume: 10},
+		{Time: day.Add(time.Hour), Volume: 30},
+		{Time: day.Add(2 * time.Hour), Volume: 60},
+	}
+	profile := NewVolumeProfile(history, time.Hour)
+
+	start := day.Add(24 * time.Hour)
+	order, err := executor.VWAP(model.SideTypeSell, "BTCUSDT", 10, start, start.Add(3*time.Hour), profile)
+	require.NoError(t, err)
+
+	wallet.OnCandle(newCandle(start, 100))
+	_, err = wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 10)
+	require.NoError(t, err)
+
+	expected := []float64{1, 3, 6}
+	for i := range expected {
+		candle := newCandle(start.Add(time.Duration(i)*time.Hour), 100)
+		wallet.OnCandle(candle)
+		executor.OnCandle(candle)
+	}
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, order.Status)
+	require.Len(t, order.Children, len(expected))
+	for i, quantity := range expected {
+		require.InDelta(t, quantity, order.Children[i].Quantity, 1e-9)
+	}
+	require.InDelta(t, 10.0, order.Executed, 1e-9)
+}
+
+func TestExecutor_Iceberg(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 10000))
+	executor := NewExecutor(wallet)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	candle := newCandle(start, 110)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+
+	_, err := executor.Iceberg(model.SideTypeBuy, "BTCUSDT", 5, 0, 1)
+	require.ErrorIs(t, err, ErrInvalidPrice)
+
+	order, err := executor.Iceberg(model.SideTypeBuy, "BTCUSDT", 5, 100, 2)
+	require.NoError(t, err)
+	require.Len(t, order.Children, 1)
+	require.Equal(t, 2.0, order.Children[0].Quantity)
+	require.Equal(t, model.OrderTypeLimit, order.Children[0].Type)
+
+	// price does not reach the limit
+	candle = newCandle(start.Add(time.Minute), 105)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Len(t, order.Children, 1)
+
+	// fill first and second slices
+	for i := 2; i < 4; i++ {
+		candle = newCandle(start.Add(time.Duration(i)*time.Minute), 100)
+		wallet.OnCandle(candle)
+		executor.OnCandle(candle)
+	}
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Len(t, order.Children, 3)
+	require.Equal(t, 4.0, order.Executed)
+	require.Equal(t, 1.0, order.Children[2].Quantity)
+
+	// last slice
+	candle = newCandle(start.Add(5*time.Minute), 100)
+	wallet.OnCandle(candle)
+	executor.OnCandle(candle)
+
+	order, err = executor.Order(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, order.Status)
+	require.Equal(t, 5.0, order.Executed)
+	require.Equal(t, 100.0, order.AveragePrice())
+}
+
+func TestExecutor_IcebergCancel(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 1000))
+	executor := NewExecutor(wallet)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	wallet.OnCandle(newCandle(start, 110))
+
+	order, err := executor.Iceberg(model.SideTypeBuy, "BTCUSDT", 5, 100, 2)
+	require.NoError(t, err)
+	account, err := wallet.Account()
+	require.NoError(t, err)
+	_, quote := account.Balance("BTC", "USDT")
+	require.Equal(t, 200.0, quote.Lock)
+
+	order, err = executor.Cancel(order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCanceled, order.Status)
+	require.NoError(t, order.Err)
+	require.Equal(t, model.OrderStatusTypeCanceled, order.Children[0].Status)
+
+	account, err = wallet.Account()
+	require.NoError(t, err)
+	_, quote = account.Balance("BTC", "USDT")
+	require.Equal(t, 1000.0, quote.Free)
+
+	_, err = executor.Order(42)
+	require.ErrorIs(t, err, ErrOrderNotFound)
+
+	t.Run("partially filled slice", func(t *testing.T) {
+		broker := mocks.NewBroker(t)
+		executor := NewExecutor(broker)
+
+		working := model.Order{ExchangeID: 1, Pair: "BTCUSDT", Status: model.OrderStatusTypeNew, Quantity: 2, Price: 100}
+		broker.On("CreateOrderLimit", model.SideTypeBuy, "BTCUSDT", 2.0, 100.0).Return(working, nil)
+		order, err := executor.Iceberg(model.SideTypeBuy, "BTCUSDT", 5, 100, 2)
+		require.NoError(t, err)
+
+		// exchanges report the executed quantity of canceled orders with fills
+		canceled := working
+		canceled.Status = model.OrderStatusTypeCanceled
+		canceled.Quantity = 0.5
+		broker.On("Cancel", working).Return(nil)
+		broker.On("Order", "BTCUSDT", int64(1)).Return(canceled, nil)
+
+		order, err = executor.Cancel(order.ID)
+		require.NoError(t, err)
+		require.Equal(t, StatusCanceled, order.Status)
+		require.Equal(t, 0.5, order.Executed)
+		require.Equal(t, 100.0, order.AveragePrice())
+	})
+}
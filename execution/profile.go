@@ -0,0 +1,57 @@
+package execution
+
+import (
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// VolumeProfile is the historical rate of volume traded in each bucket of the day (UTC)
+type VolumeProfile struct {
+	Bucket  time.Duration
+	Weights map[time.Duration]float64
+}
+
+// NewVolumeProfile calculates the intraday volume profile from historical candles.
+// The bucket is the size of each period of the day, eg. one hour.
+func NewVolumeProfile(candles []model.Candle, bucket time.Duration) VolumeProfile {
+	profile := VolumeProfile{
+		Bucket:  bucket,
+		Weights: make(map[time.Duration]float64),
+	}
+
+	if bucket <= 0 {
+		return profile
+	}
+
+	var total float64
+	for _, candle := range candles {
+		profile.Weights[profile.offset(candle.Time)] += candle.Volume
+		total += candle.Volume
+	}
+
+	if total == 0 {
+		return profile
+	}
+
+	for offset := range profile.Weights {
+		profile.Weights[offset] /= total
+	}
+
+	return profile
+}
+
+// offset returns the start of the bucket of a given time, relative to the start of the day
+func (v VolumeProfile) offset(t time.Time) time.Duration {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return t.Sub(day).Truncate(v.Bucket)
+}
+
+// Weight returns the rate of daily volume traded in the bucket of a given time
+func (v VolumeProfile) Weight(t time.Time) float64 {
+	if v.Bucket <= 0 {
+		return 0
+	}
+	return v.Weights[v.offset(t)]
+}
@@ -0,0 +1,29 @@
+package execution
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestNewVolumeProfile(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	candles := []model.Candle{
+		{Time: day, Volume: 10},
+		{Time: day.Add(30 * time.Minute), Volume: 10},
+		{Time: day.Add(time.Hour), Volume: 20},
+		{Time: day.Add(24 * time.Hour), Volume: 20},
+		{Time: day.Add(25 * time.Hour), Volume: 20},
+	}
+
+	profile := NewVolumeProfile(candles, time.Hour)
+	require.Equal(t, 0.5, profile.Weight(day.Add(48*time.Hour)))
+	require.Equal(t, 0.5, profile.Weight(day.Add(49*time.Hour+10*time.Minute)))
+	require.Equal(t, 0.0, profile.Weight(day.Add(2*time.Hour)))
+
+	require.Equal(t, 0.0, NewVolumeProfile(nil, time.Hour).Weight(day))
+	require.Equal(t, 0.0, NewVolumeProfile(candles, 0).Weight(day))
+}
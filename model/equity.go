@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// AssetValue is the value of an asset or the account in the quote coin at a given time
+type AssetValue struct {
+	Time  time.Time
+	Value float64
+}
+
+// Equity is a persisted snapshot of the account value in the quote coin.
+// Asset is empty for the total equity of the account.
+type Equity struct {
+	ID            int64     `db:"id" json:"id" gorm:"primaryKey,autoIncrement"`
+	Time          time.Time `db:"time" json:"time" gorm:"index"`
+	Asset         string    `db:"asset" json:"asset"`
+	Value         float64   `db:"value" json:"value"`
+	UnrealizedPnL float64   `db:"unrealized_pnl" json:"unrealized_pnl"`
+}
@@ -35,6 +35,7 @@ type AssetInfo struct {
 	MaxQuantity float64
 	StepSize    float64
 	TickSize    float64
+	MinNotional float64
 
 	QuotePrecision     int
 	BaseAssetPrecision int
@@ -54,6 +55,9 @@ type Dataframe struct {
 
 	// Custom user metadata
 	Metadata map[string]Series[float64]
+
+	// OrderBook is the last order book of the pair, nil without an order book feed
+	OrderBook *OrderBook
 }
 
 func (df Dataframe) Sample(positions int) Dataframe {
@@ -73,6 +77,7 @@ func (df Dataframe) Sample(positions int) Dataframe {
 		Time:       df.Time[start:],
 		LastUpdate: df.LastUpdate,
 		Metadata:   make(map[string]Series[float64]),
+		OrderBook:  df.OrderBook,
 	}
 
 	for key := range df.Metadata {
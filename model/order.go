@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -8,6 +9,7 @@ import (
 type SideType string
 type OrderType string
 type OrderStatusType string
+type TimeInForceType string
 
 var (
 	SideTypeBuy  SideType = "BUY"
@@ -20,6 +22,7 @@ var (
 	OrderTypeStopLossLimit   OrderType = "STOP_LOSS_LIMIT"
 	OrderTypeTakeProfit      OrderType = "TAKE_PROFIT"
 	OrderTypeTakeProfitLimit OrderType = "TAKE_PROFIT_LIMIT"
+	OrderTypeTrailingStop    OrderType = "TRAILING_STOP_MARKET"
 
 	OrderStatusTypeNew             OrderStatusType = "NEW"
 	OrderStatusTypePartiallyFilled OrderStatusType = "PARTIALLY_FILLED"
@@ -28,6 +31,17 @@ var (
 	OrderStatusTypePendingCancel   OrderStatusType = "PENDING_CANCEL"
 	OrderStatusTypeRejected        OrderStatusType = "REJECTED"
 	OrderStatusTypeExpired         OrderStatusType = "EXPIRED"
+
+	TimeInForceTypeGTC TimeInForceType = "GTC" // Good Till Cancel
+	TimeInForceTypeIOC TimeInForceType = "IOC" // Immediate or Cancel
+	TimeInForceTypeFOK TimeInForceType = "FOK" // Fill or Kill
+)
+
+var (
+	ErrInvalidOrderType   = errors.New("invalid order type")
+	ErrInvalidOrderPrice  = errors.New("invalid order price")
+	ErrInvalidOrderSize   = errors.New("invalid order size")
+	ErrInvalidTimeInForce = errors.New("invalid time in force")
 )
 
 type Order struct {
@@ -40,6 +54,11 @@ type Order struct {
 	Price      float64         `db:"price" json:"price"`
 	Quantity   float64         `db:"quantity" json:"quantity"`
 
+	TimeInForce TimeInForceType `db:"time_in_force" json:"time_in_force"`
+	ReduceOnly  bool            `db:"reduce_only" json:"reduce_only"`
+	ClientID    string          `db:"client_id" json:"client_id"`
+	Tag         string          `db:"tag" json:"tag"`
+
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 
@@ -47,6 +66,15 @@ type Order struct {
 	Stop    *float64 `db:"stop" json:"stop"`
 	GroupID *int64   `db:"group_id" json:"group_id"`
 
+	// Trailing Stop Orders only
+	Callback        float64  `db:"callback" json:"callback"`
+	CallbackPercent bool     `db:"callback_percent" json:"callback_percent"`
+	ActivationPrice *float64 `db:"activation_price" json:"activation_price"`
+
+	// Replaced Orders only
+	OriginalID    *int64 `db:"original_id" json:"original_id"`
+	ReplacementID *int64 `db:"replacement_id" json:"replacement_id"`
+
 	// Internal use (Plot)
 	RefPrice    float64 `json:"ref_price" gorm:"-"`
 	Profit      float64 `json:"profit" gorm:"-"`
@@ -58,3 +86,131 @@ func (o Order) String() string {
 	return fmt.Sprintf("[%s] %s %s | ID: %d, Type: %s, %f x $%f (~$%.f)",
 		o.Status, o.Side, o.Pair, o.ID, o.Type, o.Quantity, o.Price, o.Quantity*o.Price)
 }
+
+// OrderRequest holds the parameters of a new order. It is created with NewOrderRequest
+// and customized with OrderRequestOption, eg:
+//
+//	request := model.NewOrderRequest(model.SideTypeBuy, "BTCUSDT", 0.1,
+//		model.WithLimitPrice(30000),
+//		model.WithPostOnly(),
+//		model.WithTag("entry"),
+//	)
+type OrderRequest struct {
+	Pair     string
+	Side     SideType
+	Type     OrderType
+	Quantity float64
+	Price    float64
+
+	TimeInForce TimeInForceType
+	PostOnly    bool
+	ReduceOnly  bool
+	ClientID    string
+	Tag         string
+}
+
+type OrderRequestOption func(*OrderRequest)
+
+// NewOrderRequest creates a market order request, unless a limit price is given with WithLimitPrice
+func NewOrderRequest(side SideType, pair string, quantity float64, options ...OrderRequestOption) OrderRequest {
+	request := OrderRequest{
+		Pair:     pair,
+		Side:     side,
+		Type:     OrderTypeMarket,
+		Quantity: quantity,
+	}
+
+	for _, option := range options {
+		option(&request)
+	}
+
+	return request
+}
+
+// WithLimitPrice turns the request into a limit order with the given price
+func WithLimitPrice(price float64) OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.Type = OrderTypeLimit
+		request.Price = price
+	}
+}
+
+// WithTimeInForce sets how long a limit order remains active (GTC, IOC or FOK). Default: GTC
+func WithTimeInForce(timeInForce TimeInForceType) OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.TimeInForce = timeInForce
+	}
+}
+
+// WithPostOnly rejects the limit order if it would immediately match and take liquidity (LIMIT_MAKER)
+func WithPostOnly() OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.PostOnly = true
+	}
+}
+
+// WithReduceOnly ensures the order only reduces the current position (futures only)
+func WithReduceOnly() OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.ReduceOnly = true
+	}
+}
+
+// WithClientID sets a custom order identifier, sent to the exchange when supported
+func WithClientID(id string) OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.ClientID = id
+	}
+}
+
+// WithTag sets a label stored with the order, eg. the strategy signal that created it
+func WithTag(tag string) OrderRequestOption {
+	return func(request *OrderRequest) {
+		request.Tag = tag
+	}
+}
+
+// IsLimit returns true for limit requests, including post-only (LIMIT_MAKER) orders
+func (r OrderRequest) IsLimit() bool {
+	return r.Type == OrderTypeLimit || r.Type == OrderTypeLimitMaker
+}
+
+// IsPostOnly returns true if the order must be rejected when it would take liquidity
+func (r OrderRequest) IsPostOnly() bool {
+	return r.PostOnly || r.Type == OrderTypeLimitMaker
+}
+
+// Validate checks the consistency of request parameters
+func (r OrderRequest) Validate() error {
+	if r.Quantity <= 0 {
+		return ErrInvalidOrderSize
+	}
+
+	switch r.Type {
+	case OrderTypeMarket:
+		if r.PostOnly {
+			return fmt.Errorf("%w: post-only requires a limit order", ErrInvalidOrderType)
+		}
+		if r.TimeInForce != "" {
+			return fmt.Errorf("%w: not supported by market orders", ErrInvalidTimeInForce)
+		}
+	case OrderTypeLimit, OrderTypeLimitMaker:
+		if r.Price <= 0 {
+			return ErrInvalidOrderPrice
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidOrderType, r.Type)
+	}
+
+	switch r.TimeInForce {
+	case "", TimeInForceTypeGTC:
+	case TimeInForceTypeIOC, TimeInForceTypeFOK:
+		if r.IsPostOnly() {
+			return fmt.Errorf("%w: post-only orders must be GTC", ErrInvalidTimeInForce)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidTimeInForce, r.TimeInForce)
+	}
+
+	return nil
+}
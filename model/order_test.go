@@ -22,3 +22,39 @@ func TestOrder_String(t *testing.T) {
 	}
 	require.Equal(t, "[FILLED] SELL BNBUSDT | ID: 1, Type: LIMIT, 1.000000 x $10.000000 (~$10)", order.String())
 }
+
+func TestOrderRequest_Validate(t *testing.T) {
+	t.Run("market", func(t *testing.T) {
+		request := NewOrderRequest(SideTypeBuy, "BTCUSDT", 1, WithClientID("my-id"), WithTag("entry"))
+		require.NoError(t, request.Validate())
+		require.Equal(t, OrderTypeMarket, request.Type)
+		require.Equal(t, "my-id", request.ClientID)
+		require.Equal(t, "entry", request.Tag)
+		require.False(t, request.IsLimit())
+	})
+
+	t.Run("post-only limit", func(t *testing.T) {
+		request := NewOrderRequest(SideTypeSell, "BTCUSDT", 1, WithLimitPrice(100), WithPostOnly())
+		require.NoError(t, request.Validate())
+		require.True(t, request.IsLimit())
+		require.True(t, request.IsPostOnly())
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		request := NewOrderRequest(SideTypeBuy, "BTCUSDT", 0)
+		require.ErrorIs(t, request.Validate(), ErrInvalidOrderSize)
+
+		request = NewOrderRequest(SideTypeBuy, "BTCUSDT", 1, WithPostOnly())
+		require.ErrorIs(t, request.Validate(), ErrInvalidOrderType)
+
+		request = NewOrderRequest(SideTypeBuy, "BTCUSDT", 1, WithTimeInForce(TimeInForceTypeIOC))
+		require.ErrorIs(t, request.Validate(), ErrInvalidTimeInForce)
+
+		request = NewOrderRequest(SideTypeBuy, "BTCUSDT", 1, WithLimitPrice(100), WithPostOnly(),
+			WithTimeInForce(TimeInForceTypeFOK))
+		require.ErrorIs(t, request.Validate(), ErrInvalidTimeInForce)
+
+		request = NewOrderRequest(SideTypeBuy, "BTCUSDT", 1, WithLimitPrice(100), WithTimeInForce("GTD"))
+		require.ErrorIs(t, request.Validate(), ErrInvalidTimeInForce)
+	})
+}
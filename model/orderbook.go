@@ -0,0 +1,122 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// PriceLevel is a price of the order book and its quantity
+type PriceLevel struct {
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+}
+
+// OrderBook is a snapshot of the order book of a pair, bids are sorted by descending price and
+// asks by ascending price
+type OrderBook struct {
+	Pair         string       `json:"pair"`
+	Time         time.Time    `json:"time"`
+	LastUpdateID int64        `json:"last_update_id"`
+	Bids         []PriceLevel `json:"bids"`
+	Asks         []PriceLevel `json:"asks"`
+}
+
+// DepthUpdate is an incremental update of the order book, levels with zero quantity are removed.
+// Update IDs follow the Binance diff depth stream, PrevFinalUpdateID is only set in futures.
+type DepthUpdate struct {
+	Pair              string       `json:"pair"`
+	Time              time.Time    `json:"time"`
+	FirstUpdateID     int64        `json:"first_update_id"`
+	FinalUpdateID     int64        `json:"final_update_id"`
+	PrevFinalUpdateID int64        `json:"prev_final_update_id,omitempty"`
+	Bids              []PriceLevel `json:"bids"`
+	Asks              []PriceLevel `json:"asks"`
+}
+
+// BestBid returns the highest bid, or false when there are no bids
+func (o OrderBook) BestBid() (PriceLevel, bool) {
+	if len(o.Bids) == 0 {
+		return PriceLevel{}, false
+	}
+	return o.Bids[0], true
+}
+
+// BestAsk returns the lowest ask, or false when there are no asks
+func (o OrderBook) BestAsk() (PriceLevel, bool) {
+	if len(o.Asks) == 0 {
+		return PriceLevel{}, false
+	}
+	return o.Asks[0], true
+}
+
+// MidPrice returns the average of the best bid and ask, or zero when a side is empty
+func (o OrderBook) MidPrice() float64 {
+	bid, okBid := o.BestBid()
+	ask, okAsk := o.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+// Spread returns the difference between the best ask and bid, or zero when a side is empty
+func (o OrderBook) Spread() float64 {
+	bid, okBid := o.BestBid()
+	ask, okAsk := o.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return ask.Price - bid.Price
+}
+
+// Depth returns the quantity of the first levels of bids and asks
+func (o OrderBook) Depth(levels int) (bids, asks float64) {
+	for i := 0; i < levels && i < len(o.Bids); i++ {
+		bids += o.Bids[i].Quantity
+	}
+	for i := 0; i < levels && i < len(o.Asks); i++ {
+		asks += o.Asks[i].Quantity
+	}
+	return bids, asks
+}
+
+// Imbalance returns the difference between bid and ask quantity in the first levels, relative to the total,
+// from -1 (only asks) to 1 (only bids)
+func (o OrderBook) Imbalance(levels int) float64 {
+	bids, asks := o.Depth(levels)
+	if bids+asks == 0 {
+		return 0
+	}
+	return (bids - asks) / (bids + asks)
+}
+
+// MarketPrice returns the average price of a market order of a quantity, walking the asks for buys and
+// the bids for sells. The quantity beyond the book depth is priced at the last level. It returns
+// false when the side is empty.
+func (o OrderBook) MarketPrice(side SideType, quantity float64) (float64, bool) {
+	levels := o.Asks
+	if side == SideTypeSell {
+		levels = o.Bids
+	}
+
+	if len(levels) == 0 {
+		return 0, false
+	}
+
+	if quantity <= 0 {
+		return levels[0].Price, true
+	}
+
+	var filled, value float64
+	for _, level := range levels {
+		size := math.Min(level.Quantity, quantity-filled)
+		filled += size
+		value += size * level.Price
+		if filled >= quantity {
+			return value / quantity, true
+		}
+	}
+
+	value += (quantity - filled) * levels[len(levels)-1].Price
+	return value / quantity, true
+}
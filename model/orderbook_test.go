@@ -0,0 +1,58 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderBook(t *testing.T) {
+	book := OrderBook{
+		Pair: "BTCUSDT",
+		Bids: []PriceLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 2}, {Price: 97, Quantity: 5}},
+		Asks: []PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 1}},
+	}
+
+	bid, ok := book.BestBid()
+	require.True(t, ok)
+	require.Equal(t, 99.0, bid.Price)
+	ask, ok := book.BestAsk()
+	require.True(t, ok)
+	require.Equal(t, 101.0, ask.Price)
+	require.Equal(t, 2.0, book.Spread())
+	require.Equal(t, 100.0, book.MidPrice())
+
+	bids, asks := book.Depth(2)
+	require.Equal(t, 3.0, bids)
+	require.Equal(t, 2.0, asks)
+	require.InDelta(t, 0.2, book.Imbalance(2), 1e-9)
+	require.InDelta(t, 6.0/10, book.Imbalance(10), 1e-9)
+
+	t.Run("market price", func(t *testing.T) {
+		price, ok := book.MarketPrice(SideTypeBuy, 0.5)
+		require.True(t, ok)
+		require.Equal(t, 101.0, price)
+
+		// walk two levels
+		price, ok = book.MarketPrice(SideTypeSell, 2)
+		require.True(t, ok)
+		require.Equal(t, 98.5, price)
+
+		// beyond the depth, at the last level
+		price, ok = book.MarketPrice(SideTypeBuy, 4)
+		require.True(t, ok)
+		require.Equal(t, (101.0+102*3)/4, price)
+
+		_, ok = OrderBook{}.MarketPrice(SideTypeBuy, 1)
+		require.False(t, ok)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		empty := OrderBook{}
+		_, ok := empty.BestBid()
+		require.False(t, ok)
+		require.Zero(t, empty.Spread())
+		require.Zero(t, empty.MidPrice())
+		require.Zero(t, empty.Imbalance(5))
+	})
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"fmt"
+	"math"
+)
+
+type PositionSideType string
+type MarginType string
+
+const (
+	PositionSideTypeLong  PositionSideType = "LONG"
+	PositionSideTypeShort PositionSideType = "SHORT"
+
+	MarginTypeIsolated MarginType = "ISOLATED"
+	MarginTypeCrossed  MarginType = "CROSSED"
+)
+
+// Position is the state of an open position in a pair, including futures information.
+// Quantity is always positive, the direction is given by Side.
+type Position struct {
+	Pair             string
+	Side             PositionSideType
+	Quantity         float64
+	EntryPrice       float64
+	MarkPrice        float64
+	Leverage         float64
+	MarginType       MarginType
+	Margin           float64
+	UnrealizedPnL    float64
+	LiquidationPrice float64
+}
+
+// IsOpen returns true if the position has a quantity
+func (p Position) IsOpen() bool {
+	return p.Quantity > 0
+}
+
+// Notional returns the value of the position in the mark price
+func (p Position) Notional() float64 {
+	return p.Quantity * p.MarkPrice
+}
+
+// ROE returns the return on equity of the position, the unrealized PnL relative to the margin
+func (p Position) ROE() float64 {
+	if p.Margin == 0 {
+		return 0
+	}
+	return p.UnrealizedPnL / p.Margin
+}
+
+// LiquidationDistance returns the rate of price change from the mark price to the liquidation price.
+// It returns +Inf if the position can not be liquidated.
+func (p Position) LiquidationDistance() float64 {
+	if p.LiquidationPrice <= 0 || p.MarkPrice <= 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(p.MarkPrice-p.LiquidationPrice) / p.MarkPrice
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s %s | %f x $%f, Mark: $%f, Leverage: %.fx, PnL: %f, Liquidation: $%f",
+		p.Side, p.Pair, p.Quantity, p.EntryPrice, p.MarkPrice, p.Leverage, p.UnrealizedPnL, p.LiquidationPrice)
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPosition(t *testing.T) {
+	position := Position{
+		Pair:             "BTCUSDT",
+		Side:             PositionSideTypeLong,
+		Quantity:         2,
+		EntryPrice:       100,
+		MarkPrice:        110,
+		Leverage:         10,
+		Margin:           20,
+		UnrealizedPnL:    20,
+		LiquidationPrice: 88,
+	}
+
+	require.True(t, position.IsOpen())
+	require.Equal(t, 220.0, position.Notional())
+	require.Equal(t, 1.0, position.ROE())
+	require.InDelta(t, 0.2, position.LiquidationDistance(), 1e-9)
+
+	position = Position{Pair: "BTCUSDT", Leverage: 1}
+	require.False(t, position.IsOpen())
+	require.Equal(t, 0.0, position.ROE())
+	require.Equal(t, math.Inf(1), position.LiquidationDistance())
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Trade is an executed trade of a pair, as published by the exchange in the public trade stream.
+// IsBuyerMaker is true when the taker sold, ie. the trade was a market sell.
+type Trade struct {
+	ID           int64
+	Pair         string
+	Time         time.Time
+	Price        float64
+	Quantity     float64
+	IsBuyerMaker bool
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/aybabtme/uniplot/histogram"
 
@@ -25,6 +26,9 @@ import (
 
 const defaultDatabase = "ninjabot.db"
 
+// shutdownTimeout limits the cancel and close requests sent when the bot is stopped
+const shutdownTimeout = time.Minute
+
 func init() {
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp:   true,
@@ -54,8 +58,15 @@ type NinjaBot struct {
 	orderFeed             *order.Feed
 	dataFeed              *exchange.DataFeedSubscription
 	paperWallet           *exchange.PaperWallet
-
-	backtest bool
+	equityTracker         *order.EquityTracker
+	progressBar           *progressbar.ProgressBar
+	orderBookFeeder       service.OrderBookFeeder
+	orderBooks            chan model.OrderBook
+	externalData          *exchange.ExternalData
+
+	backtest          bool
+	cancelOnShutdown  bool
+	flattenOnShutdown bool
 }
 
 type Option func(*NinjaBot)
@@ -71,6 +82,7 @@ func NewBot(ctx context.Context, settings model.Settings, exch service.Exchange,
 		dataFeed:              exchange.NewDataFeed(exch),
 		strategiesControllers: make(map[string]*strategy.Controller),
 		priorityQueueCandle:   model.NewPriorityQueue(nil),
+		orderBooks:            make(chan model.OrderBook),
 	}
 
 	for _, pair := range settings.Pairs {
@@ -94,8 +106,27 @@ func NewBot(ctx context.Context, settings model.Settings, exch service.Exchange,
 
 	bot.orderController = order.NewController(ctx, exch, bot.storage, bot.orderFeed)
 
+	// equity of simulated runs is provided by paper wallet
+	var equity service.EquityReporter
+	if bot.paperWallet != nil {
+		equity = bot.paperWallet
+	} else if len(settings.Pairs) > 0 {
+		_, quote := exchange.SplitAssetQuote(settings.Pairs[0])
+		var trackerOptions []order.EquityTrackerOption
+		if equityStorage, ok := bot.storage.(storage.EquityStorage); ok {
+			trackerOptions = append(trackerOptions, order.WithEquityStorage(equityStorage))
+		}
+
+		bot.equityTracker, err = order.NewEquityTracker(bot.orderController, quote, trackerOptions...)
+		if err != nil {
+			return nil, err
+		}
+		equity = bot.equityTracker
+	}
+
 	if settings.Telegram.Enabled {
-		bot.telegram, err = notification.NewTelegram(bot.orderController, settings)
+		bot.telegram, err = notification.NewTelegram(bot.orderController, settings,
+			notification.WithEquityReporter(equity))
 		if err != nil {
 			return nil, err
 		}
@@ -139,6 +170,22 @@ func WithNotifier(notifier service.Notifier) Option {
 	}
 }
 
+// WithOrderBook sets a feed of order books, the last book of each pair is available to strategies in
+// Dataframe.OrderBook and used by the paper wallet to price market orders. It is not used in backtests.
+func WithOrderBook(feeder service.OrderBookFeeder) Option {
+	return func(bot *NinjaBot) {
+		bot.orderBookFeeder = feeder
+	}
+}
+
+// WithExternalData joins external time series into the candles of the strategy, the values are
+// available in Dataframe.Metadata with the series names, in backtests and live runs
+func WithExternalData(data *exchange.ExternalData) Option {
+	return func(bot *NinjaBot) {
+		bot.externalData = data
+	}
+}
+
 // WithCandleSubscription subscribes a given struct to the candle feed
 func WithCandleSubscription(subscriber CandleSubscriber) Option {
 	return func(bot *NinjaBot) {
@@ -161,6 +208,21 @@ func (n *NinjaBot) SubscribeCandle(subscriptions ...CandleSubscriber) {
 	}
 }
 
+// WithCancelAllOnShutdown cancels all open orders of the bot pairs when the context is canceled
+func WithCancelAllOnShutdown() Option {
+	return func(bot *NinjaBot) {
+		bot.cancelOnShutdown = true
+	}
+}
+
+// WithFlattenOnShutdown cancels all open orders and closes the positions of the bot pairs
+// at market when the context is canceled
+func WithFlattenOnShutdown() Option {
+	return func(bot *NinjaBot) {
+		bot.flattenOnShutdown = true
+	}
+}
+
 func WithOrderSubscription(subscriber OrderSubscriber) Option {
 	return func(bot *NinjaBot) {
 		bot.SubscribeOrder(subscriber)
@@ -179,6 +241,16 @@ func (n *NinjaBot) Controller() *order.Controller {
 	return n.orderController
 }
 
+// EquityTracker returns the equity tracker of live accounts, it is nil when a paper wallet is used
+func (n *NinjaBot) EquityTracker() *order.EquityTracker {
+	return n.equityTracker
+}
+
+// OrderFeed returns the feed of order updates, to subscribe with filters or read delivery metrics
+func (n *NinjaBot) OrderFeed() *order.Feed {
+	return n.orderFeed
+}
+
 // Summary function displays all trades, accuracy and some bot metrics in stdout
 // To access the raw data, you may access `bot.Controller().Results`
 func (n *NinjaBot) Summary() {
@@ -283,7 +355,26 @@ func (n NinjaBot) SaveReturns(outputDir string) error {
 	return nil
 }
 
+// joinExternalData adds the values of external series to the candle metadata, if any
+func (n *NinjaBot) joinExternalData(candle model.Candle) model.Candle {
+	if n.externalData == nil {
+		return candle
+	}
+
+	joined, err := n.externalData.Join(candle, n.strategy.Timeframe())
+	if err != nil {
+		log.Error("externalData: ", err)
+		return candle
+	}
+	return joined
+}
+
 func (n *NinjaBot) onCandle(candle model.Candle) {
+	candle = n.joinExternalData(candle)
+	if n.backtest {
+		n.backtestCandle(candle)
+		return
+	}
 	n.priorityQueueCandle.Push(candle)
 }
 
@@ -296,38 +387,115 @@ func (n *NinjaBot) processCandle(candle model.Candle) {
 	if candle.Complete {
 		n.strategiesControllers[candle.Pair].OnCandle(candle)
 		n.orderController.OnCandle(candle)
+	} else {
+		n.orderController.OnPartialCandle(candle)
+	}
+}
+
+func (n *NinjaBot) processOrderBook(book model.OrderBook) {
+	if n.paperWallet != nil {
+		n.paperWallet.OnOrderBook(book)
 	}
+	n.strategiesControllers[book.Pair].OnOrderBook(book)
 }
 
-// Process pending candles in buffer
-func (n *NinjaBot) processCandles() {
-	for item := range n.priorityQueueCandle.PopLock() {
-		n.processCandle(item.(model.Candle))
+// subscribeOrderBooks forwards the order books of all pairs to the processing loop
+func (n *NinjaBot) subscribeOrderBooks(ctx context.Context) {
+	for _, pair := range n.settings.Pairs {
+		cbook, cerr := n.orderBookFeeder.OrderBookSubscription(ctx, pair)
+		go func() {
+			for {
+				select {
+				case book, ok := <-cbook:
+					if !ok {
+						return
+					}
+					select {
+					case n.orderBooks <- book:
+					case <-ctx.Done():
+						return
+					}
+				case err, ok := <-cerr:
+					if !ok {
+						cerr = nil
+						continue
+					}
+					log.Error("orderBookSubscription: ", err)
+				}
+			}
+		}()
+	}
+}
+
+// Process pending candles in buffer until the context is canceled
+func (n *NinjaBot) processCandles(ctx context.Context) {
+	candles := n.priorityQueueCandle.PopLock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case book := <-n.orderBooks:
+			n.processOrderBook(book)
+		case item := <-candles:
+			candle := item.(model.Candle)
+			n.processCandle(candle)
+			if n.equityTracker != nil {
+				n.equityTracker.OnCandle(candle)
+			}
+		}
+	}
+}
+
+// shutdown cancels orders or closes positions according to the bot options. The context of the
+// bot is already done, so the exchange requests use a detached context with a timeout.
+func (n *NinjaBot) shutdown(ctx context.Context) {
+	if !n.flattenOnShutdown && !n.cancelOnShutdown {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	if n.flattenOnShutdown {
+		n.orderController.FlattenContext(ctx, n.settings.Pairs...)
+	} else {
+		n.orderController.CancelAllContext(ctx, n.settings.Pairs...)
 	}
 }
 
 // Start the backtest process and create a progress bar
-// backtestCandles will process candles from a prirority queue in chronological order
+// backtestCandles will process candles of all pairs in chronological order, merging the sorted feeds
+// on the fly, so the historical data is not loaded in memory
 func (n *NinjaBot) backtestCandles() {
 	log.Info("[SETUP] Starting backtesting")
 
-	progressBar := progressbar.Default(int64(n.priorityQueueCandle.Len()))
-	for n.priorityQueueCandle.Len() > 0 {
-		item := n.priorityQueueCandle.Pop()
+	total := int64(-1)
+	if count, ok := n.dataFeed.CandleCount(context.Background()); ok {
+		total = int64(count)
+	}
 
-		candle := item.(model.Candle)
-		if n.paperWallet != nil {
-			n.paperWallet.OnCandle(candle)
-		}
+	n.progressBar = progressbar.Default(total)
+	n.dataFeed.StartMerged()
+	if err := n.progressBar.Finish(); err != nil {
+		log.Warnf("update progressbar fail: %v", err)
+	}
+}
 
-		n.strategiesControllers[candle.Pair].OnPartialCandle(candle)
-		if candle.Complete {
-			n.strategiesControllers[candle.Pair].OnCandle(candle)
-		}
+func (n *NinjaBot) backtestCandle(candle model.Candle) {
+	if n.paperWallet != nil {
+		n.paperWallet.OnCandle(candle)
+	}
 
-		if err := progressBar.Add(1); err != nil {
-			log.Warnf("update progressbar fail: %v", err)
-		}
+	n.strategiesControllers[candle.Pair].OnPartialCandle(candle)
+	if candle.Complete {
+		n.strategiesControllers[candle.Pair].OnCandle(candle)
+		n.orderController.OnCandle(candle)
+	} else {
+		n.orderController.OnPartialCandle(candle)
+	}
+
+	if err := n.progressBar.Add(1); err != nil {
+		log.Warnf("update progressbar fail: %v", err)
 	}
 }
 
@@ -344,7 +512,7 @@ func (n *NinjaBot) preload(ctx context.Context, pair string) error {
 	}
 
 	for _, candle := range candles {
-		n.processCandle(candle)
+		n.processCandle(n.joinExternalData(candle))
 	}
 
 	n.dataFeed.Preload(pair, n.strategy.Timeframe(), candles)
@@ -373,20 +541,29 @@ func (n *NinjaBot) Run(ctx context.Context) error {
 
 	// start order feed and controller
 	n.orderFeed.Start()
+	if !n.backtest {
+		// sync storage with orders and balances changed while the bot was offline
+		n.orderController.Reconcile(n.settings.Pairs...)
+	}
 	n.orderController.Start()
 	defer n.orderController.Stop()
 	if n.telegram != nil {
 		n.telegram.Start()
 	}
 
-	// start data feed and receives new candles
-	n.dataFeed.Start(n.backtest)
-
-	// start processing new candles for production or backtesting environment
+	// start data feed and process new candles for production or backtesting environment
 	if n.backtest {
+		if n.orderBookFeeder != nil {
+			log.Warn("[SETUP] Order book feed is not used in backtests")
+		}
 		n.backtestCandles()
 	} else {
-		n.processCandles()
+		n.dataFeed.Start(false)
+		if n.orderBookFeeder != nil {
+			n.subscribeOrderBooks(ctx)
+		}
+		n.processCandles(ctx)
+		n.shutdown(ctx)
 	}
 
 	return nil
@@ -1,16 +1,20 @@
 package ninjabot
 
 import (
+	"time"
+
 	"context"
 	"testing"
 
 	"github.com/rodrigo-brito/ninjabot/strategy"
 
+	"github.com/adshao/go-binance/v2"
 	"github.com/markcheno/go-talib"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/exchange/binancetest"
 	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/storage"
 )
@@ -114,3 +118,216 @@ func TestMarketOrder(t *testing.T) {
 
 	bot.Summary()
 }
+
+func TestMarketOrder_CSVStream(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := storage.FromMemory()
+	require.NoError(t, err)
+
+	strategy := new(fakeStrategy)
+	csvStream, err := exchange.NewCSVStream(
+		strategy.Timeframe(),
+		exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      "testdata/btc-1h.csv",
+			Timeframe: "1h",
+		},
+		exchange.PairFeed{
+			Pair:      "ETHUSDT",
+			File:      "testdata/eth-1h.csv",
+			Timeframe: "1h",
+		},
+	)
+	require.NoError(t, err)
+
+	paperWallet := exchange.NewPaperWallet(
+		ctx,
+		"USDT",
+		exchange.WithPaperAsset("USDT", 10000),
+		exchange.WithDataFeed(csvStream),
+	)
+
+	bot, err := NewBot(ctx, Settings{
+		Pairs: []string{
+			"BTCUSDT",
+			"ETHUSDT",
+		},
+	},
+		paperWallet,
+		strategy,
+		WithStorage(storage),
+		WithBacktest(paperWallet),
+		WithLogLevel(log.ErrorLevel),
+	)
+	require.NoError(t, err)
+	require.NoError(t, bot.Run(ctx))
+
+	// same results of the in-memory feed
+	results := bot.orderController.Results["BTCUSDT"]
+	require.InDelta(t, 5340.224, results.Profit(), 0.001)
+	require.Len(t, results.Win(), 5)
+	require.Len(t, results.Lose(), 3)
+
+	results = bot.orderController.Results["ETHUSDT"]
+	require.InDelta(t, 7590.7381, results.Profit(), 0.001)
+	require.Len(t, results.Win(), 7)
+	require.Len(t, results.Lose(), 9)
+}
+
+func TestMarketOrder_SyntheticFeed(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := storage.FromMemory()
+	require.NoError(t, err)
+
+	strategy := new(fakeStrategy)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	feed, err := exchange.NewSyntheticFeed(strategy.Timeframe(), exchange.SyntheticConfig{
+		Start: start,
+		End:   start.AddDate(0, 6, 0),
+		Seed:  42,
+		Pairs: []exchange.SyntheticPair{
+			{Pair: "BTCUSDT", Price: 40000, Correlation: 0.8},
+			{Pair: "ETHUSDT", Price: 3000, Correlation: 0.8, Crashes: exchange.Jumps{PerYear: 4, Size: 0.2}},
+		},
+	})
+	require.NoError(t, err)
+
+	paperWallet := exchange.NewPaperWallet(
+		ctx,
+		"USDT",
+		exchange.WithPaperAsset("USDT", 10000),
+		exchange.WithDataFeed(feed),
+	)
+
+	bot, err := NewBot(ctx, Settings{
+		Pairs: []string{
+			"BTCUSDT",
+			"ETHUSDT",
+		},
+	},
+		paperWallet,
+		strategy,
+		WithStorage(storage),
+		WithBacktest(paperWallet),
+		WithLogLevel(log.ErrorLevel),
+	)
+	require.NoError(t, err)
+	require.NoError(t, bot.Run(ctx))
+
+	for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+		results := bot.orderController.Results[pair]
+		require.NotEmpty(t, append(results.Win(), results.Lose()...))
+	}
+}
+
+// externalDataStrategy records the external series of each candle
+type externalDataStrategy struct {
+	fakeStrategy
+	times  []time.Time
+	values []float64
+}
+
+func (e *externalDataStrategy) OnCandle(df *Dataframe, _ service.Broker) {
+	e.times = append(e.times, df.Time[len(df.Time)-1])
+	e.values = append(e.values, df.Metadata["published"].Last(0))
+}
+
+func TestExternalData_Backtest(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := storage.FromMemory()
+	require.NoError(t, err)
+
+	strategy := new(externalDataStrategy)
+	csvFeed, err := exchange.NewCSVFeed(strategy.Timeframe(), exchange.PairFeed{
+		Pair:      "BTCUSDT",
+		File:      "testdata/btc-1d.csv",
+		Timeframe: "1d",
+	})
+	require.NoError(t, err)
+
+	// daily values with the time of the value, published one hour later
+	series := exchange.TimeSeries{Name: "published", Delay: time.Hour}
+	start := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	for day := start; day.Before(start.AddDate(0, 2, 0)); day = day.AddDate(0, 0, 1) {
+		series.Points = append(series.Points, exchange.TimePoint{Time: day, Value: float64(day.Unix())})
+	}
+	data, err := exchange.NewExternalData(series)
+	require.NoError(t, err)
+
+	paperWallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 10000),
+		exchange.WithDataFeed(csvFeed))
+
+	bot, err := NewBot(ctx, Settings{Pairs: []string{"BTCUSDT"}}, paperWallet, strategy,
+		WithStorage(storage),
+		WithBacktest(paperWallet),
+		WithExternalData(data),
+		WithLogLevel(log.ErrorLevel),
+	)
+	require.NoError(t, err)
+	require.NoError(t, bot.Run(ctx))
+
+	// at the close of each candle, the value of the next day is not published yet
+	require.NotEmpty(t, strategy.values)
+	for i, value := range strategy.values {
+		require.Equal(t, float64(strategy.times[i].Unix()), value)
+	}
+}
+
+func TestCancelAllOnShutdown(t *testing.T) {
+	server := binancetest.NewServer(
+		binancetest.WithSymbol(binancetest.Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+			MinQuantity: 0.0001, StepSize: 0.0001, TickSize: 0.01}),
+		binancetest.WithBalance("USDT", 10000),
+	)
+	apiURL, wsURL, combinedURL := binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL
+	t.Cleanup(func() {
+		server.Close()
+		binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL = apiURL, wsURL, combinedURL
+	})
+	server.Play("BTCUSDT", 24*time.Hour, 100, 101, 102)
+
+	// the exchange and the bot share the context, as in the examples
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	binanceExchange, err := exchange.NewBinance(ctx,
+		exchange.WithBinanceCredentials(server.APIKey, server.APISecret),
+		exchange.WithCustomMainAPIEndpoint(server.URL, server.WsURL, server.CombinedURL),
+	)
+	require.NoError(t, err)
+
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	bot, err := NewBot(ctx, Settings{Pairs: []string{"BTCUSDT"}}, binanceExchange, new(fakeStrategy),
+		WithStorage(db),
+		WithCancelAllOnShutdown(),
+		WithLogLevel(log.ErrorLevel),
+	)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- bot.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return server.Subscribers("btcusdt@kline_1d") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	order, err := bot.orderController.CreateOrderLimit(SideTypeBuy, "BTCUSDT", 1, 90)
+	require.NoError(t, err)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	order, err = binanceExchange.WithContext(context.Background()).Order("BTCUSDT", order.ExchangeID)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusTypeCanceled, order.Status)
+	_, locked := server.Balance("USDT")
+	require.Zero(t, locked)
+
+	orders, err := db.Orders(storage.WithStatus(OrderStatusTypeCanceled))
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+}
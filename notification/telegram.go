@@ -25,12 +25,20 @@ var (
 type telegram struct {
 	settings        model.Settings
 	orderController *order.Controller
+	equity          service.EquityReporter
 	defaultMenu     *tb.ReplyMarkup
 	client          *tb.Bot
 }
 
 type Option func(telegram *telegram)
 
+// WithEquityReporter enables the /equity command with the equity curve of the account
+func WithEquityReporter(equity service.EquityReporter) Option {
+	return func(telegram *telegram) {
+		telegram.equity = equity
+	}
+}
+
 func NewTelegram(controller *order.Controller, settings model.Settings, options ...Option) (service.Telegram, error) {
 	menu := &tb.ReplyMarkup{ResizeReplyKeyboard: true}
 	poller := &tb.LongPoller{Timeout: 10 * time.Second}
@@ -79,6 +87,10 @@ func NewTelegram(controller *order.Controller, settings model.Settings, options
 		{Text: "/profit", Description: "Summary of last trade results"},
 		{Text: "/buy", Description: "open a buy order"},
 		{Text: "/sell", Description: "open a sell order"},
+		{Text: "/equity", Description: "Account equity, unrealized PnL and drawdown"},
+		{Text: "/reconcile", Description: "Sync orders and balances with exchange"},
+		{Text: "/cancelall", Description: "Cancel all open orders of the bot pairs"},
+		{Text: "/flatten", Description: "Cancel all open orders and close positions of the bot pairs"},
 	})
 	if err != nil {
 		return nil, err
@@ -108,6 +120,10 @@ func NewTelegram(controller *order.Controller, settings model.Settings, options
 	client.Handle("/profit", bot.ProfitHandle)
 	client.Handle("/buy", bot.BuyHandle)
 	client.Handle("/sell", bot.SellHandle)
+	client.Handle("/equity", bot.EquityHandle)
+	client.Handle("/reconcile", bot.ReconcileHandle)
+	client.Handle("/cancelall", bot.CancelAllHandle)
+	client.Handle("/flatten", bot.FlattenHandle)
 
 	return bot, nil
 }
@@ -314,6 +330,76 @@ func (t telegram) SellHandle(m *tb.Message) {
 	log.Info("[TELEGRAM]: SELL ORDER CREATED: ", order)
 }
 
+func (t telegram) EquityHandle(m *tb.Message) {
+	if t.equity == nil || len(t.equity.EquityValues()) == 0 {
+		_, err := t.client.Send(m.Sender, "No equity data")
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	values := t.equity.EquityValues()
+	first, last := values[0], values[len(values)-1]
+	drawdown, start, end := t.equity.MaxDrawdown()
+
+	var sb strings.Builder
+	sb.WriteString("*EQUITY*\n")
+	fmt.Fprintf(&sb, "Value: %.4f\n", last.Value)
+	fmt.Fprintf(&sb, "Change: %.2f%% since %s\n", (last.Value-first.Value)/first.Value*100,
+		first.Time.Format(time.RFC822))
+	if tracker, ok := t.equity.(interface{ UnrealizedPnL() float64 }); ok {
+		fmt.Fprintf(&sb, "Unrealized PnL: %.4f\n", tracker.UnrealizedPnL())
+	}
+	fmt.Fprintf(&sb, "Max Drawdown: %.1f%% (%s - %s)", drawdown*100,
+		start.Format(time.RFC822), end.Format(time.RFC822))
+
+	_, err := t.client.Send(m.Sender, sb.String())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (t telegram) ReconcileHandle(m *tb.Message) {
+	report := t.orderController.Reconcile(t.settings.Pairs...)
+	if !report.IsEmpty() {
+		// report already sent by notifier
+		return
+	}
+
+	_, err := t.client.Send(m.Sender, report.String())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// commandPairs returns the pairs given as arguments of a command, eg. `/flatten BTCUSDT ETHUSDT`,
+// or the pairs of the bot if none is given
+func (t telegram) commandPairs(text string) []string {
+	args := strings.Fields(text)
+	if len(args) <= 1 {
+		return t.settings.Pairs
+	}
+
+	pairs := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		pairs = append(pairs, strings.ToUpper(arg))
+	}
+	return pairs
+}
+
+func (t telegram) CancelAllHandle(m *tb.Message) {
+	// report is sent by notifier
+	report := t.orderController.CancelAll(t.commandPairs(m.Text)...)
+	log.Info("[TELEGRAM]: CANCEL ALL: ", report.IsConfirmed())
+}
+
+func (t telegram) FlattenHandle(m *tb.Message) {
+	// report is sent by notifier
+	report := t.orderController.Flatten(t.commandPairs(m.Text)...)
+	log.Info("[TELEGRAM]: FLATTEN: ", report.IsConfirmed())
+}
+
 func (t telegram) StatusHandle(m *tb.Message) {
 	status := t.orderController.Status()
 	_, err := t.client.Send(m.Sender, fmt.Sprintf("Status: `%s`", status))
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -227,11 +228,16 @@ type Controller struct {
 	notifier       service.Notifier
 	Results        map[string]*summary
 	lastPrice      map[string]float64
+	lastUpdate     map[string]time.Time
 	tickerInterval time.Duration
+	retryDelay     time.Duration
 	finish         chan bool
 	status         Status
 
-	position map[string]*Position
+	position        map[string]*Position
+	positionsLoaded bool
+	baselines       map[string]*balanceBaseline
+	trailingOrders  map[int64]*model.Order
 }
 
 func NewController(ctx context.Context, exchange service.Exchange, storage storage.Storage,
@@ -243,10 +249,14 @@ func NewController(ctx context.Context, exchange service.Exchange, storage stora
 		exchange:       exchange,
 		orderFeed:      orderFeed,
 		lastPrice:      make(map[string]float64),
+		lastUpdate:     make(map[string]time.Time),
 		Results:        make(map[string]*summary),
 		tickerInterval: time.Second,
+		retryDelay:     500 * time.Millisecond,
 		finish:         make(chan bool),
 		position:       make(map[string]*Position),
+		baselines:      make(map[string]*balanceBaseline),
+		trailingOrders: make(map[int64]*model.Order),
 	}
 }
 
@@ -255,11 +265,25 @@ func (c *Controller) SetNotifier(notifier service.Notifier) {
 }
 
 func (c *Controller) OnCandle(candle model.Candle) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
 	c.lastPrice[candle.Pair] = candle.Close
+	c.lastUpdate[candle.Pair] = candle.Time
+	c.updateTrailingOrders(candle.Pair, candle.Low, candle.High, candle.Close, candle.Time)
 }
 
-func (c *Controller) updatePosition(o *model.Order) {
-	// get filled orders before the current order
+// OnPartialCandle updates the trailing stops with the price of a candle still in progress
+func (c *Controller) OnPartialCandle(candle model.Candle) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.lastUpdate[candle.Pair] = candle.Time
+	c.updateTrailingOrders(candle.Pair, candle.Low, candle.High, candle.Close, candle.Time)
+}
+
+// movePosition updates the position of the order pair, the result is returned when a part is closed
+func (c *Controller) movePosition(o *model.Order) *Result {
 	position, ok := c.position[o.Pair]
 	if !ok {
 		c.position[o.Pair] = &Position{
@@ -268,15 +292,39 @@ func (c *Controller) updatePosition(o *model.Order) {
 			CreatedAt: o.CreatedAt,
 			Side:      o.Side,
 		}
-		return
+		return nil
 	}
 
 	result, closed := position.Update(o)
 	if closed {
 		delete(c.position, o.Pair)
 	}
+	return result
+}
+
+// loadPositions rebuilds the positions of the bot from the filled orders in storage, eg. after a restart.
+// Orders imported from the exchange are not part of the positions. The controller mutex must be
+// acquired by the caller.
+func (c *Controller) loadPositions() error {
+	orders, err := c.storage.Orders(storage.WithStatus(model.OrderStatusTypeFilled), isBotOrder)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].UpdatedAt.Before(orders[j].UpdatedAt)
+	})
+
+	c.position = make(map[string]*Position)
+	for _, order := range orders {
+		c.movePosition(order)
+	}
+	c.positionsLoaded = true
+	return nil
+}
 
-	if result != nil {
+func (c *Controller) updatePosition(o *model.Order) {
+	if result := c.movePosition(o); result != nil {
 		// TODO: replace by a slice of Result
 		if result.ProfitPercent >= 0 {
 			if result.Side == model.SideTypeBuy {
@@ -322,7 +370,7 @@ func (c *Controller) notifyError(err error) {
 }
 
 func (c *Controller) processTrade(order *model.Order) {
-	if order.Status != model.OrderStatusTypeFilled {
+	if order.Status != model.OrderStatusTypeFilled || !isBotOrder(*order) {
 		return
 	}
 
@@ -357,6 +405,11 @@ func (c *Controller) updateOrders() {
 	// For each pending order, check for updates
 	var updatedOrders []model.Order
 	for _, order := range orders {
+		// trailing stops are managed locally until triggered
+		if isLocalTrailingStop(*order) {
+			continue
+		}
+
 		excOrder, err := c.exchange.Order(order.Pair, order.ExchangeID)
 		if err != nil {
 			log.WithField("id", order.ExchangeID).Error("orderControler/get: ", err)
@@ -369,6 +422,9 @@ func (c *Controller) updateOrders() {
 		}
 
 		excOrder.ID = order.ID
+		excOrder.Tag = order.Tag
+		excOrder.OriginalID = order.OriginalID
+		excOrder.ReplacementID = order.ReplacementID
 		err = c.storage.UpdateOrder(&excOrder)
 		if err != nil {
 			c.notifyError(err)
@@ -383,6 +439,8 @@ func (c *Controller) updateOrders() {
 		c.processTrade(&processOrder)
 		c.orderFeed.Publish(processOrder, false)
 	}
+
+	c.updateTrailingOrdersFromExchange()
 }
 
 func (c *Controller) Status() Status {
@@ -392,6 +450,16 @@ func (c *Controller) Status() Status {
 func (c *Controller) Start() {
 	if c.status != StatusRunning {
 		c.status = StatusRunning
+		c.mtx.Lock()
+		err := c.loadPositions()
+		c.mtx.Unlock()
+		if err != nil {
+			c.notifyError(err)
+		}
+		if err := c.loadTrailingOrders(); err != nil {
+			c.notifyError(err)
+		}
+
 		go func() {
 			ticker := time.NewTicker(c.tickerInterval)
 			for {
@@ -425,6 +493,10 @@ func (c *Controller) Position(pair string) (asset, quote float64, err error) {
 	return c.exchange.Position(pair)
 }
 
+func (c *Controller) PositionInfo(pair string) (model.Position, error) {
+	return c.exchange.PositionInfo(pair)
+}
+
 func (c *Controller) LastQuote(pair string) (float64, error) {
 	return c.exchange.LastQuote(c.ctx, pair)
 }
@@ -437,10 +509,51 @@ func (c *Controller) PositionValue(pair string) (float64, error) {
 	return asset * c.lastPrice[pair], nil
 }
 
+// unrealizedPnL returns the profit of the open position of a pair at a given price
+func (c *Controller) unrealizedPnL(pair string, price float64) float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	position, ok := c.position[pair]
+	if !ok {
+		return 0
+	}
+
+	if position.Side == model.SideTypeSell {
+		return (position.AvgPrice - price) * position.Quantity
+	}
+	return (price - position.AvgPrice) * position.Quantity
+}
+
 func (c *Controller) Order(pair string, id int64) (model.Order, error) {
 	return c.exchange.Order(pair, id)
 }
 
+// CreateOrder creates an order given a generic request, eg. a post-only limit order or an IOC order
+func (c *Controller) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Infof("[ORDER] Creating %s %s order for %s", request.Type, request.Side, request.Pair)
+	order, err := c.exchange.CreateOrder(request)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	err = c.storage.CreateOrder(&order)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	// calculate profit of orders filled immediately
+	c.processTrade(&order)
+	c.orderFeed.Publish(order, true)
+	log.Infof("[ORDER CREATED] %s", order)
+	return order, nil
+}
+
 func (c *Controller) CreateOrderOCO(side model.SideType, pair string, size, price, stop,
 	stopLimit float64) ([]model.Order, error) {
 	c.mtx.Lock()
@@ -459,7 +572,7 @@ func (c *Controller) CreateOrderOCO(side model.SideType, pair string, size, pric
 			c.notifyError(err)
 			return nil, err
 		}
-		go c.orderFeed.Publish(orders[i], true)
+		c.orderFeed.Publish(orders[i], true)
 	}
 
 	return orders, nil
@@ -481,7 +594,7 @@ func (c *Controller) CreateOrderLimit(side model.SideType, pair string, size, li
 		c.notifyError(err)
 		return model.Order{}, err
 	}
-	go c.orderFeed.Publish(order, true)
+	c.orderFeed.Publish(order, true)
 	log.Infof("[ORDER CREATED] %s", order)
 	return order, nil
 }
@@ -505,7 +618,7 @@ func (c *Controller) CreateOrderMarketQuote(side model.SideType, pair string, am
 
 	// calculate profit
 	c.processTrade(&order)
-	go c.orderFeed.Publish(order, true)
+	c.orderFeed.Publish(order, true)
 	log.Infof("[ORDER CREATED] %s", order)
 	return order, err
 }
@@ -514,8 +627,13 @@ func (c *Controller) CreateOrderMarket(side model.SideType, pair string, size fl
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	return c.createOrderMarket(c.exchange, side, pair, size)
+}
+
+func (c *Controller) createOrderMarket(broker service.Broker, side model.SideType, pair string,
+	size float64) (model.Order, error) {
 	log.Infof("[ORDER] Creating MARKET %s order for %s", side, pair)
-	order, err := c.exchange.CreateOrderMarket(side, pair, size)
+	order, err := broker.CreateOrderMarket(side, pair, size)
 	if err != nil {
 		c.notifyError(err)
 		return model.Order{}, err
@@ -529,7 +647,7 @@ func (c *Controller) CreateOrderMarket(side model.SideType, pair string, size fl
 
 	// calculate profit
 	c.processTrade(&order)
-	go c.orderFeed.Publish(order, true)
+	c.orderFeed.Publish(order, true)
 	log.Infof("[ORDER CREATED] %s", order)
 	return order, err
 }
@@ -550,17 +668,70 @@ func (c *Controller) CreateOrderStop(pair string, size float64, limit float64) (
 		c.notifyError(err)
 		return model.Order{}, err
 	}
-	go c.orderFeed.Publish(order, true)
+	c.orderFeed.Publish(order, true)
 	log.Infof("[ORDER CREATED] %s", order)
 	return order, nil
 }
 
+// ReplaceOrder cancels an open limit order and creates a new one with the given price and quantity,
+// keeping a link between the original and the replacement order
+func (c *Controller) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Infof("[ORDER] Replacing order %d for %s", order.ID, order.Pair)
+	newOrder, err := c.exchange.ReplaceOrder(order, price, quantity)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	newOrder.OriginalID = &order.ID
+	err = c.storage.CreateOrder(&newOrder)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	order.Status = model.OrderStatusTypeCanceled
+	order.ReplacementID = &newOrder.ID
+	err = c.storage.UpdateOrder(&order)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	// calculate profit of orders filled immediately
+	c.processTrade(&newOrder)
+	c.orderFeed.Publish(order, false)
+	c.orderFeed.Publish(newOrder, true)
+	log.Infof("[ORDER REPLACED] %s", newOrder)
+	return newOrder, nil
+}
+
 func (c *Controller) Cancel(order model.Order) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	return c.cancel(c.exchange, order)
+}
+
+func (c *Controller) cancel(broker service.Broker, order model.Order) error {
 	log.Infof("[ORDER] Cancelling order for %s", order.Pair)
-	err := c.exchange.Cancel(order)
+	if isLocalTrailingStop(order) {
+		delete(c.trailingOrders, order.ID)
+		order.Status = model.OrderStatusTypeCanceled
+		err := c.storage.UpdateOrder(&order)
+		if err != nil {
+			c.notifyError(err)
+			return err
+		}
+		c.orderFeed.Publish(order, false)
+		log.Infof("[ORDER CANCELED] %s", order)
+		return nil
+	}
+
+	err := broker.Cancel(order)
 	if err != nil {
 		return err
 	}
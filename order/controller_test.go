@@ -5,10 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/adshao/go-binance/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/exchange/binancetest"
 	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/storage"
 )
@@ -222,3 +224,105 @@ func TestController_Position(t *testing.T) {
 	assert.Equal(t, 1.0, asset)
 	assert.Equal(t, 1500.0, quote)
 }
+
+func TestController_ReplaceOrder(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 900)
+	require.NoError(t, err)
+
+	newOrder, err := controller.ReplaceOrder(order, 950, 2)
+	require.NoError(t, err)
+	require.Equal(t, order.ID, *newOrder.OriginalID)
+	require.Equal(t, 950.0, newOrder.Price)
+	require.Equal(t, 2.0, newOrder.Quantity)
+
+	orders, err := db.Orders()
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	require.Equal(t, model.OrderStatusTypeCanceled, orders[0].Status)
+	require.Equal(t, newOrder.ID, *orders[0].ReplacementID)
+	require.Equal(t, model.OrderStatusTypeNew, orders[1].Status)
+
+	// the link is kept after the replacement is filled
+	candle = model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 940}
+	wallet.OnCandle(candle)
+	controller.updateOrders()
+
+	orders, err = db.Orders(storage.WithStatus(model.OrderStatusTypeFilled))
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, order.ID, *orders[0].OriginalID)
+}
+
+func TestController_FakeBinance(t *testing.T) {
+	server := binancetest.NewServer(
+		binancetest.WithSymbol(binancetest.Symbol{Name: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+			MinQuantity: 0.0001, StepSize: 0.0001, TickSize: 0.01}),
+		binancetest.WithBalance("USDT", 10000),
+	)
+	apiURL, wsURL, combinedURL := binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL
+	t.Cleanup(func() {
+		server.Close()
+		binance.BaseAPIMainURL, binance.BaseWsMainURL, binance.BaseCombinedMainURL = apiURL, wsURL, combinedURL
+	})
+	server.Play("BTCUSDT", time.Minute, 100)
+
+	ctx := context.Background()
+	exchange, err := exchange.NewBinance(ctx,
+		exchange.WithBinanceCredentials(server.APIKey, server.APISecret),
+		exchange.WithCustomMainAPIEndpoint(server.URL, server.WsURL, server.CombinedURL),
+	)
+	require.NoError(t, err)
+
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	controller := NewController(ctx, exchange, db, NewOrderFeed())
+	controller.tickerInterval = 10 * time.Millisecond
+	controller.Start()
+	defer controller.Stop()
+
+	filled := func(count int) func() bool {
+		return func() bool {
+			orders, err := db.Orders(storage.WithStatus(model.OrderStatusTypeFilled))
+			return err == nil && len(orders) == count
+		}
+	}
+
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 90)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusTypeNew, order.Status)
+
+	// the order is filled by the exchange and updated by the polling
+	server.Trade("BTCUSDT", 89, 1)
+	require.Eventually(t, filled(1), time.Second, 10*time.Millisecond)
+
+	controller.mtx.Lock()
+	require.Equal(t, 90.0, controller.position["BTCUSDT"].AvgPrice)
+	require.Equal(t, 1.0, controller.position["BTCUSDT"].Quantity)
+	controller.mtx.Unlock()
+
+	orders, err := controller.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 1, 120, 80, 79)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+
+	server.Trade("BTCUSDT", 121, 1)
+	require.Eventually(t, filled(2), time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		orders, err := db.Orders(storage.WithStatus(model.OrderStatusTypeExpired))
+		return err == nil && len(orders) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	controller.mtx.Lock()
+	defer controller.mtx.Unlock()
+	require.Nil(t, controller.position["BTCUSDT"])
+	require.Equal(t, []float64{30}, controller.Results["BTCUSDT"].WinLong)
+}
@@ -0,0 +1,320 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpillora/backoff"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+// emergencyAttempts is the number of attempts of each exchange operation in CancelAll and Flatten
+const emergencyAttempts = 3
+
+// EmergencyReport is the result of CancelAll and Flatten operations
+type EmergencyReport struct {
+	Canceled  []model.Order
+	Closed    []model.Order
+	Open      []model.Order
+	Positions []model.Position
+	Errors    []error
+}
+
+// IsConfirmed returns true if all orders were canceled and all positions were closed
+func (r EmergencyReport) IsConfirmed() bool {
+	return len(r.Open) == 0 && len(r.Positions) == 0 && len(r.Errors) == 0
+}
+
+func (r EmergencyReport) String() string {
+	var sb strings.Builder
+	if r.IsConfirmed() {
+		sb.WriteString("*EMERGENCY* - confirmed\n")
+	} else {
+		sb.WriteString("*EMERGENCY* - not confirmed\n")
+	}
+
+	fmt.Fprintf(&sb, "Canceled orders: %d\n", len(r.Canceled))
+	fmt.Fprintf(&sb, "Closed positions: %d\n", len(r.Closed))
+	for _, order := range r.Closed {
+		fmt.Fprintf(&sb, "  %s\n", order)
+	}
+	if len(r.Open) > 0 {
+		fmt.Fprintf(&sb, "Open orders: %d\n", len(r.Open))
+		for _, order := range r.Open {
+			fmt.Fprintf(&sb, "  %s\n", order)
+		}
+	}
+	if len(r.Positions) > 0 {
+		fmt.Fprintf(&sb, "Open positions: %d\n", len(r.Positions))
+		for _, position := range r.Positions {
+			fmt.Fprintf(&sb, "  %s\n", position)
+		}
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&sb, "Error: %v\n", err)
+	}
+	return sb.String()
+}
+
+// CancelAll cancels all open orders of the given pairs, or of all pairs if none is given.
+// Each cancellation is retried and confirmed with the exchange.
+func (c *Controller) CancelAll(pairs ...string) EmergencyReport {
+	return c.CancelAllContext(c.ctx, pairs...)
+}
+
+// CancelAllContext is like CancelAll, but the exchange requests are sent with ctx when the
+// exchange supports it, eg. to cancel orders after the context of the bot is done
+func (c *Controller) CancelAllContext(ctx context.Context, pairs ...string) EmergencyReport {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Warn("[EMERGENCY] Canceling all orders")
+	var report EmergencyReport
+	c.cancelAll(c.broker(ctx), pairs, &report)
+	c.notify(report.String())
+	return report
+}
+
+// Flatten cancels all open orders and closes at market the positions opened by the bot in the
+// given pairs, or in all traded pairs if none is given. Balances not bought by the bot are kept and
+// reported as open positions. Positions are loaded from the storage when the controller starts.
+func (c *Controller) Flatten(pairs ...string) EmergencyReport {
+	return c.FlattenContext(c.ctx, pairs...)
+}
+
+// FlattenContext is like Flatten, but the exchange requests are sent with ctx when the
+// exchange supports it, eg. to close positions after the context of the bot is done
+func (c *Controller) FlattenContext(ctx context.Context, pairs ...string) EmergencyReport {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Warn("[EMERGENCY] Closing all positions")
+	var report EmergencyReport
+	if len(pairs) == 0 {
+		var err error
+		pairs, err = c.tradedPairs()
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+	}
+
+	exchange := c.broker(ctx)
+	c.cancelAll(exchange, pairs, &report)
+	for _, pair := range pairs {
+		c.closePosition(exchange, pair, &report)
+	}
+
+	c.notify(report.String())
+	return report
+}
+
+// broker returns the exchange bound to ctx, if supported
+func (c *Controller) broker(ctx context.Context) service.Exchange {
+	if exchange, ok := c.exchange.(service.ContextExchange); ok {
+		return exchange.WithContext(ctx)
+	}
+	return c.exchange
+}
+
+// tradedPairs returns the pairs with orders in storage or open positions
+func (c *Controller) tradedPairs() ([]string, error) {
+	set := make(map[string]bool)
+	for pair := range c.position {
+		set[pair] = true
+	}
+
+	orders, err := c.storage.Orders()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		set[order.Pair] = true
+	}
+
+	pairs := make([]string, 0, len(set))
+	for pair := range set {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return pairs, nil
+}
+
+func (c *Controller) cancelAll(exchange service.Exchange, pairs []string, report *EmergencyReport) {
+	filters := []storage.OrderFilter{storage.WithStatusIn(
+		model.OrderStatusTypeNew,
+		model.OrderStatusTypePartiallyFilled,
+		model.OrderStatusTypePendingCancel,
+	)}
+
+	if len(pairs) > 0 {
+		set := make(map[string]bool)
+		for _, pair := range pairs {
+			set[pair] = true
+		}
+		filters = append(filters, func(order model.Order) bool {
+			return set[order.Pair]
+		})
+	}
+
+	orders, err := c.storage.Orders(filters...)
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+		return
+	}
+
+	for _, order := range orders {
+		if isLocalTrailingStop(*order) {
+			if err := c.cancel(exchange, *order); err != nil {
+				report.Errors = append(report.Errors, err)
+				continue
+			}
+			order.Status = model.OrderStatusTypeCanceled
+			report.Canceled = append(report.Canceled, *order)
+			continue
+		}
+
+		// orders of the same OCO group may be canceled by a previous request
+		cancelErr := c.retry(func() error {
+			if order.Status == model.OrderStatusTypePendingCancel {
+				return nil
+			}
+			return c.cancel(exchange, *order)
+		})
+
+		updated, err := c.syncOrder(exchange, *order)
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+			report.Open = append(report.Open, *order)
+			continue
+		}
+
+		switch {
+		case updated.Status == model.OrderStatusTypeCanceled || updated.Status == model.OrderStatusTypeExpired:
+			report.Canceled = append(report.Canceled, updated)
+		case updated.Status == model.OrderStatusTypeFilled || updated.Status == model.OrderStatusTypeRejected:
+			continue
+		default:
+			if cancelErr != nil {
+				report.Errors = append(report.Errors, cancelErr)
+			}
+			report.Open = append(report.Open, updated)
+		}
+	}
+}
+
+// syncOrder fetches the order status from the exchange and updates the storage if needed
+func (c *Controller) syncOrder(exchange service.Exchange, order model.Order) (model.Order, error) {
+	var excOrder model.Order
+	err := c.retry(func() error {
+		var err error
+		excOrder, err = exchange.Order(order.Pair, order.ExchangeID)
+		return err
+	})
+	if err != nil {
+		return order, err
+	}
+
+	if excOrder.Status == order.Status {
+		return order, nil
+	}
+
+	excOrder.ID = order.ID
+	excOrder.Pair = order.Pair
+	excOrder.Tag = order.Tag
+	excOrder.OriginalID = order.OriginalID
+	excOrder.ReplacementID = order.ReplacementID
+	err = c.storage.UpdateOrder(&excOrder)
+	if err != nil {
+		return order, err
+	}
+
+	c.processTrade(&excOrder)
+	c.orderFeed.Publish(excOrder, false)
+	return excOrder, nil
+}
+
+// closePosition sends a market order to close the position opened by the bot in a pair, positions
+// still open on the exchange, including the ones not opened by the bot, are reported as not confirmed
+func (c *Controller) closePosition(exchange service.Exchange, pair string, report *EmergencyReport) {
+	minQuantity := exchange.AssetsInfo(pair).MinQuantity
+	if position, ok := c.position[pair]; ok {
+		var asset float64
+		err := c.retry(func() error {
+			var err error
+			asset, _, err = exchange.Position(pair)
+			return err
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+			return
+		}
+
+		// the exchange may hold less than tracked, eg. after fees or manual trades
+		side := model.SideTypeSell
+		quantity := math.Min(position.Quantity, asset)
+		if position.Side == model.SideTypeSell {
+			side = model.SideTypeBuy
+			quantity = math.Min(position.Quantity, -asset)
+		}
+
+		// ignore dust balances
+		if quantity > 0 && quantity >= minQuantity {
+			var order model.Order
+			err = c.retry(func() error {
+				var err error
+				order, err = c.createOrderMarket(exchange, side, pair, quantity)
+				return err
+			})
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+			} else {
+				report.Closed = append(report.Closed, order)
+			}
+		}
+	}
+
+	var position model.Position
+	err := c.retry(func() error {
+		var err error
+		position, err = exchange.PositionInfo(pair)
+		return err
+	})
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+		return
+	}
+
+	if math.Abs(position.Quantity) > 0 && math.Abs(position.Quantity) >= minQuantity {
+		report.Positions = append(report.Positions, position)
+	}
+}
+
+// retry executes an operation until it succeeds or the attempts are exhausted
+func (c *Controller) retry(operation func() error) error {
+	ba := &backoff.Backoff{
+		Min: c.retryDelay,
+		Max: 10 * c.retryDelay,
+	}
+
+	var err error
+	for attempt := 1; attempt <= emergencyAttempts; attempt++ {
+		if err = operation(); err == nil {
+			return nil
+		}
+
+		if attempt < emergencyAttempts {
+			log.Warnf("[EMERGENCY] attempt %d failed: %v", attempt, err)
+			time.Sleep(ba.Duration())
+		}
+	}
+	return err
+}
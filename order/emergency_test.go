@@ -0,0 +1,139 @@
+package order
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func TestController_CancelAll(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000),
+		exchange.WithPaperAsset("ETH", 1))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+	controller.retryDelay = time.Millisecond
+
+	for pair, price := range map[string]float64{"BTCUSDT": 1000, "ETHUSDT": 100} {
+		candle := model.Candle{Time: time.Now(), Pair: pair, Close: price}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+	}
+
+	btc, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 900)
+	require.NoError(t, err)
+	_, err = controller.CreateOrderOCO(model.SideTypeSell, "ETHUSDT", 1, 150, 90, 80)
+	require.NoError(t, err)
+
+	t.Run("filter by pair", func(t *testing.T) {
+		report := controller.CancelAll("BTCUSDT")
+		require.True(t, report.IsConfirmed())
+		require.Len(t, report.Canceled, 1)
+		require.Equal(t, btc.ID, report.Canceled[0].ID)
+
+		order, err := db.Orders(storage.WithPair("BTCUSDT"))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, order[0].Status)
+	})
+
+	t.Run("all pairs", func(t *testing.T) {
+		// both orders of the OCO group are canceled in the first request
+		report := controller.CancelAll()
+		require.True(t, report.IsConfirmed())
+		require.Len(t, report.Canceled, 2)
+
+		orders, err := db.Orders(storage.WithStatusIn(model.OrderStatusTypeNew))
+		require.NoError(t, err)
+		require.Empty(t, orders)
+	})
+}
+
+func TestController_Flatten(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000),
+		exchange.WithPaperAsset("BTC", 1))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+	controller.retryDelay = time.Millisecond
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+
+	_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1.5)
+	require.NoError(t, err)
+	_, err = controller.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 0.5, 2000)
+	require.NoError(t, err)
+
+	report := controller.Flatten()
+	require.Len(t, report.Canceled, 1)
+	require.Len(t, report.Closed, 1)
+	require.Equal(t, model.SideTypeSell, report.Closed[0].Side)
+	require.Equal(t, 1.5, report.Closed[0].Quantity)
+
+	// balance not bought by the bot is kept, but reported as open
+	asset, _, err := controller.Position("BTCUSDT")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, asset)
+	require.False(t, report.IsConfirmed())
+	require.Len(t, report.Positions, 1)
+	require.Equal(t, 1.0, report.Positions[0].Quantity)
+
+	// nothing to close
+	report = controller.Flatten("BTCUSDT")
+	require.Empty(t, report.Closed)
+	require.Len(t, report.Positions, 1)
+
+	t.Run("after restart", func(t *testing.T) {
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		wallet.OnCandle(candle)
+		controller := NewController(ctx, wallet, db, NewOrderFeed())
+		controller.retryDelay = time.Millisecond
+		controller.OnCandle(candle)
+
+		_, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		// positions are loaded from the storage
+		restarted := NewController(ctx, wallet, db, NewOrderFeed())
+		restarted.retryDelay = time.Millisecond
+		restarted.Start()
+		defer restarted.Stop()
+
+		report := restarted.Flatten("BTCUSDT")
+		require.True(t, report.IsConfirmed(), report.String())
+		require.Len(t, report.Closed, 1)
+		require.Equal(t, 1.0, report.Closed[0].Quantity)
+	})
+}
+
+func TestController_retry(t *testing.T) {
+	controller := &Controller{retryDelay: time.Millisecond}
+
+	attempts := 0
+	err := controller.retry(func() error {
+		attempts++
+		if attempts < 2 {
+			return exchange.ErrOrderNotOpen
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+
+	attempts = 0
+	err = controller.retry(func() error {
+		attempts++
+		return exchange.ErrOrderNotOpen
+	})
+	require.ErrorIs(t, err, exchange.ErrOrderNotOpen)
+	require.Equal(t, emergencyAttempts, attempts)
+}
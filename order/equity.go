@@ -0,0 +1,207 @@
+package order
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+// EquityTracker records the account value on each complete candle, using the account balances
+// and the last close prices. It provides the same equity information of PaperWallet for live accounts.
+type EquityTracker struct {
+	mtx           sync.RWMutex
+	controller    *Controller
+	storage       storage.EquityStorage
+	quote         string
+	prices        map[string]float64
+	unrealizedPnL float64
+	assetValues   map[string][]model.AssetValue
+	equityValues  []model.AssetValue
+	last          map[string]model.Equity
+}
+
+type EquityTrackerOption func(*EquityTracker)
+
+// WithEquityStorage persists the snapshots and loads the previous history from storage
+func WithEquityStorage(storage storage.EquityStorage) EquityTrackerOption {
+	return func(tracker *EquityTracker) {
+		tracker.storage = storage
+	}
+}
+
+// NewEquityTracker creates an equity tracker for the account of a controller, values are
+// calculated in the given quote coin, eg. USDT.
+func NewEquityTracker(controller *Controller, quote string, options ...EquityTrackerOption) (*EquityTracker, error) {
+	tracker := &EquityTracker{
+		controller:  controller,
+		quote:       strings.ToUpper(quote),
+		prices:      make(map[string]float64),
+		assetValues: make(map[string][]model.AssetValue),
+		last:        make(map[string]model.Equity),
+	}
+
+	for _, option := range options {
+		option(tracker)
+	}
+
+	if tracker.storage != nil {
+		equities, err := tracker.storage.Equities(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, equity := range equities {
+			tracker.add(equity)
+		}
+	}
+
+	return tracker, nil
+}
+
+// add appends a snapshot to the history, replacing the last value with the same time
+func (e *EquityTracker) add(equity model.Equity) {
+	e.last[equity.Asset] = equity
+	value := model.AssetValue{Time: equity.Time, Value: equity.Value}
+	if equity.Asset == "" {
+		e.equityValues = appendValue(e.equityValues, value)
+		e.unrealizedPnL = equity.UnrealizedPnL
+		return
+	}
+	e.assetValues[equity.Asset] = appendValue(e.assetValues[equity.Asset], value)
+}
+
+func appendValue(values []model.AssetValue, value model.AssetValue) []model.AssetValue {
+	if len(values) > 0 && values[len(values)-1].Time.Equal(value.Time) {
+		values[len(values)-1] = value
+		return values
+	}
+	return append(values, value)
+}
+
+// OnCandle updates the price of the candle asset and takes a snapshot of the account
+func (e *EquityTracker) OnCandle(candle model.Candle) {
+	if !candle.Complete {
+		return
+	}
+
+	asset, quote := exchange.SplitAssetQuote(candle.Pair)
+	if quote != e.quote {
+		return
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.prices[asset] = candle.Close
+	if err := e.snapshot(candle.Time); err != nil {
+		log.Error("equityTracker/snapshot: ", err)
+	}
+}
+
+func (e *EquityTracker) snapshot(t time.Time) error {
+	account, err := e.controller.Account()
+	if err != nil {
+		return err
+	}
+
+	total, unrealizedPnL := 0.0, 0.0
+	assets := make([]model.Equity, 0, len(account.Balances))
+	for _, balance := range account.Balances {
+		asset := strings.ToUpper(balance.Asset)
+		amount := balance.Free + balance.Lock
+		if asset == e.quote {
+			total += amount
+			continue
+		}
+
+		price, ok := e.prices[asset]
+		if !ok {
+			continue
+		}
+
+		pair := asset + e.quote
+		if balance.Leverage > 0 {
+			// futures positions are represented by the margin in the quote balance
+			position, err := e.controller.PositionInfo(pair)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pair, err)
+			}
+			total += position.UnrealizedPnL
+			unrealizedPnL += position.UnrealizedPnL
+		} else {
+			total += amount * price
+			unrealizedPnL += e.controller.unrealizedPnL(pair, price)
+		}
+
+		assets = append(assets, model.Equity{Time: t, Asset: asset, Value: amount * price})
+	}
+
+	// pairs closed at the same time replace the previous snapshot with updated prices
+	snapshots := append([]model.Equity{{Time: t, Value: total, UnrealizedPnL: unrealizedPnL}}, assets...)
+	for _, snapshot := range snapshots {
+		if err := e.save(&snapshot); err != nil {
+			return err
+		}
+		e.add(snapshot)
+	}
+	return nil
+}
+
+// save persists a snapshot, updating the stored one of the same asset and time
+func (e *EquityTracker) save(snapshot *model.Equity) error {
+	if e.storage == nil {
+		return nil
+	}
+
+	if last, ok := e.last[snapshot.Asset]; ok && last.Time.Equal(snapshot.Time) {
+		snapshot.ID = last.ID
+		return e.storage.UpdateEquity(snapshot)
+	}
+	return e.storage.CreateEquity(snapshot)
+}
+
+// AssetValues returns the value history of an asset in the quote coin
+func (e *EquityTracker) AssetValues(asset string) []model.AssetValue {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return append([]model.AssetValue(nil), e.assetValues[strings.ToUpper(asset)]...)
+}
+
+// EquityValues returns the history of the account value in the quote coin
+func (e *EquityTracker) EquityValues() []model.AssetValue {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return append([]model.AssetValue(nil), e.equityValues...)
+}
+
+// MaxDrawdown returns the max drawdown rate of the equity curve and the period in which it happened
+func (e *EquityTracker) MaxDrawdown() (float64, time.Time, time.Time) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return exchange.MaxDrawdown(e.equityValues)
+}
+
+// Equity returns the last account value in the quote coin
+func (e *EquityTracker) Equity() float64 {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	if len(e.equityValues) == 0 {
+		return 0
+	}
+	return e.equityValues[len(e.equityValues)-1].Value
+}
+
+// UnrealizedPnL returns the profit of the open positions in the last snapshot
+func (e *EquityTracker) UnrealizedPnL() float64 {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.unrealizedPnL
+}
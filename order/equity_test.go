@@ -0,0 +1,82 @@
+package order
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func TestEquityTracker(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 1000))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	tracker, err := NewEquityTracker(controller, "USDT", WithEquityStorage(db.(storage.EquityStorage)))
+	require.NoError(t, err)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	onCandle := func(pair string, t time.Time, price float64) {
+		candle := model.Candle{Pair: pair, Time: t, Close: price, Complete: true}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		tracker.OnCandle(candle)
+	}
+
+	onCandle("BTCUSDT", start, 100)
+	_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+	require.NoError(t, err)
+
+	onCandle("BTCUSDT", start.Add(time.Hour), 120)
+	require.Equal(t, 1020.0, tracker.Equity())
+	require.Equal(t, 20.0, tracker.UnrealizedPnL())
+
+	// partial candles are ignored
+	tracker.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Hour), Close: 1})
+
+	onCandle("BTCUSDT", start.Add(2*time.Hour), 90)
+	require.Equal(t, 990.0, tracker.Equity())
+	require.Equal(t, -10.0, tracker.UnrealizedPnL())
+
+	// other pairs closed at the same time replace the snapshot
+	onCandle("ETHUSDT", start.Add(2*time.Hour), 10)
+	require.Len(t, tracker.EquityValues(), 3)
+	require.Equal(t, []model.AssetValue{
+		{Time: start.Add(time.Hour), Value: 120},
+		{Time: start.Add(2 * time.Hour), Value: 90},
+	}, tracker.AssetValues("BTC"))
+
+	drawdown, ddStart, ddEnd := tracker.MaxDrawdown()
+	require.InDelta(t, -30.0/1020, drawdown, 1e-9)
+	require.Equal(t, start.Add(time.Hour), ddStart)
+	require.Equal(t, start.Add(2*time.Hour), ddEnd)
+
+	// the returned history is not changed by new snapshots
+	values := tracker.EquityValues()
+	onCandle("BTCUSDT", start.Add(2*time.Hour), 80)
+	require.Equal(t, 990.0, values[len(values)-1].Value)
+	require.Equal(t, 980.0, tracker.Equity())
+
+	// snapshots at the same time update the stored rows
+	equities, err := db.(storage.EquityStorage).Equities(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, equities, 5)
+
+	t.Run("load from storage", func(t *testing.T) {
+		loaded, err := NewEquityTracker(controller, "USDT", WithEquityStorage(db.(storage.EquityStorage)))
+		require.NoError(t, err)
+		require.Len(t, loaded.EquityValues(), 3)
+		for i, value := range tracker.EquityValues() {
+			require.True(t, value.Time.Equal(loaded.EquityValues()[i].Time))
+			require.Equal(t, value.Value, loaded.EquityValues()[i].Value)
+		}
+		require.Equal(t, -20.0, loaded.UnrealizedPnL())
+	})
+}
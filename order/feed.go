@@ -1,61 +1,363 @@
 package order
 
 import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
 	"github.com/rodrigo-brito/ninjabot/model"
 )
 
-type DataFeed struct {
-	Data chan model.Order
-	Err  chan error
-}
+const (
+	defaultFeedBufferSize    = 1000
+	defaultFeedSlowThreshold = time.Second
+)
 
 type FeedConsumer func(order model.Order)
 
+// Feed delivers order updates to subscribers. Each pair has an ordered queue that never blocks
+// the publisher, and each subscription consumes its own buffered queue, so a slow consumer
+// does not delay the other subscribers. Updates to a full subscription queue are dropped.
 type Feed struct {
-	OrderFeeds            map[string]*DataFeed
-	SubscriptionsBySymbol map[string][]Subscription
+	mtx           sync.RWMutex
+	bufferSize    int
+	slowThreshold time.Duration
+	started       bool
+	lastID        int64
+	queues        map[string]*pairQueue
+	subscriptions map[string][]*Subscription
+
+	published int64
+	dropped   int64
+	unrouted  int64
+}
+
+type feedEvent struct {
+	order    model.Order
+	newOrder bool
+}
+
+// pairQueue is an unbounded FIFO queue of order updates of a pair
+type pairQueue struct {
+	mtx    sync.Mutex
+	events []feedEvent
+	signal chan struct{}
+}
+
+func newPairQueue() *pairQueue {
+	return &pairQueue{signal: make(chan struct{}, 1)}
+}
+
+func (q *pairQueue) push(event feedEvent) {
+	q.mtx.Lock()
+	q.events = append(q.events, event)
+	q.mtx.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *pairQueue) pop() []feedEvent {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	events := q.events
+	q.events = nil
+	return events
+}
+
+func (q *pairQueue) len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.events)
 }
 
+type FeedOption func(*Feed)
+
+// WithFeedBufferSize sets the capacity of subscription queues, default is 1000
+func WithFeedBufferSize(size int) FeedOption {
+	return func(feed *Feed) {
+		feed.bufferSize = size
+	}
+}
+
+// WithFeedSlowThreshold sets the time after which a consumer call is counted as slow, default is 1s
+func WithFeedSlowThreshold(threshold time.Duration) FeedOption {
+	return func(feed *Feed) {
+		feed.slowThreshold = threshold
+	}
+}
+
+func NewOrderFeed(options ...FeedOption) *Feed {
+	feed := &Feed{
+		bufferSize:    defaultFeedBufferSize,
+		slowThreshold: defaultFeedSlowThreshold,
+		queues:        make(map[string]*pairQueue),
+		subscriptions: make(map[string][]*Subscription),
+	}
+
+	for _, option := range options {
+		option(feed)
+	}
+
+	return feed
+}
+
+// Subscription is a consumer of order updates of a pair
 type Subscription struct {
+	ID   int64
+	Pair string
+
 	onlyNewOrder bool
+	status       map[model.OrderStatusType]bool
+	sides        map[model.SideType]bool
 	consumer     FeedConsumer
+	queue        chan model.Order
+	done         chan struct{}
+	closeOnce    sync.Once
+
+	delivered int64
+	dropped   int64
+	slow      int64
 }
 
-func NewOrderFeed() *Feed {
-	return &Feed{
-		OrderFeeds:            make(map[string]*DataFeed),
-		SubscriptionsBySymbol: make(map[string][]Subscription),
+type SubscriptionOption func(*Subscription)
+
+// WithStatusFilter delivers only orders with one of the given status
+func WithStatusFilter(status ...model.OrderStatusType) SubscriptionOption {
+	return func(s *Subscription) {
+		for _, st := range status {
+			s.status[st] = true
+		}
 	}
 }
 
-func (d *Feed) Subscribe(pair string, consumer FeedConsumer, onlyNewOrder bool) {
-	if _, ok := d.OrderFeeds[pair]; !ok {
-		d.OrderFeeds[pair] = &DataFeed{
-			Data: make(chan model.Order),
-			Err:  make(chan error),
+// WithSideFilter delivers only orders of the given side
+func WithSideFilter(sides ...model.SideType) SubscriptionOption {
+	return func(s *Subscription) {
+		for _, side := range sides {
+			s.sides[side] = true
 		}
 	}
+}
+
+func (s *Subscription) match(event feedEvent) bool {
+	if s.onlyNewOrder && !event.newOrder {
+		return false
+	}
+
+	if len(s.status) > 0 && !s.status[event.order.Status] {
+		return false
+	}
+
+	if len(s.sides) > 0 && !s.sides[event.order.Side] {
+		return false
+	}
+
+	return true
+}
+
+func (s *Subscription) run(slowThreshold time.Duration) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case order := <-s.queue:
+			start := time.Now()
+			s.consumer(order)
+			atomic.AddInt64(&s.delivered, 1)
+			if elapsed := time.Since(start); elapsed > slowThreshold {
+				atomic.AddInt64(&s.slow, 1)
+				log.Warnf("[ORDER FEED] slow consumer %d for %s: %s", s.ID, s.Pair, elapsed)
+			}
+		}
+	}
+}
 
-	d.SubscriptionsBySymbol[pair] = append(d.SubscriptionsBySymbol[pair], Subscription{
+// Subscribe registers a consumer for order updates of a pair, it can be called before or after Start.
+// If onlyNewOrder is true, only orders created by the bot are delivered.
+func (d *Feed) Subscribe(pair string, consumer FeedConsumer, onlyNewOrder bool,
+	options ...SubscriptionOption) *Subscription {
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.lastID++
+	subscription := &Subscription{
+		ID:           d.lastID,
+		Pair:         pair,
 		onlyNewOrder: onlyNewOrder,
+		status:       make(map[model.OrderStatusType]bool),
+		sides:        make(map[model.SideType]bool),
 		consumer:     consumer,
-	})
+		queue:        make(chan model.Order, d.bufferSize),
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(subscription)
+	}
+
+	d.queue(pair)
+	d.subscriptions[pair] = append(d.subscriptions[pair], subscription)
+	go subscription.run(d.slowThreshold)
+	return subscription
 }
 
-func (d *Feed) Publish(order model.Order, _ bool) {
-	if _, ok := d.OrderFeeds[order.Pair]; ok {
-		d.OrderFeeds[order.Pair].Data <- order
+// Unsubscribe removes a subscription, pending orders of the subscription are discarded
+func (d *Feed) Unsubscribe(subscription *Subscription) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	current := d.subscriptions[subscription.Pair]
+	subscriptions := make([]*Subscription, 0, len(current))
+	for _, s := range current {
+		if s != subscription {
+			subscriptions = append(subscriptions, s)
+		}
 	}
+	d.subscriptions[subscription.Pair] = subscriptions
+
+	subscription.closeOnce.Do(func() {
+		close(subscription.done)
+	})
 }
 
+// Publish enqueues an order update without blocking. Updates of the same pair are delivered
+// in the publishing order.
+func (d *Feed) Publish(order model.Order, newOrder bool) {
+	d.mtx.Lock()
+	queue := d.queue(order.Pair)
+	d.mtx.Unlock()
+
+	atomic.AddInt64(&d.published, 1)
+	queue.push(feedEvent{order: order, newOrder: newOrder})
+}
+
+// Start the delivery of order updates, updates published before are kept in queue
 func (d *Feed) Start() {
-	for pair := range d.OrderFeeds {
-		go func(pair string, feed *DataFeed) {
-			for order := range feed.Data {
-				for _, subscription := range d.SubscriptionsBySymbol[pair] {
-					subscription.consumer(order)
-				}
-			}
-		}(pair, d.OrderFeeds[pair])
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.started {
+		return
+	}
+
+	d.started = true
+	for pair, queue := range d.queues {
+		go d.dispatch(pair, queue)
+	}
+}
+
+// queue returns the queue of a pair, creating it if needed. It must be called with the lock held.
+func (d *Feed) queue(pair string) *pairQueue {
+	queue, ok := d.queues[pair]
+	if !ok {
+		queue = newPairQueue()
+		d.queues[pair] = queue
+		if d.started {
+			go d.dispatch(pair, queue)
+		}
+	}
+	return queue
+}
+
+func (d *Feed) dispatch(pair string, queue *pairQueue) {
+	for range queue.signal {
+		for _, event := range queue.pop() {
+			d.deliver(pair, event)
+		}
 	}
 }
+
+func (d *Feed) deliver(pair string, event feedEvent) {
+	d.mtx.RLock()
+	subscriptions := d.subscriptions[pair]
+	d.mtx.RUnlock()
+
+	routed := false
+	for _, subscription := range subscriptions {
+		if !subscription.match(event) {
+			continue
+		}
+
+		routed = true
+		select {
+		case subscription.queue <- event.order:
+		default:
+			atomic.AddInt64(&subscription.dropped, 1)
+			atomic.AddInt64(&d.dropped, 1)
+			log.Warnf("[ORDER FEED] consumer %d for %s is full, order %d dropped",
+				subscription.ID, pair, event.order.ID)
+		}
+	}
+
+	if !routed {
+		atomic.AddInt64(&d.unrouted, 1)
+		log.Debugf("[ORDER FEED] no subscriber for order %d of %s", event.order.ID, pair)
+	}
+}
+
+// FeedMetrics is a snapshot of the order feed counters
+type FeedMetrics struct {
+	// Published is the number of published updates
+	Published int64
+	// Pending is the number of updates waiting for dispatch
+	Pending int
+	// Dropped is the number of updates dropped by slow consumers
+	Dropped int64
+	// Unrouted is the number of updates without a matching subscription
+	Unrouted      int64
+	Subscriptions []SubscriptionMetrics
+}
+
+// SubscriptionMetrics is a snapshot of the counters of a subscription
+type SubscriptionMetrics struct {
+	ID        int64
+	Pair      string
+	Pending   int
+	Delivered int64
+	// Dropped is the number of updates dropped due to a full subscription queue
+	Dropped int64
+	// Slow is the number of consumer calls slower than the feed threshold
+	Slow int64
+}
+
+// Metrics returns the counters of the feed and its subscriptions
+func (d *Feed) Metrics() FeedMetrics {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	metrics := FeedMetrics{
+		Published: atomic.LoadInt64(&d.published),
+		Dropped:   atomic.LoadInt64(&d.dropped),
+		Unrouted:  atomic.LoadInt64(&d.unrouted),
+	}
+
+	for _, queue := range d.queues {
+		metrics.Pending += queue.len()
+	}
+
+	for _, subscriptions := range d.subscriptions {
+		for _, s := range subscriptions {
+			metrics.Subscriptions = append(metrics.Subscriptions, SubscriptionMetrics{
+				ID:        s.ID,
+				Pair:      s.Pair,
+				Pending:   len(s.queue),
+				Delivered: atomic.LoadInt64(&s.delivered),
+				Dropped:   atomic.LoadInt64(&s.dropped),
+				Slow:      atomic.LoadInt64(&s.slow),
+			})
+		}
+	}
+
+	sort.Slice(metrics.Subscriptions, func(i, j int) bool {
+		return metrics.Subscriptions[i].ID < metrics.Subscriptions[j].ID
+	})
+	return metrics
+}
@@ -1,7 +1,9 @@
 package order
 
 import (
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -25,3 +27,142 @@ func TestFeed_Subscribe(t *testing.T) {
 	feed.Publish(model.Order{Pair: pair}, false)
 	require.True(t, <-called)
 }
+
+func TestFeed_Order(t *testing.T) {
+	feed, pair := NewOrderFeed(), "BTCUSDT"
+	received := make(chan model.Order, 100)
+	feed.Subscribe(pair, func(order model.Order) {
+		received <- order
+	}, false)
+
+	// updates published before start are kept in queue
+	feed.Publish(model.Order{ID: 1, Pair: pair, Status: model.OrderStatusTypeNew}, true)
+	feed.Start()
+	for i := int64(2); i <= 50; i++ {
+		feed.Publish(model.Order{ID: i, Pair: pair, Status: model.OrderStatusTypeFilled}, false)
+	}
+
+	for i := int64(1); i <= 50; i++ {
+		require.Equal(t, i, (<-received).ID)
+	}
+}
+
+func TestFeed_SubscribeAfterStart(t *testing.T) {
+	feed := NewOrderFeed()
+	feed.Start()
+
+	called := make(chan model.Order, 1)
+	feed.Subscribe("ETHUSDT", func(order model.Order) {
+		called <- order
+	}, false)
+
+	feed.Publish(model.Order{ID: 1, Pair: "ETHUSDT"}, false)
+	require.Equal(t, int64(1), (<-called).ID)
+}
+
+func TestFeed_Filters(t *testing.T) {
+	feed, pair := NewOrderFeed(), "BTCUSDT"
+	filled := make(chan model.Order, 10)
+	feed.Subscribe(pair, func(order model.Order) {
+		filled <- order
+	}, false, WithStatusFilter(model.OrderStatusTypeFilled), WithSideFilter(model.SideTypeSell))
+
+	created := make(chan model.Order, 10)
+	feed.Subscribe(pair, func(order model.Order) {
+		created <- order
+	}, true)
+
+	feed.Start()
+	feed.Publish(model.Order{ID: 1, Pair: pair, Side: model.SideTypeSell, Status: model.OrderStatusTypeNew}, true)
+	feed.Publish(model.Order{ID: 2, Pair: pair, Side: model.SideTypeBuy, Status: model.OrderStatusTypeFilled}, false)
+	feed.Publish(model.Order{ID: 3, Pair: pair, Side: model.SideTypeSell, Status: model.OrderStatusTypeFilled}, false)
+
+	require.Equal(t, int64(3), (<-filled).ID)
+	require.Equal(t, int64(1), (<-created).ID)
+
+	require.Eventually(t, func() bool {
+		return feed.Metrics().Unrouted == 1
+	}, time.Second, time.Millisecond)
+	require.Empty(t, filled)
+	require.Empty(t, created)
+}
+
+func TestFeed_Unsubscribe(t *testing.T) {
+	feed, pair := NewOrderFeed(), "BTCUSDT"
+	feed.Start()
+
+	var count int64
+	subscription := feed.Subscribe(pair, func(_ model.Order) {
+		atomic.AddInt64(&count, 1)
+	}, false)
+
+	feed.Publish(model.Order{ID: 1, Pair: pair}, false)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&count) == 1
+	}, time.Second, time.Millisecond)
+
+	feed.Unsubscribe(subscription)
+	feed.Publish(model.Order{ID: 2, Pair: pair}, false)
+	require.Eventually(t, func() bool {
+		return feed.Metrics().Unrouted == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&count))
+	require.Empty(t, feed.Metrics().Subscriptions)
+}
+
+func TestFeed_SlowConsumer(t *testing.T) {
+	feed, pair := NewOrderFeed(WithFeedBufferSize(1), WithFeedSlowThreshold(time.Minute)), "BTCUSDT"
+	started := make(chan bool, 4)
+	release := make(chan bool)
+	feed.Subscribe(pair, func(_ model.Order) {
+		started <- true
+		<-release
+	}, false)
+
+	fast := make(chan model.Order, 10)
+	feed.Subscribe(pair, func(order model.Order) {
+		fast <- order
+	}, false)
+
+	// the fast consumer is not blocked by the full queue of the slow one
+	feed.Start()
+	for i := int64(1); i <= 4; i++ {
+		feed.Publish(model.Order{ID: i, Pair: pair}, false)
+		if i == 1 {
+			<-started
+		}
+		select {
+		case order := <-fast:
+			require.Equal(t, i, order.ID)
+		case <-time.After(time.Second):
+			require.FailNow(t, "fast consumer blocked")
+		}
+	}
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return feed.Metrics().Subscriptions[0].Delivered == 2
+	}, time.Second, time.Millisecond)
+
+	metrics := feed.Metrics()
+	require.Equal(t, int64(4), metrics.Published)
+	require.Equal(t, int64(2), metrics.Dropped)
+	require.Equal(t, int64(2), metrics.Subscriptions[0].Dropped)
+	require.Zero(t, metrics.Pending)
+	require.Equal(t, int64(4), metrics.Subscriptions[1].Delivered)
+	require.Zero(t, metrics.Subscriptions[1].Dropped)
+}
+
+func TestFeed_SlowMetric(t *testing.T) {
+	feed, pair := NewOrderFeed(WithFeedSlowThreshold(time.Millisecond)), "BTCUSDT"
+	feed.Subscribe(pair, func(_ model.Order) {
+		time.Sleep(5 * time.Millisecond)
+	}, false)
+
+	feed.Start()
+	feed.Publish(model.Order{ID: 1, Pair: pair}, false)
+	require.Eventually(t, func() bool {
+		return feed.Metrics().Subscriptions[0].Delivered == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(1), feed.Metrics().Subscriptions[0].Slow)
+}
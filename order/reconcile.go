@@ -0,0 +1,280 @@
+package order
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+const (
+	// reconcileOrdersLimit is the number of recent orders fetched from exchange for each pair
+	reconcileOrdersLimit = 100
+	// reconcileFeeRate is the tolerance of balance differences per traded quantity, for fees paid
+	// in the base asset
+	reconcileFeeRate = 0.002
+	// importedTag is the tag of orders imported from the exchange, they are not part of bot positions
+	importedTag = "imported"
+)
+
+// BalanceDiff is a difference between the expected asset balance and the balance in exchange, eg. after
+// a manual trade. The expected balance is the balance not traded by the bot in the first reconciliation,
+// plus the bot position and the imported orders.
+type BalanceDiff struct {
+	Pair     string
+	Local    float64
+	Exchange float64
+}
+
+func (b BalanceDiff) String() string {
+	return fmt.Sprintf("%s: local %f, exchange %f", b.Pair, b.Local, b.Exchange)
+}
+
+// Reconciliation is the result of a reconciliation between storage and exchange
+type Reconciliation struct {
+	Imported     []model.Order
+	Updated      []model.Order
+	Missing      []model.Order
+	BalanceDiffs []BalanceDiff
+	Errors       []error
+}
+
+// IsEmpty returns true if storage and exchange are consistent
+func (r Reconciliation) IsEmpty() bool {
+	return len(r.Imported) == 0 && len(r.Updated) == 0 && len(r.Missing) == 0 &&
+		len(r.BalanceDiffs) == 0 && len(r.Errors) == 0
+}
+
+func (r Reconciliation) String() string {
+	if r.IsEmpty() {
+		return "*RECONCILIATION*\nStorage is consistent with exchange."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*RECONCILIATION*\n")
+	fmt.Fprintf(&sb, "Imported orders: %d\n", len(r.Imported))
+	for _, order := range r.Imported {
+		fmt.Fprintf(&sb, "  %s\n", order)
+	}
+	fmt.Fprintf(&sb, "Updated orders: %d\n", len(r.Updated))
+	for _, order := range r.Updated {
+		fmt.Fprintf(&sb, "  %s\n", order)
+	}
+	fmt.Fprintf(&sb, "Missing orders: %d\n", len(r.Missing))
+	for _, order := range r.Missing {
+		fmt.Fprintf(&sb, "  %s\n", order)
+	}
+	fmt.Fprintf(&sb, "Balance differences: %d\n", len(r.BalanceDiffs))
+	for _, diff := range r.BalanceDiffs {
+		fmt.Fprintf(&sb, "  %s\n", diff)
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&sb, "Error: %v\n", err)
+	}
+	return sb.String()
+}
+
+// balanceBaseline is the asset balance not traded by the bot and the traded quantity of the bot
+// when it was taken
+type balanceBaseline struct {
+	asset  float64
+	volume float64
+}
+
+// isBotOrder returns false for orders imported from the exchange
+func isBotOrder(order model.Order) bool {
+	return order.Tag != importedTag
+}
+
+// Reconcile compares storage with the exchange state for the given pairs. Unknown orders are imported,
+// stale statuses are updated and balance differences are reported. The report is sent to the notifier.
+// The balance baseline of a pair is taken in its first reconciliation, eg. at startup.
+func (c *Controller) Reconcile(pairs ...string) Reconciliation {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Info("[RECONCILIATION] Checking orders and balances")
+	var report Reconciliation
+	if !c.positionsLoaded {
+		if err := c.loadPositions(); err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+	}
+
+	for _, pair := range pairs {
+		c.reconcilePair(pair, &report)
+	}
+
+	if report.IsEmpty() {
+		log.Info("[RECONCILIATION] Storage is consistent with exchange")
+		return report
+	}
+
+	c.notify(report.String())
+	return report
+}
+
+func (c *Controller) reconcilePair(pair string, report *Reconciliation) {
+	localOrders, err := c.storage.Orders(storage.WithPair(pair))
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+		return
+	}
+
+	// local trailing stops are not sent to exchange
+	local := make(map[int64]*model.Order)
+	for _, order := range localOrders {
+		if !isLocalTrailingStop(*order) {
+			local[order.ExchangeID] = order
+		}
+	}
+
+	excOrders, err := c.exchange.Orders(pair, reconcileOrdersLimit)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+		return
+	}
+
+	sort.Slice(excOrders, func(i, j int) bool {
+		return excOrders[i].CreatedAt.Before(excOrders[j].CreatedAt)
+	})
+
+	var imported float64
+	seen := make(map[int64]bool)
+	for _, excOrder := range excOrders {
+		seen[excOrder.ExchangeID] = true
+		order, ok := local[excOrder.ExchangeID]
+		if !ok {
+			excOrder.Pair = pair
+			excOrder.Tag = importedTag
+			if err := c.storage.CreateOrder(&excOrder); err != nil {
+				report.Errors = append(report.Errors, err)
+				continue
+			}
+			report.Imported = append(report.Imported, excOrder)
+			if excOrder.Status == model.OrderStatusTypeFilled {
+				imported += signedQuantity(excOrder.Side, excOrder.Quantity)
+			}
+			continue
+		}
+
+		if updated, ok := c.reconcileOrder(order, excOrder, report); ok {
+			report.Updated = append(report.Updated, updated)
+		}
+	}
+
+	// pending orders older than the fetched history
+	for _, order := range localOrders {
+		if isLocalTrailingStop(*order) || seen[order.ExchangeID] || !isPending(*order) {
+			continue
+		}
+
+		excOrder, err := c.exchange.Order(pair, order.ExchangeID)
+		if err != nil {
+			log.WithField("id", order.ExchangeID).Error("orderController/reconcile: ", err)
+			report.Missing = append(report.Missing, *order)
+			continue
+		}
+
+		if updated, ok := c.reconcileOrder(order, excOrder, report); ok {
+			report.Updated = append(report.Updated, updated)
+		}
+	}
+
+	c.reconcileBalance(pair, imported, report)
+}
+
+// reconcileOrder updates a local order with the exchange status, it returns false if there is no change
+func (c *Controller) reconcileOrder(order *model.Order, excOrder model.Order,
+	report *Reconciliation) (model.Order, bool) {
+	if order.Status == excOrder.Status {
+		return model.Order{}, false
+	}
+
+	excOrder.ID = order.ID
+	excOrder.Pair = order.Pair
+	excOrder.Tag = order.Tag
+	excOrder.OriginalID = order.OriginalID
+	excOrder.ReplacementID = order.ReplacementID
+	if err := c.storage.UpdateOrder(&excOrder); err != nil {
+		report.Errors = append(report.Errors, err)
+		return model.Order{}, false
+	}
+
+	c.processTrade(&excOrder)
+	c.orderFeed.Publish(excOrder, false)
+	return excOrder, true
+}
+
+// reconcileBalance compares the exchange balance with the baseline of the pair, the bot position and the
+// quantity of the imported orders, which are included in the baseline. The tolerance grows with the
+// traded quantity of the bot, for fees paid in the base asset.
+func (c *Controller) reconcileBalance(pair string, imported float64, report *Reconciliation) {
+	orders, err := c.storage.Orders(storage.WithPair(pair), storage.WithStatus(model.OrderStatusTypeFilled),
+		isBotOrder)
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+		return
+	}
+
+	var volume float64
+	for _, order := range orders {
+		volume += order.Quantity
+	}
+
+	asset, _, err := c.exchange.Position(pair)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("%s: %w", pair, err))
+		return
+	}
+
+	var position float64
+	if p, ok := c.position[pair]; ok {
+		position = signedQuantity(p.Side, p.Quantity)
+	}
+
+	tolerance := math.Max(c.exchange.AssetsInfo(pair).StepSize, 1e-8)
+	baseline, ok := c.baselines[pair]
+	if !ok {
+		// the bot must hold its position, other balances are not traded by the bot
+		baseline = &balanceBaseline{asset: asset - position, volume: volume}
+		c.baselines[pair] = baseline
+		if position != 0 && math.Abs(asset) < math.Abs(position)-tolerance-reconcileFeeRate*volume {
+			report.BalanceDiffs = append(report.BalanceDiffs, BalanceDiff{
+				Pair:     pair,
+				Local:    position,
+				Exchange: asset,
+			})
+		}
+		return
+	}
+
+	baseline.asset += imported
+	expected := baseline.asset + position
+	tolerance += reconcileFeeRate * (volume - baseline.volume)
+	if math.Abs(expected-asset) > tolerance {
+		report.BalanceDiffs = append(report.BalanceDiffs, BalanceDiff{
+			Pair:     pair,
+			Local:    expected,
+			Exchange: asset,
+		})
+	}
+}
+
+func signedQuantity(side model.SideType, quantity float64) float64 {
+	if side == model.SideTypeSell {
+		return -quantity
+	}
+	return quantity
+}
+
+func isPending(order model.Order) bool {
+	return order.Status == model.OrderStatusTypeNew ||
+		order.Status == model.OrderStatusTypePartiallyFilled ||
+		order.Status == model.OrderStatusTypePendingCancel
+}
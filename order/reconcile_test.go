@@ -0,0 +1,83 @@
+package order
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func TestController_Reconcile(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000),
+		exchange.WithPaperAsset("ETH", 1))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+
+	report := controller.Reconcile("BTCUSDT")
+	require.True(t, report.IsEmpty())
+
+	// manual trade in exchange
+	manual, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+	require.NoError(t, err)
+
+	// limit order filled while the bot was offline
+	limit, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 900)
+	require.NoError(t, err)
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 800})
+
+	// order lost by exchange
+	lost := model.Order{ExchangeID: 999, Pair: "BTCUSDT", Status: model.OrderStatusTypeNew}
+	require.NoError(t, db.CreateOrder(&lost))
+
+	report = controller.Reconcile("BTCUSDT", "ETHUSDT")
+	require.Len(t, report.Imported, 1)
+	require.Equal(t, manual.ExchangeID, report.Imported[0].ExchangeID)
+
+	require.Len(t, report.Updated, 1)
+	require.Equal(t, limit.ID, report.Updated[0].ID)
+	require.Equal(t, model.OrderStatusTypeFilled, report.Updated[0].Status)
+
+	require.Len(t, report.Missing, 1)
+	require.Equal(t, int64(999), report.Missing[0].ExchangeID)
+
+	// imported orders are part of the balance baseline and the ETH balance is not traded by the bot
+	require.Empty(t, report.BalanceDiffs)
+	require.Empty(t, report.Errors)
+
+	orders, err := db.Orders(storage.WithPair("BTCUSDT"), storage.WithStatus(model.OrderStatusTypeFilled))
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+
+	// filled order is included in the position, the imported one is not
+	require.Equal(t, 1.0, controller.position["BTCUSDT"].Quantity)
+
+	// second reconciliation has nothing to import
+	report = controller.Reconcile("BTCUSDT")
+	require.Empty(t, report.Imported)
+	require.Empty(t, report.Updated)
+	require.Empty(t, report.BalanceDiffs)
+	require.Len(t, report.Missing, 1)
+
+	t.Run("after restart", func(t *testing.T) {
+		// bot position bigger than the exchange balance
+		eth := model.Order{ExchangeID: 1000, Pair: "ETHUSDT", Side: model.SideTypeBuy, Type: model.OrderTypeMarket,
+			Status: model.OrderStatusTypeFilled, Quantity: 5, Price: 100}
+		require.NoError(t, db.CreateOrder(&eth))
+
+		restarted := NewController(ctx, wallet, db, NewOrderFeed())
+		report := restarted.Reconcile("BTCUSDT", "ETHUSDT")
+		require.Equal(t, []BalanceDiff{{Pair: "ETHUSDT", Local: 5, Exchange: 1}}, report.BalanceDiffs)
+		require.Equal(t, 1.0, restarted.position["BTCUSDT"].Quantity)
+	})
+}
@@ -0,0 +1,229 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+var ErrInvalidCallback = errors.New("invalid trailing stop callback")
+
+// trailingStopPrice returns the stop price of a trailing order given the current market price
+func trailingStopPrice(order model.Order, price float64) float64 {
+	delta := order.Callback
+	if order.CallbackPercent {
+		delta = price * order.Callback
+	}
+
+	if order.Side == model.SideTypeSell {
+		return price - delta
+	}
+	return price + delta
+}
+
+// updateTrailingStop moves the stop of a trailing order with the market price.
+// It returns true when the given price reaches the stop and the order should be executed.
+func updateTrailingStop(order *model.Order, price float64) bool {
+	// waiting for activation price
+	if order.Stop == nil {
+		if order.ActivationPrice != nil {
+			if order.Side == model.SideTypeSell && price < *order.ActivationPrice ||
+				order.Side == model.SideTypeBuy && price > *order.ActivationPrice {
+				return false
+			}
+		}
+
+		stop := trailingStopPrice(*order, price)
+		order.Stop = &stop
+		return false
+	}
+
+	if trailingStopReached(*order, price) {
+		return true
+	}
+
+	stop := trailingStopPrice(*order, price)
+	if order.Side == model.SideTypeSell && stop > *order.Stop ||
+		order.Side == model.SideTypeBuy && stop < *order.Stop {
+		order.Stop = &stop
+	}
+
+	return false
+}
+
+// trailingStopReached returns true when the price reaches the stop of an active trailing order
+func trailingStopReached(order model.Order, price float64) bool {
+	return order.Stop != nil && (order.Side == model.SideTypeSell && price <= *order.Stop ||
+		order.Side == model.SideTypeBuy && price >= *order.Stop)
+}
+
+func (c *Controller) now(pair string) time.Time {
+	if t, ok := c.lastUpdate[pair]; ok {
+		return t
+	}
+	return time.Now()
+}
+
+// CreateOrderTrailingStop creates a trailing stop order managed by the controller.
+// The stop follows the market price at a given callback distance, an absolute value or a rate
+// (eg. 0.02 = 2%) if percent is true. A sell order protects a long position and a buy order
+// protects a short position. When activation is greater than zero, the stop only starts to trail
+// after the market reaches the activation price. Once triggered, a market order is sent to the exchange.
+func (c *Controller) CreateOrderTrailingStop(side model.SideType, pair string, size, callback float64,
+	percent bool, activation float64) (model.Order, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Infof("[ORDER] Creating TRAILING STOP %s order for %s", side, pair)
+	if size <= 0 {
+		err := &exchange.OrderError{Err: exchange.ErrInvalidQuantity, Pair: pair, Quantity: size}
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	if callback <= 0 || percent && callback >= 1 {
+		err := &exchange.OrderError{Err: ErrInvalidCallback, Pair: pair, Quantity: size}
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	order := model.Order{
+		Pair:            pair,
+		Side:            side,
+		Type:            model.OrderTypeTrailingStop,
+		Status:          model.OrderStatusTypeNew,
+		Quantity:        size,
+		Callback:        callback,
+		CallbackPercent: percent,
+		CreatedAt:       c.now(pair),
+		UpdatedAt:       c.now(pair),
+	}
+
+	if activation > 0 {
+		order.ActivationPrice = &activation
+	}
+
+	if price, ok := c.lastPrice[pair]; ok {
+		order.RefPrice = price
+		updateTrailingStop(&order, price)
+	}
+
+	err := c.storage.CreateOrder(&order)
+	if err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	c.trailingOrders[order.ID] = &order
+	c.orderFeed.Publish(order, true)
+	log.Infof("[ORDER CREATED] %s", order)
+	return order, nil
+}
+
+// loadTrailingOrders restores pending trailing stop orders from storage
+func (c *Controller) loadTrailingOrders() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	orders, err := c.storage.Orders(
+		storage.WithStatus(model.OrderStatusTypeNew),
+		isLocalTrailingStop,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		c.trailingOrders[order.ID] = order
+	}
+
+	return nil
+}
+
+// isLocalTrailingStop returns true for trailing orders not sent to the exchange yet
+func isLocalTrailingStop(order model.Order) bool {
+	return order.Type == model.OrderTypeTrailingStop && order.ExchangeID == 0
+}
+
+// updateTrailingOrders moves the trailing stops of a pair and executes the triggered orders. The stops
+// are checked against the low and high prices first, since they may be reached before the close moves them.
+// The controller mutex must be acquired by the caller.
+func (c *Controller) updateTrailingOrders(pair string, low, high, price float64, t time.Time) {
+	for id, order := range c.trailingOrders {
+		if order.Pair != pair {
+			continue
+		}
+
+		extreme := high
+		if order.Side == model.SideTypeSell {
+			extreme = low
+		}
+
+		previous := order.Stop
+		if !trailingStopReached(*order, extreme) && !updateTrailingStop(order, price) {
+			if order.Stop != previous {
+				order.UpdatedAt = t
+				if err := c.storage.UpdateOrder(order); err != nil {
+					c.notifyError(err)
+				}
+			}
+			continue
+		}
+
+		delete(c.trailingOrders, id)
+		c.executeTrailingOrder(order)
+	}
+}
+
+// executeTrailingOrder sends a market order to the exchange for a triggered trailing stop
+func (c *Controller) executeTrailingOrder(order *model.Order) {
+	log.Infof("[ORDER] Trailing stop triggered for %s at %f", order.Pair, *order.Stop)
+	excOrder, err := c.exchange.CreateOrderMarket(order.Side, order.Pair, order.Quantity)
+	if err != nil {
+		c.notifyError(fmt.Errorf("trailing stop %d: %w", order.ID, err))
+		order.Status = model.OrderStatusTypeRejected
+		if err := c.storage.UpdateOrder(order); err != nil {
+			c.notifyError(err)
+		}
+		c.orderFeed.Publish(*order, false)
+		return
+	}
+
+	order.ExchangeID = excOrder.ExchangeID
+	order.Status = excOrder.Status
+	order.Price = excOrder.Price
+	order.Quantity = excOrder.Quantity
+	order.UpdatedAt = excOrder.UpdatedAt
+	if err := c.storage.UpdateOrder(order); err != nil {
+		c.notifyError(err)
+		return
+	}
+
+	c.processTrade(order)
+	c.orderFeed.Publish(*order, false)
+	log.Infof("[ORDER %s] %s", order.Status, order)
+}
+
+// updateTrailingOrdersFromExchange fetches the last quote of pairs with pending trailing stops.
+// The controller mutex must be acquired by the caller.
+func (c *Controller) updateTrailingOrdersFromExchange() {
+	pairs := make(map[string]bool)
+	for _, order := range c.trailingOrders {
+		pairs[order.Pair] = true
+	}
+
+	for pair := range pairs {
+		price, err := c.exchange.LastQuote(c.ctx, pair)
+		if err != nil {
+			log.WithField("pair", pair).Debug("orderController/lastQuote: ", err)
+			continue
+		}
+		c.updateTrailingOrders(pair, price, price, price, time.Now())
+	}
+}
@@ -0,0 +1,221 @@
+package order
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func newTrailingCandle(price float64) model.Candle {
+	return model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: price, Low: price, High: price}
+}
+
+func TestUpdateTrailingStop(t *testing.T) {
+	t.Run("long with percent callback", func(t *testing.T) {
+		order := model.Order{Side: model.SideTypeSell, Callback: 0.1, CallbackPercent: true}
+
+		require.False(t, updateTrailingStop(&order, 100))
+		require.InDelta(t, 90.0, *order.Stop, 1e-9)
+
+		// price goes up, stop follows
+		require.False(t, updateTrailingStop(&order, 200))
+		require.InDelta(t, 180.0, *order.Stop, 1e-9)
+
+		// price goes down, stop keeps the same
+		require.False(t, updateTrailingStop(&order, 190))
+		require.InDelta(t, 180.0, *order.Stop, 1e-9)
+
+		require.True(t, updateTrailingStop(&order, 180))
+	})
+
+	t.Run("short with absolute callback", func(t *testing.T) {
+		order := model.Order{Side: model.SideTypeBuy, Callback: 10}
+
+		require.False(t, updateTrailingStop(&order, 100))
+		require.Equal(t, 110.0, *order.Stop)
+
+		require.False(t, updateTrailingStop(&order, 50))
+		require.Equal(t, 60.0, *order.Stop)
+
+		require.False(t, updateTrailingStop(&order, 55))
+		require.Equal(t, 60.0, *order.Stop)
+
+		require.True(t, updateTrailingStop(&order, 61))
+	})
+
+	t.Run("activation price", func(t *testing.T) {
+		activation := 150.0
+		order := model.Order{Side: model.SideTypeSell, Callback: 10, ActivationPrice: &activation}
+
+		require.False(t, updateTrailingStop(&order, 100))
+		require.Nil(t, order.Stop)
+
+		// should not trigger before activation
+		require.False(t, updateTrailingStop(&order, 10))
+		require.Nil(t, order.Stop)
+
+		require.False(t, updateTrailingStop(&order, 150))
+		require.Equal(t, 140.0, *order.Stop)
+		require.True(t, updateTrailingStop(&order, 140))
+	})
+}
+
+func TestController_CreateOrderTrailingStop(t *testing.T) {
+	t.Run("long position", func(t *testing.T) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+		candle := newTrailingCandle(1000)
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+
+		_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		order, err := controller.CreateOrderTrailingStop(model.SideTypeSell, "BTCUSDT", 1, 0.1, true, 0)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderTypeTrailingStop, order.Type)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+		require.Equal(t, 900.0, *order.Stop)
+
+		// partial candle moves the stop
+		candle = newTrailingCandle(2000)
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Equal(t, 1800.0, *controller.trailingOrders[order.ID].Stop)
+
+		// trailing orders should not be fetched from exchange
+		controller.updateOrders()
+		require.Len(t, controller.trailingOrders, 1)
+
+		// price reaches the stop
+		candle = newTrailingCandle(1800)
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		require.Empty(t, controller.trailingOrders)
+		require.Nil(t, controller.position["BTCUSDT"])
+
+		orders, err := storage.Orders(withTrailingStop)
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Equal(t, model.OrderStatusTypeFilled, orders[0].Status)
+		require.Equal(t, 1800.0, orders[0].Price)
+		require.NotZero(t, orders[0].ExchangeID)
+
+		require.Len(t, controller.Results["BTCUSDT"].WinLong, 1)
+		require.Equal(t, 800.0, controller.Results["BTCUSDT"].WinLong[0])
+	})
+
+	t.Run("short position", func(t *testing.T) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+		candle := newTrailingCandle(1000)
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+
+		_, err = controller.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		order, err := controller.CreateOrderTrailingStop(model.SideTypeBuy, "BTCUSDT", 1, 100, false, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1100.0, *order.Stop)
+
+		candle = newTrailingCandle(500)
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Equal(t, 600.0, *controller.trailingOrders[order.ID].Stop)
+
+		candle = newTrailingCandle(600)
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Empty(t, controller.trailingOrders)
+		require.Nil(t, controller.position["BTCUSDT"])
+
+		orders, err := storage.Orders(withTrailingStop)
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Equal(t, model.OrderStatusTypeFilled, orders[0].Status)
+		require.Equal(t, model.SideTypeBuy, orders[0].Side)
+		require.Equal(t, 600.0, orders[0].Price)
+	})
+
+	t.Run("stop reached inside the candle", func(t *testing.T) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+		candle := newTrailingCandle(1000)
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+
+		_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+		_, err = controller.CreateOrderTrailingStop(model.SideTypeSell, "BTCUSDT", 1, 0.1, true, 0)
+		require.NoError(t, err)
+
+		// the low reaches the stop before the price recovers, the close does not move the stop
+		candle = newTrailingCandle(1100)
+		candle.Low = 850
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		require.Empty(t, controller.trailingOrders)
+		require.Nil(t, controller.position["BTCUSDT"])
+
+		orders, err := storage.Orders(withTrailingStop)
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Equal(t, model.OrderStatusTypeFilled, orders[0].Status)
+		require.Equal(t, 900.0, *orders[0].Stop)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+		order, err := controller.CreateOrderTrailingStop(model.SideTypeSell, "BTCUSDT", 1, 10, false, 1500)
+		require.NoError(t, err)
+		require.Nil(t, order.Stop)
+
+		err = controller.Cancel(order)
+		require.NoError(t, err)
+		require.Empty(t, controller.trailingOrders)
+
+		orders, err := storage.Orders(withTrailingStop)
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		require.Equal(t, model.OrderStatusTypeCanceled, orders[0].Status)
+	})
+
+	t.Run("invalid callback", func(t *testing.T) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+		_, err = controller.CreateOrderTrailingStop(model.SideTypeSell, "BTCUSDT", 1, 1.5, true, 0)
+		require.ErrorIs(t, err, ErrInvalidCallback)
+	})
+}
+
+func withTrailingStop(order model.Order) bool {
+	return order.Type == model.OrderTypeTrailingStop
+}
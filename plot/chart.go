@@ -15,6 +15,7 @@ import (
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
 	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/strategy"
 
 	"github.com/StudioSol/set"
@@ -36,7 +37,7 @@ type Chart struct {
 	ordersIDsByPair map[string]*set.LinkedHashSetINT64
 	orderByID       map[int64]model.Order
 	indicators      []Indicator
-	paperWallet     *exchange.PaperWallet
+	equity          service.EquityReporter
 	scriptContent   string
 	indexHTML       *template.Template
 	strategy        strategy.Strategy
@@ -155,16 +156,16 @@ func (c *Chart) equityValuesByPair(pair string) (asset []assetValue, quote []ass
 	assetValues := make([]assetValue, 0)
 	equityValues := make([]assetValue, 0)
 
-	if c.paperWallet != nil {
+	if c.equity != nil {
 		asset, _ := exchange.SplitAssetQuote(pair)
-		for _, value := range c.paperWallet.AssetValues(asset) {
+		for _, value := range c.equity.AssetValues(asset) {
 			assetValues = append(assetValues, assetValue{
 				Time:  value.Time,
 				Value: value.Value,
 			})
 		}
 
-		for _, value := range c.paperWallet.EquityValues() {
+		for _, value := range c.equity.EquityValues() {
 			equityValues = append(equityValues, assetValue{
 				Time:  value.Time,
 				Value: value.Value,
@@ -269,7 +270,8 @@ func (c *Chart) shapesByPair(pair string) []Shape {
 		order := c.orderByID[id]
 
 		if order.Type != model.OrderTypeStopLoss &&
-			order.Type != model.OrderTypeLimitMaker {
+			order.Type != model.OrderTypeLimitMaker &&
+			order.Type != model.OrderTypeTrailingStop {
 			continue
 		}
 
@@ -285,6 +287,15 @@ func (c *Chart) shapesByPair(pair string) []Shape {
 			shape.Color = "rgba(255, 0, 0, 0.3)"
 		}
 
+		// trailing stops are drawn from the reference price to the last stop
+		if order.Type == model.OrderTypeTrailingStop {
+			if order.Stop == nil {
+				continue
+			}
+			shape.EndY = *order.Stop
+			shape.Color = "rgba(255, 0, 0, 0.3)"
+		}
+
 		shapes = append(shapes, shape)
 	}
 
@@ -352,8 +363,8 @@ func (c *Chart) handleData(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/json")
 
 	var maxDrawdown *drawdown
-	if c.paperWallet != nil {
-		value, start, end := c.paperWallet.MaxDrawdown()
+	if c.equity != nil {
+		value, start, end := c.equity.MaxDrawdown()
 		maxDrawdown = &drawdown{
 			Start: start,
 			End:   end,
@@ -453,7 +464,14 @@ func WithStrategyIndicators(strategy strategy.Strategy) Option {
 
 func WithPaperWallet(paperWallet *exchange.PaperWallet) Option {
 	return func(chart *Chart) {
-		chart.paperWallet = paperWallet
+		chart.equity = paperWallet
+	}
+}
+
+// WithEquityReporter plots the equity curve and drawdown of a live account, eg. order.EquityTracker
+func WithEquityReporter(reporter service.EquityReporter) Option {
+	return func(chart *Chart) {
+		chart.equity = reporter
 	}
 }
 
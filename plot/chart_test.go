@@ -158,7 +158,7 @@ func TestChart_WithPaperWallet(t *testing.T) {
 	wallet := &exchange.PaperWallet{}
 	c, err := NewChart(WithPaperWallet(wallet))
 	require.NoErrorf(t, err, "error when initial chart")
-	require.Equal(t, wallet, c.paperWallet)
+	require.Equal(t, wallet, c.equity)
 }
 
 func TestChart_WithDebug(t *testing.T) {
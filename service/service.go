@@ -12,6 +12,7 @@ import (
 type Exchange interface {
 	Broker
 	Feeder
+	Orders(pair string, limit int) ([]model.Order, error)
 }
 
 type Feeder interface {
@@ -22,10 +23,29 @@ type Feeder interface {
 	CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error)
 }
 
+// ContextExchange is an exchange that sends the account and order requests with the context of its
+// creation. WithContext returns a copy bound to another context, eg. to cancel orders on shutdown
+type ContextExchange interface {
+	WithContext(ctx context.Context) Exchange
+}
+
+// TradeFeeder provides the trades of a pair, eg. to build candles from the trade flow
+type TradeFeeder interface {
+	TradesSubscription(ctx context.Context, pair string) (chan model.Trade, chan error)
+}
+
+// OrderBookFeeder provides the order book of a pair, updated on each change
+type OrderBookFeeder interface {
+	OrderBookSubscription(ctx context.Context, pair string) (chan model.OrderBook, chan error)
+}
+
 type Broker interface {
 	Account() (model.Account, error)
 	Position(pair string) (asset, quote float64, err error)
+	PositionInfo(pair string) (model.Position, error)
 	Order(pair string, id int64) (model.Order, error)
+	CreateOrder(request model.OrderRequest) (model.Order, error)
+	ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error)
 	CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64) ([]model.Order, error)
 	CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error)
 	CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error)
@@ -34,6 +54,13 @@ type Broker interface {
 	Cancel(model.Order) error
 }
 
+// EquityReporter provides the equity curve of an account, from a simulated or a live run
+type EquityReporter interface {
+	AssetValues(asset string) []model.AssetValue
+	EquityValues() []model.AssetValue
+	MaxDrawdown() (float64, time.Time, time.Time)
+}
+
 type Notifier interface {
 	Notify(string)
 	OnOrder(order model.Order)
@@ -0,0 +1,146 @@
+package sizing
+
+import (
+	"errors"
+	"math"
+
+	"github.com/rodrigo-brito/ninjabot/indicator"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+var (
+	ErrInvalidRisk       = errors.New("invalid risk")
+	ErrInvalidStop       = errors.New("invalid stop distance")
+	ErrInvalidVolatility = errors.New("invalid volatility")
+	ErrNotEnoughTrades   = errors.New("not enough trades")
+	ErrNoEdge            = errors.New("no positive edge")
+)
+
+// FixedFractional risks a fixed rate of the equity in each trade, eg. 0.01 = 1%.
+// The position is sized to lose the risk amount if the stop price is reached.
+type FixedFractional struct {
+	Risk float64
+	Stop float64
+}
+
+func (f FixedFractional) Quantity(equity, price float64) (float64, error) {
+	if f.Risk <= 0 || f.Risk > 1 {
+		return 0, ErrInvalidRisk
+	}
+
+	distance := math.Abs(price - f.Stop)
+	if f.Stop <= 0 || distance == 0 {
+		return 0, ErrInvalidStop
+	}
+
+	return equity * f.Risk / distance, nil
+}
+
+// VolatilityTarget risks a fixed rate of the equity in a move of Multiplier times the ATR.
+// It results in smaller positions in volatile markets and larger positions in calm markets.
+type VolatilityTarget struct {
+	Risk       float64
+	ATR        float64
+	Multiplier float64
+}
+
+// NewVolatilityTarget creates a volatility target model using the last ATR value of the dataframe
+func NewVolatilityTarget(df *model.Dataframe, period int, risk, multiplier float64) VolatilityTarget {
+	target := VolatilityTarget{Risk: risk, Multiplier: multiplier}
+	if len(df.Close) > period {
+		atr := indicator.ATR(df.High, df.Low, df.Close, period)
+		target.ATR = atr[len(atr)-1]
+	}
+	return target
+}
+
+func (v VolatilityTarget) Quantity(equity, _ float64) (float64, error) {
+	if v.Risk <= 0 || v.Risk > 1 {
+		return 0, ErrInvalidRisk
+	}
+
+	multiplier := v.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	if v.ATR <= 0 || multiplier < 0 {
+		return 0, ErrInvalidVolatility
+	}
+
+	return equity * v.Risk / (v.ATR * multiplier), nil
+}
+
+// Kelly allocates a fraction of the Kelly criterion, calculated from the win rate and the payoff
+// (average win / average loss) of historical trades. A fraction of 0.5 (half Kelly) is common
+// to reduce the drawdown of estimation errors.
+type Kelly struct {
+	WinRate  float64
+	Payoff   float64
+	Fraction float64
+}
+
+// NewKelly creates a Kelly model given the profits of historical trades, negative values are losses.
+// The profits of a pair are available in the results of order.Controller, eg. append(s.Win(), s.Lose()...)
+func NewKelly(profits []float64, fraction float64) (Kelly, error) {
+	var wins, losses int
+	var totalWin, totalLoss float64
+	for _, profit := range profits {
+		if profit > 0 {
+			wins++
+			totalWin += profit
+		} else if profit < 0 {
+			losses++
+			totalLoss -= profit
+		}
+	}
+
+	if wins == 0 || losses == 0 {
+		return Kelly{}, ErrNotEnoughTrades
+	}
+
+	return Kelly{
+		WinRate:  float64(wins) / float64(wins+losses),
+		Payoff:   (totalWin / float64(wins)) / (totalLoss / float64(losses)),
+		Fraction: fraction,
+	}, nil
+}
+
+// Rate returns the rate of the equity allocated by the model
+func (k Kelly) Rate() float64 {
+	if k.Payoff <= 0 {
+		return 0
+	}
+	return (k.WinRate - (1-k.WinRate)/k.Payoff) * k.Fraction
+}
+
+func (k Kelly) Quantity(equity, price float64) (float64, error) {
+	if k.Fraction <= 0 || k.Fraction > 1 {
+		return 0, ErrInvalidRisk
+	}
+
+	rate := k.Rate()
+	if rate <= 0 {
+		return 0, ErrNoEdge
+	}
+
+	return equity * math.Min(rate, 1) / price, nil
+}
+
+// PercentOfEquity allocates a fixed rate of the equity, eg. 0.25 = 25%
+type PercentOfEquity float64
+
+func (p PercentOfEquity) Quantity(equity, price float64) (float64, error) {
+	if p <= 0 || p > 1 {
+		return 0, ErrInvalidRisk
+	}
+	return equity * float64(p) / price, nil
+}
+
+// EqualWeight allocates the same rate of the equity for each pair, given the number of pairs
+func EqualWeight(pairs int) PercentOfEquity {
+	if pairs <= 0 {
+		return 0
+	}
+	return PercentOfEquity(1 / float64(pairs))
+}
@@ -0,0 +1,80 @@
+package sizing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestFixedFractional(t *testing.T) {
+	// risk 1% of 10000 with a stop 5 below the entry
+	quantity, err := FixedFractional{Risk: 0.01, Stop: 95}.Quantity(10000, 100)
+	require.NoError(t, err)
+	require.Equal(t, 20.0, quantity)
+
+	// short position
+	quantity, err = FixedFractional{Risk: 0.01, Stop: 110}.Quantity(10000, 100)
+	require.NoError(t, err)
+	require.Equal(t, 10.0, quantity)
+
+	_, err = FixedFractional{Risk: 0.01, Stop: 100}.Quantity(10000, 100)
+	require.ErrorIs(t, err, ErrInvalidStop)
+
+	_, err = FixedFractional{Risk: 0, Stop: 90}.Quantity(10000, 100)
+	require.ErrorIs(t, err, ErrInvalidRisk)
+}
+
+func TestVolatilityTarget(t *testing.T) {
+	df := &model.Dataframe{}
+	for i := 0; i < 20; i++ {
+		df.Time = append(df.Time, time.Unix(int64(i)*60, 0))
+		df.Close = append(df.Close, 100)
+		df.High = append(df.High, 102)
+		df.Low = append(df.Low, 98)
+	}
+
+	target := NewVolatilityTarget(df, 14, 0.01, 2)
+	require.InDelta(t, 4.0, target.ATR, 1e-9)
+
+	quantity, err := target.Quantity(10000, 100)
+	require.NoError(t, err)
+	require.InDelta(t, 12.5, quantity, 1e-9)
+
+	// not enough data
+	target = NewVolatilityTarget(&model.Dataframe{}, 14, 0.01, 2)
+	_, err = target.Quantity(10000, 100)
+	require.ErrorIs(t, err, ErrInvalidVolatility)
+}
+
+func TestKelly(t *testing.T) {
+	// 60% of wins with payoff 2
+	kelly, err := NewKelly([]float64{20, 20, 20, -10, -10}, 0.5)
+	require.NoError(t, err)
+	require.InDelta(t, 0.6, kelly.WinRate, 1e-9)
+	require.InDelta(t, 2.0, kelly.Payoff, 1e-9)
+	require.InDelta(t, 0.2, kelly.Rate(), 1e-9)
+
+	quantity, err := kelly.Quantity(1000, 10)
+	require.NoError(t, err)
+	require.InDelta(t, 20.0, quantity, 1e-9)
+
+	_, err = NewKelly([]float64{10, 20}, 0.5)
+	require.ErrorIs(t, err, ErrNotEnoughTrades)
+
+	kelly, err = NewKelly([]float64{10, -10, -10}, 1)
+	require.NoError(t, err)
+	_, err = kelly.Quantity(1000, 10)
+	require.ErrorIs(t, err, ErrNoEdge)
+}
+
+func TestEqualWeight(t *testing.T) {
+	quantity, err := EqualWeight(4).Quantity(1000, 10)
+	require.NoError(t, err)
+	require.Equal(t, 25.0, quantity)
+
+	_, err = EqualWeight(0).Quantity(1000, 10)
+	require.ErrorIs(t, err, ErrInvalidRisk)
+}
@@ -0,0 +1,97 @@
+package sizing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+var (
+	ErrInvalidPrice     = errors.New("invalid price")
+	ErrInvalidEquity    = errors.New("invalid equity")
+	ErrBelowMinQuantity = errors.New("quantity below min quantity")
+	ErrBelowMinNotional = errors.New("order value below min notional")
+)
+
+// Model is a risk model that returns the raw quantity of an order given the equity and the entry price
+type Model interface {
+	Quantity(equity, price float64) (float64, error)
+}
+
+// Size returns the order quantity of a risk model, rounded down to the step size of the pair
+// and limited by the max quantity. It fails if the order is smaller than min quantity or min notional.
+func Size(m Model, info model.AssetInfo, equity, price float64) (float64, error) {
+	if price <= 0 {
+		return 0, ErrInvalidPrice
+	}
+
+	if equity <= 0 {
+		return 0, ErrInvalidEquity
+	}
+
+	quantity, err := m.Quantity(equity, price)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.MaxQuantity > 0 {
+		quantity = math.Min(quantity, info.MaxQuantity)
+	}
+
+	quantity = RoundQuantity(info, quantity)
+	if quantity <= 0 || quantity < info.MinQuantity {
+		return 0, fmt.Errorf("%w: %f < %f", ErrBelowMinQuantity, quantity, info.MinQuantity)
+	}
+
+	if quantity*price < info.MinNotional {
+		return 0, fmt.Errorf("%w: %f < %f", ErrBelowMinNotional, quantity*price, info.MinNotional)
+	}
+
+	return quantity, nil
+}
+
+// Quantity returns the order quantity of a risk model for a pair. The equity is the quote balance
+// plus the value of the asset position in the broker, eg. USDT + BTC value for BTCUSDT.
+func Quantity(broker service.Broker, m Model, info model.AssetInfo, pair string, price float64) (float64, error) {
+	equity, err := Equity(broker, pair, price)
+	if err != nil {
+		return 0, err
+	}
+
+	return Size(m, info, equity, price)
+}
+
+// Equity returns the quote balance plus the value of the asset position of a pair in the broker
+func Equity(broker service.Broker, pair string, price float64) (float64, error) {
+	account, err := broker.Account()
+	if err != nil {
+		return 0, err
+	}
+
+	asset, quote := exchange.SplitAssetQuote(pair)
+	assetBalance, quoteBalance := account.Balance(asset, quote)
+
+	position := assetBalance.Free + assetBalance.Lock
+	// futures positions are represented by the margin in the quote balance
+	if assetBalance.Leverage > 0 {
+		position = 0
+	}
+
+	return quoteBalance.Free + quoteBalance.Lock + position*price, nil
+}
+
+// RoundQuantity rounds down a quantity to the step size of the pair
+func RoundQuantity(info model.AssetInfo, quantity float64) float64 {
+	if info.StepSize <= 0 {
+		return quantity
+	}
+
+	// small tolerance to avoid float errors, eg. 0.3 / 0.1 = 2.9999999999999996
+	steps := math.Floor(quantity/info.StepSize + 1e-9)
+	precision := math.Pow(10, math.Max(0, math.Ceil(-math.Log10(info.StepSize))))
+	return math.Round(steps*info.StepSize*precision) / precision
+}
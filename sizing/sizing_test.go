@@ -0,0 +1,82 @@
+package sizing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestRoundQuantity(t *testing.T) {
+	tt := []struct {
+		step     float64
+		quantity float64
+		expected float64
+	}{
+		{0.1, 0.3, 0.3},
+		{0.1, 0.39, 0.3},
+		{0.001, 1.23456, 1.234},
+		{1, 10.9, 10},
+		{5, 12, 10},
+		{0, 1.23456, 1.23456},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.expected, RoundQuantity(model.AssetInfo{StepSize: tc.step}, tc.quantity))
+	}
+}
+
+func TestSize(t *testing.T) {
+	info := model.AssetInfo{StepSize: 0.001, MinQuantity: 0.001, MaxQuantity: 10, MinNotional: 10}
+
+	t.Run("rounded quantity", func(t *testing.T) {
+		quantity, err := Size(PercentOfEquity(0.5), info, 1000, 300)
+		require.NoError(t, err)
+		require.Equal(t, 1.666, quantity)
+	})
+
+	t.Run("max quantity", func(t *testing.T) {
+		quantity, err := Size(PercentOfEquity(1), info, 1000, 1)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, quantity)
+	})
+
+	t.Run("min quantity", func(t *testing.T) {
+		_, err := Size(PercentOfEquity(0.01), info, 100, 10000)
+		require.ErrorIs(t, err, ErrBelowMinQuantity)
+	})
+
+	t.Run("min notional", func(t *testing.T) {
+		_, err := Size(PercentOfEquity(0.01), info, 100, 10)
+		require.ErrorIs(t, err, ErrBelowMinNotional)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := Size(PercentOfEquity(0.5), info, 1000, 0)
+		require.ErrorIs(t, err, ErrInvalidPrice)
+
+		_, err = Size(PercentOfEquity(0.5), info, 0, 10)
+		require.ErrorIs(t, err, ErrInvalidEquity)
+
+		_, err = Size(PercentOfEquity(2), info, 1000, 10)
+		require.ErrorIs(t, err, ErrInvalidRisk)
+	})
+}
+
+func TestQuantity(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 1000))
+	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+	_, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 5)
+	require.NoError(t, err)
+
+	equity, err := Equity(wallet, "BTCUSDT", 200)
+	require.NoError(t, err)
+	require.Equal(t, 1500.0, equity)
+
+	quantity, err := Quantity(wallet, EqualWeight(3), wallet.AssetsInfo("BTCUSDT"), "BTCUSDT", 200)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, quantity)
+}
@@ -3,14 +3,20 @@ package storage
 import (
 	"encoding/json"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/tidwall/buntdb"
 
 	"github.com/rodrigo-brito/ninjabot/model"
 )
 
+// equityPrefix is the key prefix of equity snapshots, orders are stored by ID without prefix
+const equityPrefix = "equity:"
+
 type Bunt struct {
 	lastID int64
 	db     *buntdb.DB
@@ -35,6 +41,11 @@ func newBunt(sourceFile string) (Storage, error) {
 		return nil, err
 	}
 
+	err = db.CreateIndex("equity_index", equityPrefix+"*", buntdb.IndexJSON("time"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Bunt{
 		db: db,
 	}, nil
@@ -74,7 +85,11 @@ func (b Bunt) UpdateOrder(order *model.Order) error {
 func (b Bunt) Orders(filters ...OrderFilter) ([]*model.Order, error) {
 	orders := make([]*model.Order, 0)
 	err := b.db.View(func(tx *buntdb.Tx) error {
-		err := tx.Ascend("update_index", func(_, value string) bool {
+		err := tx.Ascend("update_index", func(key, value string) bool {
+			if strings.HasPrefix(key, equityPrefix) {
+				return true
+			}
+
 			var order model.Order
 			err := json.Unmarshal([]byte(value), &order)
 			if err != nil {
@@ -99,3 +114,56 @@ func (b Bunt) Orders(filters ...OrderFilter) ([]*model.Order, error) {
 	}
 	return orders, nil
 }
+
+func (b *Bunt) CreateEquity(equity *model.Equity) error {
+	return b.db.Update(func(tx *buntdb.Tx) error {
+		equity.ID = b.getID()
+		content, err := json.Marshal(equity)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = tx.Set(equityPrefix+strconv.FormatInt(equity.ID, 10), string(content), nil)
+		return err
+	})
+}
+
+func (b *Bunt) UpdateEquity(equity *model.Equity) error {
+	return b.db.Update(func(tx *buntdb.Tx) error {
+		content, err := json.Marshal(equity)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = tx.Set(equityPrefix+strconv.FormatInt(equity.ID, 10), string(content), nil)
+		return err
+	})
+}
+
+func (b *Bunt) Equities(since time.Time) ([]model.Equity, error) {
+	equities := make([]model.Equity, 0)
+	err := b.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("equity_index", func(_, value string) bool {
+			var equity model.Equity
+			err := json.Unmarshal([]byte(value), &equity)
+			if err != nil {
+				log.Println(err)
+				return true
+			}
+
+			if !equity.Time.Before(since) {
+				equities = append(equities, equity)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// index is sorted as text, it may differ from the time order in distinct time zones
+	sort.SliceStable(equities, func(i, j int) bool {
+		return equities[i].Time.Before(equities[j].Time)
+	})
+	return equities, nil
+}
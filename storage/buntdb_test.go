@@ -23,4 +23,10 @@ func TestNewBunt(t *testing.T) {
 	require.NoError(t, err)
 
 	storageUseCase(repo, t)
+	equityUseCase(repo.(EquityStorage), t)
+
+	// equity snapshots are not listed as orders
+	orders, err := repo.Orders()
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
 }
@@ -35,7 +35,7 @@ func FromSQL(dialect gorm.Dialector, opts ...gorm.Option) (Storage, error) {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	err = db.AutoMigrate(&model.Order{})
+	err = db.AutoMigrate(&model.Order{}, &model.Equity{})
 	if err != nil {
 		return nil, err
 	}
@@ -78,3 +78,23 @@ func (s *SQL) Orders(filters ...OrderFilter) ([]*model.Order, error) {
 		return true
 	}), nil
 }
+
+// CreateEquity creates a new equity snapshot in a SQL database
+func (s *SQL) CreateEquity(equity *model.Equity) error {
+	return s.db.Create(equity).Error
+}
+
+// UpdateEquity updates a given equity snapshot
+func (s *SQL) UpdateEquity(equity *model.Equity) error {
+	return s.db.Save(equity).Error
+}
+
+// Equities returns the equity snapshots since a given time, sorted by time
+func (s *SQL) Equities(since time.Time) ([]model.Equity, error) {
+	equities := make([]model.Equity, 0)
+	result := s.db.Where("time >= ?", since).Order("time, id").Find(&equities)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return nil, result.Error
+	}
+	return equities, nil
+}
@@ -21,4 +21,5 @@ func TestFromSQL(t *testing.T) {
 	require.NoError(t, err)
 
 	storageUseCase(repo, t)
+	equityUseCase(repo.(EquityStorage), t)
 }
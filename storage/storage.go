@@ -14,6 +14,13 @@ type Storage interface {
 	Orders(filters ...OrderFilter) ([]*model.Order, error)
 }
 
+// EquityStorage persists snapshots of the account equity, it is implemented by Bunt and SQL storages
+type EquityStorage interface {
+	CreateEquity(equity *model.Equity) error
+	UpdateEquity(equity *model.Equity) error
+	Equities(since time.Time) ([]model.Equity, error)
+}
+
 func WithStatusIn(status ...model.OrderStatusType) OrderFilter {
 	return func(order model.Order) bool {
 		for _, s := range status {
@@ -82,3 +82,36 @@ func storageUseCase(repo Storage, t *testing.T) {
 		require.Equal(t, firstOrder.Quantity, orders[0].Quantity)
 	})
 }
+
+func equityUseCase(repo EquityStorage, t *testing.T) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i, value := range []float64{100, 90, 110} {
+		err := repo.CreateEquity(&model.Equity{Time: now.Add(time.Duration(i) * time.Hour), Value: value})
+		require.NoError(t, err)
+	}
+	err := repo.CreateEquity(&model.Equity{Time: now, Asset: "BTC", Value: 50})
+	require.NoError(t, err)
+
+	equities, err := repo.Equities(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, equities, 2)
+	require.Equal(t, 90.0, equities[0].Value)
+	require.Equal(t, 110.0, equities[1].Value)
+	require.True(t, now.Add(2*time.Hour).Equal(equities[1].Time))
+
+	equities, err = repo.Equities(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, equities, 4)
+
+	equity := equities[len(equities)-1]
+	equity.Value = 120
+	err = repo.UpdateEquity(&equity)
+	require.NoError(t, err)
+
+	equities, err = repo.Equities(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, equities, 1)
+	require.Equal(t, 120.0, equities[0].Value)
+}
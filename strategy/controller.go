@@ -41,6 +41,18 @@ func (s *Controller) OnPartialCandle(candle model.Candle) {
 	}
 }
 
+// OnOrderBook sets the order book of the dataframe, used in the next strategy calls
+func (s *Controller) OnOrderBook(book model.OrderBook) {
+	s.dataframe.OrderBook = &book
+	if str, ok := s.strategy.(OrderBookStrategy); ok && s.started &&
+		len(s.dataframe.Close) >= s.strategy.WarmupPeriod() {
+
+		sample := s.dataframe.Sample(s.strategy.WarmupPeriod())
+		str.Indicators(&sample)
+		str.OnOrderBook(&sample, s.broker)
+	}
+}
+
 func (s *Controller) updateDataFrame(candle model.Candle) {
 	if len(s.dataframe.Time) > 0 && candle.Time.Equal(s.dataframe.Time[len(s.dataframe.Time)-1]) {
 		last := len(s.dataframe.Time) - 1
@@ -24,3 +24,10 @@ type HighFrequencyStrategy interface {
 	// OnPartialCandle will be executed for each new partial candle, after indicators are filled.
 	OnPartialCandle(df *model.Dataframe, broker service.Broker)
 }
+
+type OrderBookStrategy interface {
+	Strategy
+
+	// OnOrderBook will be executed for each order book update, the book is also available in df.OrderBook.
+	OnOrderBook(df *model.Dataframe, broker service.Broker)
+}
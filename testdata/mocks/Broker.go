@@ -100,6 +100,50 @@ func (_c *Broker_Cancel_Call) Return(_a0 error) *Broker_Cancel_Call {
 	return _c
 }
 
+// CreateOrder provides a mock function with given fields: request
+func (_m *Broker) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	ret := _m.Called(request)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.OrderRequest) model.Order); ok {
+		r0 = rf(request)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.OrderRequest) error); ok {
+		r1 = rf(request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Broker_CreateOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateOrder'
+type Broker_CreateOrder_Call struct {
+	*mock.Call
+}
+
+// CreateOrder is a helper method to define mock.On call
+//   - request model.OrderRequest
+func (_e *Broker_Expecter) CreateOrder(request interface{}) *Broker_CreateOrder_Call {
+	return &Broker_CreateOrder_Call{Call: _e.mock.On("CreateOrder", request)}
+}
+
+func (_c *Broker_CreateOrder_Call) Run(run func(request model.OrderRequest)) *Broker_CreateOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.OrderRequest))
+	})
+	return _c
+}
+
+func (_c *Broker_CreateOrder_Call) Return(_a0 model.Order, _a1 error) *Broker_CreateOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 // CreateOrderLimit provides a mock function with given fields: side, pair, size, limit
 func (_m *Broker) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error) {
 	ret := _m.Called(side, pair, size, limit)
@@ -432,6 +476,96 @@ func (_c *Broker_Position_Call) Return(asset float64, quote float64, err error)
 	return _c
 }
 
+// PositionInfo provides a mock function with given fields: pair
+func (_m *Broker) PositionInfo(pair string) (model.Position, error) {
+	ret := _m.Called(pair)
+
+	var r0 model.Position
+	if rf, ok := ret.Get(0).(func(string) model.Position); ok {
+		r0 = rf(pair)
+	} else {
+		r0 = ret.Get(0).(model.Position)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(pair)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Broker_PositionInfo_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'PositionInfo'
+type Broker_PositionInfo_Call struct {
+	*mock.Call
+}
+
+// PositionInfo is a helper method to define mock.On call
+//   - pair string
+func (_e *Broker_Expecter) PositionInfo(pair interface{}) *Broker_PositionInfo_Call {
+	return &Broker_PositionInfo_Call{Call: _e.mock.On("PositionInfo", pair)}
+}
+
+func (_c *Broker_PositionInfo_Call) Run(run func(pair string)) *Broker_PositionInfo_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *Broker_PositionInfo_Call) Return(_a0 model.Position, _a1 error) *Broker_PositionInfo_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+// ReplaceOrder provides a mock function with given fields: order, price, quantity
+func (_m *Broker) ReplaceOrder(order model.Order, price float64, quantity float64) (model.Order, error) {
+	ret := _m.Called(order, price, quantity)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.Order, float64, float64) model.Order); ok {
+		r0 = rf(order, price, quantity)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.Order, float64, float64) error); ok {
+		r1 = rf(order, price, quantity)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Broker_ReplaceOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReplaceOrder'
+type Broker_ReplaceOrder_Call struct {
+	*mock.Call
+}
+
+// ReplaceOrder is a helper method to define mock.On call
+//   - order model.Order
+//   - price float64
+//   - quantity float64
+func (_e *Broker_Expecter) ReplaceOrder(order interface{}, price interface{}, quantity interface{}) *Broker_ReplaceOrder_Call {
+	return &Broker_ReplaceOrder_Call{Call: _e.mock.On("ReplaceOrder", order, price, quantity)}
+}
+
+func (_c *Broker_ReplaceOrder_Call) Run(run func(order model.Order, price float64, quantity float64)) *Broker_ReplaceOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.Order), args[1].(float64), args[2].(float64))
+	})
+	return _c
+}
+
+func (_c *Broker_ReplaceOrder_Call) Return(_a0 model.Order, _a1 error) *Broker_ReplaceOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 type mockConstructorTestingTNewBroker interface {
 	mock.TestingT
 	Cleanup(func())
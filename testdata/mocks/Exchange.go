@@ -290,6 +290,50 @@ func (_c *Exchange_CandlesSubscription_Call) Return(_a0 chan model.Candle, _a1 c
 	return _c
 }
 
+// CreateOrder provides a mock function with given fields: request
+func (_m *Exchange) CreateOrder(request model.OrderRequest) (model.Order, error) {
+	ret := _m.Called(request)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.OrderRequest) model.Order); ok {
+		r0 = rf(request)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.OrderRequest) error); ok {
+		r1 = rf(request)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_CreateOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateOrder'
+type Exchange_CreateOrder_Call struct {
+	*mock.Call
+}
+
+// CreateOrder is a helper method to define mock.On call
+//   - request model.OrderRequest
+func (_e *Exchange_Expecter) CreateOrder(request interface{}) *Exchange_CreateOrder_Call {
+	return &Exchange_CreateOrder_Call{Call: _e.mock.On("CreateOrder", request)}
+}
+
+func (_c *Exchange_CreateOrder_Call) Run(run func(request model.OrderRequest)) *Exchange_CreateOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.OrderRequest))
+	})
+	return _c
+}
+
+func (_c *Exchange_CreateOrder_Call) Return(_a0 model.Order, _a1 error) *Exchange_CreateOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 // CreateOrderLimit provides a mock function with given fields: side, pair, size, limit
 func (_m *Exchange) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error) {
 	ret := _m.Called(side, pair, size, limit)
@@ -616,6 +660,53 @@ func (_c *Exchange_Order_Call) Return(_a0 model.Order, _a1 error) *Exchange_Orde
 	return _c
 }
 
+// Orders provides a mock function with given fields: pair, limit
+func (_m *Exchange) Orders(pair string, limit int) ([]model.Order, error) {
+	ret := _m.Called(pair, limit)
+
+	var r0 []model.Order
+	if rf, ok := ret.Get(0).(func(string, int) []model.Order); ok {
+		r0 = rf(pair, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Order)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(pair, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_Orders_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Orders'
+type Exchange_Orders_Call struct {
+	*mock.Call
+}
+
+// Orders is a helper method to define mock.On call
+//   - pair string
+//   - limit int
+func (_e *Exchange_Expecter) Orders(pair interface{}, limit interface{}) *Exchange_Orders_Call {
+	return &Exchange_Orders_Call{Call: _e.mock.On("Orders", pair, limit)}
+}
+
+func (_c *Exchange_Orders_Call) Run(run func(pair string, limit int)) *Exchange_Orders_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *Exchange_Orders_Call) Return(_a0 []model.Order, _a1 error) *Exchange_Orders_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 // Position provides a mock function with given fields: pair
 func (_m *Exchange) Position(pair string) (float64, float64, error) {
 	ret := _m.Called(pair)
@@ -667,6 +758,96 @@ func (_c *Exchange_Position_Call) Return(asset float64, quote float64, err error
 	return _c
 }
 
+// PositionInfo provides a mock function with given fields: pair
+func (_m *Exchange) PositionInfo(pair string) (model.Position, error) {
+	ret := _m.Called(pair)
+
+	var r0 model.Position
+	if rf, ok := ret.Get(0).(func(string) model.Position); ok {
+		r0 = rf(pair)
+	} else {
+		r0 = ret.Get(0).(model.Position)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(pair)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_PositionInfo_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'PositionInfo'
+type Exchange_PositionInfo_Call struct {
+	*mock.Call
+}
+
+// PositionInfo is a helper method to define mock.On call
+//   - pair string
+func (_e *Exchange_Expecter) PositionInfo(pair interface{}) *Exchange_PositionInfo_Call {
+	return &Exchange_PositionInfo_Call{Call: _e.mock.On("PositionInfo", pair)}
+}
+
+func (_c *Exchange_PositionInfo_Call) Run(run func(pair string)) *Exchange_PositionInfo_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *Exchange_PositionInfo_Call) Return(_a0 model.Position, _a1 error) *Exchange_PositionInfo_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+// ReplaceOrder provides a mock function with given fields: order, price, quantity
+func (_m *Exchange) ReplaceOrder(order model.Order, price float64, quantity float64) (model.Order, error) {
+	ret := _m.Called(order, price, quantity)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.Order, float64, float64) model.Order); ok {
+		r0 = rf(order, price, quantity)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.Order, float64, float64) error); ok {
+		r1 = rf(order, price, quantity)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_ReplaceOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReplaceOrder'
+type Exchange_ReplaceOrder_Call struct {
+	*mock.Call
+}
+
+// ReplaceOrder is a helper method to define mock.On call
+//   - order model.Order
+//   - price float64
+//   - quantity float64
+func (_e *Exchange_Expecter) ReplaceOrder(order interface{}, price interface{}, quantity interface{}) *Exchange_ReplaceOrder_Call {
+	return &Exchange_ReplaceOrder_Call{Call: _e.mock.On("ReplaceOrder", order, price, quantity)}
+}
+
+func (_c *Exchange_ReplaceOrder_Call) Run(run func(order model.Order, price float64, quantity float64)) *Exchange_ReplaceOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.Order), args[1].(float64), args[2].(float64))
+	})
+	return _c
+}
+
+func (_c *Exchange_ReplaceOrder_Call) Return(_a0 model.Order, _a1 error) *Exchange_ReplaceOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 type mockConstructorTestingTNewExchange interface {
 	mock.TestingT
 	Cleanup(func())
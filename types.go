@@ -12,6 +12,9 @@ type (
 	SideType         = model.SideType
 	OrderType        = model.OrderType
 	OrderStatusType  = model.OrderStatusType
+	TimeInForceType  = model.TimeInForceType
+	OrderRequest     = model.OrderRequest
+	Position         = model.Position
 )
 
 var (
@@ -24,6 +27,7 @@ var (
 	OrderTypeStopLossLimit         = model.OrderTypeStopLossLimit
 	OrderTypeTakeProfit            = model.OrderTypeTakeProfit
 	OrderTypeTakeProfitLimit       = model.OrderTypeTakeProfitLimit
+	OrderTypeTrailingStop          = model.OrderTypeTrailingStop
 	OrderStatusTypeNew             = model.OrderStatusTypeNew
 	OrderStatusTypePartiallyFilled = model.OrderStatusTypePartiallyFilled
 	OrderStatusTypeFilled          = model.OrderStatusTypeFilled
@@ -31,4 +35,7 @@ var (
 	OrderStatusTypePendingCancel   = model.OrderStatusTypePendingCancel
 	OrderStatusTypeRejected        = model.OrderStatusTypeRejected
 	OrderStatusTypeExpired         = model.OrderStatusTypeExpired
+	TimeInForceTypeGTC             = model.TimeInForceTypeGTC
+	TimeInForceTypeIOC             = model.TimeInForceTypeIOC
+	TimeInForceTypeFOK             = model.TimeInForceTypeFOK
 )
@@ -0,0 +1,289 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xhit/go-str2duration/v2"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+const defaultSpikeThreshold = 0.5
+
+type Rule string
+
+const (
+	// RuleDuplicate finds candles with the same time of the previous one
+	RuleDuplicate Rule = "duplicate"
+	// RuleOrder finds candles older than the previous one
+	RuleOrder Rule = "order"
+	// RuleGap finds missing candles between two rows
+	RuleGap Rule = "gap"
+	// RuleZeroVolume finds candles without volume
+	RuleZeroVolume Rule = "zero_volume"
+	// RuleHighLow finds candles with high lower than low, or open and close out of the high-low range
+	RuleHighLow Rule = "high_low"
+	// RuleSpike finds close prices that change more than the spike threshold from the previous close
+	RuleSpike Rule = "spike"
+)
+
+var allRules = []Rule{RuleDuplicate, RuleOrder, RuleGap, RuleZeroVolume, RuleHighLow, RuleSpike}
+
+// Issue is a rule violation in a candle, Index is the position of the candle in the input
+type Issue struct {
+	Rule    Rule      `json:"rule"`
+	Index   int       `json:"index"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Report is the result of a validation, it can be encoded as JSON
+type Report struct {
+	Pair      string       `json:"pair,omitempty"`
+	Timeframe string       `json:"timeframe"`
+	Candles   int          `json:"candles"`
+	Counts    map[Rule]int `json:"counts"`
+	Issues    []Issue      `json:"issues"`
+}
+
+// Valid returns true if no issue was found
+func (r Report) Valid() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *Report) add(rule Rule, index int, candle model.Candle, format string, args ...any) {
+	r.Counts[rule]++
+	r.Issues = append(r.Issues, Issue{
+		Rule:    rule,
+		Index:   index,
+		Time:    candle.Time,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+type config struct {
+	rules          map[Rule]bool
+	spikeThreshold float64
+}
+
+type Option func(*config)
+
+// WithRules checks only the given rules, all rules are checked by default
+func WithRules(rules ...Rule) Option {
+	return func(c *config) {
+		c.rules = make(map[Rule]bool)
+		for _, rule := range rules {
+			c.rules[rule] = true
+		}
+	}
+}
+
+// WithSpikeThreshold sets the close price change rate considered a spike, default is 0.5 (50%)
+func WithSpikeThreshold(threshold float64) Option {
+	return func(c *config) {
+		c.spikeThreshold = threshold
+	}
+}
+
+func newConfig(options []Option) config {
+	cfg := config{
+		rules:          make(map[Rule]bool),
+		spikeThreshold: defaultSpikeThreshold,
+	}
+	for _, rule := range allRules {
+		cfg.rules[rule] = true
+	}
+
+	for _, option := range options {
+		option(&cfg)
+	}
+	return cfg
+}
+
+func isSpike(previous, current model.Candle, threshold float64) bool {
+	if previous.Close == 0 {
+		return false
+	}
+	return math.Abs(current.Close/previous.Close-1) > threshold
+}
+
+func isInvalidRange(candle model.Candle) bool {
+	return candle.High < candle.Low ||
+		candle.Open > candle.High || candle.Open < candle.Low ||
+		candle.Close > candle.High || candle.Close < candle.Low
+}
+
+// Validate checks the candles of a timeframe, in the given order, against the validation rules
+func Validate(candles []model.Candle, timeframe string, options ...Option) (Report, error) {
+	cfg := newConfig(options)
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		Timeframe: timeframe,
+		Candles:   len(candles),
+		Counts:    make(map[Rule]int),
+		Issues:    make([]Issue, 0),
+	}
+	if len(candles) > 0 {
+		report.Pair = candles[0].Pair
+	}
+
+	for i, candle := range candles {
+		if cfg.rules[RuleZeroVolume] && candle.Volume == 0 {
+			report.add(RuleZeroVolume, i, candle, "zero volume")
+		}
+
+		if cfg.rules[RuleHighLow] && isInvalidRange(candle) {
+			report.add(RuleHighLow, i, candle, "invalid range: open %f, close %f, low %f, high %f",
+				candle.Open, candle.Close, candle.Low, candle.High)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		previous := candles[i-1]
+		diff := candle.Time.Sub(previous.Time)
+		switch {
+		case diff == 0:
+			if cfg.rules[RuleDuplicate] {
+				report.add(RuleDuplicate, i, candle, "duplicated candle")
+			}
+		case diff < 0:
+			if cfg.rules[RuleOrder] {
+				report.add(RuleOrder, i, candle, "candle before the previous one at %s",
+					previous.Time.Format(time.RFC3339))
+			}
+		case diff > interval:
+			if cfg.rules[RuleGap] {
+				report.add(RuleGap, i, candle, "%d missing candles after %s",
+					int(diff/interval)-1, previous.Time.Format(time.RFC3339))
+			}
+		}
+
+		if cfg.rules[RuleSpike] && isSpike(previous, candle, cfg.spikeThreshold) {
+			report.add(RuleSpike, i, candle, "close changed from %f to %f", previous.Close, candle.Close)
+		}
+	}
+
+	return report, nil
+}
+
+type RepairMode string
+
+const (
+	// RepairSort sorts the candles by time
+	RepairSort RepairMode = "sort"
+	// RepairDeduplicate keeps only the last candle of each time
+	RepairDeduplicate RepairMode = "dedupe"
+	// RepairDropOutliers removes candles with an invalid range or a close price spike that reverts
+	// in the next candle
+	RepairDropOutliers RepairMode = "outliers"
+	// RepairFillGaps inserts candles with the previous close price and zero volume in gaps
+	RepairFillGaps RepairMode = "fill"
+)
+
+// Repair fixes the candles with the given modes, they are applied in the order:
+// sort, dedupe, outliers and fill. The input slice is not modified.
+func Repair(candles []model.Candle, timeframe string, modes []RepairMode, options ...Option) ([]model.Candle, error) {
+	cfg := newConfig(options)
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := make(map[RepairMode]bool)
+	for _, mode := range modes {
+		switch mode {
+		case RepairSort, RepairDeduplicate, RepairDropOutliers, RepairFillGaps:
+			enabled[mode] = true
+		default:
+			return nil, fmt.Errorf("invalid repair mode: %s", mode)
+		}
+	}
+
+	result := make([]model.Candle, len(candles))
+	copy(result, candles)
+
+	if enabled[RepairSort] {
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Time.Before(result[j].Time)
+		})
+	}
+
+	if enabled[RepairDeduplicate] {
+		result = deduplicate(result)
+	}
+
+	if enabled[RepairDropOutliers] {
+		result = dropOutliers(result, cfg.spikeThreshold)
+	}
+
+	if enabled[RepairFillGaps] {
+		result = fillGaps(result, interval)
+	}
+
+	return result, nil
+}
+
+func deduplicate(candles []model.Candle) []model.Candle {
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if len(result) > 0 && result[len(result)-1].Time.Equal(candle.Time) {
+			result[len(result)-1] = candle
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result
+}
+
+// dropOutliers removes candles with an invalid range and isolated spikes. A candle is a spike when its
+// close differs from the closes of both neighbours, so a lasting step change in the price is kept.
+func dropOutliers(candles []model.Candle, threshold float64) []model.Candle {
+	valid := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if !isInvalidRange(candle) {
+			valid = append(valid, candle)
+		}
+	}
+
+	result := make([]model.Candle, 0, len(valid))
+	for i, candle := range valid {
+		if i > 0 && i < len(valid)-1 &&
+			isSpike(valid[i-1], candle, threshold) && isSpike(valid[i+1], candle, threshold) {
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result
+}
+
+func fillGaps(candles []model.Candle, interval time.Duration) []model.Candle {
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if len(result) > 0 {
+			previous := result[len(result)-1]
+			for t := previous.Time.Add(interval); t.Before(candle.Time); t = t.Add(interval) {
+				result = append(result, model.Candle{
+					Pair:      previous.Pair,
+					Time:      t,
+					UpdatedAt: t,
+					Open:      previous.Close,
+					Close:     previous.Close,
+					Low:       previous.Close,
+					High:      previous.Close,
+					Complete:  true,
+				})
+			}
+		}
+		result = append(result, candle)
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func candle(hour int, price, volume float64) model.Candle {
+	t := time.Date(2022, 1, 1, hour, 0, 0, 0, time.UTC)
+	return model.Candle{
+		Pair: "BTCUSDT", Time: t, UpdatedAt: t, Complete: true,
+		Open: price, Close: price, Low: price - 1, High: price + 1, Volume: volume,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalidRange := candle(6, 100, 1)
+	invalidRange.High, invalidRange.Low = 90, 110
+
+	candles := []model.Candle{
+		candle(0, 100, 1),
+		candle(1, 101, 0),
+		candle(1, 101, 1),
+		candle(4, 102, 1),
+		candle(3, 102, 1),
+		candle(5, 300, 1),
+		invalidRange,
+	}
+
+	report, err := Validate(candles, "1h")
+	require.NoError(t, err)
+	require.False(t, report.Valid())
+	require.Equal(t, "BTCUSDT", report.Pair)
+	require.Equal(t, 7, report.Candles)
+	require.Equal(t, map[Rule]int{
+		RuleZeroVolume: 1,
+		RuleDuplicate:  1,
+		RuleGap:        2,
+		RuleOrder:      1,
+		RuleSpike:      2,
+		RuleHighLow:    1,
+	}, report.Counts)
+	require.Equal(t, Issue{
+		Rule:    RuleGap,
+		Index:   3,
+		Time:    candles[3].Time,
+		Message: "2 missing candles after 2022-01-01T01:00:00Z",
+	}, report.Issues[2])
+
+	_, err = json.Marshal(report)
+	require.NoError(t, err)
+
+	t.Run("selected rules", func(t *testing.T) {
+		report, err := Validate(candles, "1h", WithRules(RuleDuplicate), WithSpikeThreshold(10))
+		require.NoError(t, err)
+		require.Equal(t, map[Rule]int{RuleDuplicate: 1}, report.Counts)
+	})
+
+	t.Run("invalid timeframe", func(t *testing.T) {
+		_, err := Validate(candles, "batata")
+		require.Error(t, err)
+	})
+}
+
+func TestRepair(t *testing.T) {
+	duplicated := candle(1, 102, 2)
+	candles := []model.Candle{
+		candle(0, 100, 1),
+		candle(4, 103, 1),
+		candle(1, 101, 1),
+		duplicated,
+		candle(2, 1000, 1),
+	}
+
+	repaired, err := Repair(candles, "1h", []RepairMode{RepairFillGaps, RepairSort, RepairDeduplicate,
+		RepairDropOutliers})
+	require.NoError(t, err)
+	require.Len(t, candles, 5)
+
+	report, err := Validate(repaired, "1h", WithRules(RuleDuplicate, RuleOrder, RuleGap, RuleSpike))
+	require.NoError(t, err)
+	require.True(t, report.Valid(), report.Issues)
+
+	require.Len(t, repaired, 5)
+	require.Equal(t, duplicated, repaired[1])
+	for _, filled := range repaired[2:4] {
+		require.Equal(t, 102.0, filled.Close)
+		require.Zero(t, filled.Volume)
+	}
+	require.Equal(t, 103.0, repaired[4].Close)
+
+	_, err = Repair(candles, "1h", []RepairMode{"batata"})
+	require.Error(t, err)
+
+	t.Run("step change", func(t *testing.T) {
+		candles := []model.Candle{
+			candle(0, 100, 1),
+			candle(1, 101, 1),
+			candle(2, 300, 1),
+			candle(3, 301, 1),
+			candle(4, 900, 1),
+			candle(5, 302, 1),
+		}
+
+		repaired, err := Repair(candles, "1h", []RepairMode{RepairDropOutliers})
+		require.NoError(t, err)
+		require.Equal(t, append(candles[:4:4], candles[5]), repaired)
+	})
+}